@@ -0,0 +1,452 @@
+package sonic
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"unsafe"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var (
+	ErrVarintOverflow = errors.New("varint overflows a 64-bit integer")
+	ErrMisalignedView = errors.New("view is not aligned to its type's alignment")
+)
+
+// BinaryEncoder writes fixed-width integers and floats, varints and
+// length-prefixed bytes to the write area of a Buffer, as a codec's Encode
+// does. Fixed-width values are written in the encoder's byte order.
+//
+// The encoder does not grow the buffer: the codec reserves enough space before
+// encoding, like codec/frame does. If there is not enough space left for a
+// value, nothing is written and sonicerrors.ErrNeedMore is returned, in which
+// case the partially encoded message can be removed with Rollback.
+//
+// A BinaryEncoder does not allocate. It is meant to be created on the stack for
+// each encoded message:
+//
+//	dst.Reserve(n)
+//	e := sonic.NewBinaryEncoder(dst, binary.BigEndian)
+//	e.PutUint32(...)
+type BinaryEncoder struct {
+	b     Buffer
+	order binary.ByteOrder
+	n     int
+}
+
+func NewBinaryEncoder(b Buffer, order binary.ByteOrder) BinaryEncoder {
+	return BinaryEncoder{b: b, order: order}
+}
+
+// Len returns the number of bytes written by the encoder.
+func (e *BinaryEncoder) Len() int {
+	return e.n
+}
+
+// Rollback removes the bytes written by the encoder from the write area.
+func (e *BinaryEncoder) Rollback() {
+	e.b.ShrinkBy(e.n)
+	e.n = 0
+}
+
+func (e *BinaryEncoder) claim(n int) ([]byte, error) {
+	if e.b.Reserved() < n {
+		return nil, sonicerrors.ErrNeedMore
+	}
+	e.n += n
+	return e.b.ClaimFixed(n), nil
+}
+
+func (e *BinaryEncoder) PutUint8(v uint8) error {
+	b, err := e.claim(1)
+	if err == nil {
+		b[0] = v
+	}
+	return err
+}
+
+func (e *BinaryEncoder) PutUint16(v uint16) error {
+	b, err := e.claim(2)
+	if err == nil {
+		e.order.PutUint16(b, v)
+	}
+	return err
+}
+
+func (e *BinaryEncoder) PutUint32(v uint32) error {
+	b, err := e.claim(4)
+	if err == nil {
+		e.order.PutUint32(b, v)
+	}
+	return err
+}
+
+func (e *BinaryEncoder) PutUint64(v uint64) error {
+	b, err := e.claim(8)
+	if err == nil {
+		e.order.PutUint64(b, v)
+	}
+	return err
+}
+
+func (e *BinaryEncoder) PutInt8(v int8) error {
+	return e.PutUint8(uint8(v))
+}
+
+func (e *BinaryEncoder) PutInt16(v int16) error {
+	return e.PutUint16(uint16(v))
+}
+
+func (e *BinaryEncoder) PutInt32(v int32) error {
+	return e.PutUint32(uint32(v))
+}
+
+func (e *BinaryEncoder) PutInt64(v int64) error {
+	return e.PutUint64(uint64(v))
+}
+
+func (e *BinaryEncoder) PutFloat32(v float32) error {
+	return e.PutUint32(math.Float32bits(v))
+}
+
+func (e *BinaryEncoder) PutFloat64(v float64) error {
+	return e.PutUint64(math.Float64bits(v))
+}
+
+// PutUvarint writes v in the varint format of encoding/binary.
+func (e *BinaryEncoder) PutUvarint(v uint64) error {
+	b, err := e.claim(uvarintLen(v))
+	if err == nil {
+		binary.PutUvarint(b, v)
+	}
+	return err
+}
+
+// PutVarint writes v in the zig-zag varint format of encoding/binary.
+func (e *BinaryEncoder) PutVarint(v int64) error {
+	uv := uint64(v) << 1
+	if v < 0 {
+		uv = ^uv
+	}
+	return e.PutUvarint(uv)
+}
+
+func uvarintLen(v uint64) (n int) {
+	for n = 1; v >= 0x80; n++ {
+		v >>= 7
+	}
+	return n
+}
+
+// PutBytes writes b as is.
+func (e *BinaryEncoder) PutBytes(b []byte) error {
+	into, err := e.claim(len(b))
+	if err == nil {
+		copy(into, b)
+	}
+	return err
+}
+
+// PutString writes s as is.
+func (e *BinaryEncoder) PutString(s string) error {
+	into, err := e.claim(len(s))
+	if err == nil {
+		copy(into, s)
+	}
+	return err
+}
+
+// PutPrefixedBytes writes the length of b as an uvarint, followed by b.
+func (e *BinaryEncoder) PutPrefixedBytes(b []byte) error {
+	into, err := e.claim(uvarintLen(uint64(len(b))) + len(b))
+	if err == nil {
+		n := binary.PutUvarint(into, uint64(len(b)))
+		copy(into[n:], b)
+	}
+	return err
+}
+
+// PutPrefixedString writes the length of s as an uvarint, followed by s.
+func (e *BinaryEncoder) PutPrefixedString(s string) error {
+	into, err := e.claim(uvarintLen(uint64(len(s))) + len(s))
+	if err == nil {
+		n := binary.PutUvarint(into, uint64(len(s)))
+		copy(into[n:], s)
+	}
+	return err
+}
+
+// PutZeros writes n zero bytes.
+func (e *BinaryEncoder) PutZeros(n int) error {
+	b, err := e.claim(n)
+	for i := range b {
+		b[i] = 0
+	}
+	return err
+}
+
+// Align writes zero bytes until the number of bytes written by the encoder is
+// a multiple of n, which must be a power of two.
+func (e *BinaryEncoder) Align(n int) error {
+	return e.PutZeros(-e.n & (n - 1))
+}
+
+// EncodeView claims the bytes of a T in the write area and returns them as a
+// *T, such that the value is encoded by filling it in place. T must not hold
+// pointers and is encoded in the memory layout and byte order of the machine.
+//
+// The returned value must be filled before the write area is flushed or
+// reserved again. ErrMisalignedView is returned if the claimed bytes are not
+// aligned for T, see BinaryEncoder.Align.
+func EncodeView[T any](e *BinaryEncoder) (*T, error) {
+	var zero T
+	size := int(unsafe.Sizeof(zero))
+	if e.b.Reserved() < size {
+		return nil, sonicerrors.ErrNeedMore
+	}
+	if size == 0 {
+		return new(T), nil
+	}
+
+	// Misaligned bytes are given back, such that nothing is written on error.
+	into := e.b.ClaimFixed(size)
+	if !aligned[T](into) {
+		e.b.ShrinkBy(size)
+		return nil, ErrMisalignedView
+	}
+	e.n += size
+
+	v := (*T)(unsafe.Pointer(&into[0]))
+	*v = zero
+	return v, nil
+}
+
+func aligned[T any](b []byte) bool {
+	var zero T
+	return uintptr(unsafe.Pointer(&b[0]))%unsafe.Alignof(zero) == 0
+}
+
+// BinaryDecoder reads fixed-width integers and floats, varints and
+// length-prefixed bytes from the read area of a Buffer, as a codec's Decode
+// does. Fixed-width values are read in the decoder's byte order.
+//
+// The decoder is a cursor over the read area: it does not consume the bytes it
+// reads, and commits bytes from the write area as it needs them, like
+// PrepareRead does. If the buffer does not hold enough bytes for a value, the
+// cursor does not move and sonicerrors.ErrNeedMore is returned, which a codec
+// returns as is for CodecConn to read more bytes. Once a message is decoded, it
+// is consumed with Consume.
+//
+// Bytes and PrefixedBytes return views of the buffer, which are only valid
+// until the decoded bytes are consumed.
+//
+// A BinaryDecoder does not allocate. It is meant to be created on the stack for
+// each decoded message:
+//
+//	d := sonic.NewBinaryDecoder(src, binary.BigEndian)
+//	v, err := d.Uint32()
+type BinaryDecoder struct {
+	b     Buffer
+	order binary.ByteOrder
+	n     int
+}
+
+func NewBinaryDecoder(b Buffer, order binary.ByteOrder) BinaryDecoder {
+	return BinaryDecoder{b: b, order: order}
+}
+
+// Len returns the number of bytes read by the decoder.
+func (d *BinaryDecoder) Len() int {
+	return d.n
+}
+
+// Reset moves the cursor back to the start of the read area.
+func (d *BinaryDecoder) Reset() {
+	d.n = 0
+}
+
+// Consume consumes the bytes read by the decoder and moves the cursor back to
+// the start of the read area.
+func (d *BinaryDecoder) Consume() {
+	d.b.Consume(d.n)
+	d.n = 0
+}
+
+func (d *BinaryDecoder) next(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, sonicerrors.ErrNeedMore
+	}
+	if err := d.b.PrepareRead(d.n + n); err != nil {
+		return nil, err
+	}
+	b := d.b.Data()[d.n : d.n+n]
+	d.n += n
+	return b, nil
+}
+
+func (d *BinaryDecoder) Uint8() (uint8, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *BinaryDecoder) Uint16() (uint16, error) {
+	b, err := d.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint16(b), nil
+}
+
+func (d *BinaryDecoder) Uint32() (uint32, error) {
+	b, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *BinaryDecoder) Uint64() (uint64, error) {
+	b, err := d.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint64(b), nil
+}
+
+func (d *BinaryDecoder) Int8() (int8, error) {
+	v, err := d.Uint8()
+	return int8(v), err
+}
+
+func (d *BinaryDecoder) Int16() (int16, error) {
+	v, err := d.Uint16()
+	return int16(v), err
+}
+
+func (d *BinaryDecoder) Int32() (int32, error) {
+	v, err := d.Uint32()
+	return int32(v), err
+}
+
+func (d *BinaryDecoder) Int64() (int64, error) {
+	v, err := d.Uint64()
+	return int64(v), err
+}
+
+func (d *BinaryDecoder) Float32() (float32, error) {
+	v, err := d.Uint32()
+	return math.Float32frombits(v), err
+}
+
+func (d *BinaryDecoder) Float64() (float64, error) {
+	v, err := d.Uint64()
+	return math.Float64frombits(v), err
+}
+
+// Uvarint reads an uvarint in the format of encoding/binary.
+func (d *BinaryDecoder) Uvarint() (uint64, error) {
+	v, n, err := d.peekUvarint()
+	if err == nil {
+		d.n += n
+	}
+	return v, err
+}
+
+// Varint reads a zig-zag varint in the format of encoding/binary.
+func (d *BinaryDecoder) Varint() (int64, error) {
+	uv, err := d.Uvarint()
+	v := int64(uv >> 1)
+	if uv&1 != 0 {
+		v = ^v
+	}
+	return v, err
+}
+
+// peekUvarint decodes the uvarint at the cursor without moving it, committing
+// one byte at a time until the uvarint is complete.
+func (d *BinaryDecoder) peekUvarint() (uint64, int, error) {
+	for want := d.n + 1; ; want++ {
+		if err := d.b.PrepareRead(want); err != nil {
+			return 0, 0, err
+		}
+		v, n := binary.Uvarint(d.b.Data()[d.n:])
+		if n > 0 {
+			return v, n, nil
+		}
+		if n < 0 {
+			return 0, 0, ErrVarintOverflow
+		}
+		if want < d.b.ReadLen() {
+			want = d.b.ReadLen()
+		}
+	}
+}
+
+// Bytes returns a view of the next n bytes.
+func (d *BinaryDecoder) Bytes(n int) ([]byte, error) {
+	return d.next(n)
+}
+
+// Skip moves the cursor past the next n bytes.
+func (d *BinaryDecoder) Skip(n int) error {
+	_, err := d.next(n)
+	return err
+}
+
+// Align moves the cursor past the padding written by BinaryEncoder.Align.
+func (d *BinaryDecoder) Align(n int) error {
+	return d.Skip(-d.n & (n - 1))
+}
+
+// PrefixedBytes returns a view of the bytes written by
+// BinaryEncoder.PutPrefixedBytes.
+func (d *BinaryDecoder) PrefixedBytes() ([]byte, error) {
+	length, n, err := d.peekUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if length > math.MaxInt32 {
+		return nil, ErrVarintOverflow
+	}
+	b, err := d.next(n + int(length))
+	if err != nil {
+		return nil, err
+	}
+	return b[n:], nil
+}
+
+// PrefixedString returns a copy of the string written by
+// BinaryEncoder.PutPrefixedString. Use PrefixedBytes for a view which does not
+// allocate.
+func (d *BinaryDecoder) PrefixedString() (string, error) {
+	b, err := d.PrefixedBytes()
+	return string(b), err
+}
+
+// DecodeView returns the next bytes of the read area as a *T, without copying
+// them. T must not hold pointers and is decoded in the memory layout and byte
+// order of the machine. The returned value is only valid until the decoded
+// bytes are consumed.
+//
+// ErrMisalignedView is returned if the bytes are not aligned for T, see
+// BinaryDecoder.Align.
+func DecodeView[T any](d *BinaryDecoder) (*T, error) {
+	var zero T
+	size := int(unsafe.Sizeof(zero))
+	if size == 0 {
+		return new(T), nil
+	}
+	if err := d.b.PrepareRead(d.n + size); err != nil {
+		return nil, err
+	}
+	b := d.b.Data()[d.n : d.n+size]
+	if !aligned[T](b) {
+		return nil, ErrMisalignedView
+	}
+	d.n += size
+	return (*T)(unsafe.Pointer(&b[0])), nil
+}
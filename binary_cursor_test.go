@@ -0,0 +1,295 @@
+package sonic
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+type testView struct {
+	A uint64
+	B uint32
+	C uint16
+}
+
+func encodeTestMessage(e *BinaryEncoder) error {
+	for _, err := range []error{
+		e.PutUint8(1),
+		e.PutUint16(2),
+		e.PutUint32(3),
+		e.PutUint64(4),
+		e.PutInt8(-5),
+		e.PutInt16(-6),
+		e.PutInt32(-7),
+		e.PutInt64(-8),
+		e.PutFloat32(9.5),
+		e.PutFloat64(math.Pi),
+		e.PutUvarint(300),
+		e.PutVarint(-300),
+		e.PutPrefixedBytes([]byte("hello")),
+		e.PutPrefixedString("world"),
+		e.PutString("raw"),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeTestMessage(t *testing.T, d *BinaryDecoder) {
+	check := func(err error, got, want any) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	u8, err := d.Uint8()
+	check(err, u8, uint8(1))
+	u16, err := d.Uint16()
+	check(err, u16, uint16(2))
+	u32, err := d.Uint32()
+	check(err, u32, uint32(3))
+	u64, err := d.Uint64()
+	check(err, u64, uint64(4))
+	i8, err := d.Int8()
+	check(err, i8, int8(-5))
+	i16, err := d.Int16()
+	check(err, i16, int16(-6))
+	i32, err := d.Int32()
+	check(err, i32, int32(-7))
+	i64, err := d.Int64()
+	check(err, i64, int64(-8))
+	f32, err := d.Float32()
+	check(err, f32, float32(9.5))
+	f64, err := d.Float64()
+	check(err, f64, math.Pi)
+	uv, err := d.Uvarint()
+	check(err, uv, uint64(300))
+	v, err := d.Varint()
+	check(err, v, int64(-300))
+	b, err := d.PrefixedBytes()
+	check(err, string(b), "hello")
+	s, err := d.PrefixedString()
+	check(err, s, "world")
+	b, err = d.Bytes(3)
+	check(err, string(b), "raw")
+}
+
+func TestBinaryCursorRoundTrip(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		b := NewByteBuffer()
+		e := NewBinaryEncoder(b, order)
+		if err := encodeTestMessage(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Len() != b.WriteLen() {
+			t.Fatalf("expected %d bytes written, got %d", b.WriteLen(), e.Len())
+		}
+
+		d := NewBinaryDecoder(b, order)
+		decodeTestMessage(t, &d)
+		if d.Len() != e.Len() {
+			t.Fatalf("expected %d bytes read, got %d", e.Len(), d.Len())
+		}
+		d.Consume()
+		if b.ReadLen() != 0 || b.WriteLen() != 0 {
+			t.Fatal("buffer should be empty")
+		}
+	}
+
+	// Fixed-width values are written in the encoder's byte order.
+	b := NewByteBuffer()
+	e := NewBinaryEncoder(b, binary.LittleEndian)
+	_ = e.PutUint32(0x01020304)
+	if got := b.data[:4]; got[0] != 4 || got[3] != 1 {
+		t.Fatalf("expected little endian bytes, got %v", got)
+	}
+}
+
+func TestBinaryEncoderNeedMore(t *testing.T) {
+	b := &ByteBuffer{data: make([]byte, 0, 6)}
+	e := NewBinaryEncoder(b, binary.BigEndian)
+
+	if err := e.PutUint32(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.PutUint32(2); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected ErrNeedMore, got %v", err)
+	}
+	if err := e.PutPrefixedString("abc"); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected ErrNeedMore, got %v", err)
+	}
+	if e.Len() != 4 || b.WriteLen() != 4 {
+		t.Fatalf("nothing should be written on error, len=%d", b.WriteLen())
+	}
+
+	e.Rollback()
+	if e.Len() != 0 || b.WriteLen() != 0 {
+		t.Fatal("encoded bytes should be rolled back")
+	}
+
+	b.Reserve(64)
+	if err := e.PutPrefixedString("abc"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBinaryDecoderNeedMore(t *testing.T) {
+	encoded := NewByteBuffer()
+	e := NewBinaryEncoder(encoded, binary.BigEndian)
+	if err := encodeTestMessage(&e); err != nil {
+		t.Fatal(err)
+	}
+
+	// Feed the message one byte at a time, as if read from a stream. The
+	// message is decoded from scratch after each byte, as a codec would.
+	b := NewByteBuffer()
+	for i, c := range encoded.data {
+		d := NewBinaryDecoder(b, binary.BigEndian)
+		if _, err := d.Uint8(); err != nil && err != sonicerrors.ErrNeedMore {
+			t.Fatal(err)
+		}
+		d.Reset()
+
+		_ = b.WriteByte(c)
+		if i < len(encoded.data)-1 {
+			continue
+		}
+		decodeTestMessage(t, &d)
+	}
+
+	// A truncated message is not decoded and the cursor does not move.
+	b.Reset()
+	_, _ = b.Write(encoded.data[:len(encoded.data)-1])
+	d := NewBinaryDecoder(b, binary.BigEndian)
+	if err := d.Skip(len(encoded.data) - 3); err != nil {
+		t.Fatal(err)
+	}
+	n := d.Len()
+	if _, err := d.Bytes(3); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected ErrNeedMore, got %v", err)
+	}
+	if d.Len() != n {
+		t.Fatal("cursor should not move on error")
+	}
+
+	// Varints longer than 10 bytes overflow.
+	b.Reset()
+	for i := 0; i < 11; i++ {
+		_ = b.WriteByte(0xff)
+	}
+	d = NewBinaryDecoder(b, binary.BigEndian)
+	if _, err := d.Uvarint(); err != ErrVarintOverflow {
+		t.Fatalf("expected ErrVarintOverflow, got %v", err)
+	}
+}
+
+func TestBinaryCursorViews(t *testing.T) {
+	b := NewByteBuffer()
+	e := NewBinaryEncoder(b, binary.BigEndian)
+
+	_ = e.PutUint8(1)
+	if _, err := EncodeView[testView](&e); err != ErrMisalignedView {
+		t.Fatalf("expected ErrMisalignedView, got %v", err)
+	}
+	if err := e.Align(8); err != nil || e.Len() != 8 {
+		t.Fatalf("expected 8 aligned bytes, got %d err=%v", e.Len(), err)
+	}
+	v, err := EncodeView[testView](&e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.A, v.B, v.C = 1, 2, 3
+	_ = e.PutUint8(4)
+
+	d := NewBinaryDecoder(b, binary.BigEndian)
+	_, _ = d.Uint8()
+	if _, err := DecodeView[testView](&d); err != ErrMisalignedView {
+		t.Fatalf("expected ErrMisalignedView, got %v", err)
+	}
+	if err := d.Align(8); err != nil {
+		t.Fatal(err)
+	}
+	view, err := DecodeView[testView](&d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *view != (testView{1, 2, 3}) {
+		t.Fatalf("invalid view %+v", *view)
+	}
+	if x, err := d.Uint8(); err != nil || x != 4 {
+		t.Fatalf("expected 4, got %d err=%v", x, err)
+	}
+}
+
+func TestBinaryCursorAllocs(t *testing.T) {
+	b := NewByteBuffer()
+	b.Reserve(1024)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		e := NewBinaryEncoder(b, binary.BigEndian)
+		_ = encodeTestMessage(&e)
+		b.Commit(e.Len())
+
+		d := NewBinaryDecoder(b, binary.BigEndian)
+		_, _ = d.Uint64()
+		_, _ = d.Uvarint()
+		_, _ = d.PrefixedBytes()
+		b.Reset()
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %f", allocs)
+	}
+}
+
+func BenchmarkBinaryEncoder(b *testing.B) {
+	buf := NewByteBuffer()
+	buf.Reserve(1024)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := NewBinaryEncoder(buf, binary.BigEndian)
+		if err := encodeTestMessage(&e); err != nil {
+			b.Fatal(err)
+		}
+		e.Rollback()
+	}
+}
+
+func BenchmarkBinaryDecoder(b *testing.B) {
+	buf := NewByteBuffer()
+	e := NewBinaryEncoder(buf, binary.BigEndian)
+	_ = e.Align(8)
+	v, _ := EncodeView[testView](&e)
+	v.A = 1
+	_ = e.PutUint64(2)
+	_ = e.PutVarint(-300)
+	_ = e.PutPrefixedBytes([]byte("hello"))
+	buf.Commit(e.Len())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := NewBinaryDecoder(buf, binary.BigEndian)
+		if _, err := DecodeView[testView](&d); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := d.Uint64(); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := d.Varint(); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := d.PrefixedBytes(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
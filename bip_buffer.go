@@ -1,5 +1,11 @@
 package sonic
 
+import (
+	"net"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
 // BipBuffer is a circular buffer capable of providing continuous, arbitrarily
 // sized byte chunks in a first-in-first-out manner.
 //
@@ -159,3 +165,79 @@ func (buf *BipBuffer) Size() int {
 func (buf *BipBuffer) Empty() bool {
 	return buf.Claimed() == 0 && buf.Committed() == 0
 }
+
+// AsyncReadFrom reads once from the supplied asynchronous reader into the
+// largest contiguous chunk of free space and commits the bytes read.
+//
+// The callback is invoked with sonicerrors.ErrNoBufferSpaceAvailable if the
+// buffer is full. Nothing is committed if the read fails.
+func (buf *BipBuffer) AsyncReadFrom(r AsyncReader, cb AsyncCallback) {
+	b := buf.Claim(buf.Size())
+	if b == nil {
+		cb(sonicerrors.ErrNoBufferSpaceAvailable, 0)
+		return
+	}
+	r.AsyncRead(b, func(err error, n int) {
+		if err != nil {
+			n = 0
+		}
+		buf.Commit(n)
+		cb(err, n)
+	})
+}
+
+// AsyncReadFromPacket reads one datagram from the supplied packet connection
+// and commits it. The callback is invoked with the datagram, which stays valid
+// until it is consumed.
+//
+// Datagrams are not split across the end of the buffer, so maxPacketSize
+// contiguous bytes must be free: otherwise the callback is invoked with
+// sonicerrors.ErrNoBufferSpaceAvailable, as the datagram could be truncated.
+func (buf *BipBuffer) AsyncReadFromPacket(
+	conn PacketConn,
+	maxPacketSize int,
+	cb func(err error, packet []byte, addr net.Addr),
+) {
+	b := buf.Claim(maxPacketSize)
+	if len(b) < maxPacketSize {
+		buf.Commit(0)
+		cb(sonicerrors.ErrNoBufferSpaceAvailable, nil, nil)
+		return
+	}
+	conn.AsyncReadFrom(b, func(err error, n int, addr net.Addr) {
+		if err != nil {
+			buf.Commit(0)
+			cb(err, nil, addr)
+		} else {
+			cb(nil, buf.Commit(n), addr)
+		}
+	})
+}
+
+// AsyncWriteTo writes all the committed bytes to the supplied asynchronous
+// writer, consuming them as they are written. This takes two writes if the
+// committed bytes wrap around the end of the buffer.
+//
+// The callback is invoked with the number of bytes written.
+func (buf *BipBuffer) AsyncWriteTo(w AsyncWriter, cb AsyncCallback) {
+	written := 0
+	var onWrite AsyncCallback
+	onWrite = func(err error, n int) {
+		buf.Consume(n)
+		written += n
+		if err == nil {
+			if head := buf.Head(); head != nil {
+				w.AsyncWriteAll(head, onWrite)
+				return
+			}
+		}
+		cb(err, written)
+	}
+
+	head := buf.Head()
+	if head == nil {
+		cb(nil, 0)
+		return
+	}
+	w.AsyncWriteAll(head, onWrite)
+}
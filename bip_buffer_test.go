@@ -2,7 +2,14 @@ package sonic
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
 )
 
 func TestBipBufferClaim(t *testing.T) {
@@ -297,3 +304,136 @@ func TestBipBufferCommitAfterWrapping(t *testing.T) {
 		}
 	}
 }
+
+// chunkedStream reads from src and writes to dst at most chunk bytes at a
+// time, calling the callbacks in place.
+type chunkedStream struct {
+	src   *bytes.Reader
+	dst   bytes.Buffer
+	chunk int
+}
+
+func (s *chunkedStream) AsyncRead(b []byte, cb AsyncCallback) {
+	if len(b) > s.chunk {
+		b = b[:s.chunk]
+	}
+	n, err := s.src.Read(b)
+	cb(err, n)
+}
+
+func (s *chunkedStream) AsyncReadAll(b []byte, cb AsyncCallback) {
+	n, err := io.ReadFull(s.src, b)
+	cb(err, n)
+}
+
+func (s *chunkedStream) AsyncWrite(b []byte, cb AsyncCallback) {
+	if len(b) > s.chunk {
+		b = b[:s.chunk]
+	}
+	n, err := s.dst.Write(b)
+	cb(err, n)
+}
+
+func (s *chunkedStream) AsyncWriteAll(b []byte, cb AsyncCallback) {
+	n, err := s.dst.Write(b)
+	cb(err, n)
+}
+
+func TestBipBufferAsyncReadWrite(t *testing.T) {
+	data := make([]byte, 10000)
+	rand.Read(data)
+	stream := &chunkedStream{src: bytes.NewReader(data), chunk: 7}
+
+	buf := NewBipBuffer(64)
+	for stream.src.Len() > 0 {
+		// Fill the buffer with partial reads until it is full, which wraps
+		// around once the head is consumed.
+		for {
+			var rerr error
+			buf.AsyncReadFrom(stream, func(err error, n int) {
+				rerr = err
+			})
+			if rerr == io.EOF {
+				break
+			}
+			if rerr == sonicerrors.ErrNoBufferSpaceAvailable {
+				break
+			}
+			if rerr != nil {
+				t.Fatal(rerr)
+			}
+		}
+
+		// Write part of the buffer to free its head.
+		head := buf.Head()
+		n := 1 + len(head)/2
+		_, _ = stream.dst.Write(head[:n])
+		buf.Consume(n)
+	}
+
+	written := -1
+	buf.AsyncWriteTo(stream, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = n
+	})
+	if written <= 0 || !buf.Empty() {
+		t.Fatalf("invalid write %d", written)
+	}
+	if !bytes.Equal(stream.dst.Bytes(), data) {
+		t.Fatal("corrupt bytes")
+	}
+}
+
+func TestBipBufferAsyncReadFromPacket(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := NewPacketConn(ioc, "udp", "localhost:9086")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var (
+		buf     = NewBipBuffer(100)
+		packets []string
+		results []error
+	)
+	read := func() {
+		n := len(results)
+		buf.AsyncReadFromPacket(conn, 45, func(err error, b []byte, _ net.Addr) {
+			results = append(results, err)
+			if err == nil {
+				packets = append(packets, string(b))
+			}
+		})
+		for len(results) == n {
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+
+	for i := 0; i < 8; i++ {
+		if err := sendTo([]byte(fmt.Sprintf("packet-%d", i)), "localhost:9086"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Packets of 8 bytes are read until less than 45 contiguous bytes are
+	// free.
+	for i := 0; i < 8; i++ {
+		read()
+	}
+	if len(packets) != 7 || packets[6] != "packet-6" ||
+		results[7] != sonicerrors.ErrNoBufferSpaceAvailable {
+		t.Fatalf("invalid reads %v %v", packets, results)
+	}
+
+	// Once the head is consumed, the next packet wraps around.
+	buf.Consume(48)
+	read()
+	if len(packets) != 8 || packets[7] != "packet-7" || !buf.Wrapped() {
+		t.Fatalf("invalid reads %v %v", packets, results)
+	}
+}
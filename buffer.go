@@ -0,0 +1,75 @@
+package sonic
+
+import (
+	"io"
+)
+
+// Buffer is the interface of the buffers codecs encode to and decode from.
+//
+// A Buffer has the 3 areas of a ByteBuffer. In order:
+// - save area:  bytes saved with Save, which remain valid until discarded.
+// - read area:  bytes which can be read, returned by Data.
+// - write area: bytes written but not yet committed to the read area.
+//
+// ByteBuffer is the default implementation. It grows on demand and copies the read area to the front of its memory
+// when bytes are consumed. bytes.MirroredByteBuffer has a fixed size and consumes bytes without copies.
+type Buffer interface {
+	io.Reader
+	io.ByteReader
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+	io.ReaderFrom
+	io.WriterTo
+	AsyncReaderFrom
+	AsyncWriterTo
+
+	// Reserve capacity for at least `n` more bytes to be written into the write area. Fixed size buffers which cannot
+	// hold `n` more bytes fail with sonicerrors.ErrNoBufferSpaceAvailable.
+	Reserve(n int) error
+
+	// Reserved returns the number of bytes that can be written in the write area.
+	Reserved() int
+
+	// Claim calls `fn` with the free space after the write area. `fn` returns the number of bytes written into it,
+	// which are added to the write area. If `fn` returns more bytes than it was given, nothing is added and Claim
+	// fails with sonicerrors.ErrNoBufferSpaceAvailable.
+	Claim(fn func(b []byte) int) error
+
+	// ClaimFixed adds `n` bytes to the write area and returns them. It returns nil, adding nothing, if there is not
+	// enough space.
+	ClaimFixed(n int) []byte
+
+	// Commit moves `n` bytes from the write area to the read area.
+	Commit(n int)
+
+	// PrepareRead commits enough bytes for the read area to hold `n` bytes, or returns ErrNeedMore without
+	// committing anything if the write area does not hold enough bytes.
+	PrepareRead(n int) error
+
+	// Data returns the bytes in the read area.
+	Data() []byte
+
+	SaveLen() int
+	ReadLen() int
+	WriteLen() int
+
+	// Cap returns the number of bytes the buffer can hold without growing.
+	Cap() int
+
+	// Consume removes the first `n` bytes of the read area. They cannot be referenced afterwards.
+	Consume(n int)
+
+	// Save moves the first `n` bytes of the read area to the save area, where they remain valid until discarded.
+	Save(n int) Slot
+	Saved() []byte
+	SavedSlot(slot Slot) []byte
+	Discard(slot Slot) int
+	DiscardAll()
+
+	// ShrinkBy shrinks the write area by at most `n` bytes.
+	ShrinkBy(n int) int
+
+	// Reset empties all areas.
+	Reset()
+}
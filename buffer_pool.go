@@ -0,0 +1,208 @@
+package sonic
+
+import (
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBufferSizes are the size classes of a BufferPool created without
+// explicit sizes.
+var DefaultBufferSizes = []int{512, 4096, 64 * 1024, 1024 * 1024}
+
+// BufferPool hands out ByteBuffers and BipBuffers of a few size classes, such
+// that short-lived connections reuse the buffers of the connections that came
+// before them instead of allocating their own.
+//
+// A buffer is acquired with Get or GetBipBuffer and given back with Put or
+// PutBipBuffer once it is not used anymore. Buffers are reset when given back.
+// A ByteBuffer which grew past the largest size class while in use is not kept:
+// it is left to the garbage collector, such that one large message does not pin
+// memory for the lifetime of the pool. Idle buffers are also released by the
+// garbage collector, as the size classes are backed by sync.Pools.
+//
+// In debug mode, the pool tracks the buffers which are acquired and not given
+// back, along with the stack trace of their acquisition. This is meant to catch
+// leaks in tests, see Outstanding.
+//
+// It is safe to use a BufferPool concurrently.
+type BufferPool struct {
+	sizes       []int
+	byteBuffers []sync.Pool
+	bipBuffers  []sync.Pool
+
+	debug       atomic.Bool
+	tracked     atomic.Int64
+	mu          sync.Mutex
+	outstanding map[any][]byte
+}
+
+// NewBufferPool returns a pool with the passed size classes, or with
+// DefaultBufferSizes if none are passed.
+func NewBufferPool(sizes ...int) *BufferPool {
+	if len(sizes) == 0 {
+		sizes = DefaultBufferSizes
+	}
+	sizes = append([]int(nil), sizes...)
+	sort.Ints(sizes)
+
+	p := &BufferPool{outstanding: make(map[any][]byte)}
+	for _, size := range sizes {
+		if size <= 0 || (len(p.sizes) > 0 && p.sizes[len(p.sizes)-1] == size) {
+			continue
+		}
+		p.sizes = append(p.sizes, size)
+	}
+	if len(p.sizes) == 0 {
+		p.sizes = append(p.sizes, DefaultBufferSizes...)
+	}
+
+	p.byteBuffers = make([]sync.Pool, len(p.sizes))
+	p.bipBuffers = make([]sync.Pool, len(p.sizes))
+	for i := range p.sizes {
+		size := p.sizes[i]
+		p.byteBuffers[i].New = func() interface{} {
+			return &ByteBuffer{data: make([]byte, 0, size)}
+		}
+		p.bipBuffers[i].New = func() interface{} {
+			return NewBipBuffer(size)
+		}
+	}
+	return p
+}
+
+// Sizes returns the size classes of the pool, in increasing order.
+func (p *BufferPool) Sizes() []int {
+	return append([]int(nil), p.sizes...)
+}
+
+// class returns the index of the smallest size class holding n bytes, or -1 if
+// there is none.
+func (p *BufferPool) class(n int) int {
+	i := sort.SearchInts(p.sizes, n)
+	if i == len(p.sizes) {
+		return -1
+	}
+	return i
+}
+
+// Get returns an empty ByteBuffer in which at least n bytes can be written
+// without allocating.
+//
+// If n is larger than the largest size class, the buffer is allocated and is
+// not kept by the pool once given back.
+func (p *BufferPool) Get(n int) *ByteBuffer {
+	var b *ByteBuffer
+	if i := p.class(n); i >= 0 {
+		b = p.byteBuffers[i].Get().(*ByteBuffer)
+	} else {
+		b = &ByteBuffer{data: make([]byte, 0, n)}
+	}
+	p.track(b)
+	return b
+}
+
+// Put gives back a ByteBuffer acquired with Get. The buffer must not be used
+// after this call.
+func (p *BufferPool) Put(b *ByteBuffer) {
+	p.untrack(b)
+
+	b.Reset()
+	// A buffer's capacity cannot be reduced in place: ShrinkTo only shrinks its
+	// write area. A buffer which grew past the largest class is dropped rather
+	// than kept at its grown size.
+	if cap(b.data) > p.sizes[len(p.sizes)-1] {
+		return
+	}
+	// The buffer may have grown while in use, in which case it goes to the
+	// largest class it can serve.
+	i := sort.SearchInts(p.sizes, cap(b.data))
+	if i == len(p.sizes) || p.sizes[i] != cap(b.data) {
+		i--
+	}
+	if i >= 0 {
+		p.byteBuffers[i].Put(b)
+	}
+}
+
+// Release gives back the passed buffer if it is a ByteBuffer, see Put. Other
+// buffers are ignored.
+func (p *BufferPool) Release(b Buffer) {
+	if bb, ok := b.(*ByteBuffer); ok && bb != nil {
+		p.Put(bb)
+	}
+}
+
+// GetBipBuffer returns an empty BipBuffer of at least n bytes.
+//
+// If n is larger than the largest size class, the buffer is allocated and is
+// not kept by the pool once given back.
+func (p *BufferPool) GetBipBuffer(n int) *BipBuffer {
+	var b *BipBuffer
+	if i := p.class(n); i >= 0 {
+		b = p.bipBuffers[i].Get().(*BipBuffer)
+	} else {
+		b = NewBipBuffer(n)
+	}
+	p.track(b)
+	return b
+}
+
+// PutBipBuffer gives back a BipBuffer acquired with GetBipBuffer. The buffer
+// must not be used after this call.
+func (p *BufferPool) PutBipBuffer(b *BipBuffer) {
+	p.untrack(b)
+
+	b.Reset()
+	if i := p.class(b.Size()); i >= 0 && p.sizes[i] == b.Size() {
+		p.bipBuffers[i].Put(b)
+	}
+}
+
+// SetDebug enables or disables the tracking of acquired buffers.
+//
+// Buffers acquired while debug mode is disabled are not tracked, so it should be
+// enabled before any buffer is acquired: in debug mode, giving back a buffer
+// which is not tracked panics, as it is assumed to be given back twice.
+func (p *BufferPool) SetDebug(debug bool) {
+	p.debug.Store(debug)
+}
+
+// Outstanding returns the stack traces of the acquisitions of the buffers which
+// were not given back, in debug mode.
+func (p *BufferPool) Outstanding() (stacks []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, stack := range p.outstanding {
+		stacks = append(stacks, string(stack))
+	}
+	sort.Strings(stacks)
+	return stacks
+}
+
+func (p *BufferPool) track(b any) {
+	if p.debug.Load() {
+		p.mu.Lock()
+		p.outstanding[b] = debug.Stack()
+		p.tracked.Store(int64(len(p.outstanding)))
+		p.mu.Unlock()
+	}
+}
+
+func (p *BufferPool) untrack(b any) {
+	if !p.debug.Load() && p.tracked.Load() == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.outstanding[b]; ok {
+		delete(p.outstanding, b)
+		p.tracked.Store(int64(len(p.outstanding)))
+	} else if p.debug.Load() {
+		panic("sonic: buffer given back twice or not acquired from the pool")
+	}
+}
@@ -0,0 +1,131 @@
+package sonic
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBufferPoolSizeClasses(t *testing.T) {
+	pool := NewBufferPool(4096, 512, 512, -1)
+	if sizes := pool.Sizes(); len(sizes) != 2 || sizes[0] != 512 || sizes[1] != 4096 {
+		t.Fatalf("invalid size classes %v", sizes)
+	}
+
+	for _, c := range []struct {
+		n, cap int
+	}{
+		{0, 512},
+		{100, 512},
+		{512, 512},
+		{513, 4096},
+		{4096, 4096},
+		{5000, 5000},
+	} {
+		b := pool.Get(c.n)
+		if b.Cap() != c.cap || b.Reserved() < c.n || b.Len() != 0 {
+			t.Fatalf("Get(%d): invalid buffer cap=%d reserved=%d len=%d",
+				c.n, b.Cap(), b.Reserved(), b.Len())
+		}
+		pool.Put(b)
+	}
+
+	// Buffers are reset when given back.
+	b := pool.Get(100)
+	_, _ = b.Write([]byte("hello"))
+	b.Commit(2)
+	pool.Put(b)
+	if b.Len() != 0 || b.ReadLen() != 0 || b.WriteLen() != 0 {
+		t.Fatal("buffer should be reset")
+	}
+
+	// A buffer which grew is served for the largest class it can hold.
+	b = pool.Get(100)
+	b.Reserve(2000)
+	pool.Put(b)
+	for i := 0; i < 10; i++ {
+		b = pool.Get(512)
+		if b.Reserved() < 512 {
+			t.Fatalf("invalid buffer reserved=%d", b.Reserved())
+		}
+		defer pool.Put(b)
+	}
+
+	bip := pool.GetBipBuffer(1000)
+	if bip.Size() != 4096 {
+		t.Fatalf("invalid bip buffer size %d", bip.Size())
+	}
+	bip.Commit(len(bip.Claim(10)))
+	pool.PutBipBuffer(bip)
+	if !bip.Empty() {
+		t.Fatal("bip buffer should be reset")
+	}
+	if bip = pool.GetBipBuffer(10000); bip.Size() != 10000 {
+		t.Fatalf("invalid bip buffer size %d", bip.Size())
+	}
+	pool.PutBipBuffer(bip)
+}
+
+func TestBufferPoolDebug(t *testing.T) {
+	pool := NewBufferPool()
+	pool.SetDebug(true)
+
+	b := pool.Get(100)
+	bip := pool.GetBipBuffer(100)
+	if stacks := pool.Outstanding(); len(stacks) != 2 {
+		t.Fatalf("expected 2 outstanding buffers, got %d", len(stacks))
+	}
+
+	pool.Put(b)
+	stacks := pool.Outstanding()
+	if len(stacks) != 1 || !strings.Contains(stacks[0], "TestBufferPoolDebug") {
+		t.Fatalf("invalid outstanding buffers %v", stacks)
+	}
+	pool.PutBipBuffer(bip)
+	if stacks := pool.Outstanding(); len(stacks) != 0 {
+		t.Fatalf("expected no outstanding buffers, got %v", stacks)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("giving back a buffer twice should panic")
+			}
+		}()
+		pool.Put(b)
+	}()
+}
+
+func TestCodecConnBufferPool(t *testing.T) {
+	pool := NewBufferPool()
+	pool.SetDebug(true)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	stream, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, dst := pool.Get(1024), pool.Get(1024)
+	conn, err := NewCodecConn[TestItem, TestItem](stream, &TestCodec{}, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetBufferPool(pool)
+
+	if stacks := pool.Outstanding(); len(stacks) != 2 {
+		t.Fatalf("expected 2 outstanding buffers, got %d", len(stacks))
+	}
+	_ = conn.Close()
+	if stacks := pool.Outstanding(); len(stacks) != 0 {
+		t.Fatalf("expected no outstanding buffers, got %v", stacks)
+	}
+}
@@ -37,6 +37,8 @@ type ByteBuffer struct {
 }
 
 var (
+	_ Buffer = &ByteBuffer{}
+
 	_ io.Reader      = &ByteBuffer{}
 	_ io.ByteReader  = &ByteBuffer{}
 	_ io.ByteScanner = &ByteBuffer{}
@@ -62,13 +64,15 @@ func NewByteBuffer() *ByteBuffer {
 // into the ByteBuffer's write area.
 //
 // This call grows the write area by at least `n` bytes. This might allocate.
-func (b *ByteBuffer) Reserve(n int) {
+// It never fails.
+func (b *ByteBuffer) Reserve(n int) error {
 	existing := cap(b.data) - b.wi
 	if need := n - existing; need > 0 {
 		b.data = b.data[:cap(b.data)]
 		b.data = append(b.data, make([]byte, need)...)
 	}
 	b.data = b.data[:b.wi]
+	return nil
 }
 
 // Reserved returns the number of bytes that can be written
@@ -356,19 +360,26 @@ func (b *ByteBuffer) PrepareRead(n int) (err error) {
 //
 // Callers have the option to write less than they claim. The amount is returned
 // in the callback and the unused bytes will be used in future claims.
-func (b *ByteBuffer) Claim(fn func(b []byte) int) {
+//
+// If `fn` claims more bytes than it was given, nothing is claimed and
+// ErrNoBufferSpaceAvailable is returned.
+func (b *ByteBuffer) Claim(fn func(b []byte) int) error {
 	n := fn(b.data[b.wi:cap(b.data)])
-	if wi := b.wi + n; n >= 0 && wi <= cap(b.data) {
-		// wi <= cap(b.data) because the invariant is that b.wi = min(len(b.data), cap(b.data)) after each call
-		b.wi = wi
-		b.data = b.data[:b.wi]
+	wi := b.wi + n
+	if n < 0 || wi > cap(b.data) {
+		return sonicerrors.ErrNoBufferSpaceAvailable
 	}
+	// wi <= cap(b.data) because the invariant is that b.wi = min(len(b.data), cap(b.data)) after each call
+	b.wi = wi
+	b.data = b.data[:b.wi]
+	return nil
 }
 
 // ClaimFixed claims a fixed byte slice from the write area.
 //
 // Callers do not have the option to write less than they claim. The write area
-// will grow by `n`.
+// will grow by `n`. Nothing is claimed, and nil is returned, if the write area
+// cannot hold `n` more bytes: see Reserve.
 func (b *ByteBuffer) ClaimFixed(n int) (claimed []byte) {
 	if wi := b.wi + n; n >= 0 && wi <= cap(b.data) {
 		claimed = b.data[b.wi:wi]
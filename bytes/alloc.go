@@ -0,0 +1,139 @@
+package bytes
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// HugePages selects the pages backing the memory of a buffer.
+type HugePages uint8
+
+const (
+	// HugePagesNone backs the buffer with the system's regular pages.
+	HugePagesNone HugePages = iota
+
+	// HugePagesTransparent asks the kernel to back the buffer with transparent
+	// huge pages through madvise(MADV_HUGEPAGE). The kernel may still back
+	// parts of the buffer with regular pages if it cannot find contiguous
+	// physical memory. On Linux, a MirroredBuffer is then backed by a memfd
+	// rather than by a file in /dev/shm, as shmem_enabled only governs the
+	// former.
+	HugePagesTransparent
+
+	// HugePagesExplicit backs the buffer with huge pages reserved by the
+	// administrator in a hugetlbfs pool, as MAP_HUGETLB does. It falls back to
+	// HugePagesTransparent if there is no hugetlbfs mount or if the pool does
+	// not have enough free pages.
+	HugePagesExplicit
+)
+
+func (h HugePages) String() string {
+	switch h {
+	case HugePagesNone:
+		return "none"
+	case HugePagesTransparent:
+		return "transparent"
+	case HugePagesExplicit:
+		return "explicit"
+	default:
+		return fmt.Sprintf("HugePages(%d)", uint8(h))
+	}
+}
+
+// AllocOptions control how the memory of a buffer is allocated.
+//
+// Each option is a request: if the system does not grant it, the buffer is
+// still allocated without it and the reason is reported in the buffer's
+// Allocation.
+type AllocOptions struct {
+	// Prefault backs the buffer with physical memory at allocation time,
+	// after the NUMA policy is applied.
+	Prefault bool
+
+	// HugePages selects the pages backing the buffer. If huge pages are
+	// requested, the buffer's size is rounded up to a multiple of the huge page
+	// size.
+	HugePages HugePages
+
+	// Lock locks the buffer in RAM with mlock, such that it is never paged
+	// out. The locked size counts against RLIMIT_MEMLOCK.
+	Lock bool
+
+	// BindNUMA binds the buffer's memory to NUMANode with mbind. Use
+	// util.NUMANodeOf to get the node of the CPU the IO thread is pinned to.
+	BindNUMA bool
+	NUMANode int
+}
+
+// Allocation reports what was granted when allocating a buffer.
+type Allocation struct {
+	// PageSize is the size of the pages backing the buffer.
+	PageSize int
+
+	HugePages  HugePages
+	Prefaulted bool
+	Locked     bool
+
+	// NUMANode is the node the buffer's memory is bound to, or -1 if it is not
+	// bound.
+	NUMANode int
+
+	// Fallbacks holds, for each requested option which was not granted, the
+	// reason why.
+	Fallbacks []error
+}
+
+func (a Allocation) String() string {
+	var fallbacks []string
+	for _, err := range a.Fallbacks {
+		fallbacks = append(fallbacks, err.Error())
+	}
+	return fmt.Sprintf(
+		"page_size=%d huge_pages=%s prefaulted=%t locked=%t numa_node=%d fallbacks=[%s]",
+		a.PageSize,
+		a.HugePages,
+		a.Prefaulted,
+		a.Locked,
+		a.NUMANode,
+		strings.Join(fallbacks, "; "),
+	)
+}
+
+func (a *Allocation) fallback(option string, err error) {
+	a.Fallbacks = append(a.Fallbacks, fmt.Errorf("%s: %w", option, err))
+}
+
+// applyPolicies applies the NUMA, locking and prefaulting options to the
+// passed memory, in this order such that the pages are faulted on the right
+// node. Options which are not granted are recorded as fallbacks.
+func (a *Allocation) applyPolicies(b []byte, opts AllocOptions) {
+	if opts.BindNUMA {
+		if err := bindNUMA(b, opts.NUMANode); err != nil {
+			a.fallback("numa", err)
+		} else {
+			a.NUMANode = opts.NUMANode
+		}
+	}
+
+	if opts.Lock {
+		if err := syscall.Mlock(b); err != nil {
+			if err == syscall.ENOMEM || err == syscall.EPERM {
+				err = fmt.Errorf("%w (check RLIMIT_MEMLOCK)", err)
+			}
+			a.fallback("lock", err)
+		} else {
+			// Locking faults in all the pages.
+			a.Locked = true
+			a.Prefaulted = true
+		}
+	}
+
+	if opts.Prefault && !a.Prefaulted {
+		// Touching a byte per page is enough to fault it in.
+		for i := 0; i < len(b); i += a.PageSize {
+			b[i] = 0
+		}
+		a.Prefaulted = true
+	}
+}
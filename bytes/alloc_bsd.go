@@ -0,0 +1,30 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package bytes
+
+import (
+	"errors"
+	"os"
+)
+
+var errAllocUnsupported = errors.New("not supported on this platform")
+
+func hugetlbfs() (directory string, pageSize int, err error) {
+	return "", 0, errAllocUnsupported
+}
+
+func transparentHugePages() (pageSize int, err error) {
+	return 0, errAllocUnsupported
+}
+
+func memfd(string) (*os.File, error) {
+	return nil, errAllocUnsupported
+}
+
+func adviseHugePages([]byte) error {
+	return errAllocUnsupported
+}
+
+func bindNUMA([]byte, int) error {
+	return errAllocUnsupported
+}
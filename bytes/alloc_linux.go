@@ -0,0 +1,109 @@
+//go:build linux
+
+package bytes
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const mpolBind = 2 // MPOL_BIND, see mbind(2)
+
+// hugetlbfs returns the first hugetlbfs mount point, along with the size of
+// its huge pages.
+func hugetlbfs() (directory string, pageSize int, err error) {
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[2] != "hugetlbfs" {
+			continue
+		}
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(fields[1], &stat); err != nil {
+			continue
+		}
+		return fields[1], int(stat.Bsize), nil
+	}
+	return "", 0, errors.New("no hugetlbfs mount")
+}
+
+// transparentHugePages returns the size of transparent huge pages if the
+// kernel backs its internal shared memory, that of memfd files, with them,
+// either always or when advised to. The files of other tmpfs mounts, like
+// /dev/shm, follow the mount's huge= option instead.
+func transparentHugePages() (pageSize int, err error) {
+	const path = "/sys/kernel/mm/transparent_hugepage/shmem_enabled"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	// The selected mode is bracketed: "always within_size [advise] never".
+	mode := string(data)
+	if i := strings.IndexByte(mode, '['); i >= 0 {
+		mode = mode[i+1:]
+		if j := strings.IndexByte(mode, ']'); j >= 0 {
+			mode = mode[:j]
+		}
+	}
+	if mode == "never" || mode == "deny" {
+		return 0, fmt.Errorf("disabled for shared memory in %s", path)
+	}
+
+	data, err = os.ReadFile("/sys/kernel/mm/transparent_hugepage/hpage_pmd_size")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// memfd returns an anonymous file in the kernel's internal shared memory, see
+// memfd_create(2).
+func memfd(name string) (*os.File, error) {
+	fd, err := unix.MemfdCreate(name, unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("memfd_create", err)
+	}
+	return os.NewFile(uintptr(fd), "memfd:"+name), nil
+}
+
+func adviseHugePages(b []byte) error {
+	return unix.Madvise(b, unix.MADV_HUGEPAGE)
+}
+
+func bindNUMA(b []byte, node int) error {
+	if node < 0 {
+		return fmt.Errorf("invalid node %d", node)
+	}
+	mask := make([]uint64, node/64+1)
+	mask[node/64] |= 1 << (node % 64)
+
+	/* #nosec G103 -- the use of unsafe has been audited */
+	_, _, errno := unix.Syscall6(
+		unix.SYS_MBIND,
+		uintptr(unsafe.Pointer(&b[0])),
+		uintptr(len(b)),
+		mpolBind,
+		uintptr(unsafe.Pointer(&mask[0])),
+		uintptr(len(mask)*64+1),
+		0,
+	)
+	if errno != 0 {
+		return fmt.Errorf("could not bind to node %d: %w", node, errno)
+	}
+	return nil
+}
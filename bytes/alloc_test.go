@@ -0,0 +1,100 @@
+package bytes
+
+import (
+	"log"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/talostrading/sonic/util"
+)
+
+func TestMirroredBufferWithOptions(t *testing.T) {
+	node, err := util.NUMANodeOf(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hugePages := range []HugePages{
+		HugePagesNone,
+		HugePagesTransparent,
+		HugePagesExplicit,
+	} {
+		opts := AllocOptions{
+			Prefault:  true,
+			HugePages: hugePages,
+			Lock:      true,
+			BindNUMA:  true,
+			NUMANode:  node,
+		}
+		buf, err := NewMirroredBufferWithOptions(syscall.Getpagesize(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		alloc := buf.Allocation()
+		log.Printf("requested huge_pages=%s granted %s", hugePages, alloc)
+
+		// Whatever was not granted must be explained.
+		fallbacks := 0
+		if alloc.HugePages != hugePages {
+			fallbacks++
+		}
+		if alloc.HugePages == HugePagesNone && hugePages == HugePagesExplicit {
+			// Explicit huge pages fall back to transparent ones first.
+			fallbacks++
+		}
+		if !alloc.Locked {
+			fallbacks++
+		}
+		if alloc.NUMANode != node {
+			fallbacks++
+		}
+		if len(alloc.Fallbacks) != fallbacks {
+			t.Fatalf("expected %d fallbacks, got %v", fallbacks, alloc.Fallbacks)
+		}
+		if !alloc.Prefaulted {
+			t.Fatal("buffer should be prefaulted")
+		}
+		if alloc.HugePages == HugePagesTransparent &&
+			!strings.HasPrefix(buf.Name(), "memfd:") {
+			// shmem_enabled does not govern the files of /dev/shm.
+			t.Fatalf("transparent huge pages granted for %s", buf.Name())
+		}
+		if alloc.PageSize < syscall.Getpagesize() ||
+			buf.Size()%alloc.PageSize != 0 {
+			t.Fatalf(
+				"invalid page_size=%d for size=%d", alloc.PageSize, buf.Size())
+		}
+
+		// The buffer is mirrored regardless of what was granted.
+		buf.Commit(buf.Size() - 1)
+		buf.Consume(buf.Size() - 1)
+		b := buf.Claim(2)
+		b[0], b[1] = 1, 2
+		buf.Commit(2)
+		if buf.slice[0] != 2 || buf.Head()[1] != 2 {
+			t.Fatal("buffer is not mirrored")
+		}
+
+		if err := buf.Destroy(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMirroredBufferWithOptionsInvalidNode(t *testing.T) {
+	buf, err := NewMirroredBufferWithOptions(
+		syscall.Getpagesize(),
+		AllocOptions{BindNUMA: true, NUMANode: 1 << 20},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Destroy()
+
+	if alloc := buf.Allocation(); alloc.NUMANode != -1 ||
+		len(alloc.Fallbacks) != 1 {
+		t.Fatalf("the buffer should not be bound %s", alloc)
+	}
+}
@@ -0,0 +1,194 @@
+package bytes
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+)
+
+const (
+	recordHeaderLen = 4
+	recordAlign     = 8
+)
+
+// ByteRing is a bounded single-producer/single-consumer queue of
+// variable-length byte records.
+//
+// The records are stored back to back in a MirroredBuffer, each prefixed by
+// its length, so a record is always a contiguous byte slice, even when it
+// wraps around the end of the buffer. The producer writes records in place
+// with Claim and Commit, and the consumer reads them in place with Peek and
+// Consume: records are not copied.
+//
+// The producer and the consumer each own a cursor, which they publish to the
+// other side after each Commit or Consume, and cache the last cursor they read
+// from the other side, so they only touch the other side's cache line when
+// the ring looks full or empty.
+//
+// If the ring is notified, a consumer waiting for records sleeps instead of
+// busy-spinning and can wait from a sonic.IO through a ByteRingReader.
+type ByteRing struct {
+	buf  *MirroredBuffer
+	size uint64
+
+	published cursor // tail, shared with the consumer
+	consumed  cursor // head, shared with the producer
+
+	// Producer state.
+	_          cacheLinePad
+	tail       uint64
+	cachedHead uint64
+	claimed    int
+
+	// Consumer state.
+	_          cacheLinePad
+	head       uint64
+	cachedTail uint64
+	_          cacheLinePad
+
+	waiter
+
+	closed atomic.Bool
+}
+
+// NewByteRing returns a ring over a MirroredBuffer of at least the passed
+// size. See NewMirroredBuffer.
+//
+// If notify is true, the consumer sleeps while waiting for records and the
+// producer wakes it up, which costs a syscall when it does. Otherwise, the
+// consumer busy-spins, which is best when it has a core of its own.
+func NewByteRing(size int, notify bool) (*ByteRing, error) {
+	buf, err := NewMirroredBuffer(size, false)
+	if err != nil {
+		return nil, err
+	}
+	r := &ByteRing{
+		buf:  buf,
+		size: uint64(buf.Size()),
+	}
+	if err := r.waiter.init(notify); err != nil {
+		_ = buf.Destroy()
+		return nil, err
+	}
+	return r, nil
+}
+
+// recordLen returns the number of bytes taken by a record of n bytes in the
+// buffer. Records are aligned such that their header is.
+func recordLen(n int) uint64 {
+	return uint64(recordHeaderLen+n+recordAlign-1) &^ (recordAlign - 1)
+}
+
+// MaxRecordLen returns the length of the largest record the ring can hold.
+func (r *ByteRing) MaxRecordLen() int {
+	return int(r.size) - recordHeaderLen
+}
+
+// Claim returns a slice of n bytes in which the producer writes the next
+// record. It returns nil if the ring does not have enough free space. The
+// record is published by Commit.
+func (r *ByteRing) Claim(n int) []byte {
+	if n < 0 || n > r.MaxRecordLen() {
+		return nil
+	}
+	need := recordLen(n)
+	if r.tail-r.cachedHead+need > r.size {
+		r.cachedHead = r.consumed.Load()
+		if r.tail-r.cachedHead+need > r.size {
+			return nil
+		}
+	}
+
+	r.claimed = n
+	start := r.tail%r.size + recordHeaderLen
+	return r.buf.slice[start : start+uint64(n) : start+uint64(n)]
+}
+
+// Commit publishes the first n bytes of the last claimed slice as a record.
+func (r *ByteRing) Commit(n int) {
+	if n > r.claimed {
+		n = r.claimed
+	}
+	if n < 0 {
+		return
+	}
+	r.claimed = 0
+
+	start := r.tail % r.size
+	binary.LittleEndian.PutUint32(r.buf.slice[start:], uint32(n))
+	r.tail += recordLen(n)
+	r.published.Store(r.tail)
+	r.notify()
+}
+
+// Write copies b in the ring as a record. It returns false if the ring does
+// not have enough free space.
+func (r *ByteRing) Write(b []byte) bool {
+	claimed := r.Claim(len(b))
+	if claimed == nil {
+		return false
+	}
+	r.Commit(copy(claimed, b))
+	return true
+}
+
+// Peek returns the next record, or nil if the ring is empty. The record
+// remains valid and in the ring until Consume is called.
+func (r *ByteRing) Peek() []byte {
+	if r.empty() {
+		return nil
+	}
+
+	start := r.head % r.size
+	n := uint64(binary.LittleEndian.Uint32(r.buf.slice[start:]))
+	start += recordHeaderLen
+	return r.buf.slice[start : start+n : start+n]
+}
+
+// Consume removes the record returned by Peek from the ring, making its space
+// available to the producer.
+func (r *ByteRing) Consume() {
+	if r.empty() {
+		return
+	}
+	n := binary.LittleEndian.Uint32(r.buf.slice[r.head%r.size:])
+	r.head += recordLen(int(n))
+	r.consumed.Store(r.head)
+}
+
+func (r *ByteRing) empty() bool {
+	if r.head == r.cachedTail {
+		r.cachedTail = r.published.Load()
+	}
+	return r.head == r.cachedTail
+}
+
+// Wait blocks until the ring is not empty, busy-spinning or sleeping depending
+// on how the ring was created.
+func (r *ByteRing) Wait() error {
+	return r.wait(r.ready)
+}
+
+func (r *ByteRing) ready() bool {
+	return r.Peek() != nil
+}
+
+// Len returns the number of bytes taken by the records in the ring, including
+// their headers. It is only accurate when called by the consumer while the
+// producer does not commit.
+func (r *ByteRing) Len() int {
+	return int(r.published.Load() - r.head)
+}
+
+func (r *ByteRing) Size() int {
+	return int(r.size)
+}
+
+// Close releases the memory and the notification resources of the ring. It
+// must be called once the producer and the consumer are done with the ring.
+func (r *ByteRing) Close() error {
+	if !r.closed.CompareAndSwap(false, true) {
+		return ErrRingClosed
+	}
+	_ = r.waiter.close()
+	return r.buf.Destroy()
+}
@@ -0,0 +1,216 @@
+package bytes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// record returns the i-th record written by the tests: its index followed by
+// a variable number of bytes.
+func record(i int) []byte {
+	b := make([]byte, 8+i%300)
+	binary.LittleEndian.PutUint64(b, uint64(i))
+	for j := 8; j < len(b); j++ {
+		b[j] = byte(i + j)
+	}
+	return b
+}
+
+func produce(ring *ByteRing, n int) {
+	for i := 0; i < n; i++ {
+		b := record(i)
+		for {
+			if claimed := ring.Claim(len(b)); claimed != nil {
+				ring.Commit(copy(claimed, b))
+				break
+			}
+			runtime.Gosched()
+		}
+	}
+}
+
+func TestByteRingClaimCommit(t *testing.T) {
+	ring, err := NewByteRing(syscall.Getpagesize(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+
+	if ring.Peek() != nil {
+		t.Fatal("ring should be empty")
+	}
+	if ring.Claim(ring.MaxRecordLen()+1) != nil {
+		t.Fatal("should not claim more than the ring holds")
+	}
+
+	// Records wrap around the end of the buffer.
+	for i := 0; i < 1000; i++ {
+		claimed := ring.Claim(100)
+		if len(claimed) != 100 {
+			t.Fatal("invalid claim")
+		}
+		payload := bytes.Repeat([]byte{byte(i)}, 100)
+		copy(claimed, payload)
+		ring.Commit(50)
+
+		if !ring.Write(nil) {
+			t.Fatal("should write an empty record")
+		}
+
+		if b := ring.Peek(); !bytes.Equal(b, payload[:50]) {
+			t.Fatalf("invalid record %d", i)
+		}
+		ring.Consume()
+		if b := ring.Peek(); b == nil || len(b) != 0 {
+			t.Fatalf("invalid empty record %d", i)
+		}
+		ring.Consume()
+	}
+	if ring.Peek() != nil || ring.Len() != 0 {
+		t.Fatal("ring should be empty")
+	}
+
+	// Fill the ring.
+	n := 0
+	for ring.Write(make([]byte, 60)) {
+		n++
+	}
+	if n != ring.Size()/64 {
+		t.Fatalf("wrote %d records", n)
+	}
+	ring.Consume()
+	if !ring.Write(make([]byte, 60)) {
+		t.Fatal("consuming should free space")
+	}
+}
+
+func TestByteRingProducerConsumer(t *testing.T) {
+	for _, notify := range []bool{false, true} {
+		ring, err := NewByteRing(4*syscall.Getpagesize(), notify)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const n = 20000
+		go produce(ring, n)
+
+		for i := 0; i < n; i++ {
+			if err := ring.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			if b := ring.Peek(); !bytes.Equal(b, record(i)) {
+				t.Fatalf("notify=%v: invalid record %d", notify, i)
+			}
+			ring.Consume()
+		}
+		_ = ring.Close()
+	}
+}
+
+func TestByteRingReader(t *testing.T) {
+	ring, err := NewByteRing(syscall.Getpagesize(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	if _, err := NewByteRingReader(ioc, &ByteRing{}); !errors.Is(
+		err, ErrRingNotNotified) {
+		t.Fatalf("expected ErrRingNotNotified, got %v", err)
+	}
+	reader, err := NewByteRingReader(ioc, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10000
+	go produce(ring, n)
+
+	read := 0
+	var onNext func(error, []byte)
+	onNext = func(err error, b []byte) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, record(read)) {
+			t.Fatalf("invalid record %d", read)
+		}
+		read++
+		if read < n {
+			reader.AsyncNext(onNext)
+		}
+	}
+	reader.AsyncNext(onNext)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for read < n && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if read != n {
+		t.Fatalf("read %d records, expected %d", read, n)
+	}
+
+	// Records are copied by AsyncRead, provided they fit.
+	ring.Write([]byte("hello"))
+	var (
+		b       = make([]byte, 4)
+		results []error
+	)
+	onRead := func(err error, n int) {
+		results = append(results, err)
+		if err == nil && string(b[:n]) != "hello" {
+			t.Fatalf("invalid record %q", b[:n])
+		}
+	}
+	reader.AsyncRead(b, onRead)
+	b = make([]byte, 5)
+	reader.AsyncRead(b, onRead)
+	if len(results) != 2 || results[0] != io.ErrShortBuffer || results[1] != nil {
+		t.Fatalf("invalid results %v", results)
+	}
+
+	// A pending read can be cancelled.
+	reader.AsyncRead(b, onRead)
+	reader.Cancel()
+	if len(results) != 3 || results[2] != sonicerrors.ErrCancelled {
+		t.Fatalf("invalid results %v", results)
+	}
+}
+
+func BenchmarkByteRing(b *testing.B) {
+	ring, err := NewByteRing(1024*1024, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ring.Close()
+
+	payload := make([]byte, 64)
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			for !ring.Write(payload) {
+				runtime.Gosched()
+			}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		for ring.Peek() == nil {
+			runtime.Gosched()
+		}
+		ring.Consume()
+	}
+}
@@ -10,9 +10,13 @@ package bytes
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"syscall"
 	"unsafe"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
 )
 
 // MirroredBuffer is a circular FIFO buffer that always returns continuous byte
@@ -41,10 +45,11 @@ import (
 // address is then used to mmap the shared memory file twice, consecutively.
 // This is done in the locally defined `remap()` function in the constructor.
 type MirroredBuffer struct {
-	slice    []byte
-	size     int
-	sizeMask int
-	name     string
+	slice   []byte
+	mapping []byte // the reserved area holding slice, see mapMirrored
+	size    int
+	name    string
+	alloc   Allocation
 
 	// state
 	head int
@@ -64,7 +69,19 @@ type MirroredBuffer struct {
 // MirroredBuffer.Prefault().
 //
 // It is safe to call NewMirroredBuffer concurrently.
-func NewMirroredBuffer(size int, prefault bool) (b *MirroredBuffer, err error) {
+func NewMirroredBuffer(size int, prefault bool) (*MirroredBuffer, error) {
+	return NewMirroredBufferWithOptions(size, AllocOptions{Prefault: prefault})
+}
+
+// NewMirroredBufferWithOptions returns a mirrored buffer of at least the passed
+// size, allocated as requested by the passed options. Options which are not
+// granted by the system are reported in MirroredBuffer.Allocation().
+//
+// It is safe to call NewMirroredBufferWithOptions concurrently.
+func NewMirroredBufferWithOptions(
+	size int,
+	opts AllocOptions,
+) (b *MirroredBuffer, err error) {
 	defer func() {
 		// NOTE: We must ensure the mapping is destroyed in case the constructor
 		// fails. This means you should never write `err :=` below. Always write
@@ -75,62 +92,199 @@ func NewMirroredBuffer(size int, prefault bool) (b *MirroredBuffer, err error) {
 		}
 	}()
 
-	pageSize := syscall.Getpagesize()
-	if remainder := size % pageSize; remainder > 0 {
-		size += pageSize - remainder
-	}
 	if size <= 0 {
 		return nil, fmt.Errorf("invalid buffer size %d", size)
 	}
 
 	b = &MirroredBuffer{
-		slice:    nil,
-		size:     size,
-		sizeMask: size - 1,
+		slice: nil,
+		size:  size,
 
 		head: 0,
 		tail: 0,
 		used: 0,
+
+		alloc: Allocation{NUMANode: -1},
 	}
 
-	// TODO location should be logged to syslog
-	directory := "/dev/shm"
-	if _, err = os.Stat(directory); os.IsNotExist(err) {
-		directory = ""
+	hugePages := opts.HugePages
+	if hugePages == HugePagesExplicit {
+		directory, pageSize, err := hugetlbfs()
+		var file *os.File
+		if err == nil {
+			file, err = createTemp(directory)
+		}
+		if err == nil {
+			err = b.mirror(file, pageSize, pageSize)
+			_ = file.Close()
+		}
+		if err != nil {
+			b.alloc.fallback("explicit huge pages", err)
+			hugePages = HugePagesTransparent
+		}
 	}
-	var file *os.File
-	file, err = os.CreateTemp(directory, "sonic-mirrored-buffer-")
+
+	if b.slice == nil {
+		var file *os.File
+		pageSize, align := syscall.Getpagesize(), syscall.Getpagesize()
+		if hugePages == HugePagesTransparent {
+			// The buffer is backed by a memfd, for which the kernel grants
+			// transparent huge pages as transparentHugePages reports. The
+			// buffer and both of its mappings must be aligned to the huge
+			// page size for the kernel to back them with huge pages.
+			hugePageSize, err := transparentHugePages()
+			if err == nil {
+				file, err = memfd("sonic-mirrored-buffer")
+			}
+			if err != nil {
+				b.alloc.fallback("transparent huge pages", err)
+				hugePages = HugePagesNone
+			} else {
+				align = hugePageSize
+			}
+		}
+
+		if file == nil {
+			// TODO location should be logged to syslog
+			directory := "/dev/shm"
+			if _, err = os.Stat(directory); os.IsNotExist(err) {
+				directory = ""
+			}
+			if file, err = createTemp(directory); err != nil {
+				return nil, err
+			}
+		}
+
+		err = b.mirror(file, pageSize, align)
+		_ = file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if hugePages == HugePagesTransparent {
+			if err := adviseHugePages(b.slice); err != nil {
+				b.alloc.fallback("transparent huge pages", err)
+			} else {
+				b.alloc.HugePages = HugePagesTransparent
+				b.alloc.PageSize = align
+			}
+		}
+	}
+
+	b.alloc.applyPolicies(b.slice[:b.size], opts)
+
+	return b, nil
+}
+
+// createTemp creates a file in the passed directory and removes it, such that
+// it only lives as long as it is open or mapped.
+func createTemp(directory string) (*os.File, error) {
+	file, err := os.CreateTemp(directory, "sonic-mirrored-buffer-")
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		_ = os.Remove(file.Name())
-		_ = file.Close()
-	}()
+	_ = os.Remove(file.Name())
+	return file, nil
+}
 
-	if err = file.Truncate(int64(size)); err != nil {
-		return nil, err
+// mirror truncates the passed file to the buffer's size, rounded up to a
+// multiple of align, and maps it twice at an address aligned to align. The file
+// can be closed once mapped.
+func (b *MirroredBuffer) mirror(file *os.File, pageSize, align int) error {
+	size := b.size
+	if remainder := size % align; remainder > 0 {
+		size += align - remainder
+	}
+	if err := file.Truncate(int64(size)); err != nil {
+		return err
 	}
 
+	slice, mapping, err := mapMirrored(file, 0, size, align, false)
+	if err != nil {
+		return err
+	}
+
+	b.slice, b.mapping = slice, mapping
+	b.size = size
 	b.name = file.Name()
+	if pageSize > syscall.Getpagesize() {
+		b.alloc.HugePages = HugePagesExplicit
+	}
+	b.alloc.PageSize = pageSize
+
+	return nil
+}
+
+// MapMirrored maps size bytes of the passed file, starting at offset, twice and
+// back to back in the process' virtual memory space, as done by a
+// MirroredBuffer. The returned slice is 2*size long and must be released with
+// syscall.Munmap.
+//
+// The size and the offset must be multiples of the system's page size. Since
+// the file is mapped with MAP_SHARED, processes mapping the same file see each
+// other's writes.
+func MapMirrored(
+	file *os.File,
+	offset int64,
+	size int,
+	prefault bool,
+) (slice []byte, err error) {
+	slice, _, err = mapMirrored(
+		file, offset, size, syscall.Getpagesize(), prefault)
+	return slice, err
+}
+
+// mapMirrored maps the file as MapMirrored does, at an address aligned to the
+// passed alignment, which must be a multiple of the system's page size.
+//
+// The returned slice holds the mirrored mappings. The returned mapping is the
+// reserved virtual memory area which contains them, and is what must be
+// released with syscall.Munmap.
+func mapMirrored(
+	file *os.File,
+	offset int64,
+	size int,
+	align int,
+	prefault bool,
+) (slice, mapping []byte, err error) {
+	pageSize := syscall.Getpagesize()
+	if size <= 0 || size%pageSize != 0 || offset%int64(pageSize) != 0 ||
+		align%pageSize != 0 {
+		return nil, nil, fmt.Errorf(
+			"invalid mirrored mapping size=%d offset=%d", size, offset)
+	}
 
 	// This creates the anonymous mapping - we remap this area twice, starting
-	// at the address of the returned slice.
-	b.slice, err = mmapAllocate(2*size, prefault)
+	// at the first address of the area which is aligned as requested.
+	mapping, err = mmapAllocate(2*size+align-pageSize, prefault)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = syscall.Munmap(mapping)
+			slice, mapping = nil, nil
+		}
+	}()
+
+	start := 0
+	/* #nosec G103 -- the use of unsafe has been audited */
+	reservedAddr := uintptr(unsafe.Pointer(&mapping[0]))
+	if remainder := int(reservedAddr % uintptr(align)); remainder > 0 {
+		start = align - remainder
 	}
+	slice = mapping[start : start+2*size : start+2*size]
 
 	// We now map the shared memory file twice at fixed addresses wrt the
-	// b.slice above.
+	// slice above.
 	/* #nosec G103 -- the use of unsafe has been audited */
 	var (
-		firstAddr  = uintptr(unsafe.Pointer(&b.slice[0]))
-		secondAddr = uintptr(unsafe.Pointer(&b.slice[size]))
+		firstAddr  = uintptr(unsafe.Pointer(&slice[0]))
+		secondAddr = uintptr(unsafe.Pointer(&slice[size]))
 	)
 
 	if int(secondAddr)-int(firstAddr) != size {
-		return nil, fmt.Errorf(
+		return nil, nil, fmt.Errorf(
 			"could not compute offset addresses for left and right mappings",
 		)
 	}
@@ -158,7 +312,7 @@ func NewMirroredBuffer(size int, prefault bool) (b *MirroredBuffer, err error) {
 			uintptr(prot),
 			uintptr(flags),
 			fd,
-			0,
+			uintptr(offset),
 		)
 		var err error = nil
 		if errno != 0 {
@@ -173,17 +327,17 @@ func NewMirroredBuffer(size int, prefault bool) (b *MirroredBuffer, err error) {
 		return err
 	}
 
-	// First mapping at offset=0 of length=size.
+	// First mapping of the file region, at the start of the slice.
 	if err = remap(firstAddr); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Second mapping of the same file at offset=size of length=size.
+	// Second mapping of the same file region, right after the first one.
 	if err = remap(secondAddr); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return b, nil
+	return slice, mapping, nil
 }
 
 // Prefault the buffer, forcing physical memory allocation.
@@ -217,7 +371,7 @@ func (b *MirroredBuffer) Commit(n int) int {
 		n = free
 	}
 	b.used += n
-	b.tail = (b.tail + n) & b.sizeMask
+	b.tail = (b.tail + n) % b.size
 	return n
 }
 
@@ -229,19 +383,93 @@ func (b *MirroredBuffer) Consume(n int) int {
 		return 0
 	}
 	b.used -= n
-	b.head = (b.head + n) & b.sizeMask
+	b.head = (b.head + n) % b.size
 	return n
 }
 
+// Head returns the committed bytes, which are always contiguous.
+func (b *MirroredBuffer) Head() []byte {
+	if b.used == 0 {
+		return nil
+	}
+	return b.slice[b.head : b.head+b.used]
+}
+
+// AsyncReadFrom reads once from the supplied asynchronous reader into the free
+// space of the buffer and commits the bytes read.
+//
+// The callback is invoked with sonicerrors.ErrNoBufferSpaceAvailable if the
+// buffer is full. Nothing is committed if the read fails.
+func (b *MirroredBuffer) AsyncReadFrom(
+	r sonic.AsyncReader,
+	cb sonic.AsyncCallback,
+) {
+	claimed := b.Claim(b.FreeSpace())
+	if claimed == nil {
+		cb(sonicerrors.ErrNoBufferSpaceAvailable, 0)
+		return
+	}
+	r.AsyncRead(claimed, func(err error, n int) {
+		if err != nil {
+			n = 0
+		}
+		b.Commit(n)
+		cb(err, n)
+	})
+}
+
+// AsyncReadFromPacket reads one datagram from the supplied packet connection
+// and commits it. The callback is invoked with the datagram, which stays valid
+// until it is consumed.
+//
+// If less than maxPacketSize bytes are free, the callback is invoked with
+// sonicerrors.ErrNoBufferSpaceAvailable, as the datagram could be truncated.
+func (b *MirroredBuffer) AsyncReadFromPacket(
+	conn sonic.PacketConn,
+	maxPacketSize int,
+	cb func(err error, packet []byte, addr net.Addr),
+) {
+	if b.FreeSpace() < maxPacketSize {
+		cb(sonicerrors.ErrNoBufferSpaceAvailable, nil, nil)
+		return
+	}
+	claimed := b.Claim(maxPacketSize)
+	conn.AsyncReadFrom(claimed, func(err error, n int, addr net.Addr) {
+		if err != nil {
+			cb(err, nil, addr)
+		} else {
+			cb(nil, claimed[:b.Commit(n)], addr)
+		}
+	})
+}
+
+// AsyncWriteTo writes all the committed bytes to the supplied asynchronous
+// writer, consuming them as they are written. The bytes are contiguous even if
+// they wrap around the end of the buffer, so this takes a single write.
+func (b *MirroredBuffer) AsyncWriteTo(
+	w sonic.AsyncWriter,
+	cb sonic.AsyncCallback,
+) {
+	head := b.Head()
+	if head == nil {
+		cb(nil, 0)
+		return
+	}
+	w.AsyncWriteAll(head, func(err error, n int) {
+		b.Consume(n)
+		cb(err, n)
+	})
+}
+
 func (b *MirroredBuffer) Full() bool {
 	return b.used == b.size
 }
 
 func (b *MirroredBuffer) Destroy() (err error) {
-	if b.slice != nil {
-		err = syscall.Munmap(b.slice)
+	if b.mapping != nil {
+		err = syscall.Munmap(b.mapping)
 		if err == nil {
-			b.slice = nil
+			b.slice, b.mapping = nil, nil
 		}
 	}
 	return nil
@@ -260,3 +488,8 @@ func (b *MirroredBuffer) Reset() {
 func (b *MirroredBuffer) Name() string {
 	return b.name
 }
+
+// Allocation reports what the system granted when the buffer was allocated.
+func (b *MirroredBuffer) Allocation() Allocation {
+	return b.alloc
+}
@@ -0,0 +1,328 @@
+package bytes
+
+import (
+	"io"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// MirroredByteBuffer is a sonic.Buffer backed by a MirroredBuffer. It can be
+// used in place of a sonic.ByteBuffer by codecs and sonic.CodecConn.
+//
+// A sonic.ByteBuffer copies the bytes left in its read area to the front of
+// its memory each time bytes are consumed. A MirroredByteBuffer instead moves
+// the start of its areas forward, the mirroring guaranteeing that they are
+// always contiguous. Consuming or discarding bytes is copy-free, unless the
+// bytes are in the middle of the buffer: bytes consumed while the save area
+// is not empty, or a slot discarded while bytes are saved before it.
+//
+// The size of a MirroredByteBuffer is fixed: Reserve does not grow it, and
+// reading into a full buffer fails with ErrNoBufferSpaceAvailable. It must be
+// large enough to hold the largest decoded item.
+type MirroredByteBuffer struct {
+	buf *MirroredBuffer
+
+	// Offset of the save area in the mirrored slice, always smaller than the
+	// size of the buffer. The areas end at the following offsets from head.
+	head int
+
+	si int // End of the save area.
+	ri int // End of the read area.
+	wi int // End of the write area.
+
+	oneByte [1]byte
+}
+
+var _ sonic.Buffer = &MirroredByteBuffer{}
+
+// NewMirroredByteBuffer returns a buffer over a MirroredBuffer of at least the
+// passed size. See NewMirroredBuffer.
+func NewMirroredByteBuffer(
+	size int,
+	prefault bool,
+) (*MirroredByteBuffer, error) {
+	buf, err := NewMirroredBuffer(size, prefault)
+	if err != nil {
+		return nil, err
+	}
+	return &MirroredByteBuffer{buf: buf}, nil
+}
+
+// slice returns the bytes in [from, to), relative to head.
+func (b *MirroredByteBuffer) slice(from, to int) []byte {
+	return b.buf.slice[b.head+from : b.head+to]
+}
+
+// remove removes n bytes starting at offset from, relative to head, from the
+// read and write areas. Bytes at the front of the buffer are removed by moving
+// head forward; others by copying the bytes which follow them.
+func (b *MirroredByteBuffer) remove(from, n int) {
+	if from == 0 {
+		b.head = (b.head + n) % b.buf.size
+	} else {
+		copy(b.slice(from, b.wi), b.slice(from+n, b.wi))
+	}
+	b.ri -= n
+	b.wi -= n
+	if b.wi == 0 {
+		b.head = 0
+	}
+}
+
+// Reserve does not grow the buffer. It fails with ErrNoBufferSpaceAvailable if
+// the buffer cannot hold n more bytes.
+func (b *MirroredByteBuffer) Reserve(n int) error {
+	if n > b.Reserved() {
+		return sonicerrors.ErrNoBufferSpaceAvailable
+	}
+	return nil
+}
+
+func (b *MirroredByteBuffer) Reserved() int {
+	return b.buf.size - b.wi
+}
+
+// Claim fails with ErrNoBufferSpaceAvailable, claiming nothing, if fn claims
+// more bytes than the buffer can hold.
+func (b *MirroredByteBuffer) Claim(fn func(b []byte) int) error {
+	n := fn(b.slice(b.wi, b.buf.size))
+	if n < 0 || b.wi+n > b.buf.size {
+		return sonicerrors.ErrNoBufferSpaceAvailable
+	}
+	b.wi += n
+	return nil
+}
+
+// ClaimFixed returns nil, claiming nothing, if the buffer cannot hold n more
+// bytes.
+func (b *MirroredByteBuffer) ClaimFixed(n int) (claimed []byte) {
+	if wi := b.wi + n; n >= 0 && wi <= b.buf.size {
+		claimed = b.slice(b.wi, wi)
+		b.wi = wi
+	}
+	return
+}
+
+func (b *MirroredByteBuffer) Commit(n int) {
+	if n <= 0 {
+		return
+	}
+	b.ri += n
+	if b.ri > b.wi {
+		b.ri = b.wi
+	}
+}
+
+func (b *MirroredByteBuffer) PrepareRead(n int) (err error) {
+	if need := n - b.ReadLen(); need > 0 {
+		if b.WriteLen() >= need {
+			b.Commit(need)
+		} else {
+			err = sonicerrors.ErrNeedMore
+		}
+	}
+	return
+}
+
+func (b *MirroredByteBuffer) Data() []byte {
+	return b.slice(b.si, b.ri)
+}
+
+func (b *MirroredByteBuffer) SaveLen() int {
+	return b.si
+}
+
+func (b *MirroredByteBuffer) ReadLen() int {
+	return b.ri - b.si
+}
+
+func (b *MirroredByteBuffer) WriteLen() int {
+	return b.wi - b.ri
+}
+
+func (b *MirroredByteBuffer) Cap() int {
+	return b.buf.size
+}
+
+func (b *MirroredByteBuffer) Consume(n int) {
+	if readLen := b.ReadLen(); n > readLen {
+		n = readLen
+	}
+	if n > 0 {
+		b.remove(b.si, n)
+	}
+}
+
+func (b *MirroredByteBuffer) Save(n int) (slot sonic.Slot) {
+	if readLen := b.ReadLen(); n > readLen {
+		n = readLen
+	}
+	if n <= 0 {
+		return
+	}
+	slot.Length = n
+	slot.Index = b.si
+	b.si += n
+	return
+}
+
+func (b *MirroredByteBuffer) Saved() []byte {
+	return b.slice(0, b.si)
+}
+
+func (b *MirroredByteBuffer) SavedSlot(slot sonic.Slot) []byte {
+	return b.slice(slot.Index, slot.Index+slot.Length)
+}
+
+func (b *MirroredByteBuffer) Discard(slot sonic.Slot) (discarded int) {
+	if slot.Length <= 0 {
+		return 0
+	}
+	b.remove(slot.Index, slot.Length)
+	b.si -= slot.Length
+	return slot.Length
+}
+
+func (b *MirroredByteBuffer) DiscardAll() {
+	b.Discard(sonic.Slot{Index: 0, Length: b.si})
+}
+
+func (b *MirroredByteBuffer) ShrinkBy(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	if length := b.WriteLen(); n > length {
+		n = length
+	}
+	b.wi -= n
+	return n
+}
+
+func (b *MirroredByteBuffer) Reset() {
+	b.head = 0
+	b.si = 0
+	b.ri = 0
+	b.wi = 0
+}
+
+// Read the bytes from the read area into `dst`. Consume them.
+func (b *MirroredByteBuffer) Read(dst []byte) (int, error) {
+	if len(dst) == 0 {
+		return 0, nil
+	}
+	if b.ReadLen() == 0 {
+		return 0, io.EOF
+	}
+	n := copy(dst, b.Data())
+	b.Consume(n)
+	return n, nil
+}
+
+func (b *MirroredByteBuffer) ReadByte() (byte, error) {
+	_, err := b.Read(b.oneByte[:])
+	return b.oneByte[0], err
+}
+
+// Write the supplied slice into the write area. If the buffer cannot hold all
+// of it, as many bytes as possible are written and
+// ErrNoBufferSpaceAvailable is returned.
+func (b *MirroredByteBuffer) Write(bb []byte) (n int, err error) {
+	n = copy(b.slice(b.wi, b.buf.size), bb)
+	b.wi += n
+	if n < len(bb) {
+		err = sonicerrors.ErrNoBufferSpaceAvailable
+	}
+	return n, err
+}
+
+func (b *MirroredByteBuffer) WriteByte(bb byte) error {
+	b.oneByte[0] = bb
+	_, err := b.Write(b.oneByte[:])
+	return err
+}
+
+func (b *MirroredByteBuffer) WriteString(s string) (n int, err error) {
+	n = copy(b.slice(b.wi, b.buf.size), s)
+	b.wi += n
+	if n < len(s) {
+		err = sonicerrors.ErrNoBufferSpaceAvailable
+	}
+	return n, err
+}
+
+// ReadFrom the supplied reader into the write area. It fails with
+// ErrNoBufferSpaceAvailable if the buffer is full.
+func (b *MirroredByteBuffer) ReadFrom(r io.Reader) (int64, error) {
+	if b.Reserved() == 0 {
+		return 0, sonicerrors.ErrNoBufferSpaceAvailable
+	}
+	n, err := r.Read(b.slice(b.wi, b.buf.size))
+	if err == nil {
+		b.wi += n
+	}
+	return int64(n), err
+}
+
+// AsyncReadFrom the supplied asynchronous reader into the write area. It fails
+// with ErrNoBufferSpaceAvailable if the buffer is full.
+func (b *MirroredByteBuffer) AsyncReadFrom(
+	r sonic.AsyncReader,
+	cb sonic.AsyncCallback,
+) {
+	if b.Reserved() == 0 {
+		cb(sonicerrors.ErrNoBufferSpaceAvailable, 0)
+		return
+	}
+	r.AsyncRead(b.slice(b.wi, b.buf.size), func(err error, n int) {
+		if err == nil {
+			b.wi += n
+		}
+		cb(err, n)
+	})
+}
+
+// WriteTo the provided writer bytes from the read area. Consume them if no
+// error occurred.
+func (b *MirroredByteBuffer) WriteTo(w io.Writer) (int64, error) {
+	var (
+		n            int
+		err          error
+		writtenBytes = 0
+	)
+
+	for b.si+writtenBytes < b.ri {
+		n, err = w.Write(b.slice(b.si+writtenBytes, b.ri))
+		if err != nil {
+			break
+		}
+		writtenBytes += n
+	}
+	b.Consume(writtenBytes)
+	return int64(writtenBytes), err
+}
+
+// AsyncWriteTo the provided asynchronous writer bytes from the read area.
+// Consume them if no error occurred.
+func (b *MirroredByteBuffer) AsyncWriteTo(
+	w sonic.AsyncWriter,
+	cb sonic.AsyncCallback,
+) {
+	w.AsyncWriteAll(b.Data(), func(err error, n int) {
+		if err == nil {
+			b.Consume(n)
+		}
+		cb(err, n)
+	})
+}
+
+// Size returns the size of the buffer, which is also its capacity.
+func (b *MirroredByteBuffer) Size() int {
+	return b.buf.size
+}
+
+// Destroy unmaps the memory of the buffer. The buffer must not be used
+// afterwards.
+func (b *MirroredByteBuffer) Destroy() error {
+	return b.buf.Destroy()
+}
@@ -0,0 +1,406 @@
+package bytes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/codec/fix"
+	"github.com/talostrading/sonic/codec/frame"
+	"github.com/talostrading/sonic/codec/line"
+	"github.com/talostrading/sonic/codec/resp"
+	"github.com/talostrading/sonic/codec/websocket"
+	"github.com/talostrading/sonic/http"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestMirroredByteBufferLikeByteBuffer(t *testing.T) {
+	// A MirroredByteBuffer behaves like a ByteBuffer which never grows,
+	// including when its areas wrap around the end of the mirrored slice.
+	mirrored, err := NewMirroredByteBuffer(syscall.Getpagesize(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mirrored.Destroy()
+	reference := sonic.NewByteBuffer()
+
+	rng := rand.New(rand.NewSource(42))
+	var slots []sonic.Slot
+	next := byte(0)
+	for i := 0; i < 100000; i++ {
+		switch op := rng.Intn(6); {
+		case op <= 1:
+			n := rng.Intn(512)
+			if n > mirrored.Reserved() {
+				n = mirrored.Reserved()
+			}
+			b := make([]byte, n)
+			for j := range b {
+				b[j] = next
+				next++
+			}
+			_, _ = mirrored.Write(b)
+			_, _ = reference.Write(b)
+		case op == 2:
+			n := rng.Intn(512)
+			mirrored.Commit(n)
+			reference.Commit(n)
+		case op == 3:
+			n := rng.Intn(512)
+			mirrored.Consume(n)
+			reference.Consume(n)
+		case op == 4:
+			n := rng.Intn(64)
+			slot := mirrored.Save(n)
+			if slot != reference.Save(n) {
+				t.Fatal("different slots")
+			}
+			if slot.Length > 0 {
+				slots = append(slots, slot)
+			}
+		case op == 5 && len(slots) > 0:
+			// Discarding the first slot shifts the others.
+			slot := slots[0]
+			slots = slots[1:]
+			for j := range slots {
+				slots[j].Index -= slot.Length
+			}
+			if mirrored.Discard(slot) != reference.Discard(slot) {
+				t.Fatal("different discarded lengths")
+			}
+		}
+
+		if mirrored.SaveLen() != reference.SaveLen() ||
+			mirrored.ReadLen() != reference.ReadLen() ||
+			mirrored.WriteLen() != reference.WriteLen() {
+			t.Fatalf(
+				"op %d: areas differ mirrored=(%d %d %d) reference=(%d %d %d)",
+				i,
+				mirrored.SaveLen(), mirrored.ReadLen(), mirrored.WriteLen(),
+				reference.SaveLen(), reference.ReadLen(), reference.WriteLen(),
+			)
+		}
+		if !bytes.Equal(mirrored.Data(), reference.Data()) ||
+			!bytes.Equal(mirrored.Saved(), reference.Saved()) {
+			t.Fatalf("op %d: contents differ", i)
+		}
+		for _, slot := range slots {
+			if !bytes.Equal(
+				mirrored.SavedSlot(slot), reference.SavedSlot(slot),
+			) {
+				t.Fatalf("op %d: slots differ", i)
+			}
+		}
+	}
+}
+
+func TestMirroredByteBufferFull(t *testing.T) {
+	size := syscall.Getpagesize()
+	buf, err := NewMirroredByteBuffer(size, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Destroy()
+
+	if err := buf.Reserve(2 * size); !errors.Is(
+		err, sonicerrors.ErrNoBufferSpaceAvailable) {
+		t.Fatalf("expected ErrNoBufferSpaceAvailable, got %v", err)
+	}
+	if buf.Reserved() != size || buf.Cap() != size {
+		t.Fatalf("the buffer should not grow")
+	}
+	if err := buf.Claim(func(b []byte) int { return len(b) + 1 }); !errors.Is(
+		err, sonicerrors.ErrNoBufferSpaceAvailable) || buf.WriteLen() != 0 {
+		t.Fatalf("expected ErrNoBufferSpaceAvailable, got %v", err)
+	}
+
+	n, err := buf.Write(make([]byte, size+1))
+	if n != size || !errors.Is(err, sonicerrors.ErrNoBufferSpaceAvailable) {
+		t.Fatalf("expected a short write, got n=%d err=%v", n, err)
+	}
+	if buf.ClaimFixed(1) != nil {
+		t.Fatal("should not claim from a full buffer")
+	}
+	if _, err := buf.ReadFrom(bytes.NewReader([]byte{1})); !errors.Is(
+		err, sonicerrors.ErrNoBufferSpaceAvailable) {
+		t.Fatalf("expected ErrNoBufferSpaceAvailable, got %v", err)
+	}
+
+	// Consuming from the front frees space without copying.
+	buf.Commit(size)
+	buf.Consume(10)
+	if buf.Reserved() != 10 || buf.ReadLen() != size-10 {
+		t.Fatal("invalid areas after consume")
+	}
+	n, err = buf.Write(bytes.Repeat([]byte{1}, 10))
+	if n != 10 || err != nil {
+		t.Fatalf("expected a full write, got n=%d err=%v", n, err)
+	}
+	buf.Commit(10)
+	if data := buf.Data(); len(data) != size || data[size-1] != 1 {
+		t.Fatal("the read area should wrap around")
+	}
+
+	buf.Reset()
+	if _, err := buf.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestMirroredByteBufferCodecConn(t *testing.T) {
+	const frames = 10000
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		dst := sonic.NewByteBuffer()
+		codec := frame.NewCodec(nil)
+		for i := 0; i < frames; i++ {
+			payload := bytes.Repeat([]byte{byte(i)}, 1+i%1000)
+			_ = codec.Encode(payload, dst)
+			dst.Commit(dst.WriteLen())
+		}
+		_, _ = dst.WriteTo(conn)
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	stream, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	src, err := NewMirroredByteBuffer(syscall.Getpagesize(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Destroy()
+	dst, err := NewMirroredByteBuffer(syscall.Getpagesize(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Destroy()
+
+	conn, err := sonic.NewCodecConn[[]byte, []byte](
+		stream, frame.NewCodec(src), src, dst,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := 0
+	var onRead func(error, []byte)
+	onRead = func(err error, payload []byte) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := bytes.Repeat([]byte{byte(decoded)}, 1+decoded%1000)
+		if !bytes.Equal(payload, expected) {
+			t.Fatalf("corrupt frame %d", decoded)
+		}
+		decoded++
+		if decoded < frames {
+			conn.AsyncReadNext(onRead)
+		}
+	}
+	conn.AsyncReadNext(onRead)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for decoded < frames && time.Now().Before(deadline) {
+		_, _ = ioc.PollOne()
+	}
+	if decoded != frames {
+		t.Fatalf("decoded %d frames, expected %d", decoded, frames)
+	}
+}
+
+func TestMirroredByteBufferEncodeFull(t *testing.T) {
+	size := syscall.Getpagesize()
+	payload := bytes.Repeat([]byte{'a'}, size)
+
+	lengthCodec, err := frame.NewLengthCodec(nil, frame.LengthConfig{Width: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineCodec, err := line.NewCodec(nil, []byte("\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineCodec.SetMaxLineLength(size)
+
+	encoders := map[string]func(dst sonic.Buffer) error{
+		"frame": func(dst sonic.Buffer) error {
+			return frame.NewCodec(nil).Encode(payload, dst)
+		},
+		"length": func(dst sonic.Buffer) error {
+			return lengthCodec.Encode(payload[:size/2], dst)
+		},
+		"line": func(dst sonic.Buffer) error {
+			return lineCodec.Encode(payload, dst)
+		},
+		"fix": func(dst sonic.Buffer) error {
+			b := fix.NewBuilder("0")
+			b.Body.AddBytes(58, payload)
+			return fix.NewCodec(nil, "FIX.4.4").Encode(b, dst)
+		},
+		"resp": func(dst sonic.Buffer) error {
+			return resp.NewCodec(nil).Encode(resp.BulkString(payload), dst)
+		},
+		"websocket": func(dst sonic.Buffer) error {
+			f := websocket.NewFrame()
+			f.SetText().SetPayload(payload)
+			return websocket.NewFrameCodec(nil, dst, size).Encode(f, dst)
+		},
+		"http": func(dst sonic.Buffer) error {
+			return http.NewClientCodec().Encode(&http.Request{
+				Method: "POST",
+				URL:    &url.URL{Scheme: "http", Host: "localhost", Path: "/"},
+				Body:   payload[:size-64],
+			}, dst)
+		},
+	}
+
+	for name, encode := range encoders {
+		t.Run(name, func(t *testing.T) {
+			buf, err := NewMirroredByteBuffer(size, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Destroy()
+
+			_, _ = buf.Write(make([]byte, size/2))
+			buf.Commit(size / 2)
+
+			err = encode(buf)
+			if !errors.Is(err, sonicerrors.ErrNoBufferSpaceAvailable) {
+				t.Fatalf("expected ErrNoBufferSpaceAvailable, got %v", err)
+			}
+			if buf.ReadLen() != size/2 || buf.WriteLen() != 0 {
+				t.Fatalf(
+					"nothing should be encoded, read=%d write=%d",
+					buf.ReadLen(), buf.WriteLen())
+			}
+		})
+	}
+}
+
+func TestMirroredByteBufferCodecConnFull(t *testing.T) {
+	size := syscall.Getpagesize()
+	payload := bytes.Repeat([]byte{1}, size-frame.HeaderLen-64)
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		received <- b
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	stream, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := NewMirroredByteBuffer(size, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Destroy()
+	dst, err := NewMirroredByteBuffer(size, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Destroy()
+
+	conn, err := sonic.NewCodecConn[[]byte, []byte](
+		stream, frame.NewCodec(src), src, dst,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second frame does not fit: it must fail rather than be dropped.
+	if err := conn.Enqueue(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Enqueue(payload); !errors.Is(
+		err, sonicerrors.ErrNoBufferSpaceAvailable) {
+		t.Fatalf("expected ErrNoBufferSpaceAvailable, got %v", err)
+	}
+	if conn.Queued() != frame.HeaderLen+len(payload) {
+		t.Fatalf("invalid queued bytes %d", conn.Queued())
+	}
+
+	// Once flushed, the frame fits again.
+	flushed := 0
+	var flush func()
+	flush = func() {
+		conn.AsyncFlush(func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			flushed++
+			if flushed == 1 {
+				if err := conn.Enqueue(payload); err != nil {
+					t.Fatal(err)
+				}
+				flush()
+			}
+		})
+	}
+	flush()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for flushed < 2 && time.Now().Before(deadline) {
+		_, _ = ioc.PollOne()
+	}
+	if flushed != 2 {
+		t.Fatal("timed out flushing")
+	}
+	_ = stream.Close()
+
+	b := <-received
+	in := sonic.NewByteBuffer()
+	_, _ = in.Write(b)
+	decoder := frame.NewCodec(in)
+	for i := 0; i < 2; i++ {
+		f, err := decoder.Decode(in)
+		if err != nil || !bytes.Equal(f, payload) {
+			t.Fatalf("invalid frame %d: %v", i, err)
+		}
+	}
+	if _, err := decoder.Decode(in); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected exactly two frames, got %v", err)
+	}
+}
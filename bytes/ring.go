@@ -0,0 +1,242 @@
+package bytes
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	ErrRingNotNotified = errors.New("ring is not notified")
+	ErrRingClosed      = errors.New("ring is closed")
+)
+
+const cacheLineSize = 64
+
+type cacheLinePad [cacheLineSize]byte
+
+// cursor is a position in a ring shared between goroutines. It is alone on its
+// cache line, such that updating it does not invalidate the cache line of the
+// other cursors of the ring (false sharing).
+type cursor struct {
+	_ cacheLinePad
+	atomic.Uint64
+	_ cacheLinePad
+}
+
+// waiter implements the two ways in which the consumer of a ring waits for
+// the producers: busy-spinning, or sleeping until notified.
+//
+// A notified consumer announces that it sleeps through the waiting flag, then
+// checks the ring once more before sleeping. Producers notify the consumer
+// only if the flag is set, after publishing. With sequentially consistent
+// atomics, either the consumer sees the published item, or the producer sees
+// the flag.
+type waiter struct {
+	notifier *notifier // nil if busy-spinning
+
+	_       cacheLinePad
+	waiting atomic.Uint32
+	_       cacheLinePad
+}
+
+func (w *waiter) init(notify bool) (err error) {
+	if notify {
+		w.notifier, err = newNotifier()
+	}
+	return err
+}
+
+// notify wakes up the consumer if it sleeps. It is called by the producers
+// after publishing.
+func (w *waiter) notify() {
+	if w.notifier != nil && w.waiting.Load() == 1 &&
+		w.waiting.CompareAndSwap(1, 0) {
+		w.notifier.signal()
+	}
+}
+
+// wait blocks until ready returns true.
+func (w *waiter) wait(ready func() bool) error {
+	for !ready() {
+		if w.notifier == nil {
+			// Yielding is cheap when no other goroutine is runnable, and lets the
+			// producers run when they share the processor of the consumer.
+			runtime.Gosched()
+			continue
+		}
+
+		w.waiting.Store(1)
+		if ready() {
+			w.waiting.Store(0)
+			return nil
+		}
+
+		fds := []unix.PollFd{{Fd: int32(w.notifier.fd()), Events: unix.POLLIN}}
+		if _, err := unix.Poll(fds, -1); err != nil && err != unix.EINTR {
+			return err
+		}
+		w.notifier.drain()
+	}
+	return nil
+}
+
+func (w *waiter) close() error {
+	if w.notifier != nil {
+		return w.notifier.close()
+	}
+	return nil
+}
+
+// Ring is a bounded multi-producer/single-consumer queue of fixed-size
+// elements.
+//
+// Any number of goroutines can Push elements concurrently, while a single
+// goroutine consumes them with Peek and Consume, or Pop. Producers never wait:
+// Push fails if the ring is full.
+//
+// Each slot of the ring carries a sequence number telling whether it holds an
+// element for the current lap of the consumer, so producers and the consumer
+// only share the slot they operate on, and the producers' cursor.
+//
+// If the ring is notified, a consumer waiting for elements sleeps instead of
+// busy-spinning and can wait from a sonic.IO through a RingReader.
+type Ring[T any] struct {
+	slots []ringSlot[T]
+	mask  uint64
+
+	tail cursor // next slot claimed by a producer
+
+	_    cacheLinePad
+	head uint64 // next slot read by the consumer
+	_    cacheLinePad
+
+	waiter
+
+	closed atomic.Bool
+	zero   T
+}
+
+type ringSlot[T any] struct {
+	seq atomic.Uint64
+	v   T
+}
+
+// NewRing returns a ring of at least the passed size, rounded up to a power of
+// two.
+//
+// If notify is true, the consumer sleeps while waiting for elements and
+// producers wake it up, which costs a syscall when they do. Otherwise, the
+// consumer busy-spins, which is best when it has a core of its own.
+func NewRing[T any](size int, notify bool) (*Ring[T], error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid ring size %d", size)
+	}
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+
+	r := &Ring[T]{
+		slots: make([]ringSlot[T], n),
+		mask:  uint64(n - 1),
+	}
+	for i := range r.slots {
+		r.slots[i].seq.Store(uint64(i))
+	}
+	if err := r.waiter.init(notify); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Push adds an element to the ring. It returns false if the ring is full.
+//
+// It is safe to call Push concurrently.
+func (r *Ring[T]) Push(v T) bool {
+	pos := r.tail.Load()
+	for {
+		slot := &r.slots[pos&r.mask]
+		seq := slot.seq.Load()
+		if diff := int64(seq - pos); diff == 0 {
+			if r.tail.CompareAndSwap(pos, pos+1) {
+				slot.v = v
+				// Publish the element to the consumer.
+				slot.seq.Store(pos + 1)
+				r.notify()
+				return true
+			}
+			pos = r.tail.Load()
+		} else if diff < 0 {
+			// The slot still holds the element of the previous lap.
+			return false
+		} else {
+			// Another producer claimed the slot.
+			pos = r.tail.Load()
+		}
+	}
+}
+
+// Peek returns the next element, or nil if the ring is empty. The element
+// remains in the ring until Consume is called.
+func (r *Ring[T]) Peek() *T {
+	slot := &r.slots[r.head&r.mask]
+	if slot.seq.Load() != r.head+1 {
+		return nil
+	}
+	return &slot.v
+}
+
+// Consume removes the element returned by Peek from the ring.
+func (r *Ring[T]) Consume() {
+	slot := &r.slots[r.head&r.mask]
+	if slot.seq.Load() != r.head+1 {
+		return
+	}
+	slot.v = r.zero
+	// Hand the slot over to the producers of the next lap.
+	slot.seq.Store(r.head + r.mask + 1)
+	r.head++
+}
+
+// Pop removes and returns the next element. It returns false if the ring is
+// empty.
+func (r *Ring[T]) Pop() (v T, ok bool) {
+	if p := r.Peek(); p != nil {
+		v, ok = *p, true
+		r.Consume()
+	}
+	return
+}
+
+// Wait blocks until the ring is not empty, busy-spinning or sleeping depending
+// on how the ring was created.
+func (r *Ring[T]) Wait() error {
+	return r.wait(r.ready)
+}
+
+func (r *Ring[T]) ready() bool {
+	return r.Peek() != nil
+}
+
+// Len returns the number of elements in the ring. It is only accurate when
+// called by the consumer while no producer pushes.
+func (r *Ring[T]) Len() int {
+	return int(r.tail.Load() - r.head)
+}
+
+func (r *Ring[T]) Size() int {
+	return len(r.slots)
+}
+
+// Close releases the notification resources of the ring. It must be called
+// once the producers and the consumer are done with the ring.
+func (r *Ring[T]) Close() error {
+	if !r.closed.CompareAndSwap(false, true) {
+		return ErrRingClosed
+	}
+	return r.waiter.close()
+}
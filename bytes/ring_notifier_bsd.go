@@ -0,0 +1,51 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package bytes
+
+import (
+	"github.com/talostrading/sonic/internal"
+)
+
+// notifier wakes up the consumer of a ring through a pipe, as there are no
+// eventfds on BSD.
+type notifier struct {
+	pipe *internal.Pipe
+	b    [64]byte
+}
+
+func newNotifier() (*notifier, error) {
+	pipe, err := internal.NewPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := pipe.SetReadNonblock(); err != nil {
+		_ = pipe.Close()
+		return nil, err
+	}
+	if err := pipe.SetWriteNonblock(); err != nil {
+		_ = pipe.Close()
+		return nil, err
+	}
+	return &notifier{pipe: pipe}, nil
+}
+
+func (n *notifier) fd() int {
+	return n.pipe.ReadFd()
+}
+
+func (n *notifier) signal() {
+	_, _ = n.pipe.Write([]byte{1})
+}
+
+// drain empties the pipe. It does not block.
+func (n *notifier) drain() {
+	for {
+		if k, err := n.pipe.Read(n.b[:]); err != nil || k < len(n.b) {
+			return
+		}
+	}
+}
+
+func (n *notifier) close() error {
+	return n.pipe.Close()
+}
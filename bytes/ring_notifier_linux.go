@@ -0,0 +1,38 @@
+//go:build linux
+
+package bytes
+
+import (
+	"github.com/talostrading/sonic/internal"
+)
+
+// notifier wakes up the consumer of a ring through an eventfd.
+type notifier struct {
+	efd *internal.EventFd
+	b   [8]byte
+}
+
+func newNotifier() (*notifier, error) {
+	efd, err := internal.NewEventFd(true)
+	if err != nil {
+		return nil, err
+	}
+	return &notifier{efd: efd}, nil
+}
+
+func (n *notifier) fd() int {
+	return n.efd.Fd()
+}
+
+func (n *notifier) signal() {
+	_, _ = n.efd.Write(1)
+}
+
+// drain resets the eventfd counter. It does not block.
+func (n *notifier) drain() {
+	_, _ = n.efd.Read(n.b[:])
+}
+
+func (n *notifier) close() error {
+	return n.efd.Close()
+}
@@ -0,0 +1,232 @@
+package bytes
+
+import (
+	"io"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// asyncWaiter waits for the producers of a notified ring from a sonic.IO, by
+// registering the notification file descriptor of the ring like a socket.
+type asyncWaiter struct {
+	ioc    *sonic.IO
+	waiter *waiter
+	ready  func() bool
+
+	slot      internal.Slot
+	cb        func(error)
+	scheduled bool
+	onReadyFn internal.Handler
+}
+
+func (a *asyncWaiter) init(ioc *sonic.IO, w *waiter, ready func() bool) error {
+	if w.notifier == nil {
+		return ErrRingNotNotified
+	}
+	a.ioc = ioc
+	a.waiter = w
+	a.ready = ready
+	a.slot.Fd = w.notifier.fd()
+	a.onReadyFn = a.onReady
+	return nil
+}
+
+// asyncWait calls cb once the ring is not empty.
+func (a *asyncWaiter) asyncWait(cb func(error)) {
+	if a.ready() {
+		if a.ioc.Dispatched < sonic.MaxCallbackDispatch {
+			a.ioc.Dispatched++
+			cb(nil)
+			a.ioc.Dispatched--
+			return
+		}
+		// Too many callbacks were called in place: the callback is called by
+		// the next dispatch cycle instead, by signaling ourselves.
+		a.waiter.notifier.signal()
+	} else {
+		a.waiter.waiting.Store(1)
+		if a.ready() {
+			a.waiter.waiting.Store(0)
+			a.asyncWait(cb)
+			return
+		}
+	}
+
+	a.cb = cb
+	a.slot.Set(internal.ReadEvent, a.onReadyFn)
+	if err := a.ioc.SetRead(&a.slot); err != nil {
+		a.waiter.waiting.Store(0)
+		cb(err)
+		return
+	}
+	a.ioc.Register(&a.slot)
+	a.scheduled = true
+}
+
+func (a *asyncWaiter) onReady(err error) {
+	a.ioc.Deregister(&a.slot)
+	a.scheduled = false
+	a.waiter.notifier.drain()
+
+	cb := a.cb
+	a.cb = nil
+	if err != nil {
+		cb(err)
+	} else {
+		// The notification may be stale, in which case we wait again.
+		a.asyncWait(cb)
+	}
+}
+
+func (a *asyncWaiter) cancel() {
+	if a.scheduled {
+		a.scheduled = false
+		a.waiter.waiting.Store(0)
+		_ = a.ioc.UnsetRead(&a.slot)
+		a.ioc.Deregister(&a.slot)
+
+		cb := a.cb
+		a.cb = nil
+		cb(sonicerrors.ErrCancelled)
+	}
+}
+
+// ByteRingReader reads the records of a notified ByteRing from a sonic.IO,
+// like from a socket. It is the consumer of the ring.
+type ByteRingReader struct {
+	ring   *ByteRing
+	waiter asyncWaiter
+
+	b        []byte
+	readCb   sonic.AsyncCallback
+	nextCb   func(error, []byte)
+	onReadFn func(error)
+	onNextFn func(error)
+
+	// Set while the callback of AsyncNext runs. The record it is given is only
+	// consumed once it returns, so AsyncNext calls made from the callback wait
+	// until then.
+	inNext      bool
+	nextPending bool
+}
+
+func NewByteRingReader(ioc *sonic.IO, ring *ByteRing) (*ByteRingReader, error) {
+	r := &ByteRingReader{ring: ring}
+	if err := r.waiter.init(ioc, &ring.waiter, ring.ready); err != nil {
+		return nil, err
+	}
+	r.onReadFn = r.onRead
+	r.onNextFn = r.onNext
+	return r, nil
+}
+
+// AsyncRead copies the next record into b and removes it from the ring. The
+// callback is invoked with the length of the record. If b is shorter than the
+// record, the callback is invoked with io.ErrShortBuffer and the record stays
+// in the ring.
+func (r *ByteRingReader) AsyncRead(b []byte, cb sonic.AsyncCallback) {
+	r.b = b
+	r.readCb = cb
+	r.waiter.asyncWait(r.onReadFn)
+}
+
+func (r *ByteRingReader) onRead(err error) {
+	b, cb := r.b, r.readCb
+	r.b, r.readCb = nil, nil
+	if err != nil {
+		cb(err, 0)
+		return
+	}
+
+	record := r.ring.Peek()
+	if len(record) > len(b) {
+		cb(io.ErrShortBuffer, 0)
+		return
+	}
+	n := copy(b, record)
+	r.ring.Consume()
+	cb(nil, n)
+}
+
+// AsyncNext invokes the callback with the next record, in place. The record is
+// removed from the ring once the callback returns.
+func (r *ByteRingReader) AsyncNext(cb func(error, []byte)) {
+	r.nextCb = cb
+	if r.inNext {
+		r.nextPending = true
+	} else {
+		r.waiter.asyncWait(r.onNextFn)
+	}
+}
+
+func (r *ByteRingReader) onNext(err error) {
+	cb := r.nextCb
+	r.nextCb = nil
+	if err != nil {
+		cb(err, nil)
+		return
+	}
+
+	r.inNext = true
+	cb(nil, r.ring.Peek())
+	r.inNext = false
+	r.ring.Consume()
+
+	if r.nextPending {
+		r.nextPending = false
+		r.waiter.asyncWait(r.onNextFn)
+	}
+}
+
+// Cancel cancels the pending read, if any. Its callback is invoked with
+// sonicerrors.ErrCancelled.
+func (r *ByteRingReader) Cancel() {
+	r.waiter.cancel()
+}
+
+// RingReader pops the elements of a notified Ring from a sonic.IO. It is the
+// consumer of the ring.
+type RingReader[T any] struct {
+	ring   *Ring[T]
+	waiter asyncWaiter
+
+	cb      func(error, T)
+	onPopFn func(error)
+	zero    T
+}
+
+func NewRingReader[T any](ioc *sonic.IO, ring *Ring[T]) (*RingReader[T], error) {
+	r := &RingReader[T]{ring: ring}
+	if err := r.waiter.init(ioc, &ring.waiter, ring.ready); err != nil {
+		return nil, err
+	}
+	r.onPopFn = r.onPop
+	return r, nil
+}
+
+// AsyncPop invokes the callback with the next element, once it is removed from
+// the ring.
+func (r *RingReader[T]) AsyncPop(cb func(error, T)) {
+	r.cb = cb
+	r.waiter.asyncWait(r.onPopFn)
+}
+
+func (r *RingReader[T]) onPop(err error) {
+	cb := r.cb
+	r.cb = nil
+	if err != nil {
+		cb(err, r.zero)
+		return
+	}
+
+	v, _ := r.ring.Pop()
+	cb(nil, v)
+}
+
+// Cancel cancels the pending pop, if any. Its callback is invoked with
+// sonicerrors.ErrCancelled.
+func (r *RingReader[T]) Cancel() {
+	r.waiter.cancel()
+}
@@ -0,0 +1,187 @@
+package bytes
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+type item struct {
+	producer int
+	seq      int
+}
+
+func pushAll(ring *Ring[item], producers, n int) {
+	for p := 0; p < producers; p++ {
+		go func(p int) {
+			for i := 0; i < n; i++ {
+				for !ring.Push(item{producer: p, seq: i}) {
+					runtime.Gosched()
+				}
+			}
+		}(p)
+	}
+}
+
+func TestRingPushPop(t *testing.T) {
+	ring, err := NewRing[int](5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+
+	if ring.Size() != 8 {
+		t.Fatalf("expected size 8, got %d", ring.Size())
+	}
+	if _, ok := ring.Pop(); ok {
+		t.Fatal("ring should be empty")
+	}
+
+	for lap := 0; lap < 3; lap++ {
+		for i := 0; i < 8; i++ {
+			if !ring.Push(lap*8 + i) {
+				t.Fatalf("push %d failed", i)
+			}
+		}
+		if ring.Push(-1) {
+			t.Fatal("ring should be full")
+		}
+		if ring.Len() != 8 {
+			t.Fatalf("expected 8 elements, got %d", ring.Len())
+		}
+
+		if p := ring.Peek(); p == nil || *p != lap*8 {
+			t.Fatal("invalid peek")
+		}
+		for i := 0; i < 8; i++ {
+			if v, ok := ring.Pop(); !ok || v != lap*8+i {
+				t.Fatalf("invalid pop %d %v", v, ok)
+			}
+		}
+	}
+}
+
+func TestRingProducersConsumer(t *testing.T) {
+	const (
+		producers = 4
+		n         = 50000
+	)
+
+	for _, notify := range []bool{false, true} {
+		ring, err := NewRing[item](1024, notify)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pushAll(ring, producers, n)
+
+		// Elements of the same producer are popped in order.
+		next := make([]int, producers)
+		for i := 0; i < producers*n; i++ {
+			if err := ring.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			v, _ := ring.Pop()
+			if v.seq != next[v.producer] {
+				t.Fatalf("notify=%v: producer %d: expected %d got %d",
+					notify, v.producer, next[v.producer], v.seq)
+			}
+			next[v.producer]++
+		}
+		_ = ring.Close()
+	}
+}
+
+func TestRingReader(t *testing.T) {
+	const (
+		producers = 2
+		n         = 10000
+	)
+
+	ring, err := NewRing[item](64, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	reader, err := NewRingReader(ioc, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Let the reader wait for the first element.
+		time.Sleep(10 * time.Millisecond)
+		pushAll(ring, producers, n)
+	}()
+
+	popped := 0
+	next := make([]int, producers)
+	var onPop func(error, item)
+	onPop = func(err error, v item) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.seq != next[v.producer] {
+			t.Fatalf("producer %d: expected %d got %d",
+				v.producer, next[v.producer], v.seq)
+		}
+		next[v.producer]++
+		popped++
+		if popped < producers*n {
+			reader.AsyncPop(onPop)
+		}
+	}
+	reader.AsyncPop(onPop)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for popped < producers*n && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if popped != producers*n {
+		t.Fatalf("popped %d elements, expected %d", popped, producers*n)
+	}
+	wg.Wait()
+}
+
+func BenchmarkRing(b *testing.B) {
+	ring, err := NewRing[int](1024, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ring.Close()
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			for !ring.Push(i) {
+				runtime.Gosched()
+			}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		for ring.Peek() == nil {
+			runtime.Gosched()
+		}
+		ring.Consume()
+	}
+}
+
+func BenchmarkRingChannel(b *testing.B) {
+	ch := make(chan int, 1024)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			ch <- i
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		<-ch
+	}
+}
@@ -11,14 +11,14 @@ type Encoder[Item any] interface {
 	// Implementations should:
 	// - Commit() the bytes into the given buffer if the encoding is successful.
 	// - Ensure the given buffer is big enough to hold the serialized `Item`s by calling `Reserve(...)`.
-	Encode(item Item, dst *ByteBuffer) error
+	Encode(item Item, dst Buffer) error
 }
 
 type Decoder[Item any] interface {
 	// Decode the next `Item`, if any, from the provided buffer. If there are not enough bytes to decode an `Item`,
 	// implementations should return an empty `Item` along with `ErrNeedMore`. `CodecConn` will then know to read more
 	// bytes before calling `Decode(...)` again.
-	Decode(src *ByteBuffer) (Item, error)
+	Decode(src Buffer) (Item, error)
 }
 
 // Codec groups together and Encoder and a Decoder for a CodecConn.
@@ -28,11 +28,32 @@ type Codec[Enc, Dec any] interface {
 }
 
 // CodecConn reads/writes `Item`s through the provided `Codec`. For an example, see `codec/frame.go`.
+//
+// Items can be written one at a time with `AsyncWriteNext`, or batched: `Enqueue` encodes an item without writing it and
+// `AsyncFlush` writes all the enqueued items at once. With auto-flush enabled, enqueued items are flushed at the end of
+// the current dispatch cycle of the IO, or as soon as a byte threshold is reached.
 type CodecConn[Enc, Dec any] struct {
 	stream Stream
 	codec  Codec[Enc, Dec]
-	src    *ByteBuffer
-	dst    *ByteBuffer
+	src    Buffer
+	dst    Buffer
+
+	// Callbacks of the flush in progress, and of the flushes requested while it is in progress.
+	flushing  bool
+	flushCbs  []AsyncCallback
+	waitCbs   []AsyncCallback
+	onFlushFn AsyncCallback
+
+	ioc             *IO
+	autoFlush       bool
+	autoThreshold   int
+	autoCb          AsyncCallback
+	autoFlushing    bool // an automatic flush is in progress
+	autoDeferred    bool // an automatic flush is deferred to the end of the dispatch cycle
+	onAutoFlushFn   AsyncCallback
+	deferredFlushFn func()
+
+	pool *BufferPool
 
 	emptyEnc Enc
 	emptyDec Dec
@@ -41,7 +62,7 @@ type CodecConn[Enc, Dec any] struct {
 func NewCodecConn[Enc, Dec any](
 	stream Stream,
 	codec Codec[Enc, Dec],
-	src, dst *ByteBuffer,
+	src, dst Buffer,
 ) (*CodecConn[Enc, Dec], error) {
 	c := &CodecConn[Enc, Dec]{
 		stream: stream,
@@ -49,6 +70,9 @@ func NewCodecConn[Enc, Dec any](
 		src:    src,
 		dst:    dst,
 	}
+	c.onFlushFn = c.onFlush
+	c.onAutoFlushFn = c.onAutoFlush
+	c.deferredFlushFn = c.deferredFlush
 	return c, nil
 }
 
@@ -88,6 +112,9 @@ func (c *CodecConn[Enc, Dec]) ReadNext() (Dec, error) {
 func (c *CodecConn[Enc, Dec]) WriteNext(item Enc) (n int, err error) {
 	err = c.codec.Encode(item, c.dst)
 	if err == nil {
+		// Codecs may leave the encoded bytes in the write area.
+		c.dst.Commit(c.dst.WriteLen())
+
 		var nn int64
 		nn, err = c.dst.WriteTo(c.stream)
 		n = int(nn)
@@ -95,12 +122,125 @@ func (c *CodecConn[Enc, Dec]) WriteNext(item Enc) (n int, err error) {
 	return
 }
 
+// AsyncWriteNext encodes the item and flushes it along with the items enqueued before it.
 func (c *CodecConn[Enc, Dec]) AsyncWriteNext(item Enc, cb AsyncCallback) {
-	err := c.codec.Encode(item, c.dst)
-	if err == nil {
-		c.dst.AsyncWriteTo(c.stream, cb)
-	} else {
+	if err := c.Enqueue(item); err != nil {
 		cb(err, 0)
+	} else {
+		c.AsyncFlush(cb)
+	}
+}
+
+// Enqueue encodes the item without writing it. The item is written by the next flush.
+func (c *CodecConn[Enc, Dec]) Enqueue(item Enc) error {
+	if err := c.codec.Encode(item, c.dst); err != nil {
+		return err
+	}
+	// Codecs may leave the encoded bytes in the write area.
+	c.dst.Commit(c.dst.WriteLen())
+
+	if c.autoFlush {
+		c.scheduleFlush()
+	}
+	return nil
+}
+
+// Queued returns the number of encoded bytes which are not yet written, including the ones of a flush in progress.
+func (c *CodecConn[Enc, Dec]) Queued() int {
+	return c.dst.ReadLen()
+}
+
+// AsyncFlush writes all the enqueued items in one write. The callback is invoked with the number of bytes written.
+//
+// Flushes requested while a flush is in progress are coalesced into the next write.
+func (c *CodecConn[Enc, Dec]) AsyncFlush(cb AsyncCallback) {
+	if c.flushing {
+		c.waitCbs = append(c.waitCbs, cb)
+		return
+	}
+	if c.dst.ReadLen() == 0 {
+		cb(nil, 0)
+		return
+	}
+
+	c.flushing = true
+	c.flushCbs = append(c.flushCbs, cb)
+	c.dst.AsyncWriteTo(c.stream, c.onFlushFn)
+}
+
+func (c *CodecConn[Enc, Dec]) onFlush(err error, n int) {
+	for {
+		// Flushes requested from the callbacks are waiting, since the flush is still in progress.
+		for i, cb := range c.flushCbs {
+			c.flushCbs[i] = nil
+			cb(err, n)
+		}
+		c.flushCbs, c.waitCbs = c.waitCbs, c.flushCbs[:0]
+
+		if len(c.flushCbs) == 0 {
+			c.flushing = false
+			return
+		}
+		if err == nil && c.dst.ReadLen() > 0 {
+			c.dst.AsyncWriteTo(c.stream, c.onFlushFn)
+			return
+		}
+
+		// Nothing left to write, or the write failed: the waiting flushes complete now.
+		n = 0
+	}
+}
+
+// EnableAutoFlush flushes the enqueued items automatically at the end of the current dispatch cycle of the IO, such
+// that all the items enqueued by the handlers of a cycle are written at once. Items enqueued outside a dispatch cycle
+// are flushed immediately. If threshold is positive, the items are also flushed as soon as that many bytes are queued.
+//
+// The callback, which may be nil, is invoked after each automatic flush.
+func (c *CodecConn[Enc, Dec]) EnableAutoFlush(ioc *IO, threshold int, cb AsyncCallback) {
+	c.ioc = ioc
+	c.autoFlush = true
+	c.autoThreshold = threshold
+	c.autoCb = cb
+}
+
+func (c *CodecConn[Enc, Dec]) DisableAutoFlush() {
+	c.autoFlush = false
+}
+
+func (c *CodecConn[Enc, Dec]) scheduleFlush() {
+	if c.autoFlushing {
+		// The bytes are written once the automatic flush in progress completes.
+		return
+	}
+
+	if c.ioc.Dispatching() && (c.autoThreshold <= 0 || c.dst.ReadLen() < c.autoThreshold) {
+		if !c.autoDeferred {
+			c.autoDeferred = true
+			c.ioc.Defer(c.deferredFlushFn)
+		}
+		return
+	}
+
+	c.autoFlushing = true
+	c.AsyncFlush(c.onAutoFlushFn)
+}
+
+func (c *CodecConn[Enc, Dec]) deferredFlush() {
+	c.autoDeferred = false
+	if c.autoFlush && !c.autoFlushing && c.dst.ReadLen() > 0 {
+		c.autoFlushing = true
+		c.AsyncFlush(c.onAutoFlushFn)
+	}
+}
+
+func (c *CodecConn[Enc, Dec]) onAutoFlush(err error, n int) {
+	c.autoFlushing = false
+	if c.autoCb != nil {
+		c.autoCb(err, n)
+	}
+	if err == nil && c.autoFlush && c.dst.ReadLen() > 0 {
+		// Items were enqueued while the flush was in progress.
+		c.scheduleFlush()
 	}
 }
 
@@ -108,6 +248,18 @@ func (c *CodecConn[Enc, Dec]) NextLayer() Stream {
 	return c.stream
 }
 
+// SetBufferPool makes Close give the buffers of the CodecConn back to the passed pool. The buffers must have been
+// acquired from it.
+func (c *CodecConn[Enc, Dec]) SetBufferPool(pool *BufferPool) {
+	c.pool = pool
+}
+
 func (c *CodecConn[Enc, Dec]) Close() error {
-	return c.stream.Close()
+	err := c.stream.Close()
+	if c.pool != nil {
+		c.pool.Release(c.src)
+		c.pool.Release(c.dst)
+		c.pool = nil
+	}
+	return err
 }
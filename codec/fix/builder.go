@@ -0,0 +1,141 @@
+package fix
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+// Fields is a sequence of encoded fields to which fields are appended.
+type Fields []byte
+
+func (f *Fields) AddString(tag int, value string) {
+	*f = strconv.AppendInt(*f, int64(tag), 10)
+	*f = append(*f, '=')
+	*f = append(*f, value...)
+	*f = append(*f, SOH)
+}
+
+func (f *Fields) AddBytes(tag int, value []byte) {
+	*f = strconv.AppendInt(*f, int64(tag), 10)
+	*f = append(*f, '=')
+	*f = append(*f, value...)
+	*f = append(*f, SOH)
+}
+
+func (f *Fields) AddInt(tag int, value int) {
+	*f = strconv.AppendInt(*f, int64(tag), 10)
+	*f = append(*f, '=')
+	*f = strconv.AppendInt(*f, int64(value), 10)
+	*f = append(*f, SOH)
+}
+
+func (f *Fields) AddBool(tag int, value bool) {
+	if value {
+		f.AddString(tag, "Y")
+	} else {
+		f.AddString(tag, "N")
+	}
+}
+
+// AddTime adds a UTCTimestamp field with milliseconds.
+func (f *Fields) AddTime(tag int, value time.Time) {
+	*f = strconv.AppendInt(*f, int64(tag), 10)
+	*f = append(*f, '=')
+	*f = value.UTC().AppendFormat(*f, TimestampFormat)
+	*f = append(*f, SOH)
+}
+
+func (f *Fields) Reset() {
+	*f = (*f)[:0]
+}
+
+// Builder builds a message out of its type, header fields and body fields. BeginString(8), BodyLength(9) and
+// CheckSum(10) are added when the message is encoded, and MsgType(35) precedes the header fields.
+//
+// A Builder is meant to be reused: its buffers grow to fit the largest message built, after which building does not
+// allocate.
+type Builder struct {
+	MsgType string
+	Header  Fields
+	Body    Fields
+}
+
+func NewBuilder(msgType string) *Builder {
+	return &Builder{MsgType: msgType}
+}
+
+// Reset clears the fields and sets the message type.
+func (b *Builder) Reset(msgType string) {
+	b.MsgType = msgType
+	b.Header.Reset()
+	b.Body.Reset()
+}
+
+// bodyLength returns the value of BodyLength(9): the number of bytes from MsgType(35) to CheckSum(10) excluded.
+func (b *Builder) bodyLength() int {
+	return len("35=") + len(b.MsgType) + 1 + len(b.Header) + len(b.Body)
+}
+
+// Len returns the length of the encoded message.
+func (b *Builder) Len(beginString string) int {
+	bodyLength := b.bodyLength()
+	return len("8=") + len(beginString) + 1 +
+		len("9=") + digits(bodyLength) + 1 +
+		bodyLength +
+		len("10=000") + 1
+}
+
+// AppendTo appends the encoded message to dst.
+func (b *Builder) AppendTo(dst []byte, beginString string) []byte {
+	start := len(dst)
+
+	dst = append(dst, "8="...)
+	dst = append(dst, beginString...)
+	dst = append(dst, SOH)
+	dst = append(dst, "9="...)
+	dst = strconv.AppendInt(dst, int64(b.bodyLength()), 10)
+	dst = append(dst, SOH)
+	dst = append(dst, "35="...)
+	dst = append(dst, b.MsgType...)
+	dst = append(dst, SOH)
+	dst = append(dst, b.Header...)
+	dst = append(dst, b.Body...)
+
+	sum := Checksum(dst[start:])
+	dst = append(dst, "10="...)
+	dst = append(dst, '0'+sum/100, '0'+sum/10%10, '0'+sum%10)
+	dst = append(dst, SOH)
+
+	return dst
+}
+
+// Encode writes the encoded message in the write area of dst. It fails with sonicerrors.ErrNoBufferSpaceAvailable,
+// writing nothing, if dst cannot hold the message.
+func (b *Builder) Encode(dst sonic.Buffer, beginString string) error {
+	n := b.Len(beginString)
+	if err := dst.Reserve(n); err != nil {
+		return err
+	}
+	return dst.Claim(func(into []byte) int {
+		return len(b.AppendTo(into[:0], beginString))
+	})
+}
+
+// Checksum returns the sum of the bytes modulo 256, as set in CheckSum(10).
+func Checksum(b []byte) uint8 {
+	var sum uint8
+	for _, c := range b {
+		sum += c
+	}
+	return sum
+}
+
+func digits(n int) int {
+	d := 1
+	for ; n >= 10; n /= 10 {
+		d++
+	}
+	return d
+}
@@ -0,0 +1,159 @@
+package fix
+
+import (
+	"bytes"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var _ sonic.Codec[*Builder, Message] = &Codec{}
+
+const (
+	DefaultMaxMessageLength = 1024 * 1024
+
+	// reserveLen is the room made in the source buffer for an incomplete message, unless the message is known to need
+	// more or the buffer is fixed and has less.
+	reserveLen = 4096
+
+	// trailerLen is the length of the CheckSum(10) field: "10=ddd" followed by SOH.
+	trailerLen = 7
+)
+
+// Codec frames messages by their BodyLength(9) and verifies their CheckSum(10).
+//
+// Decoded messages are slices into the source buffer, valid until the next call to Decode.
+type Codec struct {
+	src sonic.Buffer
+
+	beginString string
+	prefix      []byte // "8=" BeginString SOH "9="
+	maxLen      int
+
+	decodeReset bool
+	decodeBytes int
+}
+
+// NewCodec returns a codec for messages of the given BeginString(8), such as BeginStringFIX44.
+func NewCodec(src sonic.Buffer, beginString string) *Codec {
+	prefix := make([]byte, 0, len(beginString)+5)
+	prefix = append(prefix, "8="...)
+	prefix = append(prefix, beginString...)
+	prefix = append(prefix, SOH)
+	prefix = append(prefix, "9="...)
+
+	return &Codec{
+		src:         src,
+		beginString: beginString,
+		prefix:      prefix,
+		maxLen:      DefaultMaxMessageLength,
+	}
+}
+
+// SetMaxMessageLength bounds the length of messages. Longer messages fail with ErrMessageTooLarge.
+func (c *Codec) SetMaxMessageLength(n int) {
+	c.maxLen = n
+}
+
+func (c *Codec) BeginString() string {
+	return c.beginString
+}
+
+func (c *Codec) Encode(b *Builder, dst sonic.Buffer) error {
+	if b.Len(c.beginString) > c.maxLen {
+		return ErrMessageTooLarge
+	}
+	if err := b.Encode(dst, c.beginString); err != nil {
+		return err
+	}
+	dst.Commit(dst.WriteLen())
+	return nil
+}
+
+func (c *Codec) resetDecode() {
+	if c.decodeReset {
+		c.decodeReset = false
+		c.src.Consume(c.decodeBytes)
+		c.decodeBytes = 0
+	}
+}
+
+// needMore reserves room in src for the n bytes the message is still missing, or for reserveLen bytes if src has room
+// for them. It fails with the error of src if it cannot hold the missing bytes.
+func (c *Codec) needMore(src sonic.Buffer, n int) (Message, error) {
+	if n >= reserveLen || src.Reserve(reserveLen) != nil {
+		if err := src.Reserve(n); err != nil {
+			return nil, err
+		}
+	}
+	return nil, sonicerrors.ErrNeedMore
+}
+
+func (c *Codec) Decode(src sonic.Buffer) (Message, error) {
+	c.resetDecode()
+
+	src.Commit(src.WriteLen())
+	data := src.Data()
+
+	// BeginString(8) and the start of BodyLength(9).
+	n := len(c.prefix)
+	if len(data) < n {
+		if !bytes.HasPrefix(c.prefix, data) {
+			return nil, ErrInvalidBeginString
+		}
+		return c.needMore(src, n-len(data))
+	}
+	if !bytes.Equal(data[:n], c.prefix) {
+		return nil, ErrInvalidBeginString
+	}
+
+	// The value of BodyLength(9).
+	bodyLength := 0
+	for ; ; n++ {
+		if n == len(data) {
+			return c.needMore(src, 1)
+		}
+		ch := data[n]
+		if ch == SOH {
+			break
+		}
+		if ch < '0' || ch > '9' || bodyLength > c.maxLen {
+			return nil, ErrInvalidBodyLength
+		}
+		bodyLength = bodyLength*10 + int(ch-'0')
+	}
+	if n == len(c.prefix) {
+		return nil, ErrInvalidBodyLength
+	}
+	n++ // SOH
+
+	end := n + bodyLength
+	total := end + trailerLen
+	if total > c.maxLen {
+		return nil, ErrMessageTooLarge
+	}
+	if len(data) < total {
+		return c.needMore(src, total-len(data))
+	}
+
+	// CheckSum(10).
+	trailer := data[end:total]
+	if !bytes.HasPrefix(trailer, []byte("10=")) || trailer[trailerLen-1] != SOH {
+		return nil, ErrInvalidBodyLength
+	}
+	sum := 0
+	for _, ch := range trailer[3 : trailerLen-1] {
+		if ch < '0' || ch > '9' {
+			return nil, ErrInvalidChecksum
+		}
+		sum = sum*10 + int(ch-'0')
+	}
+	if sum != int(Checksum(data[:end])) {
+		return nil, ErrInvalidChecksum
+	}
+
+	c.decodeReset = true
+	c.decodeBytes = total
+
+	return Message(data[:total]), nil
+}
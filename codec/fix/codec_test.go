@@ -0,0 +1,115 @@
+package fix
+
+import (
+	"testing"
+
+	"github.com/talostrading/sonic"
+	sonicbytes "github.com/talostrading/sonic/bytes"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	dst := sonic.NewByteBuffer()
+	codec := NewCodec(dst, BeginStringFIX42)
+
+	b := NewBuilder(MsgTypeTestRequest)
+	for i := 0; i < 3; i++ {
+		b.Reset(MsgTypeTestRequest)
+		b.Header.AddInt(TagMsgSeqNum, i+1)
+		b.Body.AddInt(TagTestReqID, i)
+		if err := codec.Encode(b, dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encoded := append([]byte(nil), dst.Data()...)
+
+	// All at once.
+	src := sonic.NewByteBuffer()
+	codec = NewCodec(src, BeginStringFIX42)
+	_, _ = src.Write(encoded)
+	for i := 0; i < 3; i++ {
+		msg, err := codec.Decode(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.SeqNum() != i+1 || !msg.IsType(MsgTypeTestRequest) {
+			t.Fatalf("invalid message %q", msg)
+		}
+	}
+	if _, err := codec.Decode(src); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected ErrNeedMore, got %v", err)
+	}
+
+	// One byte at a time.
+	src = sonic.NewByteBuffer()
+	codec = NewCodec(src, BeginStringFIX42)
+	decoded := 0
+	for i := range encoded {
+		_ = src.WriteByte(encoded[i])
+		msg, err := codec.Decode(src)
+		if err == sonicerrors.ErrNeedMore {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded++
+		if msg.SeqNum() != decoded {
+			t.Fatalf("invalid message %q", msg)
+		}
+	}
+	if decoded != 3 {
+		t.Fatalf("decoded %d messages", decoded)
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		maxLen   int
+		expected error
+	}{
+		{"8=FIX.4.2|9=5|35=0|10=161|", 0, nil},
+		{"8=FIX.4.2|9=5|35=0|10=162|", 0, ErrInvalidChecksum},
+		{"8=FIX.4.2|9=5|35=0|10=1a1|", 0, ErrInvalidChecksum},
+		{"8=FIX.4.2|9=4|35=0|10=161|", 0, ErrInvalidBodyLength},
+		{"8=FIX.4.2|9=|35=0|10=161|", 0, ErrInvalidBodyLength},
+		{"8=FIX.4.2|9=5x|", 0, ErrInvalidBodyLength},
+		{"8=FIX.4.4|9=5|35=0|10=163|", 0, ErrInvalidBeginString},
+		{"9=5|", 0, ErrInvalidBeginString},
+		{"8=FIX.4.2|9=5|35=0|10=161|", 20, ErrMessageTooLarge},
+		{"8=FIX.4.2|9=5|35=0|10=16", 0, sonicerrors.ErrNeedMore},
+	} {
+		src := sonic.NewByteBuffer()
+		codec := NewCodec(src, BeginStringFIX42)
+		if test.maxLen > 0 {
+			codec.SetMaxMessageLength(test.maxLen)
+		}
+		_, _ = src.WriteString(fixString(test.input))
+		if _, err := codec.Decode(src); err != test.expected {
+			t.Fatalf("%q: expected %v, got %v", test.input, test.expected, err)
+		}
+	}
+}
+
+func TestCodecFixedBuffer(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		expected error
+	}{
+		// The buffer has room for the rest of the message, though not for reserveLen more bytes.
+		{"8=FIX.4.2|9=5|35=0|10=16", sonicerrors.ErrNeedMore},
+		{"8=FIX.4.2|9=5000|", sonicerrors.ErrNoBufferSpaceAvailable},
+	} {
+		src, err := sonicbytes.NewMirroredByteBuffer(4096, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codec := NewCodec(src, BeginStringFIX42)
+		_, _ = src.WriteString(fixString(test.input))
+		if _, err := codec.Decode(src); err != test.expected {
+			t.Fatalf("%q: expected %v, got %v", test.input, test.expected, err)
+		}
+		_ = src.Destroy()
+	}
+}
@@ -0,0 +1,67 @@
+// Package fix implements the tag=value encoding of the FIX protocol, versions 4.2 and 4.4, and a session layer on top
+// of it.
+//
+// Messages are framed by their BodyLength(9) and verified against their CheckSum(10). Decoded messages are slices into
+// the connection's read buffer: their fields are read in place with a FieldIterator, without allocating.
+package fix
+
+import "errors"
+
+const (
+	SOH = 0x01 // field delimiter
+
+	BeginStringFIX42 = "FIX.4.2"
+	BeginStringFIX44 = "FIX.4.4"
+
+	// TimestampFormat is the format of UTCTimestamp fields with milliseconds.
+	TimestampFormat = "20060102-15:04:05.000"
+)
+
+// Tags of the standard header and trailer, and of the session level messages.
+const (
+	TagBeginSeqNo      = 7
+	TagBeginString     = 8
+	TagBodyLength      = 9
+	TagCheckSum        = 10
+	TagEndSeqNo        = 16
+	TagMsgSeqNum       = 34
+	TagMsgType         = 35
+	TagNewSeqNo        = 36
+	TagPossDupFlag     = 43
+	TagRefSeqNum       = 45
+	TagSenderCompID    = 49
+	TagSendingTime     = 52
+	TagTargetCompID    = 56
+	TagText            = 58
+	TagEncryptMethod   = 98
+	TagHeartBtInt      = 108
+	TagTestReqID       = 112
+	TagOrigSendingTime = 122
+	TagGapFillFlag     = 123
+	TagResetSeqNumFlag = 141
+)
+
+// Types of the session level messages.
+const (
+	MsgTypeHeartbeat     = "0"
+	MsgTypeTestRequest   = "1"
+	MsgTypeResendRequest = "2"
+	MsgTypeReject        = "3"
+	MsgTypeSequenceReset = "4"
+	MsgTypeLogout        = "5"
+	MsgTypeLogon         = "A"
+)
+
+var (
+	ErrInvalidBeginString = errors.New("fix: invalid BeginString")
+	ErrInvalidBodyLength  = errors.New("fix: invalid BodyLength")
+	ErrInvalidChecksum    = errors.New("fix: invalid CheckSum")
+	ErrMessageTooLarge    = errors.New("fix: message too large")
+	ErrMalformedField     = errors.New("fix: malformed field")
+	ErrFieldNotFound      = errors.New("fix: field not found")
+)
+
+// IsAdmin returns true if the message type is a session level one.
+func IsAdmin(msgType []byte) bool {
+	return len(msgType) == 1 && (msgType[0] >= '0' && msgType[0] <= '5' || msgType[0] == 'A')
+}
@@ -0,0 +1,154 @@
+package fix
+
+import (
+	"bytes"
+	"time"
+)
+
+// Message is a complete message, from BeginString(8) to CheckSum(10) included.
+type Message []byte
+
+// Fields returns an iterator over the fields of the message.
+func (m Message) Fields() FieldIterator {
+	return FieldIterator{b: m}
+}
+
+// Get returns the value of the first field with the given tag.
+func (m Message) Get(tag int) ([]byte, bool) {
+	it := m.Fields()
+	for it.Next() {
+		if it.Tag() == tag {
+			return it.Value(), true
+		}
+	}
+	return nil, false
+}
+
+// GetInt returns the value of the first field with the given tag as an integer.
+func (m Message) GetInt(tag int) (int, error) {
+	v, ok := m.Get(tag)
+	if !ok {
+		return 0, ErrFieldNotFound
+	}
+	return ParseInt(v)
+}
+
+// GetBool returns true if the first field with the given tag is set to Y.
+func (m Message) GetBool(tag int) bool {
+	v, ok := m.Get(tag)
+	return ok && len(v) == 1 && v[0] == 'Y'
+}
+
+// MsgType returns the value of MsgType(35), the third field of the message.
+func (m Message) MsgType() []byte {
+	it := m.Fields()
+	for i := 0; i < 3 && it.Next(); i++ {
+		if it.Tag() == TagMsgType {
+			return it.Value()
+		}
+	}
+	return nil
+}
+
+// IsType returns true if the message is of the given type.
+func (m Message) IsType(msgType string) bool {
+	return string(m.MsgType()) == msgType
+}
+
+// SeqNum returns the value of MsgSeqNum(34), or 0 if the field is missing or malformed.
+func (m Message) SeqNum() int {
+	n, _ := m.GetInt(TagMsgSeqNum)
+	return n
+}
+
+// FieldIterator iterates over the fields of a message without allocating:
+//
+//	it := msg.Fields()
+//	for it.Next() {
+//		tag, value := it.Tag(), it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Values are slices into the message. Length-prefixed data fields which contain SOH are not supported.
+type FieldIterator struct {
+	b     []byte
+	tag   int
+	value []byte
+	err   error
+}
+
+// Next advances to the next field. It returns false once all fields are read or if a field is malformed.
+func (it *FieldIterator) Next() bool {
+	if len(it.b) == 0 || it.err != nil {
+		return false
+	}
+
+	tag, i := 0, 0
+	for ; i < len(it.b) && it.b[i] != '='; i++ {
+		c := it.b[i]
+		if c < '0' || c > '9' || tag > 1e8 {
+			it.err = ErrMalformedField
+			return false
+		}
+		tag = tag*10 + int(c-'0')
+	}
+	if i == 0 || i == len(it.b) {
+		it.err = ErrMalformedField
+		return false
+	}
+
+	rest := it.b[i+1:]
+	j := bytes.IndexByte(rest, SOH)
+	if j < 0 {
+		it.err = ErrMalformedField
+		return false
+	}
+
+	it.tag = tag
+	it.value = rest[:j]
+	it.b = rest[j+1:]
+	return true
+}
+
+func (it *FieldIterator) Tag() int {
+	return it.tag
+}
+
+func (it *FieldIterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (it *FieldIterator) Err() error {
+	return it.err
+}
+
+// ParseInt parses a non-negative integer value without allocating.
+func ParseInt(b []byte) (int, error) {
+	if len(b) == 0 || len(b) > 18 {
+		return 0, ErrMalformedField
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, ErrMalformedField
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, nil
+}
+
+// ParseTimestamp parses a UTCTimestamp value, with or without milliseconds.
+func ParseTimestamp(b []byte) (time.Time, error) {
+	layout := TimestampFormat
+	if len(b) == len("20060102-15:04:05") {
+		layout = layout[:len(b)]
+	}
+	t, err := time.Parse(layout, string(b))
+	if err != nil {
+		return time.Time{}, ErrMalformedField
+	}
+	return t, nil
+}
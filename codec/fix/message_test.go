@@ -0,0 +1,105 @@
+package fix
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func fixString(s string) string {
+	return strings.ReplaceAll(s, "|", "\x01")
+}
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder("D")
+	b.Header.AddString(TagSenderCompID, "CLIENT")
+	b.Header.AddString(TagTargetCompID, "BROKER")
+	b.Header.AddInt(TagMsgSeqNum, 12)
+	b.Header.AddTime(TagSendingTime, time.Date(2024, 3, 1, 9, 30, 0, 5e6, time.UTC))
+	b.Body.AddString(11, "order-1")
+	b.Body.AddInt(38, 100)
+	b.Body.AddBool(TagPossDupFlag, false)
+
+	msg := b.AppendTo(nil, BeginStringFIX44)
+	body := "35=D|49=CLIENT|56=BROKER|34=12|52=20240301-09:30:00.005|11=order-1|38=100|43=N|"
+	prefix := "8=FIX.4.4|9=79|"
+
+	expected := fixString(prefix + body)
+	sum := Checksum([]byte(expected))
+	if string(msg[:len(expected)]) != expected {
+		t.Fatalf("invalid encoding %q", msg)
+	}
+	if trailer := string(msg[len(expected):]); trailer != fixString("10="+threeDigits(sum)+"|") {
+		t.Fatalf("invalid trailer %q", trailer)
+	}
+	if b.Len(BeginStringFIX44) != len(msg) {
+		t.Fatalf("Len=%d encoded %d", b.Len(BeginStringFIX44), len(msg))
+	}
+}
+
+func threeDigits(n uint8) string {
+	return string([]byte{'0' + n/100, '0' + n/10%10, '0' + n%10})
+}
+
+func TestMessageFields(t *testing.T) {
+	msg := Message(fixString("8=FIX.4.4|9=5|35=0|34=7|43=Y|52=20240301-09:30:00|10=000|"))
+
+	var tags []int
+	var values []string
+	it := msg.Fields()
+	for it.Next() {
+		tags = append(tags, it.Tag())
+		values = append(values, string(it.Value()))
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if len(tags) != 7 || tags[2] != TagMsgType || values[3] != "7" || tags[6] != TagCheckSum {
+		t.Fatalf("invalid fields %v %q", tags, values)
+	}
+
+	if !msg.IsType(MsgTypeHeartbeat) || msg.SeqNum() != 7 || !msg.GetBool(TagPossDupFlag) {
+		t.Fatal("invalid header")
+	}
+	if _, err := msg.GetInt(TagText); err != ErrFieldNotFound {
+		t.Fatalf("expected ErrFieldNotFound, got %v", err)
+	}
+	v, _ := msg.Get(TagSendingTime)
+	if ts, err := ParseTimestamp(v); err != nil || !ts.Equal(time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)) {
+		t.Fatalf("invalid timestamp %v %v", ts, err)
+	}
+
+	for _, malformed := range []string{"35", "=0|", "3a=0|", "35=0"} {
+		it := Message(fixString(malformed)).Fields()
+		for it.Next() {
+		}
+		if it.Err() != ErrMalformedField {
+			t.Fatalf("%q: expected ErrMalformedField, got %v", malformed, it.Err())
+		}
+	}
+}
+
+func TestMessageNoAllocs(t *testing.T) {
+	msg := Message(fixString("8=FIX.4.4|9=5|35=D|34=7|11=order-1|38=100|10=000|"))
+	b := NewBuilder("D")
+	dst := make([]byte, 0, 256)
+	now := time.Now()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		it := msg.Fields()
+		for it.Next() {
+		}
+		if n, _ := msg.GetInt(38); n != 100 || !msg.IsType("D") {
+			t.Fatal("invalid message")
+		}
+
+		b.Reset("D")
+		b.Header.AddInt(TagMsgSeqNum, 7)
+		b.Header.AddTime(TagSendingTime, now)
+		b.Body.AddString(11, "order-1")
+		dst = b.AppendTo(dst[:0], BeginStringFIX44)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
@@ -0,0 +1,681 @@
+package fix
+
+import (
+	"errors"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var (
+	ErrNotLoggedOn       = errors.New("fix: session not logged on")
+	ErrSessionClosed     = errors.New("fix: session closed")
+	ErrInvalidCompID     = errors.New("fix: invalid SenderCompID or TargetCompID")
+	ErrSeqNumTooLow      = errors.New("fix: MsgSeqNum too low")
+	ErrHeartbeatTimeout  = errors.New("fix: heartbeat timeout")
+	ErrUnexpectedMessage = errors.New("fix: unexpected message")
+)
+
+const (
+	DefaultHeartBtInt    = 30 * time.Second
+	DefaultLogonTimeout  = 10 * time.Second
+	DefaultLogoutTimeout = 2 * time.Second
+)
+
+// SessionConfig configures a Session.
+type SessionConfig struct {
+	// BeginString of the messages, BeginStringFIX44 if empty.
+	BeginString string
+
+	SenderCompID string
+	TargetCompID string
+
+	// HeartBtInt is the heartbeat interval. It is sent in the Logon message rounded up to the second, while the
+	// timers of the session use it as is. If zero, the initiator sends DefaultHeartBtInt and the acceptor uses the
+	// interval of the initiator's Logon.
+	HeartBtInt time.Duration
+
+	// ResetSeqNumOnLogon resets both sequence numbers to 1 when logging on, and sets ResetSeqNumFlag(141) in the Logon
+	// message.
+	ResetSeqNumOnLogon bool
+
+	// LogonTimeout and LogoutTimeout bound the wait for the counterparty's Logon and Logout. DefaultLogonTimeout and
+	// DefaultLogoutTimeout if zero.
+	LogonTimeout  time.Duration
+	LogoutTimeout time.Duration
+
+	// Store of the sequence numbers and sent messages, a MemoryStore if nil.
+	Store MessageStore
+}
+
+// Application receives the events of a Session. The callbacks are invoked from the IO's goroutine.
+type Application interface {
+	// OnLogon is called once the Logon exchange completes.
+	OnLogon(s *Session)
+
+	// OnMessage is called for each application message and each Reject(3), in sequence. The message is only valid for
+	// the duration of the call.
+	OnMessage(s *Session, msg Message)
+
+	// OnLogout is called once the session is closed, with nil after a Logout exchange and with the cause otherwise.
+	OnLogout(s *Session, err error)
+}
+
+type sessionState uint8
+
+const (
+	stateIdle sessionState = iota
+	stateLogonWait
+	stateActive
+	stateLogoutWait
+	stateClosed
+)
+
+// Session is the session layer of a FIX connection. It logs on and off, keeps the connection alive with heartbeats
+// and test requests, tracks sequence numbers and recovers from sequence gaps with ResendRequest(2) and
+// SequenceReset(4).
+//
+// A Session must only be used from the IO's goroutine.
+type Session struct {
+	ioc    *sonic.IO
+	stream sonic.Stream
+	config SessionConfig
+	app    Application
+	store  MessageStore
+
+	codec *Codec
+	conn  *sonic.CodecConn[*Builder, Message]
+	dst   *sonic.ByteBuffer
+
+	state     sessionState
+	initiator bool
+	writing   bool
+
+	// closeAfterWrite is set once the reply to the counterparty's Logout is sent.
+	closeAfterWrite bool
+	closeErr        error
+
+	// resendUntil is the highest sequence number requested with a ResendRequest, not yet received.
+	resendUntil int
+
+	timer          *sonic.Timer
+	stateSince     time.Time
+	lastSent       time.Time
+	lastReceived   time.Time
+	testRequestAt  time.Time
+	testRequestSeq int
+
+	admin Builder
+}
+
+// NewSession returns a session over the given stream, which is closed along with the session.
+func NewSession(ioc *sonic.IO, stream sonic.Stream, config SessionConfig, app Application) (*Session, error) {
+	if config.BeginString == "" {
+		config.BeginString = BeginStringFIX44
+	}
+	if config.LogonTimeout <= 0 {
+		config.LogonTimeout = DefaultLogonTimeout
+	}
+	if config.LogoutTimeout <= 0 {
+		config.LogoutTimeout = DefaultLogoutTimeout
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryStore()
+	}
+
+	timer, err := sonic.NewTimer(ioc)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		ioc:    ioc,
+		stream: stream,
+		config: config,
+		app:    app,
+		store:  config.Store,
+		dst:    sonic.NewByteBuffer(),
+		timer:  timer,
+	}
+
+	src := sonic.NewByteBuffer()
+	s.codec = NewCodec(src, config.BeginString)
+	s.conn, err = sonic.NewCodecConn[*Builder, Message](stream, s.codec, src, s.dst)
+	if err != nil {
+		_ = timer.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Initiate sends a Logon and starts the session as the initiator.
+func (s *Session) Initiate() error {
+	if s.state != stateIdle {
+		return ErrUnexpectedMessage
+	}
+	if s.config.HeartBtInt <= 0 {
+		s.config.HeartBtInt = DefaultHeartBtInt
+	}
+	if s.config.ResetSeqNumOnLogon {
+		if err := s.store.Reset(); err != nil {
+			return err
+		}
+	}
+
+	s.initiator = true
+	if err := s.sendLogon(); err != nil {
+		return err
+	}
+	s.setState(stateLogonWait)
+	s.read()
+	return nil
+}
+
+// Accept starts the session as the acceptor, waiting for the counterparty's Logon.
+func (s *Session) Accept() {
+	s.setState(stateLogonWait)
+	s.read()
+}
+
+// Send sends an application message. The standard header fields are set by the session: the header fields of the
+// builder are overwritten. The message is saved in the store, so that it can be resent.
+func (s *Session) Send(b *Builder) error {
+	if s.state != stateActive {
+		return ErrNotLoggedOn
+	}
+	return s.send(b)
+}
+
+// Logout sends a Logout with the given text, if not empty, and closes the session once the counterparty replies or
+// after the logout timeout.
+func (s *Session) Logout(text string) error {
+	switch s.state {
+	case stateClosed:
+		return ErrSessionClosed
+	case stateLogoutWait:
+		return nil
+	}
+
+	if err := s.sendLogout(text); err != nil {
+		return err
+	}
+	s.setState(stateLogoutWait)
+	return nil
+}
+
+// Close closes the session without a Logout.
+func (s *Session) Close() error {
+	if s.state == stateClosed {
+		return ErrSessionClosed
+	}
+	s.close(ErrSessionClosed)
+	return nil
+}
+
+// LoggedOn returns true once the Logon exchange completes, until the session is logging out.
+func (s *Session) LoggedOn() bool {
+	return s.state == stateActive
+}
+
+func (s *Session) Config() SessionConfig {
+	return s.config
+}
+
+func (s *Session) Store() MessageStore {
+	return s.store
+}
+
+func (s *Session) setState(state sessionState) {
+	s.state = state
+	s.stateSince = time.Now()
+	s.schedule()
+}
+
+func (s *Session) read() {
+	s.conn.AsyncReadNext(s.onRead)
+}
+
+func (s *Session) onRead(err error, msg Message) {
+	if s.state == stateClosed {
+		return
+	}
+	if err != nil {
+		s.close(err)
+		return
+	}
+
+	s.onMessage(msg)
+
+	if s.state != stateClosed {
+		s.read()
+	}
+}
+
+func (s *Session) onMessage(msg Message) {
+	s.lastReceived = time.Now()
+	s.testRequestSeq = 0
+
+	var sender, target []byte
+	it := msg.Fields()
+	for it.Next() {
+		switch it.Tag() {
+		case TagSenderCompID:
+			sender = it.Value()
+		case TagTargetCompID:
+			target = it.Value()
+		}
+	}
+	if it.Err() != nil {
+		s.logoutAndClose(it.Err())
+		return
+	}
+	if string(sender) != s.config.TargetCompID || string(target) != s.config.SenderCompID {
+		s.logoutAndClose(ErrInvalidCompID)
+		return
+	}
+
+	msgType := msg.MsgType()
+
+	if s.state == stateLogonWait {
+		if string(msgType) != MsgTypeLogon {
+			s.close(ErrUnexpectedMessage)
+			return
+		}
+		if !s.onLogon(msg) {
+			return
+		}
+	}
+
+	// A SequenceReset(4) in reset mode sets the expected sequence number regardless of its own.
+	if string(msgType) == MsgTypeSequenceReset && !msg.GetBool(TagGapFillFlag) {
+		s.onSequenceReset(msg)
+		return
+	}
+
+	seq, err := msg.GetInt(TagMsgSeqNum)
+	if err != nil {
+		s.logoutAndClose(err)
+		return
+	}
+
+	expected := s.store.NextTargetSeqNum()
+	switch {
+	case seq < expected:
+		if !msg.GetBool(TagPossDupFlag) {
+			s.logoutAndClose(ErrSeqNumTooLow)
+		}
+		return
+	case seq > expected:
+		// The gap is requested once and the message dropped, as it will be resent. Resend requests and logouts are
+		// processed regardless, so that both sides can recover at once.
+		if seq > s.resendUntil {
+			s.resendUntil = seq
+			s.sendResendRequest(expected)
+		}
+		switch string(msgType) {
+		case MsgTypeResendRequest:
+			s.onResendRequest(msg)
+		case MsgTypeLogout:
+			s.onLogout(msg)
+		}
+		return
+	}
+
+	if err := s.store.SetNextTargetSeqNum(seq + 1); err != nil {
+		s.close(err)
+		return
+	}
+	if seq >= s.resendUntil {
+		s.resendUntil = 0
+	}
+
+	switch string(msgType) {
+	case MsgTypeLogon, MsgTypeHeartbeat:
+	case MsgTypeTestRequest:
+		s.admin.Reset(MsgTypeHeartbeat)
+		if id, ok := msg.Get(TagTestReqID); ok {
+			s.admin.Body.AddBytes(TagTestReqID, id)
+		}
+		_ = s.send(&s.admin)
+	case MsgTypeResendRequest:
+		s.onResendRequest(msg)
+	case MsgTypeSequenceReset:
+		s.onSequenceReset(msg)
+	case MsgTypeLogout:
+		s.onLogout(msg)
+	default:
+		if s.state == stateActive || s.state == stateLogoutWait {
+			s.app.OnMessage(s, msg)
+		}
+	}
+}
+
+// onLogon completes the Logon exchange. It returns false if the session is closed.
+func (s *Session) onLogon(msg Message) bool {
+	if !s.initiator {
+		if s.config.HeartBtInt <= 0 {
+			secs, err := msg.GetInt(TagHeartBtInt)
+			if err != nil || secs <= 0 {
+				s.close(ErrMalformedField)
+				return false
+			}
+			s.config.HeartBtInt = time.Duration(secs) * time.Second
+		}
+		if msg.GetBool(TagResetSeqNumFlag) {
+			s.config.ResetSeqNumOnLogon = true
+		}
+		if s.config.ResetSeqNumOnLogon {
+			if err := s.store.Reset(); err != nil {
+				s.close(err)
+				return false
+			}
+		}
+		if err := s.sendLogon(); err != nil {
+			s.close(err)
+			return false
+		}
+	}
+
+	s.setState(stateActive)
+	s.app.OnLogon(s)
+	return s.state != stateClosed
+}
+
+func (s *Session) onResendRequest(msg Message) {
+	begin, err := msg.GetInt(TagBeginSeqNo)
+	if err != nil {
+		s.logoutAndClose(err)
+		return
+	}
+	end, err := msg.GetInt(TagEndSeqNo)
+	if err != nil {
+		s.logoutAndClose(err)
+		return
+	}
+
+	last := s.store.NextSenderSeqNum() - 1
+	if end == 0 || end > last {
+		end = last
+	}
+	if begin < 1 {
+		begin = 1
+	}
+
+	// Stored application messages are resent as possible duplicates. Admin messages, which are not stored, are
+	// replaced by gap fills.
+	gap := 0
+	for seq := begin; seq <= end; seq++ {
+		stored, ok := s.store.Get(seq)
+		if !ok {
+			if gap == 0 {
+				gap = seq
+			}
+			continue
+		}
+		if gap != 0 {
+			s.sendGapFill(gap, seq)
+			gap = 0
+		}
+		s.resend(seq, Message(stored))
+	}
+	if gap != 0 {
+		s.sendGapFill(gap, end+1)
+	}
+}
+
+func (s *Session) onSequenceReset(msg Message) {
+	next, err := msg.GetInt(TagNewSeqNo)
+	if err != nil {
+		s.logoutAndClose(err)
+		return
+	}
+	if next > s.store.NextTargetSeqNum() {
+		if err := s.store.SetNextTargetSeqNum(next); err != nil {
+			s.close(err)
+		}
+	}
+}
+
+func (s *Session) onLogout(msg Message) {
+	if s.state == stateLogoutWait {
+		s.close(nil)
+		return
+	}
+
+	if err := s.sendLogout(""); err != nil {
+		s.close(err)
+		return
+	}
+	s.setState(stateLogoutWait)
+	s.closeAfterWrite = true
+	s.flush()
+}
+
+func (s *Session) sendLogon() error {
+	s.admin.Reset(MsgTypeLogon)
+	s.admin.Body.AddInt(TagEncryptMethod, 0)
+	s.admin.Body.AddInt(TagHeartBtInt, int((s.config.HeartBtInt+time.Second-1)/time.Second))
+	if s.config.ResetSeqNumOnLogon {
+		s.admin.Body.AddBool(TagResetSeqNumFlag, true)
+	}
+	return s.send(&s.admin)
+}
+
+func (s *Session) sendLogout(text string) error {
+	s.admin.Reset(MsgTypeLogout)
+	if text != "" {
+		s.admin.Body.AddString(TagText, text)
+	}
+	return s.send(&s.admin)
+}
+
+func (s *Session) sendResendRequest(begin int) {
+	s.admin.Reset(MsgTypeResendRequest)
+	s.admin.Body.AddInt(TagBeginSeqNo, begin)
+	s.admin.Body.AddInt(TagEndSeqNo, 0)
+	_ = s.send(&s.admin)
+}
+
+func (s *Session) sendGapFill(seq, next int) {
+	s.admin.Reset(MsgTypeSequenceReset)
+	s.admin.Body.AddBool(TagGapFillFlag, true)
+	s.admin.Body.AddInt(TagNewSeqNo, next)
+	s.encode(&s.admin, seq, true, nil)
+	s.flush()
+}
+
+// resend resends a stored message with its original sequence number, as a possible duplicate.
+func (s *Session) resend(seq int, stored Message) {
+	var (
+		msgType         []byte
+		origSendingTime []byte
+	)
+	s.admin.Reset("")
+	it := stored.Fields()
+	for it.Next() {
+		switch it.Tag() {
+		case TagBeginString, TagBodyLength, TagCheckSum, TagSenderCompID, TagTargetCompID, TagMsgSeqNum,
+			TagPossDupFlag, TagOrigSendingTime:
+		case TagMsgType:
+			msgType = it.Value()
+		case TagSendingTime:
+			origSendingTime = it.Value()
+		default:
+			s.admin.Body.AddBytes(it.Tag(), it.Value())
+		}
+	}
+	s.admin.MsgType = string(msgType)
+	s.encode(&s.admin, seq, true, origSendingTime)
+	s.flush()
+}
+
+// send sends a message with the next sender sequence number.
+func (s *Session) send(b *Builder) error {
+	seq := s.store.NextSenderSeqNum()
+	msg := s.encode(b, seq, false, nil)
+	if !IsAdmin([]byte(b.MsgType)) {
+		if err := s.store.Save(seq, msg); err != nil {
+			return err
+		}
+	}
+	if err := s.store.SetNextSenderSeqNum(seq + 1); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+// encode writes the standard header in the builder and encodes the message in the send buffer. It returns the encoded
+// message, valid until the buffer is written.
+func (s *Session) encode(b *Builder, seq int, possDup bool, origSendingTime []byte) []byte {
+	now := time.Now()
+
+	b.Header.Reset()
+	b.Header.AddString(TagSenderCompID, s.config.SenderCompID)
+	b.Header.AddString(TagTargetCompID, s.config.TargetCompID)
+	b.Header.AddInt(TagMsgSeqNum, seq)
+	if possDup {
+		b.Header.AddBool(TagPossDupFlag, true)
+	}
+	b.Header.AddTime(TagSendingTime, now)
+	if origSendingTime != nil {
+		b.Header.AddBytes(TagOrigSendingTime, origSendingTime)
+	}
+
+	n := s.dst.ReadLen()
+	_ = b.Encode(s.dst, s.config.BeginString) // s.dst grows on demand
+	s.dst.Commit(s.dst.WriteLen())
+	s.lastSent = now
+
+	return s.dst.Data()[n:]
+}
+
+func (s *Session) flush() {
+	if s.writing || s.state == stateClosed {
+		return
+	}
+	if s.dst.ReadLen() == 0 {
+		if s.closeAfterWrite {
+			s.close(nil)
+		}
+		return
+	}
+
+	s.writing = true
+	s.dst.AsyncWriteTo(s.stream, func(err error, _ int) {
+		s.writing = false
+		if s.state == stateClosed {
+			return
+		}
+		if err != nil {
+			s.close(err)
+			return
+		}
+		s.flush()
+	})
+}
+
+// logoutAndClose sends a Logout with the error as text and closes the session once it is written.
+func (s *Session) logoutAndClose(err error) {
+	if s.state == stateClosed {
+		return
+	}
+	if s.state == stateActive || s.state == stateLogoutWait {
+		if s.sendLogout(err.Error()) == nil {
+			s.state = stateLogoutWait
+			s.closeAfterWrite = true
+			s.closeErr = err
+			s.flush()
+			return
+		}
+	}
+	s.close(err)
+}
+
+// schedule arms the timer for the earliest of the heartbeat, test request and logon/logout deadlines. The deadlines
+// only move forward as messages are exchanged, so the timer is not rearmed for each message: it fires early and is
+// rearmed then.
+func (s *Session) schedule() {
+	if s.state == stateClosed {
+		return
+	}
+
+	now := time.Now()
+	var deadline time.Time
+	switch s.state {
+	case stateLogonWait:
+		deadline = s.stateSince.Add(s.config.LogonTimeout)
+	case stateLogoutWait:
+		deadline = s.stateSince.Add(s.config.LogoutTimeout)
+	case stateActive:
+		hb := s.config.HeartBtInt
+		deadline = s.lastSent.Add(hb)
+		var alive time.Time
+		if s.testRequestSeq != 0 {
+			alive = s.testRequestAt.Add(hb)
+		} else {
+			alive = s.lastReceived.Add(hb + hb/5)
+		}
+		if alive.Before(deadline) {
+			deadline = alive
+		}
+	default:
+		return
+	}
+
+	delay := deadline.Sub(now)
+	if delay <= 0 {
+		delay = time.Nanosecond
+	}
+	_ = s.timer.Cancel()
+	_ = s.timer.ScheduleOnce(delay, s.onTimer)
+}
+
+func (s *Session) onTimer() {
+	now := time.Now()
+	switch s.state {
+	case stateLogonWait, stateLogoutWait:
+		timeout := s.config.LogonTimeout
+		if s.state == stateLogoutWait {
+			timeout = s.config.LogoutTimeout
+		}
+		if !now.Before(s.stateSince.Add(timeout)) {
+			s.close(sonicerrors.ErrTimeout)
+			return
+		}
+	case stateActive:
+		hb := s.config.HeartBtInt
+		if s.testRequestSeq != 0 {
+			if !now.Before(s.testRequestAt.Add(hb)) {
+				s.logoutAndClose(ErrHeartbeatTimeout)
+				return
+			}
+		} else if !now.Before(s.lastReceived.Add(hb + hb/5)) {
+			s.testRequestSeq = s.store.NextSenderSeqNum()
+			s.testRequestAt = now
+			s.admin.Reset(MsgTypeTestRequest)
+			s.admin.Body.AddInt(TagTestReqID, s.testRequestSeq)
+			_ = s.send(&s.admin)
+		}
+		if !now.Before(s.lastSent.Add(hb)) {
+			s.admin.Reset(MsgTypeHeartbeat)
+			_ = s.send(&s.admin)
+		}
+	}
+	s.schedule()
+}
+
+func (s *Session) close(err error) {
+	if s.state == stateClosed {
+		return
+	}
+	if err == nil {
+		err = s.closeErr
+	}
+	s.state = stateClosed
+	_ = s.timer.Close()
+	_ = s.stream.Close()
+	s.app.OnLogout(s, err)
+}
@@ -0,0 +1,412 @@
+package fix
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+type testApp struct {
+	logon    int
+	messages []string
+	logout   bool
+	err      error
+
+	onLogon func(s *Session)
+}
+
+func (a *testApp) OnLogon(s *Session) {
+	a.logon++
+	if a.onLogon != nil {
+		a.onLogon(s)
+	}
+}
+
+func (a *testApp) OnMessage(s *Session, msg Message) {
+	v, _ := msg.Get(TagText)
+	a.messages = append(a.messages, string(msg.MsgType())+":"+string(v))
+}
+
+func (a *testApp) OnLogout(s *Session, err error) {
+	a.logout = true
+	a.err = err
+}
+
+func runUntil(t *testing.T, ioc *sonic.IO, done func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_, _ = ioc.PollOne()
+	}
+}
+
+func newsMessage(text string) *Builder {
+	b := NewBuilder("B")
+	b.Body.AddString(148, "headline")
+	b.Body.AddString(TagText, text)
+	return b
+}
+
+func TestSessionLogonLogout(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:0", sonicopts.Nonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr, err := internal.SocketAddress(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acceptorApp := &testApp{}
+	acceptorApp.onLogon = func(s *Session) {
+		_ = s.Send(newsMessage("from acceptor"))
+	}
+	ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		s, err := NewSession(ioc, conn, SessionConfig{SenderCompID: "BROKER", TargetCompID: "CLIENT"}, acceptorApp)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		s.Accept()
+	})
+
+	conn, err := sonic.Dial(ioc, "tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	initiatorApp := &testApp{}
+	initiator, err := NewSession(ioc, conn, SessionConfig{
+		SenderCompID:       "CLIENT",
+		TargetCompID:       "BROKER",
+		HeartBtInt:         time.Second,
+		ResetSeqNumOnLogon: true,
+	}, initiatorApp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := initiator.Send(newsMessage("too early")); err != ErrNotLoggedOn {
+		t.Fatalf("expected ErrNotLoggedOn, got %v", err)
+	}
+	if err := initiator.Initiate(); err != nil {
+		t.Fatal(err)
+	}
+
+	runUntil(t, ioc, func() bool { return initiatorApp.logon == 1 && len(initiatorApp.messages) == 1 })
+	if initiatorApp.messages[0] != "B:from acceptor" {
+		t.Fatalf("invalid message %q", initiatorApp.messages)
+	}
+	if !initiator.LoggedOn() {
+		t.Fatal("initiator should be logged on")
+	}
+
+	if err := initiator.Send(newsMessage("from initiator")); err != nil {
+		t.Fatal(err)
+	}
+	runUntil(t, ioc, func() bool { return len(acceptorApp.messages) == 1 })
+	if acceptorApp.messages[0] != "B:from initiator" {
+		t.Fatalf("invalid message %q", acceptorApp.messages)
+	}
+
+	if err := initiator.Logout("bye"); err != nil {
+		t.Fatal(err)
+	}
+	runUntil(t, ioc, func() bool { return initiatorApp.logout && acceptorApp.logout })
+	if initiatorApp.err != nil || acceptorApp.err != nil {
+		t.Fatalf("unexpected logout errors %v %v", initiatorApp.err, acceptorApp.err)
+	}
+
+	// Logon(1), the news and Logout(3) were sent by each side.
+	if seq := initiator.Store().NextSenderSeqNum(); seq != 4 {
+		t.Fatalf("invalid next sender sequence number %d", seq)
+	}
+	if seq := initiator.Store().NextTargetSeqNum(); seq != 4 {
+		t.Fatalf("invalid next target sequence number %d", seq)
+	}
+}
+
+// peer is a scripted counterparty, run on its own goroutine over a blocking connection.
+type peer struct {
+	t     *testing.T
+	conn  net.Conn
+	src   *sonic.ByteBuffer
+	codec *Codec
+	b     Builder
+}
+
+func newPeer(t *testing.T, conn net.Conn) *peer {
+	src := sonic.NewByteBuffer()
+	return &peer{t: t, conn: conn, src: src, codec: NewCodec(src, BeginStringFIX44)}
+}
+
+// next reads the next message, which is only valid until the next call.
+func (p *peer) next() Message {
+	for {
+		msg, err := p.codec.Decode(p.src)
+		if err == nil {
+			return msg
+		}
+		if _, err := p.src.ReadFrom(p.conn); err != nil {
+			p.t.Errorf("peer read: %v", err)
+			return nil
+		}
+	}
+}
+
+// expect reads the next message and checks its type and sequence number.
+func (p *peer) expect(msgType string, seq int) Message {
+	msg := p.next()
+	if msg == nil || !msg.IsType(msgType) || msg.SeqNum() != seq {
+		p.t.Errorf("peer expected %s/%d, got %q", msgType, seq, msg)
+	}
+	return msg
+}
+
+func (p *peer) send(msgType string, seq int, possDup bool, body func(*Fields)) {
+	p.b.Reset(msgType)
+	p.b.Header.AddString(TagSenderCompID, "BROKER")
+	p.b.Header.AddString(TagTargetCompID, "CLIENT")
+	p.b.Header.AddInt(TagMsgSeqNum, seq)
+	if possDup {
+		p.b.Header.AddBool(TagPossDupFlag, true)
+	}
+	p.b.Header.AddTime(TagSendingTime, time.Now())
+	if body != nil {
+		body(&p.b.Body)
+	}
+	if _, err := p.conn.Write(p.b.AppendTo(nil, BeginStringFIX44)); err != nil {
+		p.t.Errorf("peer write: %v", err)
+	}
+}
+
+func dialPeer(t *testing.T, script func(p *peer)) (*sonic.IO, sonic.Conn) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		script(newPeer(t, conn))
+	}()
+
+	ioc := sonic.MustIO()
+	t.Cleanup(func() { ioc.Close() })
+	conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ioc, conn
+}
+
+func TestSessionHeartbeat(t *testing.T) {
+	types := make(chan string, 16)
+	ioc, conn := dialPeer(t, func(p *peer) {
+		p.expect(MsgTypeLogon, 1)
+		p.send(MsgTypeLogon, 1, false, func(f *Fields) { f.AddInt(TagHeartBtInt, 1) })
+
+		// The peer goes silent: the session sends heartbeats, then a test request, then gives up.
+		for {
+			msg := p.next()
+			if msg == nil {
+				return
+			}
+			types <- string(msg.MsgType())
+			if msg.IsType(MsgTypeLogout) {
+				return
+			}
+		}
+	})
+
+	app := &testApp{}
+	s, err := NewSession(ioc, conn, SessionConfig{
+		SenderCompID: "CLIENT",
+		TargetCompID: "BROKER",
+		HeartBtInt:   50 * time.Millisecond,
+	}, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Initiate(); err != nil {
+		t.Fatal(err)
+	}
+
+	runUntil(t, ioc, func() bool { return app.logout })
+	if app.err != ErrHeartbeatTimeout {
+		t.Fatalf("expected ErrHeartbeatTimeout, got %v", app.err)
+	}
+
+	var sent []string
+	for len(sent) == 0 || sent[len(sent)-1] != MsgTypeLogout {
+		select {
+		case msgType := <-types:
+			sent = append(sent, msgType)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("peer received %q", sent)
+		}
+	}
+	if sent[0] != MsgTypeHeartbeat || sent[len(sent)-2] == MsgTypeLogout {
+		t.Fatalf("peer received %q", sent)
+	}
+	testRequests := 0
+	for _, msgType := range sent {
+		if msgType == MsgTypeTestRequest {
+			testRequests++
+		}
+	}
+	if testRequests != 1 {
+		t.Fatalf("peer received %q", sent)
+	}
+}
+
+func TestSessionTestRequest(t *testing.T) {
+	done := make(chan struct{})
+	ioc, conn := dialPeer(t, func(p *peer) {
+		defer close(done)
+		p.expect(MsgTypeLogon, 1)
+		p.send(MsgTypeLogon, 1, false, func(f *Fields) { f.AddInt(TagHeartBtInt, 30) })
+		p.send(MsgTypeTestRequest, 2, false, func(f *Fields) { f.AddString(TagTestReqID, "ping") })
+		msg := p.expect(MsgTypeHeartbeat, 2)
+		if id, _ := msg.Get(TagTestReqID); string(id) != "ping" {
+			p.t.Errorf("invalid TestReqID %q", id)
+		}
+	})
+
+	app := &testApp{}
+	s, err := NewSession(ioc, conn, SessionConfig{SenderCompID: "CLIENT", TargetCompID: "BROKER"}, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Initiate(); err != nil {
+		t.Fatal(err)
+	}
+
+	finished := false
+	go func() {
+		<-done
+		ioc.Post(func() { finished = true })
+	}()
+	runUntil(t, ioc, func() bool { return finished })
+}
+
+func TestSessionGapFill(t *testing.T) {
+	done := make(chan struct{})
+	ioc, conn := dialPeer(t, func(p *peer) {
+		defer close(done)
+
+		p.expect(MsgTypeLogon, 1)
+		p.send(MsgTypeLogon, 1, false, func(f *Fields) { f.AddInt(TagHeartBtInt, 30) })
+		p.expect("B", 2)
+		p.expect("B", 3)
+
+		// The peer asks for everything: the Logon is gap filled and the news resent.
+		p.send(MsgTypeResendRequest, 2, false, func(f *Fields) {
+			f.AddInt(TagBeginSeqNo, 1)
+			f.AddInt(TagEndSeqNo, 0)
+		})
+		msg := p.expect(MsgTypeSequenceReset, 1)
+		if !msg.GetBool(TagGapFillFlag) || !msg.GetBool(TagPossDupFlag) {
+			p.t.Errorf("invalid gap fill %q", msg)
+		}
+		if next, _ := msg.GetInt(TagNewSeqNo); next != 2 {
+			p.t.Errorf("invalid NewSeqNo %d", next)
+		}
+		for seq, text := range []string{"one", "two"} {
+			msg := p.expect("B", seq+2)
+			if v, _ := msg.Get(TagText); string(v) != text || !msg.GetBool(TagPossDupFlag) {
+				p.t.Errorf("invalid resent message %q", msg)
+			}
+			if _, ok := msg.Get(TagOrigSendingTime); !ok {
+				p.t.Errorf("resent message without OrigSendingTime %q", msg)
+			}
+		}
+
+		// The peer skips 3 and 4: the session asks for them and drops 5.
+		p.send("B", 5, false, func(f *Fields) { f.AddString(TagText, "five") })
+		msg = p.expect(MsgTypeResendRequest, 4)
+		if begin, _ := msg.GetInt(TagBeginSeqNo); begin != 3 {
+			p.t.Errorf("invalid BeginSeqNo %d", begin)
+		}
+		p.send(MsgTypeSequenceReset, 3, true, func(f *Fields) {
+			f.AddBool(TagGapFillFlag, true)
+			f.AddInt(TagNewSeqNo, 5)
+		})
+		p.send("B", 5, true, func(f *Fields) { f.AddString(TagText, "five") })
+
+		// Duplicates are ignored.
+		p.send("B", 5, true, func(f *Fields) { f.AddString(TagText, "five again") })
+		p.send("B", 6, false, func(f *Fields) { f.AddString(TagText, "six") })
+
+		p.send(MsgTypeLogout, 7, false, nil)
+		p.expect(MsgTypeLogout, 5)
+	})
+
+	app := &testApp{}
+	app.onLogon = func(s *Session) {
+		_ = s.Send(newsMessage("one"))
+		_ = s.Send(newsMessage("two"))
+	}
+	s, err := NewSession(ioc, conn, SessionConfig{SenderCompID: "CLIENT", TargetCompID: "BROKER"}, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Initiate(); err != nil {
+		t.Fatal(err)
+	}
+
+	runUntil(t, ioc, func() bool { return app.logout })
+	<-done
+	if app.err != nil {
+		t.Fatal(app.err)
+	}
+	if len(app.messages) != 2 || app.messages[0] != "B:five" || app.messages[1] != "B:six" {
+		t.Fatalf("invalid messages %q", app.messages)
+	}
+	if seq := s.Store().NextTargetSeqNum(); seq != 8 {
+		t.Fatalf("invalid next target sequence number %d", seq)
+	}
+}
+
+func TestSessionSeqNumTooLow(t *testing.T) {
+	ioc, conn := dialPeer(t, func(p *peer) {
+		p.expect(MsgTypeLogon, 1)
+		p.send(MsgTypeLogon, 1, false, func(f *Fields) { f.AddInt(TagHeartBtInt, 30) })
+		p.send("B", 1, false, nil)
+		msg := p.expect(MsgTypeLogout, 2)
+		if v, _ := msg.Get(TagText); string(v) != ErrSeqNumTooLow.Error() {
+			p.t.Errorf("invalid logout text %q", v)
+		}
+	})
+
+	app := &testApp{}
+	s, err := NewSession(ioc, conn, SessionConfig{SenderCompID: "CLIENT", TargetCompID: "BROKER"}, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Initiate(); err != nil {
+		t.Fatal(err)
+	}
+
+	runUntil(t, ioc, func() bool { return app.logout })
+	if app.err != ErrSeqNumTooLow {
+		t.Fatalf("expected ErrSeqNumTooLow, got %v", app.err)
+	}
+}
@@ -0,0 +1,75 @@
+package fix
+
+// MessageStore keeps the sequence numbers of a session and the messages it sent, so that they can be resent when the
+// counterparty requests it. Implementations may persist them to survive restarts.
+type MessageStore interface {
+	// NextSenderSeqNum returns the sequence number of the next message sent.
+	NextSenderSeqNum() int
+
+	// NextTargetSeqNum returns the sequence number expected from the next message received.
+	NextTargetSeqNum() int
+
+	SetNextSenderSeqNum(seq int) error
+	SetNextTargetSeqNum(seq int) error
+
+	// Save saves a sent message. The message must be copied as it is only valid for the duration of the call.
+	Save(seq int, msg []byte) error
+
+	// Get returns the message sent with the given sequence number, if any.
+	Get(seq int) ([]byte, bool)
+
+	// Reset sets both sequence numbers to 1 and drops all saved messages.
+	Reset() error
+}
+
+var _ MessageStore = &MemoryStore{}
+
+// MemoryStore is a MessageStore which keeps everything in memory.
+type MemoryStore struct {
+	sender, target int
+	messages       map[int][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sender:   1,
+		target:   1,
+		messages: make(map[int][]byte),
+	}
+}
+
+func (s *MemoryStore) NextSenderSeqNum() int {
+	return s.sender
+}
+
+func (s *MemoryStore) NextTargetSeqNum() int {
+	return s.target
+}
+
+func (s *MemoryStore) SetNextSenderSeqNum(seq int) error {
+	s.sender = seq
+	return nil
+}
+
+func (s *MemoryStore) SetNextTargetSeqNum(seq int) error {
+	s.target = seq
+	return nil
+}
+
+func (s *MemoryStore) Save(seq int, msg []byte) error {
+	s.messages[seq] = append(s.messages[seq][:0], msg...)
+	return nil
+}
+
+func (s *MemoryStore) Get(seq int) ([]byte, bool) {
+	msg, ok := s.messages[seq]
+	return msg, ok
+}
+
+func (s *MemoryStore) Reset() error {
+	s.sender, s.target = 1, 1
+	for seq := range s.messages {
+		delete(s.messages, seq)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package fix
+
+import "testing"
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	if s.NextSenderSeqNum() != 1 || s.NextTargetSeqNum() != 1 {
+		t.Fatal("sequence numbers should start at 1")
+	}
+
+	msg := []byte("message")
+	_ = s.Save(1, msg)
+	msg[0] = 'M'
+	if stored, ok := s.Get(1); !ok || string(stored) != "message" {
+		t.Fatalf("invalid stored message %q", stored)
+	}
+	if _, ok := s.Get(2); ok {
+		t.Fatal("unexpected stored message")
+	}
+
+	_ = s.SetNextSenderSeqNum(5)
+	_ = s.SetNextTargetSeqNum(7)
+	if s.NextSenderSeqNum() != 5 || s.NextTargetSeqNum() != 7 {
+		t.Fatal("invalid sequence numbers")
+	}
+
+	_ = s.Reset()
+	if _, ok := s.Get(1); ok || s.NextSenderSeqNum() != 1 || s.NextTargetSeqNum() != 1 {
+		t.Fatal("store not reset")
+	}
+}
@@ -20,32 +20,32 @@ const (
 )
 
 type Codec struct {
-	src *sonic.ByteBuffer
+	src sonic.Buffer
 
 	decodeReset bool
 	decodeBytes int
 }
 
-func NewCodec(src *sonic.ByteBuffer) *Codec {
+func NewCodec(src sonic.Buffer) *Codec {
 	return &Codec{src: src}
 }
 
-func (c *Codec) Encode(frame []byte, dst *sonic.ByteBuffer) error {
+func (c *Codec) Encode(frame []byte, dst sonic.Buffer) error {
 	payloadLen := len(frame)
 
 	if len(frame) > MaxPayloadLength {
 		return ErrPayloadLengthOverflow
 	}
 
-	dst.Reserve(HeaderLen + payloadLen)
+	if err := dst.Reserve(HeaderLen + payloadLen); err != nil {
+		return err
+	}
 
-	dst.Claim(func(into []byte) int {
+	return dst.Claim(func(into []byte) int {
 		binary.BigEndian.PutUint32(into[:HeaderLen], uint32(payloadLen))
 		copy(into[HeaderLen:], frame)
 		return HeaderLen + payloadLen
 	})
-
-	return nil
 }
 
 func (c *Codec) resetDecode() {
@@ -56,7 +56,7 @@ func (c *Codec) resetDecode() {
 	}
 }
 
-func (c *Codec) Decode(src *sonic.ByteBuffer) ([]byte, error) {
+func (c *Codec) Decode(src sonic.Buffer) ([]byte, error) {
 	c.resetDecode()
 
 	if err := src.PrepareRead(HeaderLen); err != nil {
@@ -0,0 +1,241 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var (
+	_ sonic.Codec[[]byte, []byte] = &LengthCodec{}
+
+	ErrInvalidHeaderWidth = errors.New("invalid length header width")
+	ErrInvalidLength      = errors.New("invalid length")
+)
+
+// Uvarint is the header width of lengths encoded as unsigned varints, see encoding/binary.
+const Uvarint = -1
+
+// LengthConfig describes how frames are delimited by a length header. The zero value describes the frames of Codec:
+// a 4 byte big-endian length which excludes the header.
+//
+// The length field and the payload length are related by:
+//
+//	payload length = field + Adjustment - (header width if IncludesHeader)
+//
+// where the payload is everything following the length field. For example, the Simple Open Framing Header is a 4 byte
+// big-endian message length including the header, followed by a 2 byte encoding type: it is described by
+// LengthConfig{Width: 4, IncludesHeader: true}, the payload starting with the encoding type.
+type LengthConfig struct {
+	// Width of the length field in bytes: 1, 2, 4, 8 or Uvarint. 4 if 0.
+	Width int
+
+	// ByteOrder of fixed width length fields of more than 1 byte. binary.BigEndian if nil.
+	ByteOrder binary.ByteOrder
+
+	// IncludesHeader is true if the length field counts its own width.
+	IncludesHeader bool
+
+	// Adjustment is added to the length field to obtain the payload length.
+	Adjustment int
+
+	// MaxPayloadLength bounds the payload of encoded and decoded frames. MaxPayloadLength if 0.
+	MaxPayloadLength int
+}
+
+// LengthCodec is a length-delimited codec configured by a LengthConfig.
+//
+// Decoded payloads are slices into the source buffer, valid until the next call to Decode.
+type LengthCodec struct {
+	src sonic.Buffer
+
+	width      int
+	order      binary.ByteOrder
+	includes   bool
+	adjustment int
+	maxPayload int
+	maxField   uint64
+
+	decodeReset bool
+	decodeBytes int
+}
+
+func NewLengthCodec(src sonic.Buffer, config LengthConfig) (*LengthCodec, error) {
+	c := &LengthCodec{
+		src:        src,
+		width:      config.Width,
+		order:      config.ByteOrder,
+		includes:   config.IncludesHeader,
+		adjustment: config.Adjustment,
+		maxPayload: config.MaxPayloadLength,
+	}
+
+	if c.width == 0 {
+		c.width = HeaderLen
+	}
+	switch c.width {
+	case 1, 2, 4, 8:
+		c.maxField = math.MaxUint64 >> (64 - 8*c.width)
+	case Uvarint:
+		c.maxField = math.MaxUint64
+	default:
+		return nil, ErrInvalidHeaderWidth
+	}
+
+	if c.order == nil {
+		c.order = binary.BigEndian
+	}
+	if c.maxPayload <= 0 {
+		c.maxPayload = MaxPayloadLength
+	}
+
+	return c, nil
+}
+
+// headerLen returns the width of the length field which encodes the given value.
+func (c *LengthCodec) headerLen(field uint64) int {
+	if c.width == Uvarint {
+		n := 1
+		for ; field >= 0x80; field >>= 7 {
+			n++
+		}
+		return n
+	}
+	return c.width
+}
+
+func (c *LengthCodec) Encode(payload []byte, dst sonic.Buffer) error {
+	if len(payload) > c.maxPayload {
+		return ErrPayloadLengthOverflow
+	}
+
+	field := int64(len(payload)) - int64(c.adjustment)
+	if c.includes {
+		if c.width == Uvarint {
+			// The width of the field depends on its value, which depends on the width.
+			n := int64(1)
+			for field+n >= 0 && int64(c.headerLen(uint64(field+n))) > n {
+				n++
+			}
+			field += n
+		} else {
+			field += int64(c.width)
+		}
+	}
+	if field < 0 {
+		return ErrInvalidLength
+	}
+	if uint64(field) > c.maxField {
+		return ErrPayloadLengthOverflow
+	}
+
+	headerLen := c.headerLen(uint64(field))
+	if err := dst.Reserve(headerLen + len(payload)); err != nil {
+		return err
+	}
+
+	return dst.Claim(func(into []byte) int {
+		c.putField(into, uint64(field))
+		copy(into[headerLen:], payload)
+		return headerLen + len(payload)
+	})
+}
+
+func (c *LengthCodec) putField(into []byte, field uint64) {
+	switch c.width {
+	case Uvarint:
+		binary.PutUvarint(into, field)
+	case 1:
+		into[0] = uint8(field)
+	case 2:
+		c.order.PutUint16(into, uint16(field))
+	case 4:
+		c.order.PutUint32(into, uint32(field))
+	case 8:
+		c.order.PutUint64(into, field)
+	}
+}
+
+func (c *LengthCodec) resetDecode() {
+	if c.decodeReset {
+		c.decodeReset = false
+		c.src.Consume(c.decodeBytes)
+		c.decodeBytes = 0
+	}
+}
+
+func (c *LengthCodec) Decode(src sonic.Buffer) ([]byte, error) {
+	c.resetDecode()
+
+	field, headerLen, err := c.field(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if field > math.MaxInt64>>1 {
+		return nil, ErrPayloadLengthOverflow
+	}
+
+	payloadLen := int64(field) + int64(c.adjustment)
+	if c.includes {
+		payloadLen -= int64(headerLen)
+	}
+	if payloadLen < 0 {
+		return nil, ErrInvalidLength
+	}
+	if payloadLen > int64(c.maxPayload) {
+		return nil, ErrPayloadLengthOverflow
+	}
+
+	frameLen := headerLen + int(payloadLen)
+	if err := src.PrepareRead(frameLen); err != nil {
+		if err == sonicerrors.ErrNeedMore {
+			src.Reserve(frameLen)
+		}
+		return nil, err
+	}
+
+	c.decodeReset = true
+	c.decodeBytes = frameLen
+
+	return src.Data()[headerLen:frameLen], nil
+}
+
+// field reads the length field at the start of the read area, committing bytes as needed.
+func (c *LengthCodec) field(src sonic.Buffer) (field uint64, headerLen int, err error) {
+	if c.width != Uvarint {
+		if err := src.PrepareRead(c.width); err != nil {
+			return 0, 0, err
+		}
+
+		b := src.Data()
+		switch c.width {
+		case 1:
+			return uint64(b[0]), 1, nil
+		case 2:
+			return uint64(c.order.Uint16(b)), 2, nil
+		case 4:
+			return uint64(c.order.Uint32(b)), 4, nil
+		default:
+			return c.order.Uint64(b), 8, nil
+		}
+	}
+
+	// Varints are committed one byte at a time, up to the last byte of the field.
+	for n := 1; n <= binary.MaxVarintLen64; n++ {
+		if err := src.PrepareRead(n); err != nil {
+			return 0, 0, err
+		}
+		field, headerLen = binary.Uvarint(src.Data())
+		if headerLen > 0 {
+			return field, headerLen, nil
+		}
+		if headerLen < 0 {
+			return 0, 0, ErrPayloadLengthOverflow
+		}
+	}
+	return 0, 0, ErrPayloadLengthOverflow
+}
@@ -0,0 +1,194 @@
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var lengthConfigs = []LengthConfig{
+	{},
+	{Width: 1},
+	{Width: 2, ByteOrder: binary.LittleEndian},
+	{Width: 4, ByteOrder: binary.LittleEndian, IncludesHeader: true},
+	{Width: 8},
+	{Width: Uvarint},
+	{Width: Uvarint, IncludesHeader: true},
+	{Width: 2, Adjustment: 3, MaxPayloadLength: 1024},
+	{Width: 4, IncludesHeader: true, Adjustment: -2},
+}
+
+func TestLengthCodecDefault(t *testing.T) {
+	// The zero config matches Codec.
+	src := sonic.NewByteBuffer()
+	codec, err := NewLengthCodec(src, LengthConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewCodec(src).Encode([]byte("hello"), src); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := codec.Decode(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "hello" {
+		t.Fatalf("invalid payload %q", payload)
+	}
+}
+
+func TestLengthCodecHeader(t *testing.T) {
+	for _, test := range []struct {
+		config LengthConfig
+		header []byte
+	}{
+		{LengthConfig{Width: 1}, []byte{5}},
+		{LengthConfig{Width: 2, ByteOrder: binary.LittleEndian}, []byte{5, 0}},
+		{LengthConfig{Width: 2}, []byte{0, 5}},
+		{LengthConfig{Width: 4, IncludesHeader: true}, []byte{0, 0, 0, 9}},
+		{LengthConfig{Width: 8, ByteOrder: binary.LittleEndian}, []byte{5, 0, 0, 0, 0, 0, 0, 0}},
+		{LengthConfig{Width: Uvarint}, []byte{5}},
+		{LengthConfig{Width: Uvarint, IncludesHeader: true}, []byte{6}},
+		{LengthConfig{Width: 1, Adjustment: 2}, []byte{3}},
+	} {
+		t.Run(fmt.Sprintf("%+v", test.config), func(t *testing.T) {
+			dst := sonic.NewByteBuffer()
+			codec, err := NewLengthCodec(dst, test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := codec.Encode([]byte("hello"), dst); err != nil {
+				t.Fatal(err)
+			}
+			dst.Commit(dst.WriteLen())
+
+			expected := append(append([]byte(nil), test.header...), "hello"...)
+			if string(dst.Data()) != string(expected) {
+				t.Fatalf("encoded %v expected %v", dst.Data(), expected)
+			}
+		})
+	}
+}
+
+func TestLengthCodecSOFH(t *testing.T) {
+	// Simple Open Framing Header: total length including the 6 byte header, then the encoding type.
+	src := sonic.NewByteBuffer()
+	_, _ = src.Write([]byte{0, 0, 0, 9, 0x50, 0x00, 'a', 'b', 'c'})
+
+	codec, err := NewLengthCodec(src, LengthConfig{Width: 4, IncludesHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := codec.Decode(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding := binary.BigEndian.Uint16(payload); encoding != 0x5000 {
+		t.Fatalf("invalid encoding type %x", encoding)
+	}
+	if string(payload[2:]) != "abc" {
+		t.Fatalf("invalid message %q", payload[2:])
+	}
+}
+
+func TestLengthCodecRoundTrip(t *testing.T) {
+	for _, config := range lengthConfigs {
+		t.Run(fmt.Sprintf("%+v", config), func(t *testing.T) {
+			buf := sonic.NewByteBuffer()
+			codec, err := NewLengthCodec(buf, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var payloads [][]byte
+			for _, n := range []int{0, 1, 127, 128, 255, 256, 1000, 16384} {
+				if config.Width == 1 && n > 255-config.Adjustment {
+					continue
+				}
+				if config.MaxPayloadLength > 0 && n > config.MaxPayloadLength {
+					continue
+				}
+				if !config.IncludesHeader && n < config.Adjustment {
+					continue // the length field would be negative
+				}
+				b := make([]byte, n)
+				rand.Read(b)
+				payloads = append(payloads, b)
+			}
+
+			for _, b := range payloads {
+				if err := codec.Encode(b, buf); err != nil {
+					t.Fatalf("encode %d: %v", len(b), err)
+				}
+			}
+
+			// Decode everything one byte at a time.
+			buf.Commit(buf.WriteLen())
+			encoded := append([]byte(nil), buf.Data()...)
+			buf.Reset()
+
+			decoded := 0
+			for i := 0; i < len(encoded); i++ {
+				_ = buf.WriteByte(encoded[i])
+				for {
+					payload, err := codec.Decode(buf)
+					if err == sonicerrors.ErrNeedMore {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(payload) != string(payloads[decoded]) {
+						t.Fatalf("payload %d differs", decoded)
+					}
+					decoded++
+				}
+			}
+			if decoded != len(payloads) {
+				t.Fatalf("decoded %d frames, expected %d", decoded, len(payloads))
+			}
+		})
+	}
+}
+
+func TestLengthCodecErrors(t *testing.T) {
+	if _, err := NewLengthCodec(nil, LengthConfig{Width: 3}); err != ErrInvalidHeaderWidth {
+		t.Fatalf("expected ErrInvalidHeaderWidth, got %v", err)
+	}
+
+	buf := sonic.NewByteBuffer()
+	codec, _ := NewLengthCodec(buf, LengthConfig{Width: 1})
+	if err := codec.Encode(make([]byte, 256), buf); err != ErrPayloadLengthOverflow {
+		t.Fatalf("expected ErrPayloadLengthOverflow, got %v", err)
+	}
+
+	codec, _ = NewLengthCodec(buf, LengthConfig{Width: 2, MaxPayloadLength: 10})
+	if err := codec.Encode(make([]byte, 11), buf); err != ErrPayloadLengthOverflow {
+		t.Fatalf("expected ErrPayloadLengthOverflow, got %v", err)
+	}
+	_, _ = buf.Write([]byte{0, 11})
+	if _, err := codec.Decode(buf); err != ErrPayloadLengthOverflow {
+		t.Fatalf("expected ErrPayloadLengthOverflow, got %v", err)
+	}
+
+	// A length including the header cannot be smaller than the header.
+	buf = sonic.NewByteBuffer()
+	codec, _ = NewLengthCodec(buf, LengthConfig{Width: 4, IncludesHeader: true})
+	_, _ = buf.Write([]byte{0, 0, 0, 3})
+	if _, err := codec.Decode(buf); err != ErrInvalidLength {
+		t.Fatalf("expected ErrInvalidLength, got %v", err)
+	}
+
+	// Varints longer than 64 bits overflow.
+	buf = sonic.NewByteBuffer()
+	codec, _ = NewLengthCodec(buf, LengthConfig{Width: Uvarint})
+	_, _ = buf.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	if _, err := codec.Decode(buf); err != ErrPayloadLengthOverflow {
+		t.Fatalf("expected ErrPayloadLengthOverflow, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Package line implements a codec for text protocols whose messages are terminated by a delimiter, usually a newline.
+package line
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var (
+	_ sonic.Codec[[]byte, []byte] = &Codec{}
+
+	ErrLineTooLong      = errors.New("line too long")
+	ErrDelimiterInLine  = errors.New("line contains the delimiter")
+	ErrInvalidDelimiter = errors.New("invalid delimiter")
+)
+
+var (
+	LF   = []byte("\n")
+	CRLF = []byte("\r\n")
+)
+
+const (
+	DefaultMaxLineLength = 64 * 1024 // bytes, excluding the delimiter
+
+	// reserveLen bytes are reserved in the source buffer while no delimiter is found, if it has room for them.
+	reserveLen = 4096
+)
+
+// Codec splits the bytes of a stream into lines terminated by a delimiter.
+//
+// Decoded lines are slices into the source buffer, valid until the next call to Decode. By default they include their
+// delimiter, see SetStripDelimiter.
+type Codec struct {
+	src sonic.Buffer
+
+	delimiter []byte
+	maxLen    int
+	strip     bool
+
+	// Number of bytes of the read area searched for the delimiter without success.
+	scanned int
+
+	decodeReset bool
+	decodeBytes int
+}
+
+// NewCodec returns a codec for lines terminated by the given delimiter, such as LF or CRLF.
+func NewCodec(src sonic.Buffer, delimiter []byte) (*Codec, error) {
+	if len(delimiter) == 0 {
+		return nil, ErrInvalidDelimiter
+	}
+	return &Codec{
+		src:       src,
+		delimiter: append([]byte(nil), delimiter...),
+		maxLen:    DefaultMaxLineLength,
+	}, nil
+}
+
+// SetMaxLineLength bounds the length of lines, excluding their delimiter. Longer lines fail with ErrLineTooLong.
+func (c *Codec) SetMaxLineLength(n int) {
+	c.maxLen = n
+}
+
+// SetStripDelimiter sets whether the delimiter is stripped from decoded lines.
+func (c *Codec) SetStripDelimiter(strip bool) {
+	c.strip = strip
+}
+
+// Encode writes the line followed by the delimiter. The line must not contain the delimiter.
+func (c *Codec) Encode(line []byte, dst sonic.Buffer) error {
+	if len(line) > c.maxLen {
+		return ErrLineTooLong
+	}
+	if bytes.Contains(line, c.delimiter) {
+		return ErrDelimiterInLine
+	}
+
+	n := len(line) + len(c.delimiter)
+	if err := dst.Reserve(n); err != nil {
+		return err
+	}
+	return dst.Claim(func(into []byte) int {
+		copy(into[copy(into, line):], c.delimiter)
+		return n
+	})
+}
+
+func (c *Codec) resetDecode() {
+	if c.decodeReset {
+		c.decodeReset = false
+		c.src.Consume(c.decodeBytes)
+		c.decodeBytes = 0
+	}
+}
+
+func (c *Codec) Decode(src sonic.Buffer) ([]byte, error) {
+	c.resetDecode()
+
+	src.Commit(src.WriteLen())
+	data := src.Data()
+
+	// Bytes already searched are not searched again, except for the ones which may start a delimiter.
+	from := c.scanned - len(c.delimiter) + 1
+	if from < 0 {
+		from = 0
+	}
+
+	i := bytes.Index(data[from:], c.delimiter)
+	if i < 0 {
+		c.scanned = len(data)
+		if len(data) >= c.maxLen+len(c.delimiter) {
+			return nil, ErrLineTooLong
+		}
+		// At least one more byte is needed to find the delimiter.
+		if src.Reserve(reserveLen) != nil {
+			if err := src.Reserve(1); err != nil {
+				return nil, err
+			}
+		}
+		return nil, sonicerrors.ErrNeedMore
+	}
+
+	end := from + i
+	if end > c.maxLen {
+		return nil, ErrLineTooLong
+	}
+
+	c.scanned = 0
+	c.decodeReset = true
+	c.decodeBytes = end + len(c.delimiter)
+
+	if c.strip {
+		return data[:end], nil
+	}
+	return data[:c.decodeBytes], nil
+}
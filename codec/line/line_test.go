@@ -0,0 +1,211 @@
+package line
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	sonicbytes "github.com/talostrading/sonic/bytes"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func decodeAll(t *testing.T, codec *Codec, src *sonic.ByteBuffer) (lines []string) {
+	for {
+		line, err := codec.Decode(src)
+		if err == sonicerrors.ErrNeedMore {
+			return lines
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, test := range []struct {
+		delimiter []byte
+		strip     bool
+		input     string
+		expected  []string
+	}{
+		{LF, false, "a\nbc\n\nd", []string{"a\n", "bc\n", "\n"}},
+		{LF, true, "a\nbc\n\nd", []string{"a", "bc", ""}},
+		{CRLF, true, "a\r\nb\nc\r\n\r", []string{"a", "b\nc"}},
+		{[]byte("||"), true, "a|b||c|||d||", []string{"a|b", "c", "|d"}},
+	} {
+		src := sonic.NewByteBuffer()
+		codec, err := NewCodec(src, test.delimiter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codec.SetStripDelimiter(test.strip)
+
+		// All at once.
+		_, _ = src.WriteString(test.input)
+		if lines := decodeAll(t, codec, src); strings.Join(lines, ",") != strings.Join(test.expected, ",") {
+			t.Fatalf("delimiter=%q decoded %q expected %q", test.delimiter, lines, test.expected)
+		}
+
+		// One byte at a time.
+		src = sonic.NewByteBuffer()
+		codec, _ = NewCodec(src, test.delimiter)
+		codec.SetStripDelimiter(test.strip)
+		var lines []string
+		for i := 0; i < len(test.input); i++ {
+			_ = src.WriteByte(test.input[i])
+			lines = append(lines, decodeAll(t, codec, src)...)
+		}
+		if strings.Join(lines, ",") != strings.Join(test.expected, ",") {
+			t.Fatalf("delimiter=%q decoded %q expected %q", test.delimiter, lines, test.expected)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	dst := sonic.NewByteBuffer()
+	codec, _ := NewCodec(dst, CRLF)
+	codec.SetMaxLineLength(4)
+
+	if err := codec.Encode([]byte("abcd"), dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.Encode(nil, dst); err != nil {
+		t.Fatal(err)
+	}
+	dst.Commit(dst.WriteLen())
+	if string(dst.Data()) != "abcd\r\n\r\n" {
+		t.Fatalf("invalid encoding %q", dst.Data())
+	}
+
+	if err := codec.Encode([]byte("abcde"), dst); err != ErrLineTooLong {
+		t.Fatalf("expected ErrLineTooLong, got %v", err)
+	}
+	if err := codec.Encode([]byte("a\r\nb"), dst); err != ErrDelimiterInLine {
+		t.Fatalf("expected ErrDelimiterInLine, got %v", err)
+	}
+	if _, err := NewCodec(dst, nil); err != ErrInvalidDelimiter {
+		t.Fatalf("expected ErrInvalidDelimiter, got %v", err)
+	}
+}
+
+func TestMaxLineLength(t *testing.T) {
+	src := sonic.NewByteBuffer()
+	codec, _ := NewCodec(src, CRLF)
+	codec.SetMaxLineLength(4)
+
+	// A line of the maximum length, whose delimiter is split across reads.
+	_, _ = src.WriteString("abcd\r")
+	if _, err := codec.Decode(src); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected ErrNeedMore, got %v", err)
+	}
+	_ = src.WriteByte('\n')
+	if line, err := codec.Decode(src); err != nil || string(line) != "abcd\r\n" {
+		t.Fatalf("decoded %q %v", line, err)
+	}
+
+	// Without a delimiter in sight.
+	_, _ = src.WriteString("abcde")
+	if _, err := codec.Decode(src); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected ErrNeedMore, got %v", err)
+	}
+	_ = src.WriteByte('f')
+	if _, err := codec.Decode(src); err != ErrLineTooLong {
+		t.Fatalf("expected ErrLineTooLong, got %v", err)
+	}
+
+	// With a delimiter too far.
+	src = sonic.NewByteBuffer()
+	codec, _ = NewCodec(src, LF)
+	codec.SetMaxLineLength(4)
+	_, _ = src.WriteString("abcde\n")
+	if _, err := codec.Decode(src); err != ErrLineTooLong {
+		t.Fatalf("expected ErrLineTooLong, got %v", err)
+	}
+}
+
+func TestCodecConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, chunk := range []string{"HELLO ", "WORLD\r", "\nPING\r\nBY", "E\r\n"} {
+			_, _ = conn.Write([]byte(chunk))
+			time.Sleep(time.Millisecond)
+		}
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	src, dst := sonic.NewByteBuffer(), sonic.NewByteBuffer()
+	codec, _ := NewCodec(src, CRLF)
+	codec.SetStripDelimiter(true)
+	codecConn, err := sonic.NewCodecConn[[]byte, []byte](conn, codec, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	var onLine func(error, []byte)
+	onLine = func(err error, line []byte) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+		if len(lines) < 3 {
+			codecConn.AsyncReadNext(onLine)
+		}
+	}
+	codecConn.AsyncReadNext(onLine)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(lines) < 3 && time.Now().Before(deadline) {
+		_, _ = ioc.PollOne()
+	}
+	if strings.Join(lines, ",") != "HELLO WORLD,PING,BYE" {
+		t.Fatalf("decoded %q", lines)
+	}
+}
+
+func TestDecodeFixedBuffer(t *testing.T) {
+	src, err := sonicbytes.NewMirroredByteBuffer(4096, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Destroy()
+
+	codec, err := NewCodec(src, LF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The buffer has room for more bytes, though not for reserveLen more.
+	_, _ = src.WriteString("abc")
+	if _, err := codec.Decode(src); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected ErrNeedMore, got %v", err)
+	}
+
+	// The buffer is full and holds no delimiter.
+	_, _ = src.WriteString(strings.Repeat("a", src.Cap()-3))
+	if _, err := codec.Decode(src); err != sonicerrors.ErrNoBufferSpaceAvailable {
+		t.Fatalf("expected ErrNoBufferSpaceAvailable, got %v", err)
+	}
+}
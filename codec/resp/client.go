@@ -0,0 +1,452 @@
+package resp
+
+import (
+	"errors"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+var (
+	ErrClientClosed = errors.New("resp: client closed")
+	ErrNotConnected = errors.New("resp: client not connected")
+)
+
+const (
+	DefaultDialTimeout    = 5 * time.Second
+	DefaultReconnectDelay = time.Second
+)
+
+// Client is an asynchronous Redis client over a single connection.
+//
+// Commands are pipelined: they are written as they are issued, without waiting for the replies of the previous ones,
+// and their callbacks are invoked in order as the replies are read. Replies are only valid for the duration of their
+// callback.
+//
+// With RESP3, the default, the client sends HELLO 3 once connected. Pub/sub messages are dispatched to the handlers of
+// Subscribe and PSubscribe, and other pushes to the push handler. With RESP2, the connection can only be used for
+// pub/sub commands once subscribed. If the server rejects HELLO 3, as servers which only speak RESP2 do, the connection
+// falls back to RESP2.
+//
+// If the connection fails, the callbacks of the pending commands are invoked with the error and the client reconnects
+// after the reconnect delay, subscribing again to its channels and patterns. Commands issued while disconnected fail
+// with ErrNotConnected.
+//
+// A Client must only be used from the IO's goroutine.
+type Client struct {
+	ioc  *sonic.IO
+	addr string
+
+	protocol       int // protocol requested on connection
+	dialTimeout    time.Duration
+	reconnectDelay time.Duration
+
+	conn  *sonic.CodecConn[Value, Value]
+	codec *Codec
+	dst   *sonic.ByteBuffer
+	timer *sonic.Timer
+
+	// gen is incremented for each connection so that the callbacks of a closed connection are ignored.
+	gen        int
+	connected  bool
+	connecting bool
+	closed     bool
+	writing    bool
+
+	pending []func(error, Value) // callbacks of the commands sent, in order
+
+	// connProtocol is the protocol of the current connection: the requested one, or 2 if the server rejected HELLO 3.
+	connProtocol int
+
+	connectCallbacks []func(error) // callbacks of AsyncConnect while the connection is being established
+
+	// pubsub is set while a RESP2 connection is subscribed, in which case replies to pub/sub commands are pushes.
+	pubsub   bool
+	channels map[string]func(channel, payload []byte)
+	patterns map[string]func(pattern, channel, payload []byte)
+
+	onPush       func(Value)
+	onConnect    func()
+	onDisconnect func(error)
+}
+
+// NewClient returns a client for the Redis server at the given TCP address. The client connects with AsyncConnect.
+func NewClient(ioc *sonic.IO, addr string) (*Client, error) {
+	timer, err := sonic.NewTimer(ioc)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		ioc:            ioc,
+		addr:           addr,
+		protocol:       3,
+		dialTimeout:    DefaultDialTimeout,
+		reconnectDelay: DefaultReconnectDelay,
+		timer:          timer,
+		channels:       make(map[string]func(channel, payload []byte)),
+		patterns:       make(map[string]func(pattern, channel, payload []byte)),
+	}, nil
+}
+
+// SetProtocol sets the protocol version negotiated on connection: 2 or 3.
+func (c *Client) SetProtocol(version int) {
+	c.protocol = version
+}
+
+func (c *Client) SetDialTimeout(timeout time.Duration) {
+	c.dialTimeout = timeout
+}
+
+// SetReconnectDelay sets the delay between a connection failure and the next connection attempt. A negative delay
+// disables reconnection.
+func (c *Client) SetReconnectDelay(delay time.Duration) {
+	c.reconnectDelay = delay
+}
+
+// SetPushHandler sets the handler of the pushes which are not pub/sub messages, such as client tracking invalidations.
+func (c *Client) SetPushHandler(fn func(Value)) {
+	c.onPush = fn
+}
+
+// SetConnectHandler sets the function called each time the client connects.
+func (c *Client) SetConnectHandler(fn func()) {
+	c.onConnect = fn
+}
+
+// SetDisconnectHandler sets the function called each time the connection fails.
+func (c *Client) SetDisconnectHandler(fn func(error)) {
+	c.onDisconnect = fn
+}
+
+func (c *Client) Connected() bool {
+	return c.connected
+}
+
+// AsyncConnect connects to the server asynchronously. The callback is invoked once connected, or with the error if the
+// connection fails or is not established within the dial timeout.
+func (c *Client) AsyncConnect(cb func(error)) {
+	if c.closed {
+		cb(ErrClientClosed)
+		return
+	}
+	if c.connected {
+		cb(nil)
+		return
+	}
+
+	c.connectCallbacks = append(c.connectCallbacks, cb)
+	if c.connecting {
+		return
+	}
+	c.connecting = true
+
+	sonic.AsyncDial(c.ioc, "tcp", c.addr, c.dialTimeout, func(err error, stream sonic.Conn) {
+		c.connecting = false
+		if err == nil {
+			if c.closed {
+				_ = stream.Close()
+				err = ErrClientClosed
+			} else {
+				err = c.setup(stream)
+			}
+		}
+
+		callbacks := c.connectCallbacks
+		c.connectCallbacks = nil
+		for _, cb := range callbacks {
+			cb(err)
+		}
+	})
+}
+
+// setup starts using a newly established connection.
+func (c *Client) setup(stream sonic.Conn) (err error) {
+	// The buffers of a failed connection may still be referenced by its pending operations, so they are not reused.
+	src := sonic.NewByteBuffer()
+	c.dst = sonic.NewByteBuffer()
+	c.codec = NewCodec(src)
+	c.conn, err = sonic.NewCodecConn[Value, Value](stream, c.codec, src, c.dst)
+	if err != nil {
+		_ = stream.Close()
+		return err
+	}
+
+	c.gen++
+	c.connected = true
+	c.pubsub = false
+	c.connProtocol = c.protocol
+
+	if c.protocol == 3 {
+		c.Do(func(err error, v Value) {
+			if v.IsError() {
+				// The server only speaks RESP2, in which it replies to the commands pipelined after HELLO.
+				c.connProtocol = 2
+			} else if err != nil {
+				c.disconnect(err)
+			}
+		}, "HELLO", "3")
+	}
+	if len(c.channels) > 0 {
+		c.subscribe("SUBSCRIBE", keys(c.channels))
+	}
+	if len(c.patterns) > 0 {
+		c.subscribe("PSUBSCRIBE", keys(c.patterns))
+	}
+
+	c.read(c.gen)
+
+	if c.onConnect != nil {
+		c.onConnect()
+	}
+	return nil
+}
+
+// Do sends a command, such as Do(cb, "SET", "key", "value"). The callback is invoked with the reply, or with an Error if
+// the reply is an error.
+func (c *Client) Do(cb func(error, Value), args ...string) {
+	if c.closed {
+		cb(ErrClientClosed, Value{})
+		return
+	}
+	if !c.connected {
+		cb(ErrNotConnected, Value{})
+		return
+	}
+
+	c.pending = append(c.pending, cb)
+	c.write(args...)
+}
+
+// Subscribe subscribes to channels. The handler is invoked with each message published to them.
+func (c *Client) Subscribe(handler func(channel, payload []byte), channels ...string) error {
+	for _, channel := range channels {
+		c.channels[channel] = handler
+	}
+	return c.subscribe("SUBSCRIBE", channels)
+}
+
+// PSubscribe subscribes to channel patterns. The handler is invoked with each message published to a matching channel.
+func (c *Client) PSubscribe(handler func(pattern, channel, payload []byte), patterns ...string) error {
+	for _, pattern := range patterns {
+		c.patterns[pattern] = handler
+	}
+	return c.subscribe("PSUBSCRIBE", patterns)
+}
+
+// Unsubscribe unsubscribes from channels, or from all of them if none is given.
+func (c *Client) Unsubscribe(channels ...string) error {
+	if len(channels) == 0 {
+		channels = keys(c.channels)
+	}
+	for _, channel := range channels {
+		delete(c.channels, channel)
+	}
+	return c.subscribe("UNSUBSCRIBE", channels)
+}
+
+// PUnsubscribe unsubscribes from channel patterns, or from all of them if none is given.
+func (c *Client) PUnsubscribe(patterns ...string) error {
+	if len(patterns) == 0 {
+		patterns = keys(c.patterns)
+	}
+	for _, pattern := range patterns {
+		delete(c.patterns, pattern)
+	}
+	return c.subscribe("PUNSUBSCRIBE", patterns)
+}
+
+// subscribe sends a pub/sub command. Its replies are pushes, one per channel or pattern, so no callback is queued.
+// Subscriptions made while disconnected are sent once connected.
+func (c *Client) subscribe(command string, names []string) error {
+	if c.closed {
+		return ErrClientClosed
+	}
+	if !c.connected || len(names) == 0 {
+		return nil
+	}
+
+	if command == "SUBSCRIBE" || command == "PSUBSCRIBE" {
+		c.pubsub = true
+	}
+	args := make([]string, 0, len(names)+1)
+	args = append(args, command)
+	args = append(args, names...)
+	c.write(args...)
+	return nil
+}
+
+// Close closes the connection. The callbacks of the pending commands are invoked with ErrClientClosed.
+func (c *Client) Close() error {
+	if c.closed {
+		return ErrClientClosed
+	}
+	c.closed = true
+	_ = c.timer.Close()
+	if c.connected {
+		c.disconnect(ErrClientClosed)
+	}
+	return nil
+}
+
+func (c *Client) write(args ...string) {
+	n := CommandLen(args...)
+	c.dst.Reserve(n)
+	c.dst.Claim(func(into []byte) int {
+		return len(AppendCommand(into[:0], args...))
+	})
+	c.dst.Commit(n)
+
+	// Commands issued while a write is in progress are written once it completes.
+	if !c.writing {
+		c.writing = true
+		c.onWrite(c.gen, nil)
+	}
+}
+
+func (c *Client) onWrite(gen int, err error) {
+	if gen != c.gen || !c.connected {
+		return
+	}
+	if err != nil {
+		c.disconnect(err)
+		return
+	}
+
+	if c.dst.ReadLen() > 0 {
+		c.dst.AsyncWriteTo(c.conn.NextLayer(), func(err error, _ int) {
+			c.onWrite(gen, err)
+		})
+	} else {
+		c.writing = false
+	}
+}
+
+func (c *Client) read(gen int) {
+	c.conn.AsyncReadNext(func(err error, v Value) {
+		c.onRead(gen, err, v)
+	})
+}
+
+func (c *Client) onRead(gen int, err error, v Value) {
+	if gen != c.gen || !c.connected {
+		return
+	}
+	if err != nil {
+		c.disconnect(err)
+		return
+	}
+
+	if c.isPush(v) {
+		c.dispatch(v)
+	} else if len(c.pending) == 0 {
+		c.disconnect(ErrProtocol) // unsolicited reply
+		return
+	} else {
+		cb := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		cb(v.Err(), v)
+	}
+
+	if gen == c.gen && c.connected {
+		c.read(gen)
+	}
+}
+
+func (c *Client) isPush(v Value) bool {
+	if v.Type == TypePush {
+		return true
+	}
+	return c.pubsub && c.connProtocol == 2 && v.Type == TypeArray && len(v.Elems) >= 3 && isPubSubKind(v.Elems[0].Str)
+}
+
+func isPubSubKind(kind []byte) bool {
+	switch string(kind) {
+	case "message", "pmessage", "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+		return true
+	}
+	return false
+}
+
+func (c *Client) dispatch(v Value) {
+	if len(v.Elems) == 0 {
+		return
+	}
+
+	switch string(v.Elems[0].Str) {
+	case "message":
+		if len(v.Elems) == 3 {
+			if handler := c.channels[string(v.Elems[1].Str)]; handler != nil {
+				handler(v.Elems[1].Str, v.Elems[2].Str)
+			}
+			return
+		}
+	case "pmessage":
+		if len(v.Elems) == 4 {
+			if handler := c.patterns[string(v.Elems[1].Str)]; handler != nil {
+				handler(v.Elems[1].Str, v.Elems[2].Str, v.Elems[3].Str)
+			}
+			return
+		}
+	case "subscribe", "psubscribe":
+		return
+	case "unsubscribe", "punsubscribe":
+		// The last element is the number of subscriptions left.
+		if len(v.Elems) == 3 && v.Elems[2].Int == 0 {
+			c.pubsub = false
+		}
+		return
+	}
+
+	if c.onPush != nil {
+		c.onPush(v)
+	}
+}
+
+// disconnect closes the connection, fails the pending commands and schedules a reconnection.
+func (c *Client) disconnect(err error) {
+	if !c.connected {
+		return
+	}
+	c.connected = false
+	c.writing = false
+	c.gen++
+	_ = c.conn.Close()
+
+	pending := c.pending
+	c.pending = nil
+	for _, cb := range pending {
+		cb(err, Value{})
+	}
+
+	if c.onDisconnect != nil {
+		c.onDisconnect(err)
+	}
+	c.scheduleReconnect()
+}
+
+func (c *Client) scheduleReconnect() {
+	if c.closed || c.connected || c.reconnectDelay < 0 {
+		return
+	}
+	delay := c.reconnectDelay
+	if delay == 0 {
+		delay = time.Nanosecond
+	}
+	_ = c.timer.Cancel()
+	_ = c.timer.ScheduleOnce(delay, func() {
+		c.AsyncConnect(func(err error) {
+			if err != nil && !c.closed {
+				c.scheduleReconnect()
+			}
+		})
+	})
+}
+
+func keys[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,481 @@
+package resp
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+// server is a tiny in-process stand-in for Redis. It serves HELLO, PING, SET, GET, INCR, SUBSCRIBE, UNSUBSCRIBE,
+// PSUBSCRIBE, PUBLISH and TRACKME, which sends an invalidation push before its reply.
+type server struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu    sync.Mutex
+	data  map[string]string
+	conns map[*serverConn]struct{}
+
+	resp2 bool // rejects HELLO, like servers which only speak RESP2
+}
+
+type serverConn struct {
+	net.Conn
+	mu       sync.Mutex // serializes writes
+	protocol int
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+func startServer(t *testing.T) *server {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{t: t, ln: ln, data: make(map[string]string), conns: make(map[*serverConn]struct{})}
+	t.Cleanup(func() {
+		ln.Close()
+		s.kill()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c := &serverConn{Conn: conn, protocol: 2, channels: make(map[string]bool), patterns: make(map[string]bool)}
+			s.mu.Lock()
+			s.conns[c] = struct{}{}
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *server) addr() string {
+	return s.ln.Addr().String()
+}
+
+// kill closes all connections.
+func (s *server) kill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+		delete(s.conns, c)
+	}
+}
+
+// subscriptions returns the number of channel and pattern subscriptions of all connections.
+func (s *server) subscriptions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for c := range s.conns {
+		n += len(c.channels) + len(c.patterns)
+	}
+	return n
+}
+
+func (c *serverConn) send(values ...Value) {
+	dst := sonic.NewByteBuffer()
+	codec := NewCodec(dst)
+	for _, v := range values {
+		if v.Type == TypePush && c.protocol == 2 {
+			v.Type = TypeArray
+		}
+		_ = codec.Encode(v, dst)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, _ = dst.WriteTo(c.Conn)
+}
+
+func bulk(s string) Value {
+	return BulkString([]byte(s))
+}
+
+func (s *server) serve(c *serverConn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	src := sonic.NewByteBuffer()
+	codec := NewCodec(src)
+	for {
+		v, err := codec.Decode(src)
+		if err != nil {
+			if _, err := src.ReadFrom(c); err != nil {
+				return
+			}
+			continue
+		}
+
+		args := make([]string, len(v.Elems))
+		for i, arg := range v.Elems {
+			args[i] = string(arg.Str)
+		}
+		s.handle(c, args)
+	}
+}
+
+func (s *server) handle(c *serverConn, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "HELLO":
+		if s.resp2 {
+			c.send(Value{Type: TypeError, Str: []byte("ERR unknown command 'HELLO'")})
+			return
+		}
+		c.protocol, _ = strconv.Atoi(args[1])
+		c.send(Value{Type: TypeMap, Elems: []Value{bulk("server"), bulk("stand-in"), bulk("proto"), Integer(3)}})
+	case "PING":
+		c.send(SimpleString("PONG"))
+	case "SET":
+		s.data[args[1]] = args[2]
+		c.send(SimpleString("OK"))
+	case "GET":
+		if v, ok := s.data[args[1]]; ok {
+			c.send(bulk(v))
+		} else {
+			c.send(Value{Type: TypeNull})
+		}
+	case "INCR":
+		n, _ := strconv.Atoi(s.data[args[1]])
+		n++
+		s.data[args[1]] = strconv.Itoa(n)
+		c.send(Integer(int64(n)))
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		kind := strings.ToLower(args[0])
+		set := c.channels
+		if kind[0] == 'p' {
+			set = c.patterns
+		}
+		for _, name := range args[1:] {
+			if strings.HasPrefix(kind, "sub") || strings.HasPrefix(kind, "psub") {
+				set[name] = true
+			} else {
+				delete(set, name)
+			}
+			c.send(Value{Type: TypePush, Elems: []Value{
+				bulk(kind), bulk(name), Integer(int64(len(c.channels) + len(c.patterns))),
+			}})
+		}
+	case "PUBLISH":
+		n := 0
+		for other := range s.conns {
+			if other.channels[args[1]] {
+				other.send(Value{Type: TypePush, Elems: []Value{bulk("message"), bulk(args[1]), bulk(args[2])}})
+				n++
+			}
+			for pattern := range other.patterns {
+				if strings.HasPrefix(args[1], strings.TrimSuffix(pattern, "*")) {
+					other.send(Value{Type: TypePush, Elems: []Value{
+						bulk("pmessage"), bulk(pattern), bulk(args[1]), bulk(args[2]),
+					}})
+					n++
+				}
+			}
+		}
+		c.send(Integer(int64(n)))
+	case "TRACKME":
+		c.send(
+			Value{Type: TypePush, Elems: []Value{bulk("invalidate"), Array(bulk(args[1]))}},
+			SimpleString("OK"),
+		)
+	default:
+		c.send(Value{Type: TypeError, Str: []byte("ERR unknown command '" + args[0] + "'")})
+	}
+}
+
+func runUntil(t *testing.T, ioc *sonic.IO, done func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_, _ = ioc.PollOne()
+	}
+}
+
+func newClient(t *testing.T, ioc *sonic.IO, s *server, protocol int) *Client {
+	c, err := NewClient(ioc, s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetProtocol(protocol)
+	connected := false
+	c.AsyncConnect(func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		connected = true
+	})
+	runUntil(t, ioc, func() bool { return connected })
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestClientPipelining(t *testing.T) {
+	s := startServer(t)
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	counter := 0
+	for _, protocol := range []int{2, 3} {
+		c := newClient(t, ioc, s, protocol)
+
+		var replies []string
+		record := func(err error, v Value) {
+			if err != nil {
+				replies = append(replies, "error:"+err.Error())
+			} else {
+				replies = append(replies, format(v))
+			}
+		}
+
+		// All commands are written before any reply is read.
+		c.Do(record, "SET", "key", "value")
+		c.Do(record, "GET", "key")
+		c.Do(record, "GET", "missing")
+		c.Do(record, "INCR", "counter")
+		c.Do(record, "INCR", "counter")
+		c.Do(record, "NOPE")
+		c.Do(record, "PING")
+
+		runUntil(t, ioc, func() bool { return len(replies) == 7 })
+		expected := "+OK,$value,_,:" + strconv.Itoa(counter+1) + ",:" + strconv.Itoa(counter+2) +
+			",error:ERR unknown command 'NOPE',+PONG"
+		counter += 2
+		if got := strings.Join(replies, ","); got != expected {
+			t.Fatalf("protocol %d: replies %q expected %q", protocol, got, expected)
+		}
+	}
+}
+
+func TestClientPubSub(t *testing.T) {
+	s := startServer(t)
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	for _, protocol := range []int{2, 3} {
+		subscriber := newClient(t, ioc, s, protocol)
+		publisher := newClient(t, ioc, s, 3)
+
+		var messages []string
+		_ = subscriber.Subscribe(func(channel, payload []byte) {
+			messages = append(messages, string(channel)+":"+string(payload))
+		}, "news", "weather")
+		_ = subscriber.PSubscribe(func(pattern, channel, payload []byte) {
+			messages = append(messages, string(pattern)+"/"+string(channel)+":"+string(payload))
+		}, "we*")
+		runUntil(t, ioc, func() bool { return s.subscriptions() == 3 })
+
+		published := 0
+		publish := func(channel, payload string) {
+			publisher.Do(func(err error, _ Value) {
+				if err != nil {
+					t.Error(err)
+				}
+				published++
+			}, "PUBLISH", channel, payload)
+		}
+		publish("news", "hello")
+		publish("weather", "sunny")
+		publish("sports", "none")
+		runUntil(t, ioc, func() bool { return published == 3 && len(messages) == 3 })
+
+		if got := strings.Join(messages, ","); got != "news:hello,weather:sunny,we*/weather:sunny" {
+			t.Fatalf("protocol %d: messages %q", protocol, got)
+		}
+
+		_ = subscriber.Unsubscribe()
+		_ = subscriber.PUnsubscribe()
+		runUntil(t, ioc, func() bool { return s.subscriptions() == 0 })
+
+		// Once unsubscribed, the connection serves regular commands again.
+		pong := false
+		subscriber.Do(func(err error, v Value) {
+			pong = err == nil && string(v.Str) == "PONG"
+		}, "PING")
+		runUntil(t, ioc, func() bool { return pong })
+
+		_ = subscriber.Close()
+		_ = publisher.Close()
+	}
+}
+
+func TestClientFallbackToRESP2(t *testing.T) {
+	s := startServer(t)
+	s.resp2 = true
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	subscriber := newClient(t, ioc, s, 3)
+	publisher := newClient(t, ioc, s, 3)
+
+	disconnected := 0
+	subscriber.SetDisconnectHandler(func(error) { disconnected++ })
+
+	var messages []string
+	_ = subscriber.Subscribe(func(channel, payload []byte) {
+		messages = append(messages, string(channel)+":"+string(payload))
+	}, "news")
+	runUntil(t, ioc, func() bool { return s.subscriptions() == 1 })
+
+	publisher.Do(func(err error, _ Value) {
+		if err != nil {
+			t.Error(err)
+		}
+	}, "PUBLISH", "news", "hello")
+	runUntil(t, ioc, func() bool { return len(messages) == 1 })
+
+	if got := messages[0]; got != "news:hello" {
+		t.Fatalf("message %q", got)
+	}
+	if disconnected != 0 || !subscriber.Connected() {
+		t.Fatal("the client disconnected instead of falling back to RESP2")
+	}
+}
+
+func TestClientPush(t *testing.T) {
+	s := startServer(t)
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	c := newClient(t, ioc, s, 3)
+	var events []string
+	c.SetPushHandler(func(v Value) {
+		events = append(events, format(v))
+	})
+	c.Do(func(err error, v Value) {
+		events = append(events, format(v))
+	}, "TRACKME", "key")
+
+	runUntil(t, ioc, func() bool { return len(events) == 2 })
+	if got := strings.Join(events, ","); got != ">[$invalidate *[$key]],+OK" {
+		t.Fatalf("events %q", got)
+	}
+}
+
+func TestClientAsyncConnect(t *testing.T) {
+	s := startServer(t)
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	c, err := NewClient(ioc, s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	connects := 0
+	c.SetConnectHandler(func() { connects++ })
+
+	// Concurrent attempts share the same connection.
+	var errs []error
+	for i := 0; i < 2; i++ {
+		c.AsyncConnect(func(err error) { errs = append(errs, err) })
+	}
+	runUntil(t, ioc, func() bool { return len(errs) == 2 })
+	if errs[0] != nil || errs[1] != nil || connects != 1 || !c.Connected() {
+		t.Fatalf("unexpected connection errors=%v connects=%d", errs, connects)
+	}
+
+	// A connection established after the client is closed is dropped.
+	closed, err := NewClient(ioc, s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closedErr error
+	closed.AsyncConnect(func(err error) { closedErr = err })
+	_ = closed.Close()
+	runUntil(t, ioc, func() bool { return closedErr != nil })
+	if closedErr != ErrClientClosed || closed.Connected() {
+		t.Fatalf("expected ErrClientClosed, got %v", closedErr)
+	}
+}
+
+func TestClientAsyncConnectRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	c, err := NewClient(ioc, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var connectErr error
+	c.AsyncConnect(func(err error) { connectErr = err })
+	runUntil(t, ioc, func() bool { return connectErr != nil })
+	if c.Connected() {
+		t.Fatal("expected the client to be disconnected")
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	s := startServer(t)
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	c := newClient(t, ioc, s, 3)
+	c.SetReconnectDelay(10 * time.Millisecond)
+
+	connects, disconnects := 0, 0
+	c.SetConnectHandler(func() { connects++ })
+	c.SetDisconnectHandler(func(error) { disconnects++ })
+
+	var messages []string
+	_ = c.Subscribe(func(channel, payload []byte) {
+		messages = append(messages, string(payload))
+	}, "news")
+	runUntil(t, ioc, func() bool { return s.subscriptions() == 1 })
+
+	// A command pending when the connection is killed completes, with its reply or with the error.
+	pending := false
+	c.Do(func(error, Value) { pending = true }, "PING")
+	s.kill()
+	runUntil(t, ioc, func() bool { return disconnects == 1 && pending })
+
+	c.Do(func(err error, _ Value) {
+		if err != ErrNotConnected && err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	}, "PING")
+
+	runUntil(t, ioc, func() bool { return connects == 1 && s.subscriptions() == 1 })
+
+	publisher := newClient(t, ioc, s, 3)
+	publisher.Do(func(error, Value) {}, "PUBLISH", "news", "again")
+	runUntil(t, ioc, func() bool { return len(messages) == 1 })
+	if messages[0] != "again" {
+		t.Fatalf("invalid message %q", messages[0])
+	}
+
+	_ = c.Close()
+	var closedErr error
+	c.Do(func(err error, _ Value) { closedErr = err }, "PING")
+	if closedErr != ErrClientClosed {
+		t.Fatalf("expected ErrClientClosed, got %v", closedErr)
+	}
+}
@@ -0,0 +1,380 @@
+package resp
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var _ sonic.Codec[Value, Value] = &Codec{}
+
+const (
+	DefaultMaxBulkLength = 512 * 1024 * 1024
+	DefaultMaxElements   = 1024 * 1024
+	DefaultMaxDepth      = 64
+
+	// maxLineLength bounds the lines of simple values and the headers of bulk and aggregate values.
+	maxLineLength = 64 * 1024
+
+	// reserveLen is the least room made in the source buffer for the rest of an incomplete value, as far as the buffer
+	// allows.
+	reserveLen = 4096
+)
+
+// Codec decodes RESP2 and RESP3 values and encodes values.
+//
+// A decoded value, including its elements, is only valid until the next call to Decode: strings are slices into the
+// source buffer and elements are slices into a buffer of the codec which is reused. Attributes are skipped.
+//
+// Values are parsed once they are complete: a large aggregate arriving in many reads is scanned again on each read.
+type Codec struct {
+	src sonic.Buffer
+
+	maxBulk  int
+	maxElems int
+	maxDepth int
+
+	// elems holds the elements of the last decoded value.
+	elems []Value
+	used  int
+
+	decodeReset bool
+	decodeBytes int
+}
+
+func NewCodec(src sonic.Buffer) *Codec {
+	return &Codec{
+		src:      src,
+		maxBulk:  DefaultMaxBulkLength,
+		maxElems: DefaultMaxElements,
+		maxDepth: DefaultMaxDepth,
+	}
+}
+
+// SetMaxBulkLength bounds the length of bulk strings, bulk errors and verbatim strings.
+func (c *Codec) SetMaxBulkLength(n int) {
+	c.maxBulk = n
+}
+
+// SetMaxElements bounds the total number of elements of a value, nested ones included.
+func (c *Codec) SetMaxElements(n int) {
+	c.maxElems = n
+}
+
+// SetMaxDepth bounds the nesting of aggregate values.
+func (c *Codec) SetMaxDepth(n int) {
+	c.maxDepth = n
+}
+
+func (c *Codec) Encode(v Value, dst sonic.Buffer) error {
+	n, err := encodedLen(v)
+	if err != nil {
+		return err
+	}
+	if err := dst.Reserve(n); err != nil {
+		return err
+	}
+	if err := dst.Claim(func(into []byte) int {
+		return len(appendValue(into[:0], v))
+	}); err != nil {
+		return err
+	}
+	dst.Commit(n)
+	return nil
+}
+
+func (c *Codec) resetDecode() {
+	if c.decodeReset {
+		c.decodeReset = false
+		c.src.Consume(c.decodeBytes)
+		c.decodeBytes = 0
+	}
+}
+
+func (c *Codec) Decode(src sonic.Buffer) (Value, error) {
+	c.resetDecode()
+
+	src.Commit(src.WriteLen())
+	data := src.Data()
+
+	// The value is scanned first to know whether it is complete and how many elements it has.
+	end, count, err := c.scan(data, 0, 0, 0)
+	if err != nil {
+		if err == sonicerrors.ErrNeedMore {
+			// The value needs at least the bytes it is known to miss, which a fixed buffer may not have room for.
+			if n := end - len(data); n >= reserveLen || src.Reserve(reserveLen) != nil {
+				if reserveErr := src.Reserve(n); reserveErr != nil {
+					err = reserveErr
+				}
+			}
+		}
+		return Value{}, err
+	}
+
+	if cap(c.elems) < count {
+		c.elems = make([]Value, count)
+	}
+	c.elems = c.elems[:cap(c.elems)]
+	c.used = 0
+
+	v, _ := c.fill(data, 0)
+
+	c.decodeReset = true
+	c.decodeBytes = end
+
+	return v, nil
+}
+
+// line returns the line starting at pos, without its CRLF, and the position following it.
+func line(data []byte, pos int) ([]byte, int, error) {
+	i := bytes.Index(data[pos:], []byte("\r\n"))
+	if i < 0 {
+		if len(data)-pos > maxLineLength {
+			return nil, 0, ErrProtocol
+		}
+		return nil, 0, sonicerrors.ErrNeedMore
+	}
+	return data[pos : pos+i], pos + i + 2, nil
+}
+
+func parseInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 20 {
+		return 0, ErrProtocol
+	}
+	neg := b[0] == '-'
+	if neg || b[0] == '+' {
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, ErrProtocol
+		}
+	}
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, ErrProtocol
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+// scan returns the position following the value starting at pos and the number of elements, nested ones included,
+// added to count. On ErrNeedMore, the returned position is the minimum length the data must have for the scan to
+// progress.
+func (c *Codec) scan(data []byte, pos, depth, count int) (int, int, error) {
+	if pos >= len(data) {
+		return pos + 1, count, sonicerrors.ErrNeedMore
+	}
+	if depth > c.maxDepth {
+		return 0, 0, ErrTooDeep
+	}
+
+	t := Type(data[pos])
+	ln, next, err := line(data, pos+1)
+	if err != nil {
+		return len(data) + 1, count, err
+	}
+
+	switch t {
+	case TypeSimpleString, TypeError, TypeBigNumber:
+		return next, count, nil
+	case TypeInteger:
+		_, err := parseInt(ln)
+		return next, count, err
+	case TypeNull:
+		if len(ln) != 0 {
+			return 0, 0, ErrProtocol
+		}
+		return next, count, nil
+	case TypeBoolean:
+		if len(ln) != 1 || (ln[0] != 't' && ln[0] != 'f') {
+			return 0, 0, ErrProtocol
+		}
+		return next, count, nil
+	case TypeDouble:
+		if len(ln) == 0 {
+			return 0, 0, ErrProtocol
+		}
+		return next, count, nil
+	case TypeBulkString, TypeBulkError, TypeVerbatimString:
+		n, err := parseInt(ln)
+		if err != nil {
+			return 0, 0, err
+		}
+		if n < 0 {
+			if n == -1 && t == TypeBulkString {
+				return next, count, nil
+			}
+			return 0, 0, ErrProtocol
+		}
+		if n > int64(c.maxBulk) {
+			return 0, 0, ErrValueTooLarge
+		}
+		end := next + int(n) + 2
+		if len(data) < end {
+			return end, count, sonicerrors.ErrNeedMore
+		}
+		if data[end-2] != '\r' || data[end-1] != '\n' {
+			return 0, 0, ErrProtocol
+		}
+		return end, count, nil
+	case TypeArray, TypeSet, TypePush, TypeMap, typeAttribute:
+		n, err := parseInt(ln)
+		if err != nil {
+			return 0, 0, err
+		}
+		if n < 0 {
+			if n == -1 && t == TypeArray {
+				return next, count, nil
+			}
+			return 0, 0, ErrProtocol
+		}
+		if t == TypeMap || t == typeAttribute {
+			n *= 2
+		}
+		if count += int(n); count > c.maxElems {
+			return 0, 0, ErrValueTooLarge
+		}
+		pos = next
+		for i := int64(0); i < n; i++ {
+			if pos, count, err = c.scan(data, pos, depth+1, count); err != nil {
+				return pos, count, err
+			}
+		}
+		if t == typeAttribute {
+			// The attribute is followed by the value it describes.
+			return c.scan(data, pos, depth, count)
+		}
+		return pos, count, nil
+	default:
+		return 0, 0, ErrInvalidType
+	}
+}
+
+// fill decodes the scanned value starting at pos and returns the position following it.
+func (c *Codec) fill(data []byte, pos int) (Value, int) {
+	t := Type(data[pos])
+	ln, next, _ := line(data, pos+1)
+
+	v := Value{Type: t}
+	switch t {
+	case TypeSimpleString, TypeError, TypeBigNumber, TypeDouble:
+		v.Str = ln
+	case TypeInteger:
+		v.Int, _ = parseInt(ln)
+	case TypeBoolean:
+		if ln[0] == 't' {
+			v.Int = 1
+		}
+	case TypeBulkString, TypeBulkError, TypeVerbatimString:
+		n, _ := parseInt(ln)
+		if n < 0 {
+			return Value{Type: TypeNull}, next
+		}
+		v.Str = data[next : next+int(n)]
+		next += int(n) + 2
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		n, _ := parseInt(ln)
+		if n < 0 {
+			return Value{Type: TypeNull}, next
+		}
+		if t == TypeMap {
+			n *= 2
+		}
+		v.Elems = c.elems[c.used : c.used+int(n) : c.used+int(n)]
+		c.used += int(n)
+		for i := range v.Elems {
+			v.Elems[i], next = c.fill(data, next)
+		}
+	case typeAttribute:
+		n, _ := parseInt(ln)
+		for i := int64(0); i < 2*n; i++ {
+			next, _, _ = c.scan(data, next, 1, 0)
+		}
+		return c.fill(data, next)
+	}
+	return v, next
+}
+
+// encodedLen returns the length of the encoded value.
+func encodedLen(v Value) (int, error) {
+	switch v.Type {
+	case TypeSimpleString, TypeError, TypeBigNumber, TypeDouble:
+		if bytes.ContainsAny(v.Str, "\r\n") {
+			return 0, ErrProtocol
+		}
+		return 1 + len(v.Str) + 2, nil
+	case TypeInteger:
+		n := v.Int
+		if n < 0 {
+			if n == -n { // math.MinInt64
+				return 1 + 20 + 2, nil
+			}
+			return 1 + 1 + digits(int(-n)) + 2, nil
+		}
+		return 1 + digits(int(n)) + 2, nil
+	case TypeNull:
+		return 3, nil
+	case TypeBoolean:
+		return 4, nil
+	case TypeBulkString, TypeBulkError, TypeVerbatimString:
+		return 1 + digits(len(v.Str)) + 2 + len(v.Str) + 2, nil
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		n := len(v.Elems)
+		if v.Type == TypeMap {
+			if n%2 != 0 {
+				return 0, ErrProtocol
+			}
+			n /= 2
+		}
+		total := 1 + digits(n) + 2
+		for _, elem := range v.Elems {
+			m, err := encodedLen(elem)
+			if err != nil {
+				return 0, err
+			}
+			total += m
+		}
+		return total, nil
+	default:
+		return 0, ErrInvalidType
+	}
+}
+
+// appendValue appends the encoded value to dst. The value must have been checked with encodedLen.
+func appendValue(dst []byte, v Value) []byte {
+	dst = append(dst, byte(v.Type))
+	switch v.Type {
+	case TypeSimpleString, TypeError, TypeBigNumber, TypeDouble:
+		dst = append(dst, v.Str...)
+	case TypeInteger:
+		dst = strconv.AppendInt(dst, v.Int, 10)
+	case TypeBoolean:
+		if v.Int != 0 {
+			dst = append(dst, 't')
+		} else {
+			dst = append(dst, 'f')
+		}
+	case TypeBulkString, TypeBulkError, TypeVerbatimString:
+		dst = strconv.AppendInt(dst, int64(len(v.Str)), 10)
+		dst = append(dst, '\r', '\n')
+		dst = append(dst, v.Str...)
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		n := len(v.Elems)
+		if v.Type == TypeMap {
+			n /= 2
+		}
+		dst = strconv.AppendInt(dst, int64(n), 10)
+		dst = append(dst, '\r', '\n')
+		for _, elem := range v.Elems {
+			dst = appendValue(dst, elem)
+		}
+		return dst
+	}
+	return append(dst, '\r', '\n')
+}
@@ -0,0 +1,180 @@
+package resp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// format returns a compact representation of a value, for comparisons.
+func format(v Value) string {
+	switch v.Type {
+	case TypeInteger, TypeBoolean:
+		return fmt.Sprintf("%c%d", v.Type, v.Int)
+	case TypeNull:
+		return "_"
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		elems := make([]string, len(v.Elems))
+		for i, elem := range v.Elems {
+			elems[i] = format(elem)
+		}
+		return fmt.Sprintf("%c[%s]", v.Type, strings.Join(elems, " "))
+	default:
+		return fmt.Sprintf("%c%s", v.Type, v.Str)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		expected string
+	}{
+		{"+OK\r\n", "+OK"},
+		{"-ERR wrong\r\n", "-ERR wrong"},
+		{":-42\r\n", ":-42"},
+		{"$5\r\nhe\r\no\r\n", "$he\r\no"},
+		{"$0\r\n\r\n", "$"},
+		{"$-1\r\n", "_"},
+		{"*-1\r\n", "_"},
+		{"*3\r\n:1\r\n$1\r\na\r\n*1\r\n+b\r\n", "*[:1 $a *[+b]]"},
+		{"_\r\n", "_"},
+		{"#t\r\n", "#1"},
+		{",3.14\r\n", ",3.14"},
+		{"(3492890328409238509324850943850943825024385\r\n", "(3492890328409238509324850943850943825024385"},
+		{"!9\r\nERR oops!\r\n", "!ERR oops!"},
+		{"=7\r\ntxt:abc\r\n", "=txt:abc"},
+		{"%2\r\n+a\r\n:1\r\n+b\r\n%1\r\n+c\r\n_\r\n", "%[+a :1 +b %[+c _]]"},
+		{"~2\r\n:1\r\n:2\r\n", "~[:1 :2]"},
+		{">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n", ">[$message $ch $hi]"},
+		{"|1\r\n+ttl\r\n:3600\r\n$3\r\nval\r\n", "$val"},
+		{"*2\r\n|1\r\n+a\r\n*1\r\n:1\r\n:2\r\n:3\r\n", "*[:2 :3]"},
+	} {
+		// All at once, followed by a second value.
+		src := sonic.NewByteBuffer()
+		codec := NewCodec(src)
+		_, _ = src.WriteString(test.input + ":7\r\n")
+		v, err := codec.Decode(src)
+		if err != nil {
+			t.Fatalf("%q: %v", test.input, err)
+		}
+		if format(v) != test.expected {
+			t.Fatalf("%q: decoded %q expected %q", test.input, format(v), test.expected)
+		}
+		if v, err := codec.Decode(src); err != nil || v.Int != 7 {
+			t.Fatalf("%q: invalid second value %v %v", test.input, v, err)
+		}
+
+		// One byte at a time.
+		src = sonic.NewByteBuffer()
+		codec = NewCodec(src)
+		for i := 0; i < len(test.input); i++ {
+			_ = src.WriteByte(test.input[i])
+			v, err = codec.Decode(src)
+			if i < len(test.input)-1 && err != sonicerrors.ErrNeedMore {
+				t.Fatalf("%q: expected ErrNeedMore at %d, got %v", test.input, i, err)
+			}
+		}
+		if err != nil || format(v) != test.expected {
+			t.Fatalf("%q: decoded %q %v expected %q", test.input, format(v), err, test.expected)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		expected error
+	}{
+		{"?\r\n", ErrInvalidType},
+		{":1a\r\n", ErrProtocol},
+		{"$-2\r\n", ErrProtocol},
+		{"$2\r\nabc\r\n", ErrProtocol},
+		{"#x\r\n", ErrProtocol},
+		{"_1\r\n", ErrProtocol},
+		{"%-1\r\n", ErrProtocol},
+		{"$11\r\n", ErrValueTooLarge},
+		{"*4\r\n", ErrValueTooLarge},
+		{"*1\r\n*1\r\n*1\r\n*1\r\n:1\r\n", ErrTooDeep},
+		{"+" + strings.Repeat("a", maxLineLength+1), ErrProtocol},
+	} {
+		src := sonic.NewByteBuffer()
+		codec := NewCodec(src)
+		codec.SetMaxBulkLength(10)
+		codec.SetMaxElements(3)
+		codec.SetMaxDepth(2)
+		_, _ = src.WriteString(test.input)
+		if _, err := codec.Decode(src); err != test.expected {
+			t.Fatalf("%q: expected %v, got %v", test.input, test.expected, err)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	values := []Value{
+		SimpleString("OK"),
+		{Type: TypeError, Str: []byte("ERR wrong")},
+		Integer(-9223372036854775808),
+		Integer(12345),
+		BulkString([]byte("a\r\nb")),
+		{Type: TypeNull},
+		{Type: TypeBoolean, Int: 1},
+		{Type: TypeDouble, Str: []byte("1.5")},
+		{Type: TypeMap, Elems: []Value{SimpleString("k"), Array(Integer(1), Value{Type: TypeSet})}},
+		{Type: TypePush, Elems: []Value{BulkString([]byte("message"))}},
+	}
+
+	dst := sonic.NewByteBuffer()
+	codec := NewCodec(dst)
+	for _, v := range values {
+		if err := codec.Encode(v, dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, v := range values {
+		decoded, err := codec.Decode(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format(decoded) != format(v) {
+			t.Fatalf("decoded %q expected %q", format(decoded), format(v))
+		}
+	}
+
+	for _, invalid := range []Value{
+		SimpleString("a\r\n"),
+		{Type: TypeMap, Elems: []Value{SimpleString("k")}},
+		{Type: 'x'},
+	} {
+		if err := codec.Encode(invalid, dst); err == nil {
+			t.Fatalf("expected an error encoding %v", invalid)
+		}
+	}
+
+	if cmd := string(AppendCommand(nil, "SET", "key", "")); cmd != "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$0\r\n\r\n" {
+		t.Fatalf("invalid command %q", cmd)
+	}
+	if n := CommandLen("SET", "key", ""); n != len(AppendCommand(nil, "SET", "key", "")) {
+		t.Fatalf("invalid command length %d", n)
+	}
+}
+
+func TestDecodeNoAllocs(t *testing.T) {
+	input := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n%1\r\n+a\r\n:1\r\n")
+	src := sonic.NewByteBuffer()
+	codec := NewCodec(src)
+	src.Reserve(1024)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = src.Write(input)
+		v, err := codec.Decode(src)
+		if err != nil || len(v.Elems) != 3 {
+			t.Fatal("invalid value")
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
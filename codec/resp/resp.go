@@ -0,0 +1,202 @@
+// Package resp implements RESP, the Redis serialization protocol, in versions 2 and 3, and an asynchronous Redis client
+// on top of it.
+//
+// Decoded values are slices into the connection's read buffer and are only valid until the next value is decoded.
+package resp
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Type is the type of a value, identified by its first byte on the wire.
+type Type byte
+
+const (
+	// RESP2 types.
+	TypeSimpleString Type = '+'
+	TypeError        Type = '-'
+	TypeInteger      Type = ':'
+	TypeBulkString   Type = '$'
+	TypeArray        Type = '*'
+
+	// RESP3 types.
+	TypeNull           Type = '_'
+	TypeBoolean        Type = '#'
+	TypeDouble         Type = ','
+	TypeBigNumber      Type = '('
+	TypeBulkError      Type = '!'
+	TypeVerbatimString Type = '='
+	TypeMap            Type = '%'
+	TypeSet            Type = '~'
+	TypePush           Type = '>'
+
+	// typeAttribute precedes a value with auxiliary data. Attributes are skipped by the decoder.
+	typeAttribute Type = '|'
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeSimpleString:
+		return "simple_string"
+	case TypeError:
+		return "error"
+	case TypeInteger:
+		return "integer"
+	case TypeBulkString:
+		return "bulk_string"
+	case TypeArray:
+		return "array"
+	case TypeNull:
+		return "null"
+	case TypeBoolean:
+		return "boolean"
+	case TypeDouble:
+		return "double"
+	case TypeBigNumber:
+		return "big_number"
+	case TypeBulkError:
+		return "bulk_error"
+	case TypeVerbatimString:
+		return "verbatim_string"
+	case TypeMap:
+		return "map"
+	case TypeSet:
+		return "set"
+	case TypePush:
+		return "push"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	ErrProtocol      = errors.New("resp: protocol error")
+	ErrValueTooLarge = errors.New("resp: value too large")
+	ErrTooDeep       = errors.New("resp: value nested too deep")
+	ErrInvalidType   = errors.New("resp: invalid value type")
+)
+
+// Error is an error reply: a simple or bulk error.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Value is a decoded value.
+//
+// Str holds the payload of simple strings, errors, bulk strings, verbatim strings (including their "txt:" encoding
+// prefix), doubles and big numbers. Int holds integers, and 1 or 0 for booleans. Elems holds the elements of arrays,
+// sets and pushes, and the keys and values of maps one after the other.
+//
+// RESP2 null bulk strings and null arrays are decoded as TypeNull.
+type Value struct {
+	Type  Type
+	Str   []byte
+	Int   int64
+	Elems []Value
+}
+
+func (v Value) IsNull() bool {
+	return v.Type == TypeNull
+}
+
+func (v Value) IsError() bool {
+	return v.Type == TypeError || v.Type == TypeBulkError
+}
+
+// Err returns the value as an Error if it is an error reply, nil otherwise.
+func (v Value) Err() error {
+	if v.IsError() {
+		return Error(v.Str)
+	}
+	return nil
+}
+
+// String returns a copy of Str.
+func (v Value) String() string {
+	return string(v.Str)
+}
+
+func (v Value) Bool() bool {
+	return v.Int != 0
+}
+
+// Float returns the value of a double, or of a string holding one.
+func (v Value) Float() (float64, error) {
+	if v.Type == TypeInteger {
+		return float64(v.Int), nil
+	}
+	return strconv.ParseFloat(string(v.Str), 64)
+}
+
+// Len returns the number of elements of an array, set or push, or the number of entries of a map.
+func (v Value) Len() int {
+	if v.Type == TypeMap {
+		return len(v.Elems) / 2
+	}
+	return len(v.Elems)
+}
+
+// Get returns the value of the given key of a map.
+func (v Value) Get(key string) (Value, bool) {
+	if v.Type == TypeMap {
+		for i := 0; i+1 < len(v.Elems); i += 2 {
+			if string(v.Elems[i].Str) == key {
+				return v.Elems[i+1], true
+			}
+		}
+	}
+	return Value{}, false
+}
+
+// SimpleString, BulkString, Integer and Array build values to be encoded.
+
+func SimpleString(s string) Value {
+	return Value{Type: TypeSimpleString, Str: []byte(s)}
+}
+
+func BulkString(b []byte) Value {
+	return Value{Type: TypeBulkString, Str: b}
+}
+
+func Integer(n int64) Value {
+	return Value{Type: TypeInteger, Int: n}
+}
+
+func Array(elems ...Value) Value {
+	return Value{Type: TypeArray, Elems: elems}
+}
+
+// AppendCommand appends a command, an array of bulk strings, to dst.
+func AppendCommand(dst []byte, args ...string) []byte {
+	dst = append(dst, byte(TypeArray))
+	dst = strconv.AppendInt(dst, int64(len(args)), 10)
+	dst = append(dst, '\r', '\n')
+	for _, arg := range args {
+		dst = append(dst, byte(TypeBulkString))
+		dst = strconv.AppendInt(dst, int64(len(arg)), 10)
+		dst = append(dst, '\r', '\n')
+		dst = append(dst, arg...)
+		dst = append(dst, '\r', '\n')
+	}
+	return dst
+}
+
+// CommandLen returns the length of the encoded command.
+func CommandLen(args ...string) int {
+	n := 1 + digits(len(args)) + 2
+	for _, arg := range args {
+		n += 1 + digits(len(arg)) + 2 + len(arg) + 2
+	}
+	return n
+}
+
+func digits(n int) int {
+	d := 1
+	for ; n >= 10; n /= 10 {
+		d++
+	}
+	return d
+}
@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+// closeHandshake is the state of a Stream's closing handshake.
+//
+// The handshake is complete once we wrote our close frame and received the peer's. Per RFC6455 7.1.1, the server then
+// closes the TCP connection first. The client waits for the server to do so, at which point it closes its end.
+//
+// The close timeout bounds the whole handshake: if it expires, the TCP connection is closed regardless.
+type closeHandshake struct {
+	timeout  time.Duration
+	timer    *sonic.Timer
+	callback CloseCallback
+
+	sent     bool // true if our close frame was written
+	received bool // true if the peer's close frame was received
+	done     bool // true if the next layer was closed and the callback invoked
+
+	// Code and reason of the peer's close frame, if received.
+	code   CloseCode
+	reason string
+
+	// Scratch buffer into which the client reads while waiting for the server to close the TCP connection.
+	scratch [128]byte
+}
+
+func (c *closeHandshake) reset() {
+	c.sent = false
+	c.received = false
+	c.done = false
+	c.code = CloseNone
+	c.reason = ""
+}
+
+// resetClose prepares the closing handshake state for a new connection.
+func (s *Stream) resetClose() {
+	if s.closing.timer != nil {
+		_ = s.closing.timer.Close()
+		s.closing.timer = nil
+	}
+	s.closing.reset()
+}
+
+// SetCloseTimeout sets the maximum duration of the closing handshake, measured from when the close frame is queued
+// by us, either when we initiate the handshake or when we reply to the peer's close frame. When the timeout expires,
+// the TCP connection is closed. A timeout of 0 disables it. The default is `CloseTimeout`.
+//
+// The timeout requires the IO object passed to NewWebsocketStream to be run.
+func (s *Stream) SetCloseTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	s.closing.timeout = timeout
+}
+
+func (s *Stream) CloseTimeout() time.Duration {
+	return s.closing.timeout
+}
+
+// SetCloseCallback sets the callback invoked once the stream is closed, after the TCP connection is closed. It is
+// invoked exactly once per connection with the close code and reason sent by the peer, regardless of which side
+// started the closing handshake.
+//
+// If the connection ends without a close frame from the peer, because the peer closed the TCP connection or the close
+// timeout expired, the callback is invoked with CloseAbnormal.
+func (s *Stream) SetCloseCallback(callback CloseCallback) {
+	s.closing.callback = callback
+}
+
+func (s *Stream) CloseCallback() CloseCallback {
+	return s.closing.callback
+}
+
+// onCloseQueued is called when our close frame is queued for writing.
+func (s *Stream) onCloseQueued() {
+	c := &s.closing
+	if c.done || c.timeout == 0 {
+		return
+	}
+
+	if c.timer == nil {
+		timer, err := sonic.NewTimer(s.ioc)
+		if err != nil {
+			// Without a timer the handshake is only bounded by the peer.
+			return
+		}
+		c.timer = timer
+	}
+
+	if !c.timer.Scheduled() {
+		_ = c.timer.ScheduleOnce(c.timeout, s.onCloseTimeout)
+	}
+}
+
+// onCloseSent is called once our close frame is written.
+func (s *Stream) onCloseSent() {
+	s.closing.sent = true
+	s.advanceClose()
+}
+
+// onCloseReceived is called when the peer's close frame is received.
+func (s *Stream) onCloseReceived(payload []byte) {
+	c := &s.closing
+	if c.received {
+		return
+	}
+	c.received = true
+	c.code, c.reason = DecodeCloseFramePayload(payload)
+	s.advanceClose()
+}
+
+func (s *Stream) advanceClose() {
+	c := &s.closing
+	if c.done || !c.sent || !c.received {
+		return
+	}
+
+	if s.role == RoleServer {
+		s.finishClose()
+		return
+	}
+
+	// Wait for the server to close the TCP connection. Anything it sends meanwhile is discarded.
+	var onRead sonic.AsyncCallback
+	onRead = func(err error, _ int) {
+		if err != nil {
+			s.finishClose()
+		} else if !c.done {
+			s.stream.AsyncRead(c.scratch[:], onRead)
+		}
+	}
+	s.stream.AsyncRead(c.scratch[:], onRead)
+}
+
+func (s *Stream) onCloseTimeout() {
+	if s.state == StateClosedByUs {
+		// The peer never replied.
+		s.state = StateTerminated
+	}
+	s.finishClose()
+}
+
+// finishClose closes the next layer and reports the close code, if not already done.
+func (s *Stream) finishClose() {
+	c := &s.closing
+	if c.done {
+		return
+	}
+	c.done = true
+
+	if c.timer != nil {
+		_ = c.timer.Close()
+		c.timer = nil
+	}
+
+	_ = s.CloseNextLayer()
+
+	if c.callback != nil {
+		if c.received {
+			c.callback(c.code, c.reason)
+		} else {
+			c.callback(CloseAbnormal, "")
+		}
+	}
+}
+
+// writeErr returns the error with which writes attempted in the current state fail, if any.
+func (s *Stream) writeErr() error {
+	switch s.state {
+	case StateActive:
+		return nil
+	case StateHandshake:
+		return ErrNotActive
+	default:
+		return ErrSendAfterClose
+	}
+}
@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type closeReport struct {
+	calls  int
+	code   CloseCode
+	reason string
+}
+
+func (r *closeReport) callback(code CloseCode, reason string) {
+	r.calls++
+	r.code = code
+	r.reason = reason
+}
+
+func writeMaskedFrame(ws *Stream, opcode Opcode, payload []byte) {
+	f := NewFrame()
+	f.SetIsMasked() // reserves space for the mask, see AcquireFrame
+	f.SetFIN().SetOpcode(opcode).SetPayload(payload)
+	f.MaskPayload()
+	ws.src.Write(f)
+}
+
+func TestCloseServerClosesFirst(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleServer)
+	var report closeReport
+	ws.SetCloseCallback(report.callback)
+
+	writeMaskedFrame(ws, OpcodeClose, EncodeCloseFramePayload(CloseGoingAway, "bye"))
+	f, err := ws.NextFrame()
+	assert.Nil(err)
+	assert.True(f.Opcode().IsClose())
+	assertState(t, ws, StateClosedByPeer)
+
+	// The connection is closed once the reply is written.
+	assert.False(stream.closed)
+	assert.Nil(ws.Flush())
+	assert.True(stream.closed)
+	assert.Equal(1, report.calls)
+	assert.Equal(CloseGoingAway, report.code)
+	assert.Equal("bye", report.reason)
+
+	// A server initiated close ends once the client replies.
+	ws, stream = newDeferredStream(t, RoleServer)
+	report = closeReport{}
+	ws.SetCloseCallback(report.callback)
+
+	ws.AsyncClose(CloseNormal, "", func(err error) { assert.Nil(err) })
+	stream.complete()
+	assert.False(stream.closed)
+
+	writeMaskedFrame(ws, OpcodeClose, EncodeCloseFramePayload(CloseNormal, "done"))
+	_, err = ws.NextFrame()
+	assert.Nil(err)
+	assertState(t, ws, StateCloseAcked)
+	assert.True(stream.closed)
+	assert.Equal(1, report.calls)
+	assert.Equal(CloseNormal, report.code)
+	assert.Equal("done", report.reason)
+}
+
+func TestCloseClientWaitsForServer(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleClient)
+	var report closeReport
+	ws.SetCloseCallback(report.callback)
+
+	assert.Nil(ws.Close(CloseNormal, ""))
+	writeFrame(ws, true, OpcodeClose, EncodeCloseFramePayload(CloseNormal, ""))
+	_, err := ws.NextFrame()
+	assert.Nil(err)
+	assertState(t, ws, StateCloseAcked)
+
+	// The client waits for the server to close the connection.
+	assert.False(stream.closed)
+	assert.Equal(0, report.calls)
+	assert.Len(stream.reads, 1)
+
+	stream.reads[0](nil, 1) // data sent after the close frame is discarded
+	assert.False(stream.closed)
+	assert.Len(stream.reads, 2)
+
+	stream.reads[1](io.EOF, 0)
+	assert.True(stream.closed)
+	assert.Equal(1, report.calls)
+	assert.Equal(CloseNormal, report.code)
+}
+
+func TestCloseTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleClient)
+	ws.SetCloseTimeout(10 * time.Millisecond)
+	assert.Equal(10*time.Millisecond, ws.CloseTimeout())
+
+	var report closeReport
+	ws.SetCloseCallback(report.callback)
+
+	// The peer never replies.
+	ws.AsyncClose(CloseNormal, "", func(err error) { assert.Nil(err) })
+	stream.complete()
+	assertState(t, ws, StateClosedByUs)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for report.calls == 0 && time.Now().Before(deadline) {
+		_, _ = ws.ioc.PollOne()
+	}
+
+	assertState(t, ws, StateTerminated)
+	assert.True(stream.closed)
+	assert.Equal(1, report.calls)
+	assert.Equal(CloseAbnormal, report.code)
+}
+
+func TestCloseAbnormal(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	var report closeReport
+	ws.SetCloseCallback(report.callback)
+
+	// The peer closes the connection without a close frame.
+	f, err := ws.NextFrame()
+	assert.Equal(io.EOF, err)
+	cc, _ := DecodeCloseFramePayload(f.Payload())
+	assert.Equal(CloseAbnormal, cc)
+	assertState(t, ws, StateTerminated)
+	assert.Equal(1, report.calls)
+	assert.Equal(CloseAbnormal, report.code)
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	assert.Nil(ws.Close(CloseNormal, ""))
+
+	assert.Equal(ErrSendAfterClose, ws.Write([]byte("hello"), TypeText))
+	ws.AsyncWrite([]byte("hello"), TypeText, func(err error) {
+		assert.Equal(ErrSendAfterClose, err)
+	})
+	assert.Equal(ErrSendAfterClose, ws.WriteFrame(ws.AcquireFrame().SetFIN().SetPing()))
+	assert.Equal(ErrSendAfterClose, ws.BeginMessage(TypeText))
+
+	ws, _ = NewWebsocketStream(ws.ioc, nil, RoleClient)
+	assert.Equal(ErrNotActive, ws.Write([]byte("hello"), TypeText))
+	ws.AsyncWrite([]byte("hello"), TypeText, func(err error) {
+		assert.Equal(ErrNotActive, err)
+	})
+	assert.Equal(ErrNotActive, ws.BeginMessage(TypeText))
+}
@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -99,12 +100,42 @@ func (s StreamState) String() string {
 	}
 }
 
+// WriteQueuePolicy decides what happens to a message written with AsyncWrite when queuing it would exceed the write
+// queue's byte limit. See `Stream.SetMaxQueuedBytes`.
+type WriteQueuePolicy uint8
+
+const (
+	// The write fails with ErrWriteQueueFull.
+	WriteQueueFail WriteQueuePolicy = iota
+
+	// The message is silently dropped: it is not written and the write's callback is invoked without an error.
+	WriteQueueDrop
+)
+
+func (p WriteQueuePolicy) String() string {
+	switch p {
+	case WriteQueueFail:
+		return "write_queue_fail"
+	case WriteQueueDrop:
+		return "write_queue_drop"
+	default:
+		return "write_queue_unknown"
+	}
+}
+
 type AsyncMessageCallback = func(err error, n int, messageType MessageType)
 type AsyncFrameCallback = func(err error, f Frame)
+type AsyncMessageReaderCallback = func(err error, messageType MessageType, r MessageReader)
 type ControlCallback = func(messageType MessageType, payload []byte)
+type WritableCallback = func(writable bool)
+type CloseCallback = func(closeCode CloseCode, reason string)
 type UpgradeRequestCallback = func(req *http.Request)
 type UpgradeResponseCallback = func(res *http.Response)
 
+// ProxyFunc returns the URL of the proxy through which the given target should be reached, or nil if the target
+// should be reached directly. See `proxy.FromEnvironment`.
+type ProxyFunc = func(target *url.URL) (*url.URL, error)
+
 type Header struct {
 	Key          string
 	Values       []string
@@ -23,6 +23,8 @@ var (
 
 	ErrSendAfterClose = errors.New("sending on a closed stream")
 
+	ErrNotActive = errors.New("sending before the handshake completed")
+
 	ErrNonZeroReservedBits = errors.New("non zero reserved bits")
 
 	ErrMaskedFramesFromServer = errors.New("masked frames from server")
@@ -40,4 +42,16 @@ var (
 	ErrInvalidAddress = errors.New("invalid address")
 
 	ErrInvalidUTF8 = errors.New("Invalid UTF-8 encoding")
+
+	ErrPendingMessage = errors.New("previous message not read fully")
+
+	ErrMessageInProgress = errors.New("previous message not ended")
+
+	ErrNoMessageInProgress = errors.New("no message begun")
+
+	ErrInvalidMessageType = errors.New("invalid message type")
+
+	ErrWriteQueueFull = errors.New("write queue full")
+
+	ErrAsyncWriteInProgress = errors.New("asynchronous write in progress")
 )
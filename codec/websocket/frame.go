@@ -309,7 +309,7 @@ func (f Frame) WriteTo(w io.Writer) (int64, error) {
 		n, err := w.Write(f[written:])
 		written += n
 		if err != nil {
-			return int64(n), err
+			return int64(written), err
 		}
 	}
 
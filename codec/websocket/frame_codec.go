@@ -13,15 +13,15 @@ var (
 
 // FrameCodec is a stateful streaming parser handling the encoding and decoding of WebSocket `Frame`s.
 type FrameCodec struct {
-	src            *sonic.ByteBuffer // buffer we decode from
-	dst            *sonic.ByteBuffer // buffer we encode to
+	src            sonic.Buffer // buffer we decode from
+	dst            sonic.Buffer // buffer we encode to
 	maxMessageSize int
 
 	decodeFrame Frame // frame we decode into
 	decodeReset bool  // true if we must reset the state on the next decode
 }
 
-func NewFrameCodec(src, dst *sonic.ByteBuffer, maxMessageSize int) *FrameCodec {
+func NewFrameCodec(src, dst sonic.Buffer, maxMessageSize int) *FrameCodec {
 	return &FrameCodec{
 		decodeFrame:    NewFrame(),
 		src:            src,
@@ -47,7 +47,7 @@ func (c *FrameCodec) resetDecode() {
 //
 // 2. `src` contains at least the bytes of one `Frame`: we decode the next `Frame` and leave the remainder bytes
 // composing a partial `Frame` or a set of `Frame`s in the `src` buffer.
-func (c *FrameCodec) Decode(src *sonic.ByteBuffer) (Frame, error) {
+func (c *FrameCodec) Decode(src sonic.Buffer) (Frame, error) {
 	c.resetDecode()
 
 	// read the mandatory header
@@ -97,16 +97,19 @@ func (c *FrameCodec) Decode(src *sonic.ByteBuffer) (Frame, error) {
 }
 
 // Encode encodes the `Frame` into `dst`.
-func (c *FrameCodec) Encode(frame Frame, dst *sonic.ByteBuffer) error {
+func (c *FrameCodec) Encode(frame Frame, dst sonic.Buffer) error {
 	// TODO this can be improved: we can serialize directly in the buffer with zero-copy semantics
 
 	// ensure the destination buffer can hold the serialized frame
-	dst.Reserve(frame.PayloadLength() + frameMaxHeaderLength)
+	if err := dst.Reserve(len(frame)); err != nil {
+		return err
+	}
 
 	n, err := frame.WriteTo(dst)
-	dst.Commit(int(n))
 	if err != nil {
-		dst.Consume(int(n))
+		dst.ShrinkBy(int(n))
+		return err
 	}
-	return err
+	dst.Commit(int(n))
+	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/talostrading/sonic"
+	sonicbytes "github.com/talostrading/sonic/bytes"
 	"github.com/talostrading/sonic/sonicerrors"
 )
 
@@ -160,3 +161,41 @@ func TestDecodeTwoFrames(t *testing.T) {
 		t.Fatal("should have 0 bytes in the write area")
 	}
 }
+
+func TestFrameCodecMirroredBuffer(t *testing.T) {
+	src, err := sonicbytes.NewMirroredByteBuffer(4096, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Destroy()
+	dst, err := sonicbytes.NewMirroredByteBuffer(4096, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Destroy()
+
+	codec := NewFrameCodec(src, dst, DefaultMaxMessageSize)
+
+	// The frames wrap around the end of the buffers many times.
+	for i := 0; i < 1000; i++ {
+		payload := bytes.Repeat([]byte{byte(i)}, 100+i%200)
+
+		f := NewFrame()
+		f.SetFIN().SetText().SetPayload(payload)
+		if err := codec.Encode(f, dst); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := src.Write(dst.Data()); err != nil {
+			t.Fatal(err)
+		}
+		dst.Consume(dst.ReadLen())
+
+		decoded, err := codec.Decode(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.IsFIN() || !decoded.Opcode().IsText() || !bytes.Equal(decoded.Payload(), payload) {
+			t.Fatalf("corrupt frame %d", i)
+		}
+	}
+}
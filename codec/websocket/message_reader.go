@@ -0,0 +1,417 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// MessageReader reads the payload of a single message, which can be arbitrarily large, in chunks of the caller's
+// choosing. Message fragmentation is automatically handled by the implementation: the reader delivers the payloads of
+// all fragments back to back.
+//
+// Reads return io.EOF along with the last bytes of the message. Subsequent reads return 0 and io.EOF. Reads return
+// io.ErrUnexpectedEOF if the stream is closed before the whole message is read.
+type MessageReader interface {
+	io.Reader
+	sonic.AsyncReader
+}
+
+var _ MessageReader = &messageReader{}
+
+// messageReader is the MessageReader handed out by a Stream. Each Stream owns exactly one, which is reused for every
+// message.
+//
+// Payload bytes which are already in the Stream's read buffer are copied from it. Otherwise, the payload is read from
+// the underlying stream directly into the caller's buffer.
+type messageReader struct {
+	s *Stream
+
+	messageType MessageType
+	started     bool // true if the first fragment of the message has been read
+	done        bool // true if the whole payload of the message has been read
+
+	fin       bool // true if the current fragment is the last one
+	remaining int  // payload bytes of the current fragment which have not been read yet
+
+	masked     bool
+	mask       [frameMaskLength]byte
+	maskOffset int
+
+	validator utf8Validator
+}
+
+func (r *messageReader) reset() {
+	r.messageType = TypeNone
+	r.started = false
+	r.done = false
+	r.fin = false
+	r.remaining = 0
+	r.masked = false
+	r.maskOffset = 0
+	r.validator.Reset()
+}
+
+// pending returns true if a message is being read but was not read fully.
+func (r *messageReader) pending() bool {
+	return r.started && !r.done
+}
+
+// decodeHeader returns the header of the next frame in src, without consuming it.
+func decodeHeader(src sonic.Buffer) (Frame, error) {
+	n := frameHeaderLength
+	if err := src.PrepareRead(n); err != nil {
+		return nil, err
+	}
+
+	n += Frame(src.Data()[:n]).ExtendedPayloadLengthBytes()
+	if err := src.PrepareRead(n); err != nil {
+		return nil, err
+	}
+
+	n += Frame(src.Data()[:n]).MaskBytes()
+	if err := src.PrepareRead(n); err != nil {
+		return nil, err
+	}
+
+	return src.Data()[:n], nil
+}
+
+// nextFragment reads the header of the next data frame from the Stream's read buffer, handling any control frame
+// preceding it. It returns ErrNeedMore if more bytes must be read into the buffer.
+func (r *messageReader) nextFragment() error {
+	s := r.s
+
+	for {
+		s.codec.resetDecode()
+
+		header, err := decodeHeader(s.src)
+		if err != nil {
+			return err
+		}
+
+		if header.Opcode().IsControl() {
+			// Control frames are small, so we decode them whole.
+			f, err := s.codec.Decode(s.src)
+			if err != nil {
+				if errors.Is(err, sonicerrors.ErrNeedMore) {
+					return err
+				}
+				return s.failFrame(err)
+			}
+
+			if err := s.handleFrame(f); err != nil {
+				return err
+			}
+
+			if s.controlCallback != nil {
+				s.controlCallback(MessageType(f.Opcode()), f.Payload())
+			}
+
+			if !s.canRead() {
+				if r.started {
+					return io.ErrUnexpectedEOF
+				}
+				return io.EOF
+			}
+
+			continue
+		}
+
+		if err := s.verifyFrame(header); err != nil {
+			return s.failFrame(err)
+		}
+
+		opcode := header.Opcode()
+		if opcode.IsReserved() {
+			return s.failFrame(ErrReservedOpcode)
+		}
+
+		if !r.started {
+			if opcode.IsContinuation() {
+				return s.failFrame(ErrUnexpectedContinuation)
+			}
+			r.started = true
+			r.messageType = MessageType(opcode)
+		} else if !opcode.IsContinuation() {
+			return s.failFrame(ErrExpectedContinuation)
+		}
+
+		r.fin = header.IsFIN()
+		r.remaining = header.PayloadLength()
+		r.masked = header.IsMasked()
+		if r.masked {
+			copy(r.mask[:], header.Mask())
+		}
+		r.maskOffset = 0
+
+		s.src.Consume(len(header))
+
+		if r.remaining == 0 && r.fin {
+			return r.finish()
+		}
+		return nil
+	}
+}
+
+// onPayload is called with the payload bytes of the current fragment after they are read into the caller's buffer.
+func (r *messageReader) onPayload(b []byte) error {
+	if r.masked {
+		for i := range b {
+			b[i] ^= r.mask[(r.maskOffset+i)&3]
+		}
+		r.maskOffset += len(b)
+	}
+
+	if r.messageType == TypeText && r.s.validateUTF8 && !r.validator.Valid(b) {
+		return r.s.failFrame(ErrInvalidUTF8)
+	}
+
+	r.remaining -= len(b)
+	if r.remaining == 0 && r.fin {
+		return r.finish()
+	}
+	return nil
+}
+
+func (r *messageReader) finish() error {
+	r.done = true
+	if r.messageType == TypeText && r.s.validateUTF8 && !r.validator.Done() {
+		return r.s.failFrame(ErrInvalidUTF8)
+	}
+	return io.EOF
+}
+
+// readBuffered reads payload bytes of the current fragment from the Stream's read buffer into b.
+func (r *messageReader) readBuffered(b []byte) (n int, err error) {
+	src := r.s.src
+	src.Commit(src.WriteLen())
+
+	if len(b) > r.remaining {
+		b = b[:r.remaining]
+	}
+	n = copy(b, src.Data())
+	src.Consume(n)
+
+	return n, r.onPayload(b[:n])
+}
+
+func (r *messageReader) buffered() bool {
+	return r.s.src.ReadLen()+r.s.src.WriteLen() > 0
+}
+
+// onReadError is called when reading from the underlying stream fails.
+func (r *messageReader) onReadError(err error) error {
+	if err == io.EOF {
+		r.s.state = StateTerminated
+		r.s.finishClose()
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Read reads up to len(b) bytes of the message's payload into b.
+//
+// This call blocks until at least one byte is read, the end of the message is reached or an error occurs.
+func (r *messageReader) Read(b []byte) (n int, err error) {
+	if r.done {
+		return 0, io.EOF
+	}
+
+	for r.remaining == 0 {
+		if err = r.s.nextFragment(r); err != nil {
+			return 0, err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+	}
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if r.buffered() {
+		return r.readBuffered(b)
+	}
+
+	if len(b) > r.remaining {
+		b = b[:r.remaining]
+	}
+	n, err = r.s.stream.Read(b)
+	if err != nil {
+		return n, r.onReadError(err)
+	}
+	return n, r.onPayload(b[:n])
+}
+
+// AsyncRead reads up to len(b) bytes of the message's payload into b asynchronously.
+//
+// This call does not block. The provided callback is invoked when at least one byte is read, the end of the message is
+// reached or an error occurs.
+func (r *messageReader) AsyncRead(b []byte, cb sonic.AsyncCallback) {
+	if r.done {
+		cb(io.EOF, 0)
+		return
+	}
+
+	if r.remaining == 0 {
+		r.s.asyncNextFragment(r, func(err error) {
+			if err != nil {
+				cb(err, 0)
+			} else {
+				r.AsyncRead(b, cb)
+			}
+		})
+		return
+	}
+
+	if len(b) == 0 {
+		cb(nil, 0)
+		return
+	}
+
+	if r.buffered() {
+		n, err := r.readBuffered(b)
+		cb(err, n)
+		return
+	}
+
+	if len(b) > r.remaining {
+		b = b[:r.remaining]
+	}
+	r.s.stream.AsyncRead(b, func(err error, n int) {
+		if err != nil {
+			cb(r.onReadError(err), n)
+		} else {
+			cb(r.onPayload(b[:n]), n)
+		}
+	})
+}
+
+// AsyncReadAll reads exactly len(b) bytes of the message's payload into b asynchronously, unless the end of the
+// message is reached first.
+func (r *messageReader) AsyncReadAll(b []byte, cb sonic.AsyncCallback) {
+	r.asyncReadAll(b, 0, cb)
+}
+
+func (r *messageReader) asyncReadAll(b []byte, readSoFar int, cb sonic.AsyncCallback) {
+	r.AsyncRead(b[readSoFar:], func(err error, n int) {
+		readSoFar += n
+		if err != nil || readSoFar == len(b) {
+			cb(err, readSoFar)
+		} else {
+			r.asyncReadAll(b, readSoFar, cb)
+		}
+	})
+}
+
+// nextFragment reads the header of the next fragment of the message read by r, blocking until it is available.
+func (s *Stream) nextFragment(r *messageReader) error {
+	for {
+		err := r.nextFragment()
+		if !errors.Is(err, sonicerrors.ErrNeedMore) {
+			return err
+		}
+
+		// Answer any pings received in the meantime before blocking.
+		if err := s.flushBeforeRead(); err != nil {
+			return err
+		}
+
+		s.src.Reserve(frameMaxHeaderLength + MaxControlFramePayloadLength)
+		if _, err := s.src.ReadFrom(s.stream); err != nil {
+			return r.onReadError(err)
+		}
+	}
+}
+
+// asyncNextFragment is like nextFragment but asynchronous.
+func (s *Stream) asyncNextFragment(r *messageReader, cb func(error)) {
+	err := r.nextFragment()
+	if !errors.Is(err, sonicerrors.ErrNeedMore) {
+		cb(err)
+		return
+	}
+
+	// Answer any pings received in the meantime before waiting for more bytes.
+	s.asyncFlushBeforeRead(func(err error) {
+		if err != nil {
+			cb(err)
+			return
+		}
+
+		s.src.Reserve(frameMaxHeaderLength + MaxControlFramePayloadLength)
+		s.src.AsyncReadFrom(s.stream, func(err error, _ int) {
+			if err != nil {
+				cb(r.onReadError(err))
+			} else {
+				s.asyncNextFragment(r, cb)
+			}
+		})
+	})
+}
+
+// NextMessageReader returns a reader for the payload of the next message. Unlike NextMessage, the payload does not
+// need to fit in a single buffer and is not bound by MaxMessageSize.
+//
+// This call first flushes any pending control frames to the underlying stream.
+//
+// This call blocks until the first fragment of the next message is received or an error occurs. Control frames
+// received before or in between the message's fragments are handled as in NextMessage.
+//
+// The message must be read until io.EOF before reading the next message or frame.
+func (s *Stream) NextMessageReader() (MessageType, MessageReader, error) {
+	if s.reader.pending() {
+		return TypeNone, nil, ErrPendingMessage
+	}
+
+	err := s.flushBeforeRead()
+	if err == nil && !s.canRead() {
+		err = io.EOF
+	}
+	if err != nil {
+		return TypeNone, nil, err
+	}
+
+	s.reader.reset()
+	err = s.nextFragment(&s.reader)
+	// An empty message is still a message: its reader returns io.EOF straight away.
+	if err != nil && !(err == io.EOF && s.reader.started) {
+		return TypeNone, nil, err
+	}
+	return s.reader.messageType, &s.reader, nil
+}
+
+// AsyncNextMessageReader is like NextMessageReader but asynchronous.
+//
+// This call does not block. The provided callback is invoked when the first fragment of the next message is received
+// or an error occurs.
+func (s *Stream) AsyncNextMessageReader(callback AsyncMessageReaderCallback) {
+	if s.reader.pending() {
+		callback(ErrPendingMessage, TypeNone, nil)
+		return
+	}
+
+	s.asyncFlushBeforeRead(func(err error) {
+		if err == nil && !s.canRead() {
+			err = io.EOF
+		}
+		if err != nil {
+			callback(err, TypeNone, nil)
+			return
+		}
+
+		s.reader.reset()
+		s.asyncNextFragment(&s.reader, func(err error) {
+			// An empty message is still a message: its reader returns io.EOF straight away.
+			if err != nil && !(err == io.EOF && s.reader.started) {
+				callback(err, TypeNone, nil)
+			} else {
+				callback(nil, s.reader.messageType, &s.reader)
+			}
+		})
+	})
+}
@@ -0,0 +1,327 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic"
+)
+
+func newMockedStream(t *testing.T, role Role) *Stream {
+	ws, err := NewWebsocketStream(sonic.MustIO(), nil, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.state = StateActive
+	if err := ws.init(NewMockStream()); err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func writeFrame(ws *Stream, fin bool, opcode Opcode, payload []byte) {
+	f := NewFrame()
+	if fin {
+		f.SetFIN()
+	}
+	f.SetOpcode(opcode).SetPayload(payload)
+	ws.src.Write(f)
+}
+
+func TestMessageReaderFragmented(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	writeFrame(ws, false, OpcodeText, []byte("hello, "))
+	writeFrame(ws, true, OpcodePing, []byte{1, 2})
+	writeFrame(ws, false, OpcodeContinuation, nil)
+	writeFrame(ws, true, OpcodeContinuation, []byte("world!"))
+
+	pinged := false
+	ws.SetControlCallback(func(mt MessageType, b []byte) {
+		pinged = mt == TypePing && bytes.Equal(b, []byte{1, 2})
+	})
+
+	var (
+		done    = false
+		message []byte
+		b       = make([]byte, 3)
+	)
+	ws.AsyncNextMessageReader(func(err error, mt MessageType, r MessageReader) {
+		assert.Nil(err)
+		assert.Equal(TypeText, mt)
+
+		var onRead sonic.AsyncCallback
+		onRead = func(err error, n int) {
+			message = append(message, b[:n]...)
+			if err == nil {
+				r.AsyncRead(b, onRead)
+				return
+			}
+
+			assert.Equal(io.EOF, err)
+			done = true
+		}
+		r.AsyncRead(b, onRead)
+	})
+
+	assert.True(done)
+	assert.Equal("hello, world!", string(message))
+	assert.True(pinged)
+	assert.Equal(1, ws.Pending()) // the pong
+
+	// Subsequent reads keep returning EOF.
+	ws.reader.AsyncRead(b, func(err error, n int) {
+		assert.Equal(io.EOF, err)
+		assert.Equal(0, n)
+	})
+}
+
+func TestMessageReaderSync(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	writeFrame(ws, false, OpcodeBinary, []byte{1, 2, 3})
+	writeFrame(ws, true, OpcodeContinuation, []byte{4, 5})
+	writeFrame(ws, true, OpcodeText, []byte("next"))
+
+	mt, r, err := ws.NextMessageReader()
+	assert.Nil(err)
+	assert.Equal(TypeBinary, mt)
+
+	b, err := io.ReadAll(r)
+	assert.Nil(err)
+	assert.Equal([]byte{1, 2, 3, 4, 5}, b)
+
+	mt, r, err = ws.NextMessageReader()
+	assert.Nil(err)
+	assert.Equal(TypeText, mt)
+
+	b, err = io.ReadAll(r)
+	assert.Nil(err)
+	assert.Equal("next", string(b))
+}
+
+func TestMessageReaderEmptyMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	writeFrame(ws, true, OpcodeText, nil)
+
+	mt, r, err := ws.NextMessageReader()
+	assert.Nil(err)
+	assert.Equal(TypeText, mt)
+
+	n, err := r.Read(make([]byte, 16))
+	assert.Equal(0, n)
+	assert.Equal(io.EOF, err)
+}
+
+func TestMessageReaderPendingMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	writeFrame(ws, true, OpcodeText, []byte("hello"))
+
+	_, r, err := ws.NextMessageReader()
+	assert.Nil(err)
+
+	_, _, err = ws.NextMessageReader()
+	assert.Equal(ErrPendingMessage, err)
+
+	_, err = ws.NextFrame()
+	assert.Equal(ErrPendingMessage, err)
+
+	_, _, err = ws.NextMessage(make([]byte, 16))
+	assert.Equal(ErrPendingMessage, err)
+
+	failed := 0
+	ws.AsyncNextFrame(func(err error, _ Frame) {
+		assert.Equal(ErrPendingMessage, err)
+		failed++
+	})
+	ws.AsyncNextMessage(make([]byte, 16), func(err error, _ int, _ MessageType) {
+		assert.Equal(ErrPendingMessage, err)
+		failed++
+	})
+	assert.Equal(2, failed)
+
+	b, err := io.ReadAll(r)
+	assert.Nil(err)
+	assert.Equal("hello", string(b))
+}
+
+func TestMessageReaderUnexpectedContinuation(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	writeFrame(ws, true, OpcodeContinuation, []byte("hello"))
+
+	_, _, err := ws.NextMessageReader()
+	assert.Equal(ErrUnexpectedContinuation, err)
+	assertState(t, ws, StateClosedByUs)
+	assert.Equal(1, ws.Pending()) // the close
+}
+
+func TestMessageReaderExpectedContinuation(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	writeFrame(ws, false, OpcodeText, []byte("hello"))
+	writeFrame(ws, true, OpcodeText, []byte("world"))
+
+	_, r, err := ws.NextMessageReader()
+	assert.Nil(err)
+
+	_, err = io.ReadAll(r)
+	assert.Equal(ErrExpectedContinuation, err)
+	assertState(t, ws, StateClosedByUs)
+}
+
+func TestMessageReaderCloseWhileReading(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	writeFrame(ws, false, OpcodeText, []byte("hello"))
+	writeFrame(ws, true, OpcodeClose, EncodeCloseFramePayload(CloseNormal, ""))
+
+	_, r, err := ws.NextMessageReader()
+	assert.Nil(err)
+
+	_, err = io.ReadAll(r)
+	assert.Equal(io.ErrUnexpectedEOF, err)
+	assertState(t, ws, StateClosedByPeer)
+}
+
+func TestMessageReaderServerUnmasks(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleServer)
+
+	payload := []byte("a masked payload which is read in small chunks")
+	for _, fragment := range [][]byte{payload[:10], payload[10:]} {
+		f := NewFrame()
+		f.SetIsMasked() // reserves space for the mask, see AcquireFrame
+		f.SetOpcode(OpcodeText).SetPayload(fragment)
+		if len(fragment) == len(payload)-10 {
+			f.SetFIN().SetContinuation()
+		}
+		f.MaskPayload()
+		ws.src.Write(f)
+	}
+
+	_, r, err := ws.NextMessageReader()
+	assert.Nil(err)
+
+	var message []byte
+	b := make([]byte, 7)
+	for {
+		n, err := r.Read(b)
+		message = append(message, b[:n]...)
+		if err != nil {
+			assert.Equal(io.EOF, err)
+			break
+		}
+	}
+	assert.Equal(payload, message)
+}
+
+func TestMessageReaderValidatesUTF8AcrossFragments(t *testing.T) {
+	assert := assert.New(t)
+
+	text := []byte("κόσμε")
+
+	ws := newMockedStream(t, RoleClient)
+	ws.ValidateUTF8(true)
+	// Split the message in the middle of a code point.
+	writeFrame(ws, false, OpcodeText, text[:3])
+	writeFrame(ws, true, OpcodeContinuation, text[3:])
+
+	_, r, err := ws.NextMessageReader()
+	assert.Nil(err)
+	b, err := io.ReadAll(r)
+	assert.Nil(err)
+	assert.Equal(text, b)
+
+	ws = newMockedStream(t, RoleClient)
+	ws.ValidateUTF8(true)
+	writeFrame(ws, false, OpcodeText, text[:3])
+	writeFrame(ws, true, OpcodeContinuation, []byte{0xFF})
+
+	_, r, err = ws.NextMessageReader()
+	assert.Nil(err)
+	_, err = io.ReadAll(r)
+	assert.Equal(ErrInvalidUTF8, err)
+
+	ws = newMockedStream(t, RoleClient)
+	ws.ValidateUTF8(true)
+	writeFrame(ws, true, OpcodeText, text[:3]) // ends with an incomplete code point
+
+	_, r, err = ws.NextMessageReader()
+	assert.Nil(err)
+	_, err = io.ReadAll(r)
+	assert.Equal(ErrInvalidUTF8, err)
+}
+
+func TestMessageReaderLargerThanMaxMessageSize(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := make([]byte, 4*DefaultMaxMessageSize)
+	for i := range payload {
+		payload[i] = byte('a' + i%26)
+	}
+
+	srv := NewMockServer()
+	go func() {
+		defer srv.Close()
+
+		err := srv.Accept(MockServerDynamicAddr)
+		if err != nil {
+			panic(err)
+		}
+
+		assert.Nil(srv.Write(payload))
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	assert.Nil(err)
+
+	var (
+		done    = false
+		message []byte
+		b       = make([]byte, 4096)
+	)
+	ws.AsyncHandshake(fmt.Sprintf("ws://localhost:%d", <-srv.portChan), func(err error) {
+		assert.Nil(err)
+
+		ws.AsyncNextMessageReader(func(err error, mt MessageType, r MessageReader) {
+			assert.Nil(err)
+			assert.Equal(TypeText, mt)
+
+			var onRead sonic.AsyncCallback
+			onRead = func(err error, n int) {
+				message = append(message, b[:n]...)
+				if err == nil {
+					r.AsyncRead(b, onRead)
+					return
+				}
+				assert.Equal(io.EOF, err)
+				done = true
+			}
+			r.AsyncRead(b, onRead)
+		})
+	})
+
+	for !done {
+		_ = ioc.RunOne()
+	}
+
+	assert.Equal(payload, message)
+	assert.LessOrEqual(ws.src.Cap(), DefaultMaxMessageSize) // the payload did not go through the read buffer
+}
@@ -0,0 +1,176 @@
+package websocket
+
+// messageWriter tracks the message streamed through BeginMessage, WriteChunk and EndMessage.
+type messageWriter struct {
+	messageType MessageType
+	started     bool // true if BeginMessage was called and EndMessage was not
+	wroteFirst  bool // true if the first fragment, carrying the message type, was written
+}
+
+func (w *messageWriter) reset() {
+	w.messageType = TypeNone
+	w.started = false
+	w.wroteFirst = false
+}
+
+// SetMaxFrameSize sets the maximum payload size of the frames written to the peer. Messages with larger payloads are
+// split into a frame carrying the message type followed by continuation frames, none of which exceed the limit.
+// Pending pings and pongs, such as pongs replying to pings received in the meantime, are written in between the
+// fragments of a message.
+//
+// A size of 0, which is the default, means frames are not limited: every message is written as a single frame.
+func (s *Stream) SetMaxFrameSize(bytes int) {
+	if bytes < 0 {
+		bytes = 0
+	}
+	s.maxFrameSize = bytes
+}
+
+func (s *Stream) MaxFrameSize() int {
+	return s.maxFrameSize
+}
+
+// splitFragment returns the payload of the next frame to write out of b, and the remainder of b.
+func (s *Stream) splitFragment(b []byte) (fragment, remainder []byte) {
+	if s.maxFrameSize > 0 && len(b) > s.maxFrameSize {
+		return b[:s.maxFrameSize], b[s.maxFrameSize:]
+	}
+	return b, nil
+}
+
+// queueFragments queues b as one or more frames, the first with the given opcode and the rest as continuations. The
+// last frame has fin set if fin is true. The optional callback is invoked once the last frame is written.
+func (s *Stream) queueFragments(opcode Opcode, b []byte, fin bool, callback func(err error)) {
+	for {
+		fragment, remainder := s.splitFragment(b)
+		last := len(remainder) == 0
+
+		f := s.AcquireFrame().
+			SetOpcode(opcode).
+			SetPayload(fragment)
+		if fin && last {
+			f.SetFIN()
+		}
+
+		if last {
+			s.queueFrame(f, callback)
+			return
+		}
+		s.queueFrame(f, nil)
+
+		b = remainder
+		opcode = OpcodeContinuation
+	}
+}
+
+// writeFragments writes b as one or more frames, see queueFragments.
+func (s *Stream) writeFragments(opcode Opcode, b []byte, fin bool) error {
+	if s.queue.busy() {
+		return ErrAsyncWriteInProgress
+	}
+
+	if err := s.writeErr(); err != nil {
+		return err
+	}
+
+	s.queueFragments(opcode, b, fin, nil)
+	return s.Flush()
+}
+
+// asyncWriteFragments is like writeFragments but asynchronous.
+func (s *Stream) asyncWriteFragments(opcode Opcode, b []byte, fin bool, callback func(err error)) {
+	if err := s.writeErr(); err != nil {
+		callback(err)
+		return
+	}
+
+	s.queueFragments(opcode, b, fin, callback)
+	s.flush()
+}
+
+// BeginMessage starts a message of the given type whose payload is written in chunks with WriteChunk or AsyncWriteChunk
+// and which is ended by EndMessage or AsyncEndMessage. This is useful when the length of the message is not known up
+// front. Each chunk is written as one or more frames, see SetMaxFrameSize.
+//
+// This call does not write anything to the underlying stream. No other message can be written until the message is
+// ended. Control frames, such as pongs, can be written in between the message's chunks.
+func (s *Stream) BeginMessage(messageType MessageType) error {
+	if err := s.writeErr(); err != nil {
+		return err
+	}
+	if s.writer.started {
+		return ErrMessageInProgress
+	}
+	if messageType != TypeText && messageType != TypeBinary {
+		return ErrInvalidMessageType
+	}
+
+	s.writer.reset()
+	s.writer.messageType = messageType
+	s.writer.started = true
+	return nil
+}
+
+func (s *Stream) chunkOpcode() Opcode {
+	if s.writer.wroteFirst {
+		return OpcodeContinuation
+	}
+	s.writer.wroteFirst = true
+	return Opcode(s.writer.messageType)
+}
+
+// WriteChunk writes the supplied buffer as the next chunk of the message started with BeginMessage. Empty chunks are
+// not written.
+//
+// This call first flushes any pending control frames to the underlying stream.
+//
+// This call blocks until the chunk is written or an error occurs.
+func (s *Stream) WriteChunk(b []byte) error {
+	if !s.writer.started {
+		return ErrNoMessageInProgress
+	}
+	if len(b) == 0 {
+		return s.Flush()
+	}
+	return s.writeFragments(s.chunkOpcode(), b, false)
+}
+
+// AsyncWriteChunk is like WriteChunk but asynchronous.
+//
+// This call does not block. The provided callback is invoked when the chunk is written or an error occurs.
+func (s *Stream) AsyncWriteChunk(b []byte, callback func(err error)) {
+	if !s.writer.started {
+		callback(ErrNoMessageInProgress)
+		return
+	}
+	if len(b) == 0 {
+		s.AsyncFlush(callback)
+		return
+	}
+	s.asyncWriteFragments(s.chunkOpcode(), b, false, callback)
+}
+
+// EndMessage ends the message started with BeginMessage by writing a final, empty frame.
+//
+// This call blocks until the final frame is written or an error occurs.
+func (s *Stream) EndMessage() error {
+	if !s.writer.started {
+		return ErrNoMessageInProgress
+	}
+	opcode := s.chunkOpcode()
+	s.writer.reset()
+	return s.writeFragments(opcode, nil, true)
+}
+
+// AsyncEndMessage is like EndMessage but asynchronous.
+//
+// This call does not block. The provided callback is invoked when the final frame is written or an error occurs.
+func (s *Stream) AsyncEndMessage(callback func(err error)) {
+	if !s.writer.started {
+		callback(ErrNoMessageInProgress)
+		return
+	}
+	opcode := s.chunkOpcode()
+	s.writer.reset()
+	s.asyncWriteFragments(opcode, nil, true, callback)
+}
@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writtenFrames decodes the frames the stream wrote to its mock underlying stream.
+func writtenFrames(t *testing.T, ws *Stream) (frames []Frame) {
+	var mock *MockStream
+	switch stream := ws.stream.(type) {
+	case *MockStream:
+		mock = stream
+	case *deferredStream:
+		mock = stream.MockStream
+	}
+	mock.b.Commit(mock.b.WriteLen())
+
+	for mock.b.ReadLen() > 0 {
+		f := NewFrame()
+		if _, err := f.ReadFrom(mock.b); err != nil {
+			t.Fatal(err)
+		}
+		f = f[:f.payloadOffset()+f.PayloadLength()] // ReadFrom does not trim empty payloads
+		if f.IsMasked() {
+			f.UnmaskPayload()
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func assertFrame(t *testing.T, f Frame, fin bool, opcode Opcode, payload string) {
+	assert.Equal(t, fin, f.IsFIN())
+	assert.Equal(t, opcode, f.Opcode())
+	assert.Equal(t, payload, string(f.Payload()))
+}
+
+func TestWriteFragmented(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, role := range []Role{RoleClient, RoleServer} {
+		ws := newMockedStream(t, role)
+		ws.SetMaxFrameSize(4)
+		assert.Equal(4, ws.MaxFrameSize())
+
+		assert.Nil(ws.Write([]byte("hello world"), TypeText))
+
+		frames := writtenFrames(t, ws)
+		assert.Len(frames, 3)
+		assertFrame(t, frames[0], false, OpcodeText, "hell")
+		assertFrame(t, frames[1], false, OpcodeContinuation, "o wo")
+		assertFrame(t, frames[2], true, OpcodeContinuation, "rld")
+		for _, f := range frames {
+			assert.Equal(role == RoleClient, f.IsMasked())
+		}
+	}
+}
+
+func TestWriteNotFragmented(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleServer)
+	assert.Nil(ws.Write([]byte("hello world"), TypeBinary))
+
+	ws.SetMaxFrameSize(11)
+	assert.Nil(ws.Write([]byte("hello world"), TypeBinary))
+
+	frames := writtenFrames(t, ws)
+	assert.Len(frames, 2)
+	for _, f := range frames {
+		assertFrame(t, f, true, OpcodeBinary, "hello world")
+	}
+}
+
+func TestAsyncWriteFragmented(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleServer)
+	ws.SetMaxFrameSize(5)
+
+	done := false
+	ws.AsyncWrite([]byte("hello world"), TypeBinary, func(err error) {
+		assert.Nil(err)
+		done = true
+	})
+	assert.True(done)
+
+	frames := writtenFrames(t, ws)
+	assert.Len(frames, 3)
+	assertFrame(t, frames[0], false, OpcodeBinary, "hello")
+	assertFrame(t, frames[1], false, OpcodeContinuation, " worl")
+	assertFrame(t, frames[2], true, OpcodeContinuation, "d")
+}
+
+func TestStreamedMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+	ws.SetMaxFrameSize(3)
+
+	assert.Nil(ws.BeginMessage(TypeText))
+	assert.Nil(ws.WriteChunk([]byte("hello")))
+	assert.Nil(ws.WriteChunk(nil))
+
+	// A ping received in between chunks is answered before the next chunk.
+	writeFrame(ws, true, OpcodePing, []byte{1, 2})
+	f, err := ws.NextFrame()
+	assert.Nil(err)
+	assert.True(f.Opcode().IsPing())
+	assert.Equal(1, ws.Pending())
+
+	assert.Nil(ws.WriteChunk([]byte(" world")))
+	assert.Nil(ws.EndMessage())
+
+	frames := writtenFrames(t, ws)
+	assert.Len(frames, 6)
+	assertFrame(t, frames[0], false, OpcodeText, "hel")
+	assertFrame(t, frames[1], false, OpcodeContinuation, "lo")
+	assertFrame(t, frames[2], true, OpcodePong, "\x01\x02")
+	assertFrame(t, frames[3], false, OpcodeContinuation, " wo")
+	assertFrame(t, frames[4], false, OpcodeContinuation, "rld")
+	assertFrame(t, frames[5], true, OpcodeContinuation, "")
+
+	// The next message can be written.
+	assert.Nil(ws.Write([]byte("next"), TypeText))
+	frames = writtenFrames(t, ws)
+	assert.Len(frames, 2)
+	assertFrame(t, frames[0], false, OpcodeText, "nex")
+	assertFrame(t, frames[1], true, OpcodeContinuation, "t")
+}
+
+func TestAsyncStreamedMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleClient)
+
+	done := false
+	assert.Nil(ws.BeginMessage(TypeBinary))
+	ws.AsyncWriteChunk([]byte{1, 2, 3}, func(err error) {
+		assert.Nil(err)
+		ws.AsyncWriteChunk([]byte{4}, func(err error) {
+			assert.Nil(err)
+			ws.AsyncEndMessage(func(err error) {
+				assert.Nil(err)
+				done = true
+			})
+		})
+	})
+	assert.True(done)
+
+	frames := writtenFrames(t, ws)
+	assert.Len(frames, 3)
+	assertFrame(t, frames[0], false, OpcodeBinary, "\x01\x02\x03")
+	assertFrame(t, frames[1], false, OpcodeContinuation, "\x04")
+	assertFrame(t, frames[2], true, OpcodeContinuation, "")
+}
+
+func TestStreamedMessageEmpty(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleServer)
+	assert.Nil(ws.BeginMessage(TypeText))
+	assert.Nil(ws.EndMessage())
+
+	frames := writtenFrames(t, ws)
+	assert.Len(frames, 1)
+	assertFrame(t, frames[0], true, OpcodeText, "")
+}
+
+func TestStreamedMessageErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newMockedStream(t, RoleServer)
+
+	assert.Equal(ErrNoMessageInProgress, ws.WriteChunk([]byte("hello")))
+	assert.Equal(ErrNoMessageInProgress, ws.EndMessage())
+	ws.AsyncEndMessage(func(err error) {
+		assert.Equal(ErrNoMessageInProgress, err)
+	})
+	assert.Equal(ErrInvalidMessageType, ws.BeginMessage(TypePing))
+
+	assert.Nil(ws.BeginMessage(TypeText))
+	assert.Equal(ErrMessageInProgress, ws.BeginMessage(TypeText))
+	assert.Equal(ErrMessageInProgress, ws.Write([]byte("hello"), TypeText))
+	ws.AsyncWrite([]byte("hello"), TypeText, func(err error) {
+		assert.Equal(ErrMessageInProgress, err)
+	})
+
+	ws.state = StateClosedByUs
+	assert.Equal(ErrSendAfterClose, ws.WriteChunk([]byte("hello")))
+	assert.Empty(writtenFrames(t, ws))
+}
@@ -0,0 +1,216 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/proxy"
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+	sonictls "github.com/talostrading/sonic/tls"
+)
+
+// openHandshake is the state of an opening handshake run by AsyncHandshake.
+//
+// The handshake runs on the goroutine of the Stream's IO object: the TCP connection, the optional proxy and TLS
+// handshakes and the HTTP upgrade are all asynchronous. The dial timeout bounds the whole handshake: if it expires,
+// the connection is closed and the callback is invoked with sonicerrors.ErrTimeout. The completions of the operations
+// in flight at that time are then ignored.
+type openHandshake struct {
+	s        *Stream
+	timer    *sonic.Timer
+	callback func(error, sonic.Stream)
+
+	conn      sonic.Conn
+	tlsStream *sonictls.Stream
+	done      bool
+}
+
+var headersEnd = []byte("\r\n\r\n")
+
+// asyncHandshake is the asynchronous counterpart of handshake.
+func (s *Stream) asyncHandshake(addr string, headers []Header, callback func(error, sonic.Stream)) {
+	uri, err := s.resolve(addr)
+	if err != nil {
+		callback(err, nil)
+		return
+	}
+	peerAddr, err := s.dialAddr(uri)
+	if err != nil {
+		callback(err, nil)
+		return
+	}
+	var proxyURL *url.URL
+	if s.proxy != nil {
+		if proxyURL, err = s.proxy(uri); err != nil {
+			callback(err, nil)
+			return
+		}
+	}
+
+	h := &openHandshake{s: s, callback: callback}
+	h.timer, err = sonic.NewTimer(s.ioc)
+	if err == nil {
+		err = h.timer.ScheduleOnce(s.dialer.Timeout, h.onTimeout)
+	}
+	if err != nil {
+		h.finish(err, nil)
+		return
+	}
+
+	// The proxy, if any, is dialed here rather than through proxy.AsyncDial, such that the connection can be closed
+	// by finish while the proxy handshake is in flight.
+	dialAddr := peerAddr
+	if proxyURL != nil {
+		dialAddr = proxy.Addr(proxyURL)
+	}
+
+	// The connection itself is also bounded by the dial timeout, such that its file descriptor does not outlive the
+	// handshake if it times out.
+	sonic.AsyncDial(s.ioc, "tcp", dialAddr, s.dialer.Timeout, func(err error, conn sonic.Conn) {
+		if h.done {
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			h.finish(err, nil)
+			return
+		}
+		h.conn = conn
+		s.conn = conn
+
+		if proxyURL == nil {
+			h.secure(uri, headers)
+			return
+		}
+		proxy.AsyncHandshake(conn, proxyURL, peerAddr, func(err error) {
+			if h.done {
+				return
+			}
+			if err != nil {
+				h.finish(err, nil)
+			} else {
+				h.secure(uri, headers)
+			}
+		})
+	}, sonicopts.NoDelay(true))
+}
+
+// secure runs the TLS handshake over the connection, if the scheme requires it, and then the upgrade.
+func (h *openHandshake) secure(uri *url.URL, headers []Header) {
+	if uri.Scheme != "https" {
+		h.upgrade(uri, h.conn, headers)
+		return
+	}
+
+	h.tlsStream = sonictls.Client(h.s.ioc, h.conn, h.s.tlsConfig(uri))
+	h.tlsStream.AsyncHandshake(func(err error) {
+		if h.done {
+			return
+		}
+		if err != nil {
+			h.finish(err, nil)
+		} else {
+			h.upgrade(uri, h.tlsStream, headers)
+		}
+	})
+}
+
+// upgrade writes the upgrade request and reads the response asynchronously.
+func (h *openHandshake) upgrade(uri *url.URL, stream sonic.Stream, headers []Header) {
+	req, expectedKey, err := h.s.upgradeRequest(uri, headers)
+	if err != nil {
+		h.finish(err, nil)
+		return
+	}
+
+	var b bytes.Buffer
+	if err := req.Write(&b); err != nil {
+		h.finish(err, nil)
+		return
+	}
+
+	stream.AsyncWriteAll(b.Bytes(), func(err error, _ int) {
+		if h.done {
+			return
+		}
+		if err != nil {
+			h.finish(err, nil)
+			return
+		}
+		h.s.handshakeBuffer = h.s.handshakeBuffer[:0]
+		h.readResponse(req, expectedKey, stream)
+	})
+}
+
+// readResponse reads into the handshake buffer until it holds the response's headers.
+func (h *openHandshake) readResponse(req *http.Request, expectedKey string, stream sonic.Stream) {
+	b := h.s.handshakeBuffer
+	if len(b) == cap(b) {
+		// The response's headers do not fit in the handshake buffer.
+		h.finish(ErrCannotUpgrade, nil)
+		return
+	}
+
+	stream.AsyncRead(b[len(b):cap(b)], func(err error, n int) {
+		if h.done {
+			return
+		}
+		h.s.handshakeBuffer = b[:len(b)+n]
+		if err != nil {
+			h.finish(err, nil)
+		} else if bytes.Contains(h.s.handshakeBuffer, headersEnd) {
+			if err := h.s.upgradeResponse(req, expectedKey); err != nil {
+				h.finish(err, nil)
+			} else {
+				h.finish(nil, stream)
+			}
+		} else {
+			h.readResponse(req, expectedKey, stream)
+		}
+	})
+}
+
+func (h *openHandshake) onTimeout() {
+	if !h.done {
+		h.finish(sonicerrors.ErrTimeout, nil)
+	}
+}
+
+// finish closes the connection if the handshake failed and invokes the callback.
+func (h *openHandshake) finish(err error, stream sonic.Stream) {
+	h.done = true
+	if h.timer != nil {
+		_ = h.timer.Close()
+		h.timer = nil
+	}
+
+	if err != nil {
+		if h.tlsStream != nil {
+			// Fails the TLS handshake, if in flight, and closes the connection.
+			_ = h.tlsStream.Close()
+		} else if h.conn != nil {
+			_ = h.conn.Close()
+		}
+		if h.s.conn == h.conn {
+			h.s.conn = nil
+		}
+	}
+
+	h.callback(err, stream)
+}
+
+// tlsConfig returns the user provided TLS config, with the ServerName inferred from uri if not set.
+func (s *Stream) tlsConfig(uri *url.URL) *tls.Config {
+	config := s.tls
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = uri.Hostname()
+	}
+	return config
+}
@@ -30,11 +30,14 @@ import (
 	"net/url"
 	"sync"
 	"syscall"
+	"time"
 	"unicode/utf8"
 
 	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/proxy"
 	"github.com/talostrading/sonic/sonicerrors"
 	"github.com/talostrading/sonic/sonicopts"
+	sonictls "github.com/talostrading/sonic/tls"
 )
 
 type Stream struct {
@@ -61,10 +64,14 @@ type Stream struct {
 	hasher hash.Hash
 
 	// Buffer for stream reads.
-	src *sonic.ByteBuffer
+	src sonic.Buffer
 
 	// Buffer for stream writes.
-	dst *sonic.ByteBuffer
+	dst sonic.Buffer
+
+	// Optional pool from which src and dst are acquired. pooled is true while they are.
+	pool   *sonic.BufferPool
+	pooled bool
 
 	// Contains the handshake response. Is emptied after the handshake is over.
 	handshakeBuffer []byte
@@ -72,6 +79,15 @@ type Stream struct {
 	// Contains frames waiting to be sent to the peer. Is emptied by AsyncFlush or Flush.
 	pendingFrames []*Frame
 
+	// Contains the callbacks of the pending frames, invoked once they are sent. Nil for frames without a callback.
+	pendingCallbacks []func(error)
+
+	// State of the outgoing write queue made of the pending frames.
+	queue writeQueue
+
+	// State of the closing handshake.
+	closing closeHandshake
+
 	// Optional callback invoked when a control frame is received.
 	controlCallback ControlCallback
 
@@ -84,10 +100,22 @@ type Stream struct {
 	// Used to establish a TCP connection to the peer with a timeout.
 	dialer *net.Dialer
 
+	// Optional function returning the proxy through which the peer is reached.
+	proxy ProxyFunc
+
 	framePool sync.Pool
 
+	// Reader of the message currently read through NextMessageReader or AsyncNextMessageReader.
+	reader messageReader
+
+	// Writer of the message currently written through BeginMessage, WriteChunk and EndMessage.
+	writer messageWriter
+
 	maxMessageSize int
 
+	// Maximum payload size of written frames; 0 if unlimited.
+	maxFrameSize int
+
 	validateUTF8 bool
 }
 
@@ -115,6 +143,10 @@ func NewWebsocketStream(ioc *sonic.IO, tls *tls.Config, role Role) (s *Stream, e
 		validateUTF8:   false,
 	}
 
+	s.closing.timeout = CloseTimeout
+
+	s.reader.s = s
+
 	s.src.Reserve(4096)
 	s.dst.Reserve(4096)
 
@@ -158,12 +190,17 @@ func (s *Stream) init(stream sonic.Stream) (err error) {
 }
 
 func (s *Stream) reset() {
+	s.acquireBuffers()
 	s.handshakeBuffer = s.handshakeBuffer[:cap(s.handshakeBuffer)]
 	s.state = StateHandshake
 	s.stream = nil
 	s.conn = nil
 	s.src.Reset()
 	s.dst.Reset()
+	s.reader.reset()
+	s.writer.reset()
+	s.resetQueue()
+	s.resetClose()
 }
 
 // Returns the stream through which IO is done.
@@ -209,8 +246,14 @@ func (s *Stream) canRead() bool {
 //   - an error occurs while flushing the pending control frames
 //   - an error occurs when reading/decoding the message bytes from the underlying stream
 //   - a frame is successfully read from the underlying stream
+//
+// It fails with ErrPendingMessage if the message returned by NextMessageReader was not read fully.
 func (s *Stream) NextFrame() (f Frame, err error) {
-	err = s.Flush()
+	if s.reader.pending() {
+		return nil, ErrPendingMessage
+	}
+
+	err = s.flushBeforeRead()
 
 	if errors.Is(err, ErrMessageTooBig) {
 		_ = s.Close(CloseGoingAway, "payload too big")
@@ -239,6 +282,7 @@ func (s *Stream) nextFrame() (f Frame, err error) {
 	// This is an abnormal closure from the server
 	if s.state != StateTerminated && err == io.EOF {
 		s.state = StateTerminated
+		s.finishClose()
 
 		// Prepare and return the 1006 close frame directly to client
 		f = NewFrame()
@@ -259,8 +303,15 @@ func (s *Stream) nextFrame() (f Frame, err error) {
 //   - an error occurs while flushing the pending control frames
 //   - an error occurs when reading/decoding the message bytes from the underlying stream
 //   - a frame is successfully read from the underlying stream
+//
+// It fails with ErrPendingMessage if the message returned by NextMessageReader was not read fully.
 func (s *Stream) AsyncNextFrame(callback AsyncFrameCallback) {
-	s.AsyncFlush(func(err error) {
+	if s.reader.pending() {
+		callback(ErrPendingMessage, nil)
+		return
+	}
+
+	s.asyncFlushBeforeRead(func(err error) {
 		if errors.Is(err, ErrMessageTooBig) {
 			s.AsyncClose(CloseGoingAway, "payload too big", func(err error) {})
 			callback(ErrMessageTooBig, nil)
@@ -289,6 +340,7 @@ func (s *Stream) asyncNextFrame(callback AsyncFrameCallback) {
 		// This is an abnormal closure from the server
 		} else if s.state != StateTerminated && err == io.EOF {
 			s.state = StateTerminated
+			s.finishClose()
 
 			// Prepare and return the 1006 close frame directly
 			f = NewFrame()
@@ -308,6 +360,8 @@ func (s *Stream) asyncNextFrame(callback AsyncFrameCallback) {
 //   - an error occurs while flushing the pending control frames
 //   - an error occurs when reading/decoding the message from the underlying stream
 //   - the payload of the message is successfully read into the supplied buffer, after all message fragments are read
+//
+// Like NextFrame, it fails with ErrPendingMessage if the message returned by NextMessageReader was not read fully.
 func (s *Stream) NextMessage(b []byte) (messageType MessageType, readBytes int, err error) {
 	var (
 		f            Frame
@@ -374,6 +428,8 @@ func (s *Stream) NextMessage(b []byte) (messageType MessageType, readBytes int,
 //   - an error occurs while flushing the pending control frames
 //   - an error occurs when reading/decoding the message bytes from the underlying stream
 //   - the payload of the message is successfully read into the supplied buffer, after all message fragments are read
+//
+// Like AsyncNextFrame, it fails with ErrPendingMessage if the message returned by NextMessageReader was not read fully.
 func (s *Stream) AsyncNextMessage(b []byte, callback AsyncMessageCallback) {
 	s.asyncNextMessage(b, 0, false, TypeNone, callback)
 }
@@ -442,6 +498,11 @@ func (s *Stream) asyncNextMessage(
 func (s *Stream) handleFrame(f Frame) (err error) {
 	err = s.verifyFrame(f)
 
+	if err == nil && f.IsMasked() {
+		// Only servers accept masked frames. The mask bit is kept set, so the payload offset does not change.
+		f.UnmaskPayload()
+	}
+
 	if err == nil {
 		if f.Opcode().IsControl() {
 			err = s.handleControlFrame(f)
@@ -451,14 +512,21 @@ func (s *Stream) handleFrame(f Frame) (err error) {
 	}
 
 	if err != nil {
-		s.state = StateClosedByUs
-		// TODO consider flushing the close
-		s.prepareClose(EncodeCloseFramePayload(CloseProtocolError, ""))
+		_ = s.failFrame(err)
 	}
 
 	return err
 }
 
+// failFrame starts the closing handshake after receiving a frame which violates the protocol. It returns the passed
+// error.
+func (s *Stream) failFrame(err error) error {
+	s.state = StateClosedByUs
+	// TODO consider flushing the close
+	s.prepareClose(EncodeCloseFramePayload(CloseProtocolError, ""))
+	return err
+}
+
 func (s *Stream) verifyFrame(f Frame) error {
 	if f.IsRSV1() || f.IsRSV2() || f.IsRSV3() {
 		return ErrNonZeroReservedBits
@@ -527,6 +595,8 @@ func (s *Stream) handleControlFrame(f Frame) (err error) {
 		case StateTerminated:
 			panic("unreachable")
 		}
+
+		s.onCloseReceived(f.Payload())
 	default:
 		err = ErrInvalidControlFrame
 	}
@@ -548,7 +618,8 @@ func (s *Stream) handleDataFrame(f Frame) error {
 	return nil
 }
 
-// Write writes the supplied buffer as a single message with the given type to the underlying stream.
+// Write writes the supplied buffer as a single message with the given type to the underlying stream. The message is
+// split in multiple frames if it is larger than MaxFrameSize.
 //
 // This call first flushes any pending control frames to the underlying stream.
 //
@@ -561,17 +632,11 @@ func (s *Stream) Write(b []byte, messageType MessageType) error {
 		return ErrMessageTooBig
 	}
 
-	if s.state == StateActive {
-		// reserve space for mask if client
-		f := s.AcquireFrame().
-			SetFIN().
-			SetOpcode(Opcode(messageType)).
-			SetPayload(b)
-		s.prepareWrite(f)
-		return s.Flush()
+	if s.writer.started {
+		return ErrMessageInProgress
 	}
 
-	return sonicerrors.ErrCancelled
+	return s.writeFragments(Opcode(messageType), b, true)
 }
 
 // WriteFrame writes the supplied frame to the underlying stream.
@@ -583,19 +648,26 @@ func (s *Stream) Write(b []byte, messageType MessageType) error {
 //   - an error occurs during the write
 //   - the frame is successfully written to the underlying stream
 func (s *Stream) WriteFrame(f *Frame) error {
-	if s.state == StateActive {
-		s.prepareWrite(f)
-		return s.Flush()
-	} else {
+	if s.queue.busy() {
 		s.releaseFrame(f)
-		return sonicerrors.ErrCancelled
+		return ErrAsyncWriteInProgress
 	}
+
+	if err := s.writeErr(); err != nil {
+		s.releaseFrame(f)
+		return err
+	}
+
+	s.prepareWrite(f)
+	return s.Flush()
 }
 
 // AsyncWrite writes the supplied buffer as a single message with the given type to the underlying stream
-// asynchronously.
+// asynchronously. The message is split in multiple frames if it is larger than MaxFrameSize.
 //
-// This call first flushes any pending control frames to the underlying stream asynchronously.
+// The message is queued behind any pending frames. AsyncWrite can be called again before the callback of a previous
+// call is invoked: messages are written in the order in which they are queued, possibly in a single write to the
+// underlying stream. See SetWriteWatermarks and SetMaxQueuedBytes to bound the queue.
 //
 // This call does not block. The provided callback is invoked when one of the following happens:
 //   - an error occurs while flushing the pending control frames
@@ -607,16 +679,16 @@ func (s *Stream) AsyncWrite(b []byte, messageType MessageType, callback func(err
 		return
 	}
 
-	if s.state == StateActive {
-		f := s.AcquireFrame().
-			SetFIN().
-			SetOpcode(Opcode(messageType)).
-			SetPayload(b)
-		s.prepareWrite(f)
-		s.AsyncFlush(callback)
-	} else {
-		callback(sonicerrors.ErrCancelled)
+	if s.writer.started {
+		callback(ErrMessageInProgress)
+		return
+	}
+
+	if !s.admit(b, callback) {
+		return
 	}
+
+	s.asyncWriteFragments(Opcode(messageType), b, true, callback)
 }
 
 // AsyncWriteFrame writes the supplied frame to the underlying stream asynchronously.
@@ -628,20 +700,18 @@ func (s *Stream) AsyncWrite(b []byte, messageType MessageType, callback func(err
 //   - an error occurs during the write
 //   - the frame is successfully written to the underlying stream
 func (s *Stream) AsyncWriteFrame(f *Frame, callback func(err error)) {
-	if s.state == StateActive {
-		s.prepareWrite(f)
-		s.AsyncFlush(callback)
-	} else {
+	if err := s.writeErr(); err != nil {
 		s.releaseFrame(f)
-		callback(sonicerrors.ErrCancelled)
+		callback(err)
+		return
 	}
+
+	s.queueFrame(f, callback)
+	s.flush()
 }
 
 func (s *Stream) prepareWrite(f *Frame) {
-	if s.role == RoleClient {
-		f.MaskPayload()
-	}
-	s.pendingFrames = append(s.pendingFrames, f)
+	s.queueFrame(f, nil)
 }
 
 // AsyncClose sends a websocket close control frame asynchronously.
@@ -655,7 +725,8 @@ func (s *Stream) prepareWrite(f *Frame) {
 //
 // After beginning the closing handshake, the program should not write further messages, pings, pongs or close
 // frames. Instead, the program should continue reading messages until the closing handshake is complete or an error
-// occurs.
+// occurs. Writes fail with ErrSendAfterClose. The TCP connection is closed once the closing handshake completes or the
+// close timeout expires, see SetCloseTimeout and SetCloseCallback.
 func (s *Stream) AsyncClose(closeCode CloseCode, reason string, callback func(err error)) {
 	switch s.state {
 	case StateActive:
@@ -700,49 +771,43 @@ func (s *Stream) prepareClose(payload []byte) {
 		SetClose().
 		SetPayload(payload)
 	s.prepareWrite(closeFrame)
+	s.onCloseQueued()
 }
 
-// Flush writes any pending control frames to the underlying stream.
+// Flush writes all pending frames to the underlying stream at once.
 //
-// This call blocks.
+// This call blocks. It fails with ErrAsyncWriteInProgress if the pending frames are flushed asynchronously.
 func (s *Stream) Flush() (err error) {
-	flushed := 0
-	for i := 0; i < len(s.pendingFrames); i++ {
-		_, err = s.codecConn.WriteNext(*s.pendingFrames[i])
-		if err != nil {
-			break
-		}
-		s.releaseFrame(s.pendingFrames[i])
-		flushed++
+	if s.queue.busy() {
+		return ErrAsyncWriteInProgress
 	}
-	s.pendingFrames = s.pendingFrames[flushed:]
 
-	return
+	if len(s.pendingFrames) == 0 {
+		return nil
+	}
+
+	if err = s.startBatch(); err == nil {
+		_, err = s.dst.WriteTo(s.stream)
+	}
+	s.onBatchWritten(err)
+
+	return err
 }
 
-// Flush writes any pending control frames to the underlying stream asynchronously.
+// AsyncFlush writes all pending frames to the underlying stream asynchronously. Frames are written in batches: all
+// frames queued while a batch is written are written at once in the next batch.
 //
-// This call does not block.
+// This call does not block. The provided callback is invoked once no frames are pending or an error occurs.
 func (s *Stream) AsyncFlush(callback func(err error)) {
-	if len(s.pendingFrames) == 0 {
+	if !s.queue.flushing && len(s.pendingFrames) == 0 {
 		callback(nil)
 	} else {
-		sent := s.pendingFrames[0]
-		s.pendingFrames = s.pendingFrames[1:]
-
-		s.codecConn.AsyncWriteNext(*sent, func(err error, _ int) {
-			s.releaseFrame(sent)
-
-			if err != nil {
-				callback(err)
-			} else {
-				s.AsyncFlush(callback)
-			}
-		})
+		s.queue.flushCallbacks = append(s.queue.flushCallbacks, callback)
+		s.flush()
 	}
 }
 
-// Pending returns the number of currently pending control frames waiting to be flushed.
+// Pending returns the number of currently pending frames waiting to be flushed.
 func (s *Stream) Pending() int {
 	return len(s.pendingFrames)
 }
@@ -799,22 +864,30 @@ func (s *Stream) AsyncHandshake(addr string, callback func(error), extraHeaders
 
 	s.reset()
 
-	// I know, this is horrible, but if you help me write a TLS client for sonic
-	// we can asynchronously dial endpoints and remove the need for a goroutine
-	go func() {
-		s.handshake(addr, extraHeaders, func(err error, stream sonic.Stream) {
-			// TODO maybe report this error somehow although this is very fatal
-			_ = s.ioc.Post(func() {
-				if err != nil {
-					s.state = StateTerminated
-				} else {
-					s.state = StateActive
-					err = s.init(stream)
-				}
-				callback(err)
-			})
-		})
-	}()
+	s.asyncHandshake(addr, extraHeaders, func(err error, stream sonic.Stream) {
+		if err != nil {
+			s.state = StateTerminated
+		} else {
+			s.state = StateActive
+			err = s.init(stream)
+		}
+		callback(err)
+	})
+}
+
+// Accept makes the stream active in the server role over a connection on which the opening handshake has completed,
+// that is, once the server sent its 101 Switching Protocols response. Bytes received after the upgrade request, if any,
+// are passed in buffered and are decoded before anything else read from the connection.
+func (s *Stream) Accept(stream sonic.Stream, buffered []byte) error {
+	if s.role != RoleServer {
+		return ErrWrongHandshakeRole
+	}
+
+	s.reset()
+	_, _ = s.src.Write(buffered)
+
+	s.state = StateActive
+	return s.init(stream)
 }
 
 func (s *Stream) handshake(addr string, headers []Header, callback func(err error, stream sonic.Stream)) {
@@ -847,72 +920,148 @@ func (s *Stream) resolve(addr string) (url *url.URL, err error) {
 	return
 }
 
-func (s *Stream) dial(url *url.URL, callback func(err error, stream sonic.Stream)) {
-	var (
-		err  error
-		sc   syscall.Conn
-		port = url.Port()
-	)
-
+// dialAddr returns the address of the peer reached through the given url.
+func (s *Stream) dialAddr(url *url.URL) (string, error) {
+	port := url.Port()
 	switch url.Scheme {
 	case "http":
 		if port == "" {
 			port = "80"
 		}
-		addr := url.Hostname() + ":" + port
-		s.conn, err = net.DialTimeout("tcp", addr, DialTimeout)
-		if err == nil {
-			sc = s.conn.(syscall.Conn)
-		} else {
-			// This is needed otherwise the net.Conn interface will be pointing
-			// to a nil pointer. Calling something like CloseNextLayer will
-			// produce a panic then.
-			s.conn = nil
-		}
 	case "https":
 		if s.tls == nil {
-			err = fmt.Errorf(
+			return "", fmt.Errorf(
 				"wss:// scheme endpoints require a TLS configuration",
 			)
 		}
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return "", fmt.Errorf("invalid url scheme=%s", url.Scheme)
+	}
+	return url.Hostname() + ":" + port, nil
+}
 
-		if err == nil {
-			if port == "" {
-				port = "443"
+func (s *Stream) dial(url *url.URL, callback func(err error, stream sonic.Stream)) {
+	addr, err := s.dialAddr(url)
+	if err != nil {
+		callback(err, nil)
+		return
+	}
+
+	s.conn, err = s.dialTCP(url, addr)
+	if err != nil {
+		// This is needed otherwise the net.Conn interface will be pointing
+		// to a nil pointer. Calling something like CloseNextLayer will
+		// produce a panic then.
+		s.conn = nil
+		callback(err, nil)
+		return
+	}
+
+	// s.ioc is not used by this constructor, so there is NO a race
+	// condition on the io context.
+	sonic.NewAsyncAdapter(
+		s.ioc, s.conn.(syscall.Conn), s.conn, func(err error, stream *sonic.AsyncAdapter) {
+			if err != nil || url.Scheme != "https" {
+				callback(err, stream)
+				return
 			}
-			addr := url.Hostname() + ":" + port
-			s.conn, err = tls.DialWithDialer(s.dialer, "tcp", addr, s.tls)
-			if err == nil {
-				sc = s.conn.(*tls.Conn).NetConn().(syscall.Conn)
+
+			tlsStream, err := s.handshakeTLS(url, stream)
+			if err != nil {
+				callback(err, nil)
 			} else {
-				// This is needed otherwise the net.Conn interface will be
-				// pointing to a nil pointer. Calling something like
-				// CloseNextLayer will produce a panic then.
-				s.conn = nil
+				callback(nil, tlsStream)
 			}
+		}, sonicopts.NoDelay(true))
+}
+
+// dialTCP establishes a TCP connection to addr, either directly or through the proxy returned by the Stream's
+// ProxyFunc for the given uri.
+func (s *Stream) dialTCP(uri *url.URL, addr string) (net.Conn, error) {
+	var proxyURL *url.URL
+	if s.proxy != nil {
+		var err error
+		if proxyURL, err = s.proxy(uri); err != nil {
+			return nil, err
 		}
-	default:
-		err = fmt.Errorf("invalid url scheme=%s", url.Scheme)
 	}
 
+	if proxyURL == nil {
+		return s.dialer.Dial("tcp", addr)
+	}
+
+	conn, err := s.dialer.Dial("tcp", proxy.Addr(proxyURL))
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake must not outlive the dial timeout.
+	_ = conn.SetDeadline(time.Now().Add(s.dialer.Timeout))
+	err = proxy.Handshake(conn, proxyURL, addr)
 	if err == nil {
-		// s.ioc is not used by this constructor, so there is NO a race
-		// condition on the io context.
-		sonic.NewAsyncAdapter(
-			s.ioc, sc, s.conn, func(err error, stream *sonic.AsyncAdapter) {
-				callback(err, stream)
-			}, sonicopts.NoDelay(true))
-	} else {
-		callback(err, nil)
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// handshakeTLS runs the TLS handshake synchronously over the established blocking connection. The handshake is bounded
+// by the dial timeout.
+//
+// The returned stream is driven by the Stream's IO object once the handshake completes, so reads and writes of the
+// upgraded connection do not block.
+func (s *Stream) handshakeTLS(uri *url.URL, stream sonic.Stream) (*sonictls.Stream, error) {
+	config := s.tlsConfig(uri)
+
+	// The adapter's synchronous reads and writes block on the underlying net.Conn, so the deadline applies to them.
+	_ = s.conn.SetDeadline(time.Now().Add(s.dialer.Timeout))
+
+	tlsStream := sonictls.Client(s.ioc, stream, config)
+	err := tlsStream.Handshake()
+	if err == nil {
+		err = s.conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		_ = s.conn.Close()
+		return nil, err
 	}
+	return tlsStream, nil
 }
 
 func (s *Stream) upgrade(uri *url.URL, stream sonic.Stream, headers []Header) error {
-	req, err := http.NewRequest("GET", uri.String(), nil)
+	req, expectedKey, err := s.upgradeRequest(uri, headers)
 	if err != nil {
 		return err
 	}
 
+	err = req.Write(stream)
+	if err != nil {
+		return err
+	}
+
+	s.handshakeBuffer = s.handshakeBuffer[:cap(s.handshakeBuffer)]
+	n, err := stream.Read(s.handshakeBuffer)
+	if err != nil {
+		return err
+	}
+	s.handshakeBuffer = s.handshakeBuffer[:n]
+	return s.upgradeResponse(req, expectedKey)
+}
+
+// upgradeRequest returns the upgrade request sent to uri, along with the Sec-WebSocket-Accept key expected in the
+// response.
+func (s *Stream) upgradeRequest(uri *url.URL, headers []Header) (*http.Request, string, error) {
+	req, err := http.NewRequest("GET", uri.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
 	sentKey, expectedKey := s.makeHandshakeKey()
 	req.Header.Set("Upgrade", "websocket")
 	req.Header.Set("Connection", "upgrade")
@@ -938,17 +1087,11 @@ func (s *Stream) upgrade(uri *url.URL, stream sonic.Stream, headers []Header) er
 		s.upgradeRequestCallback(req)
 	}
 
-	err = req.Write(stream)
-	if err != nil {
-		return err
-	}
+	return req, expectedKey, nil
+}
 
-	s.handshakeBuffer = s.handshakeBuffer[:cap(s.handshakeBuffer)]
-	n, err := stream.Read(s.handshakeBuffer)
-	if err != nil {
-		return err
-	}
-	s.handshakeBuffer = s.handshakeBuffer[:n]
+// upgradeResponse parses the upgrade response held in the handshake buffer.
+func (s *Stream) upgradeResponse(req *http.Request, expectedKey string) error {
 	rd := bytes.NewReader(s.handshakeBuffer)
 	res, err := http.ReadResponse(bufio.NewReader(rd), req)
 	if err != nil {
@@ -1041,6 +1184,62 @@ func (s *Stream) UpgradeResponseCallback() UpgradeResponseCallback {
 	return s.upgradeResponseCallback
 }
 
+// SetProxy sets a function that is invoked during the handshake to choose the proxy through which the peer is reached.
+// Both HTTP CONNECT and SOCKS5 proxies are supported, see the `proxy` package. Pass `proxy.FromEnvironment` to honor the
+// HTTPS_PROXY, HTTP_PROXY, ALL_PROXY and NO_PROXY environment variables.
+//
+// By default, no proxy is used.
+func (s *Stream) SetProxy(proxy ProxyFunc) {
+	s.proxy = proxy
+}
+
+func (s *Stream) Proxy() ProxyFunc {
+	return s.proxy
+}
+
+// SetBuffers sets the buffers from which frames are decoded and into which they are encoded. By default, both are
+// sonic.ByteBuffers. A bytes.MirroredByteBuffer avoids copying the bytes which follow each decoded frame, provided it is
+// large enough to hold the largest frame.
+//
+// SetBuffers must be called before the handshake.
+func (s *Stream) SetBuffers(src, dst sonic.Buffer) {
+	s.releaseBuffers()
+	s.pool = nil
+	s.src = src
+	s.dst = dst
+}
+
+// SetBufferPool makes the stream acquire its buffers from the passed pool. They are given back to the pool when the
+// next layer is closed, and acquired again when the stream is reused for another handshake. The stream must not be read
+// from or written to in between.
+//
+// SetBufferPool must be called before the handshake.
+func (s *Stream) SetBufferPool(pool *sonic.BufferPool) {
+	s.releaseBuffers()
+	s.pool = pool
+	s.acquireBuffers()
+}
+
+func (s *Stream) acquireBuffers() {
+	if s.pool != nil && !s.pooled {
+		s.src = s.pool.Get(4096)
+		s.dst = s.pool.Get(4096)
+		s.pooled = true
+	}
+}
+
+// releaseBuffers gives back the pooled buffers. They are no longer referenced, such that a late use fails loudly
+// instead of touching a buffer which may have been acquired by another stream.
+func (s *Stream) releaseBuffers() {
+	if s.pooled {
+		s.pool.Release(s.src)
+		s.pool.Release(s.dst)
+		s.src = nil
+		s.dst = nil
+		s.pooled = false
+	}
+}
+
 // SetMaxMessageSize sets the maximum size of a message that can be read from or written to a peer.
 //
 // - If a message exceeds the limit while reading, the connection is closed abnormally.
@@ -1058,16 +1257,28 @@ func (s *Stream) MaxMessageSize() int {
 }
 
 func (s *Stream) RemoteAddr() net.Addr {
-	return s.conn.RemoteAddr()
+	if s.conn != nil {
+		return s.conn.RemoteAddr()
+	}
+	if conn, ok := s.NextLayer().(interface{ RemoteAddr() net.Addr }); ok {
+		return conn.RemoteAddr()
+	}
+	return nil
 }
 
 func (s *Stream) LocalAddr() net.Addr {
-	return s.conn.LocalAddr()
+	if s.conn != nil {
+		return s.conn.LocalAddr()
+	}
+	if conn, ok := s.NextLayer().(interface{ LocalAddr() net.Addr }); ok {
+		return conn.LocalAddr()
+	}
+	return nil
 }
 
 func (s *Stream) RawFd() int {
 	if s.NextLayer() != nil {
-		return s.NextLayer().(*sonic.AsyncAdapter).RawFd()
+		return s.NextLayer().RawFd()
 	}
 	return -1
 }
@@ -1076,6 +1287,9 @@ func (s *Stream) CloseNextLayer() (err error) {
 	if s.conn != nil {
 		err = s.conn.Close()
 		s.conn = nil
+	} else if s.stream != nil {
+		err = s.stream.Close()
 	}
+	s.releaseBuffers()
 	return
 }
@@ -1,16 +1,28 @@
 package websocket
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+	sonictls "github.com/talostrading/sonic/tls"
 )
 
 func assertState(t *testing.T, ws *Stream, expected StreamState) {
@@ -328,7 +340,12 @@ func TestClientSendMessageWithPayload127(t *testing.T) {
 }
 
 func TestClientReconnectOnFailedRead(t *testing.T) {
+	// Each server publishes its port once it listens, such that the client
+	// reconnects only after the previous server is closed and the next one
+	// is listening.
+	ports := make(chan int, 10)
 	srv := NewMockServer()
+	srv.portChan = ports
 	port := 0
 
 	go func() {
@@ -347,10 +364,13 @@ func TestClientReconnectOnFailedRead(t *testing.T) {
 			srv.Close()
 
 			srv = NewMockServer()
+			srv.portChan = ports
 		}
+		// Reconnecting now fails, which ends the test.
+		close(ports)
 	}()
 
-	port = <- srv.portChan
+	port = <-ports
 
 	ioc := sonic.MustIO()
 	defer ioc.Close()
@@ -372,6 +392,7 @@ func TestClientReconnectOnFailedRead(t *testing.T) {
 	onNextMessage = func(err error, n int, _ MessageType) {
 		if err != nil {
 			assertState(t, ws, StateTerminated)
+			<-ports
 			connect() // reconnect again
 		} else {
 			b = b[:n]
@@ -472,6 +493,92 @@ func TestClientFailedHandshakeNoServer(t *testing.T) {
 	assertState(t, ws, StateTerminated)
 }
 
+func TestClientHandshakeTimeout(t *testing.T) {
+	// The connection is established by the kernel, but the server never
+	// answers the upgrade request.
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.dialer.Timeout = 50 * time.Millisecond
+
+	done := false
+	start := time.Now()
+	ws.AsyncHandshake("ws://"+ln.Addr().String(), func(err error) {
+		done = true
+		if err != sonicerrors.ErrTimeout {
+			t.Fatalf("expected ErrTimeout, got %v", err)
+		}
+		assertState(t, ws, StateTerminated)
+	})
+
+	for !done {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("the handshake should time out after the dial timeout")
+	}
+}
+
+func TestClientHandshakeTimeoutThroughProxy(t *testing.T) {
+	// The proxy accepts the connection but never answers the CONNECT request.
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.Copy(io.Discard, conn)
+		closed <- err
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.dialer.Timeout = 50 * time.Millisecond
+	ws.SetProxy(func(target *url.URL) (*url.URL, error) {
+		return &url.URL{Scheme: "http", Host: ln.Addr().String()}, nil
+	})
+
+	done := false
+	ws.AsyncHandshake("ws://localhost:1", func(err error) {
+		done = true
+		if err != sonicerrors.ErrTimeout {
+			t.Fatalf("expected ErrTimeout, got %v", err)
+		}
+	})
+	for !done {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	// The connection to the proxy is closed with the handshake.
+	if err := <-closed; err != nil {
+		t.Fatalf("expected the connection to the proxy to be closed, got %v", err)
+	}
+}
+
 func TestClientSuccessfulHandshake(t *testing.T) {
 	srv := NewMockServer()
 
@@ -526,7 +633,7 @@ func TestClientSuccessfulHandshake(t *testing.T) {
 		if srv.IsClosed() {
 			break
 		}
-		ioc.RunOne()
+		_ = ioc.RunOneFor(time.Millisecond)
 	}
 }
 
@@ -581,7 +688,7 @@ func TestClientSuccessfulHandshakeWithExtraHeaders(t *testing.T) {
 	)
 
 	for !srv.IsClosed() {
-		ioc.RunOne()
+		_ = ioc.RunOneFor(time.Millisecond)
 	}
 
 	for key := range expected {
@@ -1735,3 +1842,231 @@ func TestClientAsyncAbnormalClose(t *testing.T) {
 		ioc.PollOne()
 	}
 }
+
+func TestClientHandshakeThroughProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewMockServer()
+
+	go func() {
+		defer srv.Close()
+
+		err := srv.Accept(MockServerDynamicAddr)
+		if err != nil {
+			panic(err)
+		}
+
+		assert.Nil(srv.Write([]byte("hello")))
+	}()
+
+	// A stand-in HTTP CONNECT proxy serving a single tunnel.
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectTo := make(chan string, 1)
+	go func() {
+		defer ln.Close()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		connectTo <- req.Host
+
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			return
+		}
+		defer target.Close()
+
+		_, _ = fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			_, _ = io.Copy(target, conn)
+		}()
+		_, _ = io.Copy(conn, target)
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	assert.Nil(err)
+	ws.SetProxy(func(target *url.URL) (*url.URL, error) {
+		return &url.URL{Scheme: "http", Host: ln.Addr().String()}, nil
+	})
+
+	addr := fmt.Sprintf("localhost:%d", <-srv.portChan)
+
+	done := false
+	ws.AsyncHandshake("ws://"+addr, func(err error) {
+		assert.Nil(err)
+		assert.Equal(addr, <-connectTo)
+
+		b := make([]byte, 128)
+		ws.AsyncNextMessage(b, func(err error, n int, mt MessageType) {
+			assert.Nil(err)
+			assert.Equal(TypeText, mt)
+			assert.Equal("hello", string(b[:n]))
+			done = true
+		})
+	})
+
+	for !done {
+		ioc.PollOne()
+	}
+}
+
+// testTLSConfigs returns a server config with a self-signed certificate for localhost and a client config trusting it.
+func testTLSConfigs(t *testing.T) (server *tls.Config, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:              []string{"localhost"},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: pool}
+	return server, client
+}
+
+func TestClientHandshakeTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	serverConfig, clientConfig := testTLSConfigs(t)
+
+	srv := NewMockServer()
+	srv.TLS = serverConfig
+
+	go func() {
+		defer srv.Close()
+
+		err := srv.Accept(MockServerDynamicAddr)
+		if err != nil {
+			panic(err)
+		}
+
+		assert.Nil(srv.Write([]byte("hello")))
+
+		f := NewFrame()
+		_, err = f.ReadFrom(srv.conn)
+		assert.Nil(err)
+		f.UnmaskPayload()
+		assert.Equal("world", string(f.Payload()))
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, clientConfig, RoleClient)
+	assert.Nil(err)
+
+	addr := fmt.Sprintf("wss://localhost:%d", <-srv.portChan)
+
+	done := false
+	ws.AsyncHandshake(addr, func(err error) {
+		if !assert.Nil(err) {
+			done = true
+			return
+		}
+
+		// The connection is driven by the TLS stream.
+		stream, ok := ws.NextLayer().(*sonictls.Stream)
+		assert.True(ok)
+		assert.True(stream.HandshakeComplete())
+		assert.True(ws.RawFd() > 0)
+
+		b := make([]byte, 128)
+		ws.AsyncNextMessage(b, func(err error, n int, mt MessageType) {
+			assert.Nil(err)
+			assert.Equal(TypeText, mt)
+			assert.Equal("hello", string(b[:n]))
+
+			ws.AsyncWrite([]byte("world"), TypeText, func(err error) {
+				assert.Nil(err)
+				done = true
+			})
+		})
+	})
+
+	for !done {
+		ioc.PollOne()
+	}
+}
+
+func TestClientBufferPool(t *testing.T) {
+	srv := NewMockServer()
+	go func() {
+		if err := srv.Accept(MockServerDynamicAddr); err != nil {
+			panic(err)
+		}
+		srv.Write([]byte("hello"))
+		srv.Close()
+	}()
+	port := <-srv.portChan
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	pool := sonic.NewBufferPool()
+	pool.SetDebug(true)
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetBufferPool(pool)
+	if stacks := pool.Outstanding(); len(stacks) != 2 {
+		t.Fatalf("expected 2 outstanding buffers, got %d", len(stacks))
+	}
+
+	if err := ws.Handshake(fmt.Sprintf("ws://localhost:%d", port)); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 128)
+	mt, n, err := ws.NextMessage(b)
+	if err != nil || mt != TypeText || string(b[:n]) != "hello" {
+		t.Fatalf("invalid message %v %q %v", mt, b[:n], err)
+	}
+
+	// The buffers are given back once the server closes the connection.
+	if _, _, err := ws.NextMessage(b); err == nil {
+		t.Fatal("expected an error")
+	}
+	assertState(t, ws, StateTerminated)
+	if stacks := pool.Outstanding(); len(stacks) != 0 {
+		t.Fatalf("expected no outstanding buffers, got %v", stacks)
+	}
+	if ws.src != nil || ws.dst != nil {
+		t.Fatal("the stream should not reference the released buffers")
+	}
+}
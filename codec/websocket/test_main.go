@@ -3,6 +3,7 @@ package websocket
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -21,6 +22,9 @@ type MockServer struct {
 	portChan chan int
 
 	Upgrade *http.Request
+
+	// If set, connections are accepted over TLS.
+	TLS *tls.Config
 }
 
 func NewMockServer() *MockServer {
@@ -41,6 +45,9 @@ func (s *MockServer) Accept(addr string) (err error) {
 	if err != nil {
 		return err
 	}
+	if s.TLS != nil {
+		s.ln = tls.NewListener(s.ln, s.TLS)
+	}
 
 	port := int(s.ln.Addr().(*net.TCPAddr).Port)
 	atomic.StoreInt32(&s.port, int32(port))
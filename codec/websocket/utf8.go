@@ -0,0 +1,53 @@
+package websocket
+
+import "unicode/utf8"
+
+// utf8Validator validates UTF-8 text which is received in chunks, where a code point might be split between two
+// consecutive chunks.
+type utf8Validator struct {
+	pending  [utf8.UTFMax]byte // the incomplete code point at the end of the previous chunk, if any
+	npending int
+}
+
+func (v *utf8Validator) Reset() {
+	v.npending = 0
+}
+
+// Valid reports whether b, appended to all previously validated chunks, is valid UTF-8 up to a possibly incomplete
+// code point at its end.
+func (v *utf8Validator) Valid(b []byte) bool {
+	// Complete the code point left over from the previous chunk, if any.
+	for v.npending > 0 && len(b) > 0 {
+		v.pending[v.npending] = b[0]
+		v.npending++
+		b = b[1:]
+
+		if utf8.FullRune(v.pending[:v.npending]) {
+			if !utf8.Valid(v.pending[:v.npending]) {
+				return false
+			}
+			v.npending = 0
+		} else if v.npending == utf8.UTFMax {
+			return false
+		}
+	}
+
+	// Set aside the incomplete code point at the end of b, if any.
+	end := len(b)
+	for i := len(b) - 1; i >= 0 && i >= len(b)-(utf8.UTFMax-1); i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	v.npending += copy(v.pending[v.npending:], b[end:])
+
+	return utf8.Valid(b[:end])
+}
+
+// Done reports whether all validated chunks form valid UTF-8 text, meaning no code point is left incomplete.
+func (v *utf8Validator) Done() bool {
+	return v.npending == 0
+}
@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"testing"
+)
+
+func TestUTF8ValidatorChunks(t *testing.T) {
+	text := []byte("hello, κόσμε! こんにちは 🌍")
+
+	// Every split of the text in two chunks must be valid.
+	for i := 0; i <= len(text); i++ {
+		var v utf8Validator
+		if !v.Valid(text[:i]) || !v.Valid(text[i:]) || !v.Done() {
+			t.Fatalf("split at %d should be valid", i)
+		}
+	}
+
+	// Byte by byte.
+	var v utf8Validator
+	for i := range text {
+		if !v.Valid(text[i : i+1]) {
+			t.Fatalf("byte %d should be valid", i)
+		}
+	}
+	if !v.Done() {
+		t.Fatal("text should be complete")
+	}
+}
+
+func TestUTF8ValidatorIncomplete(t *testing.T) {
+	var v utf8Validator
+
+	// The first two bytes of a three byte code point.
+	if !v.Valid([]byte{'a', 0xE3, 0x81}) {
+		t.Fatal("prefix should be valid")
+	}
+	if v.Done() {
+		t.Fatal("text should not be complete")
+	}
+
+	v.Reset()
+	if !v.Done() {
+		t.Fatal("reset validator should be complete")
+	}
+}
+
+func TestUTF8ValidatorInvalid(t *testing.T) {
+	cases := [][][]byte{
+		{{0xFF}},
+		{{'a', 0xC0}, {0x80}},           // overlong encoding
+		{{0xE3}, {0x81, 'a'}},           // truncated code point
+		{{0xED, 0xA0}, {0x80}},          // surrogate half
+		{{0xF4, 0x90, 0x80}, {0x80}},    // above the maximum code point
+		{{'a', 'b', 0xF0, 0x9F}, {'c'}}, // truncated code point
+	}
+	for i, chunks := range cases {
+		var v utf8Validator
+		valid := true
+		for _, chunk := range chunks {
+			valid = valid && v.Valid(chunk)
+		}
+		if valid && v.Done() {
+			t.Fatalf("case %d should be invalid", i)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package websocket
+
+// writeQueue is the state of a Stream's outgoing write queue.
+//
+// Frames are queued in Stream.pendingFrames, along with the callbacks invoked once they are written in
+// Stream.pendingCallbacks. Flushing moves all pending frames in a batch which is encoded in the Stream's write buffer and
+// written to the underlying stream at once. Frames queued while a batch is written are written in the next batch.
+type writeQueue struct {
+	// Frames, and their callbacks, which are currently written. Swapped with the pending frames when a flush starts.
+	batch          []*Frame
+	batchCallbacks []func(error)
+
+	// Callbacks of AsyncFlush, invoked once the queue is empty.
+	flushCallbacks []func(error)
+	spareCallbacks []func(error)
+
+	// True while a batch is written.
+	flushing bool
+
+	// True while the callbacks of a written batch are invoked. No batch is written meanwhile.
+	completing bool
+
+	// Bytes of all queued frames, including the ones which are currently written.
+	queuedBytes int
+
+	lowWatermark     int
+	highWatermark    int
+	backpressured    bool
+	writableCallback WritableCallback
+
+	maxQueuedBytes int
+	policy         WriteQueuePolicy
+	dropped        int
+}
+
+// busy returns true if the queue is flushed asynchronously, in which case the queue cannot be flushed synchronously.
+func (q *writeQueue) busy() bool {
+	return q.flushing || q.completing
+}
+
+// SetWriteWatermarks sets the queued bytes at which the Stream stops, and then again becomes, writable. See
+// SetWritableCallback.
+//
+// The stream stops being writable when the queued bytes reach the high watermark. It becomes writable again once the
+// queued bytes drop to the low watermark. A high watermark of 0, which is the default, disables the watermarks.
+func (s *Stream) SetWriteWatermarks(low, high int) {
+	if low > high {
+		low = high
+	}
+	s.queue.lowWatermark = low
+	s.queue.highWatermark = high
+}
+
+func (s *Stream) WriteWatermarks() (low, high int) {
+	return s.queue.lowWatermark, s.queue.highWatermark
+}
+
+// SetWritableCallback sets the callback invoked when the Stream stops being writable, with writable set to false, and
+// when it becomes writable again, with writable set to true. See SetWriteWatermarks.
+//
+// Writes are still queued when the Stream is not writable. It is up to the caller to stop writing until the callback
+// is invoked with writable set to true.
+func (s *Stream) SetWritableCallback(callback WritableCallback) {
+	s.queue.writableCallback = callback
+}
+
+func (s *Stream) WritableCallback() WritableCallback {
+	return s.queue.writableCallback
+}
+
+// Writable returns false if the queued bytes reached the high watermark and did not yet drop to the low watermark.
+func (s *Stream) Writable() bool {
+	return !s.queue.backpressured
+}
+
+// SetMaxQueuedBytes limits the bytes queued by AsyncWrite. Messages which would exceed the limit are handled according
+// to the given policy. A limit of 0, which is the default, means the queue is not limited.
+//
+// Control frames and the chunks of messages written with AsyncWriteChunk are not subject to the limit.
+func (s *Stream) SetMaxQueuedBytes(bytes int, policy WriteQueuePolicy) {
+	if bytes < 0 {
+		bytes = 0
+	}
+	s.queue.maxQueuedBytes = bytes
+	s.queue.policy = policy
+}
+
+func (s *Stream) MaxQueuedBytes() (int, WriteQueuePolicy) {
+	return s.queue.maxQueuedBytes, s.queue.policy
+}
+
+// QueuedBytes returns the bytes of all frames which are queued, including the ones which are currently written.
+func (s *Stream) QueuedBytes() int {
+	return s.queue.queuedBytes
+}
+
+// Dropped returns the number of messages dropped by AsyncWrite under the WriteQueueDrop policy.
+func (s *Stream) Dropped() int {
+	return s.queue.dropped
+}
+
+// admit returns true if a message with the given payload can be queued by AsyncWrite. Otherwise, the write is completed
+// according to the queue's policy.
+func (s *Stream) admit(payload []byte, callback func(err error)) bool {
+	q := &s.queue
+	if q.maxQueuedBytes == 0 || q.queuedBytes+len(payload) <= q.maxQueuedBytes {
+		return true
+	}
+
+	if q.policy == WriteQueueDrop {
+		q.dropped++
+		callback(nil)
+	} else {
+		callback(ErrWriteQueueFull)
+	}
+	return false
+}
+
+// queueFrame queues the frame for writing. The optional callback is invoked once the frame is written.
+//
+// Pings and pongs are queued ahead of all other frames such that they are not delayed by large messages. This means
+// they can be written in between the fragments of a message, which is allowed by RFC6455 5.4.
+func (s *Stream) queueFrame(f *Frame, callback func(err error)) {
+	if s.role == RoleClient {
+		f.MaskPayload()
+	}
+
+	i := len(s.pendingFrames)
+	if opcode := f.Opcode(); opcode.IsPing() || opcode.IsPong() {
+		i = 0
+		for i < len(s.pendingFrames) && (s.pendingFrames[i].Opcode().IsPing() || s.pendingFrames[i].Opcode().IsPong()) {
+			i++
+		}
+	}
+
+	s.pendingFrames = append(s.pendingFrames, nil)
+	copy(s.pendingFrames[i+1:], s.pendingFrames[i:])
+	s.pendingFrames[i] = f
+
+	s.pendingCallbacks = append(s.pendingCallbacks, nil)
+	copy(s.pendingCallbacks[i+1:], s.pendingCallbacks[i:])
+	s.pendingCallbacks[i] = callback
+
+	q := &s.queue
+	q.queuedBytes += len(*f)
+	if q.highWatermark > 0 && !q.backpressured && q.queuedBytes >= q.highWatermark {
+		q.backpressured = true
+		if q.writableCallback != nil {
+			q.writableCallback(false)
+		}
+	}
+}
+
+// flush starts writing the pending frames asynchronously, unless they are already being written.
+func (s *Stream) flush() {
+	q := &s.queue
+	if q.busy() || len(s.pendingFrames) == 0 {
+		return
+	}
+
+	if err := s.startBatch(); err != nil {
+		s.onBatchWritten(err)
+		return
+	}
+
+	s.dst.AsyncWriteTo(s.stream, func(err error, _ int) {
+		s.onBatchWritten(err)
+	})
+}
+
+// startBatch moves the pending frames in a batch and encodes them in the write buffer.
+func (s *Stream) startBatch() error {
+	q := &s.queue
+	q.flushing = true
+
+	q.batch, s.pendingFrames = s.pendingFrames, q.batch[:0]
+	q.batchCallbacks, s.pendingCallbacks = s.pendingCallbacks, q.batchCallbacks[:0]
+
+	for _, f := range q.batch {
+		if err := s.codec.Encode(*f, s.dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onBatchWritten is called once the current batch is written or fails to be written. In the latter case, all pending
+// frames are failed as well.
+func (s *Stream) onBatchWritten(err error) {
+	q := &s.queue
+	q.flushing = false
+	q.completing = true
+
+	s.completeBatch(err)
+
+	if err != nil && len(s.pendingFrames) > 0 {
+		// Frames queued after this point are failed when the next flush fails.
+		q.batch, s.pendingFrames = s.pendingFrames, q.batch[:0]
+		q.batchCallbacks, s.pendingCallbacks = s.pendingCallbacks, q.batchCallbacks[:0]
+		s.completeBatch(err)
+	}
+
+	if err != nil || len(s.pendingFrames) == 0 {
+		callbacks := q.flushCallbacks
+		q.flushCallbacks = q.spareCallbacks[:0]
+		for i, callback := range callbacks {
+			callbacks[i] = nil
+			callback(err)
+		}
+		q.spareCallbacks = callbacks[:0]
+	}
+
+	q.completing = false
+
+	if err == nil {
+		s.flush()
+	}
+}
+
+// completeBatch releases the frames of the current batch and invokes their callbacks.
+func (s *Stream) completeBatch(err error) {
+	q := &s.queue
+
+	closeSent := false
+	for i, f := range q.batch {
+		closeSent = closeSent || (err == nil && f.Opcode().IsClose())
+		q.queuedBytes -= len(*f)
+		s.releaseFrame(f)
+		q.batch[i] = nil
+	}
+	q.batch = q.batch[:0]
+
+	if q.backpressured && q.queuedBytes <= q.lowWatermark {
+		q.backpressured = false
+		if q.writableCallback != nil {
+			q.writableCallback(true)
+		}
+	}
+
+	for i, callback := range q.batchCallbacks {
+		q.batchCallbacks[i] = nil
+		if callback != nil {
+			callback(err)
+		}
+	}
+	q.batchCallbacks = q.batchCallbacks[:0]
+
+	if closeSent {
+		s.onCloseSent()
+	}
+}
+
+// flushBeforeRead writes the pending frames before a read. If the queue is already flushed asynchronously, the read
+// does not wait for it: the pending frames are written by the ongoing flush.
+func (s *Stream) flushBeforeRead() error {
+	if s.queue.busy() {
+		return nil
+	}
+	return s.Flush()
+}
+
+// asyncFlushBeforeRead is like flushBeforeRead but asynchronous.
+func (s *Stream) asyncFlushBeforeRead(callback func(err error)) {
+	if s.queue.busy() {
+		callback(nil)
+		return
+	}
+	s.AsyncFlush(callback)
+}
+
+// resetQueue releases all queued frames without writing them.
+func (s *Stream) resetQueue() {
+	for _, f := range s.pendingFrames {
+		s.releaseFrame(f)
+	}
+	s.pendingFrames = s.pendingFrames[:0]
+	s.pendingCallbacks = s.pendingCallbacks[:0]
+
+	q := &s.queue
+	q.batch = q.batch[:0]
+	q.batchCallbacks = q.batchCallbacks[:0]
+	q.flushCallbacks = q.flushCallbacks[:0]
+	q.flushing = false
+	q.completing = false
+	q.queuedBytes = 0
+	q.backpressured = false
+	q.dropped = 0
+}
@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic"
+)
+
+// deferredStream is a MockStream whose asynchronous writes complete only when complete is called. Its asynchronous
+// reads never complete.
+type deferredStream struct {
+	*MockStream
+
+	writes  int
+	pending []func()
+	err     error // if set, pending writes complete with it
+
+	reads  []sonic.AsyncCallback
+	closed bool
+}
+
+func (s *deferredStream) AsyncRead(b []byte, cb sonic.AsyncCallback) {
+	s.reads = append(s.reads, cb)
+}
+
+func (s *deferredStream) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *deferredStream) AsyncWriteAll(b []byte, cb sonic.AsyncCallback) {
+	s.writes++
+	s.pending = append(s.pending, func() {
+		if s.err != nil {
+			cb(s.err, 0)
+			return
+		}
+		n, err := s.MockStream.Write(b)
+		cb(err, n)
+	})
+}
+
+func (s *deferredStream) AsyncWrite(b []byte, cb sonic.AsyncCallback) {
+	s.AsyncWriteAll(b, cb)
+}
+
+// complete completes all pending writes, including the ones issued while completing.
+func (s *deferredStream) complete() {
+	for len(s.pending) > 0 {
+		next := s.pending[0]
+		s.pending = s.pending[1:]
+		next()
+	}
+}
+
+func newDeferredStream(t *testing.T, role Role) (*Stream, *deferredStream) {
+	ws, err := NewWebsocketStream(sonic.MustIO(), nil, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.state = StateActive
+	stream := &deferredStream{MockStream: NewMockStream()}
+	if err := ws.init(stream); err != nil {
+		t.Fatal(err)
+	}
+	return ws, stream
+}
+
+func TestWriteQueueOrdered(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleServer)
+
+	var written []int
+	for i := 0; i < 5; i++ {
+		i := i
+		ws.AsyncWrite([]byte{byte(i)}, TypeBinary, func(err error) {
+			assert.Nil(err)
+			written = append(written, i)
+		})
+	}
+
+	// The first message is written on its own, the rest are queued meanwhile.
+	assert.Equal(1, stream.writes)
+	assert.Equal(4, ws.Pending())
+	assert.Equal(5*3, ws.QueuedBytes())
+	assert.Empty(written)
+
+	stream.complete()
+
+	// The queued messages are written in a single batch.
+	assert.Equal(2, stream.writes)
+	assert.Equal([]int{0, 1, 2, 3, 4}, written)
+	assert.Equal(0, ws.Pending())
+	assert.Equal(0, ws.QueuedBytes())
+
+	frames := writtenFrames(t, ws)
+	assert.Len(frames, 5)
+	for i, f := range frames {
+		assertFrame(t, f, true, OpcodeBinary, string([]byte{byte(i)}))
+	}
+}
+
+func TestWriteQueueFromCallback(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleServer)
+
+	n := 0
+	var onWrite func(err error)
+	onWrite = func(err error) {
+		assert.Nil(err)
+		n++
+		if n < 3 {
+			ws.AsyncWrite([]byte("again"), TypeText, onWrite)
+		}
+	}
+	ws.AsyncWrite([]byte("first"), TypeText, onWrite)
+	stream.complete()
+
+	assert.Equal(3, n)
+	assert.Equal(3, stream.writes)
+	assert.Len(writtenFrames(t, ws), 3)
+}
+
+func TestWriteQueueAsyncFlush(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleServer)
+
+	flushed := false
+	ws.AsyncFlush(func(err error) {
+		assert.Nil(err)
+		flushed = true
+	})
+	assert.True(flushed) // nothing to flush
+
+	ws.AsyncWrite([]byte("a"), TypeText, func(err error) {})
+	ws.AsyncWrite([]byte("b"), TypeText, func(err error) {})
+
+	flushed = false
+	ws.AsyncFlush(func(err error) {
+		assert.Nil(err)
+		assert.Equal(0, ws.Pending())
+		flushed = true
+	})
+	assert.False(flushed)
+
+	stream.complete()
+	assert.True(flushed)
+}
+
+func TestWriteQueuePingsFirst(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleServer)
+	ws.SetMaxFrameSize(2)
+
+	ws.AsyncWrite([]byte("x"), TypeText, func(err error) {}) // written while the rest is queued
+	ws.AsyncWrite([]byte("one"), TypeText, func(err error) {})
+	ws.AsyncWrite([]byte("two"), TypeText, func(err error) {})
+	ws.AsyncWriteFrame(ws.AcquireFrame().SetFIN().SetPong().SetPayload([]byte{1}), func(err error) {})
+	ws.AsyncWriteFrame(ws.AcquireFrame().SetFIN().SetPing().SetPayload([]byte{2}), func(err error) {})
+	ws.AsyncClose(CloseNormal, "", func(err error) {})
+	stream.complete()
+
+	frames := writtenFrames(t, ws)
+	assert.Len(frames, 8)
+	assertFrame(t, frames[0], true, OpcodeText, "x")
+	// Pings and pongs jump ahead of the queued messages.
+	assertFrame(t, frames[1], true, OpcodePong, "\x01")
+	assertFrame(t, frames[2], true, OpcodePing, "\x02")
+	assertFrame(t, frames[3], false, OpcodeText, "on")
+	assertFrame(t, frames[4], true, OpcodeContinuation, "e")
+	assertFrame(t, frames[5], false, OpcodeText, "tw")
+	assertFrame(t, frames[6], true, OpcodeContinuation, "o")
+	// Close frames do not.
+	assert.Equal(OpcodeClose, frames[7].Opcode())
+}
+
+func TestWriteQueueWatermarks(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleServer)
+	ws.SetWriteWatermarks(10, 20)
+
+	low, high := ws.WriteWatermarks()
+	assert.Equal(10, low)
+	assert.Equal(20, high)
+
+	var events []bool
+	ws.SetWritableCallback(func(writable bool) {
+		events = append(events, writable)
+	})
+
+	payload := make([]byte, 8) // 10 bytes queued per message
+	ws.AsyncWrite(payload, TypeBinary, func(err error) {})
+	assert.True(ws.Writable())
+	ws.AsyncWrite(payload, TypeBinary, func(err error) {})
+	assert.False(ws.Writable())
+	ws.AsyncWrite(payload, TypeBinary, func(err error) {})
+	assert.Equal([]bool{false}, events)
+
+	// Completing the first write leaves 20 bytes queued, above the low watermark.
+	next := stream.pending[0]
+	stream.pending = stream.pending[1:]
+	next()
+	assert.False(ws.Writable())
+	assert.Equal([]bool{false}, events)
+
+	stream.complete()
+	assert.True(ws.Writable())
+	assert.Equal([]bool{false, true}, events)
+}
+
+func TestWriteQueueLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleServer)
+	ws.SetMaxQueuedBytes(16, WriteQueueFail)
+
+	payload := make([]byte, 8)
+	ws.AsyncWrite(payload, TypeBinary, func(err error) { assert.Nil(err) })
+	ws.AsyncWrite(payload, TypeBinary, func(err error) {
+		assert.Equal(ErrWriteQueueFull, err)
+	})
+	assert.Equal(10, ws.QueuedBytes())
+
+	ws.SetMaxQueuedBytes(16, WriteQueueDrop)
+	limit, policy := ws.MaxQueuedBytes()
+	assert.Equal(16, limit)
+	assert.Equal(WriteQueueDrop, policy)
+
+	dropped := false
+	ws.AsyncWrite(payload, TypeBinary, func(err error) {
+		assert.Nil(err)
+		dropped = true
+	})
+	assert.True(dropped)
+	assert.Equal(1, ws.Dropped())
+
+	stream.complete()
+	assert.Len(writtenFrames(t, ws), 1)
+
+	// There is room once the queue is drained.
+	ws.AsyncWrite(payload, TypeBinary, func(err error) { assert.Nil(err) })
+	stream.complete()
+	assert.Len(writtenFrames(t, ws), 1)
+}
+
+func TestWriteQueueSyncWriteWhileFlushing(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleServer)
+
+	ws.AsyncWrite([]byte("async"), TypeText, func(err error) {})
+	assert.Equal(ErrAsyncWriteInProgress, ws.Write([]byte("sync"), TypeText))
+	assert.Equal(ErrAsyncWriteInProgress, ws.Flush())
+	assert.Equal(0, ws.Pending())
+
+	stream.complete()
+	assert.Nil(ws.Write([]byte("sync"), TypeText))
+	assert.Len(writtenFrames(t, ws), 2)
+}
+
+func TestWriteQueueFailsPending(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, stream := newDeferredStream(t, RoleServer)
+
+	var errs []error
+	for i := 0; i < 3; i++ {
+		ws.AsyncWrite([]byte("hello"), TypeText, func(err error) {
+			errs = append(errs, err)
+		})
+	}
+
+	// The first write fails after the others are queued.
+	stream.err = io.ErrClosedPipe
+	stream.complete()
+
+	assert.Equal([]error{io.ErrClosedPipe, io.ErrClosedPipe, io.ErrClosedPipe}, errs)
+	assert.Equal(0, ws.Pending())
+	assert.Equal(0, ws.QueuedBytes())
+}
@@ -19,7 +19,7 @@ type TestCodec struct {
 	emptyItem TestItem
 }
 
-func (t *TestCodec) Encode(item TestItem, dst *ByteBuffer) error {
+func (t *TestCodec) Encode(item TestItem, dst Buffer) error {
 	n, err := dst.Write(item.V[:])
 	dst.Commit(n)
 	if err != nil {
@@ -29,7 +29,7 @@ func (t *TestCodec) Encode(item TestItem, dst *ByteBuffer) error {
 	return nil
 }
 
-func (t *TestCodec) Decode(src *ByteBuffer) (TestItem, error) {
+func (t *TestCodec) Decode(src Buffer) (TestItem, error) {
 	if err := src.PrepareRead(5); err != nil {
 		return t.emptyItem, err
 	}
@@ -391,3 +391,145 @@ func TestCodecConnWriteNext(t *testing.T) {
 		}
 	}
 }
+
+func setupCodecBatchReader(t *testing.T) (addr string, read chan int) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	read = make(chan int, 128)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 1024)
+		for {
+			n, err := conn.Read(b)
+			if err != nil {
+				return
+			}
+			read <- n
+		}
+	}()
+	return ln.Addr().String(), read
+}
+
+func readBytes(t *testing.T, read chan int, expected int) {
+	total := 0
+	for total < expected {
+		select {
+		case n := <-read:
+			total += n
+		case <-time.After(5 * time.Second):
+			t.Fatalf("read %d bytes, expected %d", total, expected)
+		}
+	}
+	if total != expected {
+		t.Fatalf("read %d bytes, expected %d", total, expected)
+	}
+}
+
+func TestCodecConnBatching(t *testing.T) {
+	addr, read := setupCodecBatchReader(t)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	codecConn, _ := NewCodecConn[TestItem, TestItem](conn, &TestCodec{}, NewByteBuffer(), NewByteBuffer())
+
+	for i := 0; i < 3; i++ {
+		if err := codecConn.Enqueue(TestItem{V: [5]byte{byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if codecConn.Queued() != 15 {
+		t.Fatalf("expected 15 queued bytes, got %d", codecConn.Queued())
+	}
+
+	var flushed []int
+	codecConn.AsyncFlush(func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		flushed = append(flushed, n)
+
+		// Flushing with nothing enqueued completes immediately.
+		codecConn.AsyncFlush(func(err error, n int) {
+			flushed = append(flushed, n)
+		})
+	})
+	for len(flushed) < 2 {
+		if _, err := ioc.PollOne(); err != nil && err != sonicerrors.ErrTimeout {
+			t.Fatal(err)
+		}
+	}
+	if flushed[0] != 15 || flushed[1] != 0 || codecConn.Queued() != 0 {
+		t.Fatalf("invalid flushes %v, %d bytes queued", flushed, codecConn.Queued())
+	}
+	readBytes(t, read, 15)
+}
+
+func TestCodecConnAutoFlush(t *testing.T) {
+	addr, read := setupCodecBatchReader(t)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	codecConn, _ := NewCodecConn[TestItem, TestItem](conn, &TestCodec{}, NewByteBuffer(), NewByteBuffer())
+
+	var flushed []int
+	codecConn.EnableAutoFlush(ioc, 10, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		flushed = append(flushed, n)
+	})
+
+	// Outside a dispatch cycle, items are flushed immediately.
+	_ = codecConn.Enqueue(TestItem{})
+	readBytes(t, read, 5)
+
+	// Within a dispatch cycle, items are flushed at its end, or once 10 bytes are queued.
+	var queued []int
+	handler := func() {
+		_ = codecConn.Enqueue(TestItem{})
+		queued = append(queued, codecConn.Queued())
+	}
+	for i := 0; i < 3; i++ {
+		_ = ioc.Post(handler)
+	}
+	for len(flushed) < 3 {
+		if _, err := ioc.PollOne(); err != nil && err != sonicerrors.ErrTimeout {
+			t.Fatal(err)
+		}
+	}
+	if len(queued) != 3 || queued[0] != 5 {
+		t.Fatalf("invalid queued bytes %v", queued)
+	}
+	if flushed[0] != 5 || flushed[1] != 10 || flushed[2] != 5 {
+		t.Fatalf("invalid flushes %v", flushed)
+	}
+	readBytes(t, read, 15)
+
+	codecConn.DisableAutoFlush()
+	_ = codecConn.Enqueue(TestItem{})
+	if codecConn.Queued() != 5 {
+		t.Fatalf("expected 5 queued bytes, got %d", codecConn.Queued())
+	}
+}
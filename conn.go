@@ -2,8 +2,10 @@ package sonic
 
 import (
 	"net"
+	"syscall"
 	"time"
 
+	"github.com/talostrading/sonic/sonicerrors"
 	"github.com/talostrading/sonic/sonicopts"
 
 	"github.com/talostrading/sonic/internal"
@@ -42,6 +44,107 @@ func DialTimeout(
 	return newConn(ioc, fd, localAddr, remoteAddr), nil
 }
 
+// AsyncDial is like DialTimeout but asynchronous.
+//
+// This call does not block on connecting: the provided callback is invoked once the connection is established, or with
+// an error if it fails. If the connection is not established within the timeout, the callback is invoked with
+// sonicerrors.ErrTimeout. A timeout of 0 means no timeout.
+//
+// The address is resolved synchronously, so it should be an IP address if resolving its host may block.
+func AsyncDial(
+	ioc *IO,
+	network, addr string,
+	timeout time.Duration,
+	cb func(error, Conn),
+	opts ...sonicopts.Option,
+) {
+	fd, remoteAddr, inProgress, err := internal.ConnectNonblocking(network, addr, opts...)
+	if err != nil {
+		cb(err, nil)
+		return
+	}
+
+	d := &asyncDialer{
+		ioc:        ioc,
+		remoteAddr: remoteAddr,
+		cb:         cb,
+	}
+	d.slot.Fd = fd
+
+	if !inProgress {
+		d.connected()
+		return
+	}
+
+	if timeout > 0 {
+		d.timer, err = NewTimer(ioc)
+		if err == nil {
+			err = d.timer.ScheduleOnce(timeout, d.onTimeout)
+		}
+		if err != nil {
+			d.fail(err)
+			return
+		}
+	}
+
+	d.slot.Set(internal.WriteEvent, d.onConnect)
+	if err := ioc.SetWrite(&d.slot); err != nil {
+		d.fail(err)
+		return
+	}
+	ioc.Register(&d.slot)
+}
+
+// asyncDialer waits for a nonblocking connect to complete.
+type asyncDialer struct {
+	ioc        *IO
+	slot       internal.Slot
+	timer      *Timer
+	remoteAddr net.Addr
+	cb         func(error, Conn)
+}
+
+func (d *asyncDialer) onConnect(err error) {
+	d.ioc.Deregister(&d.slot)
+	if err == nil {
+		err = internal.ConnectResult(d.slot.Fd)
+	}
+	if err != nil {
+		d.fail(err)
+	} else {
+		d.connected()
+	}
+}
+
+func (d *asyncDialer) onTimeout() {
+	_ = d.ioc.UnsetWrite(&d.slot)
+	d.ioc.Deregister(&d.slot)
+	d.fail(sonicerrors.ErrTimeout)
+}
+
+func (d *asyncDialer) connected() {
+	d.closeTimer()
+	localAddr, err := internal.SocketAddress(d.slot.Fd)
+	if err != nil {
+		d.fail(err)
+		return
+	}
+	d.cb(nil, newConn(d.ioc, d.slot.Fd, localAddr, d.remoteAddr))
+}
+
+func (d *asyncDialer) fail(err error) {
+	d.closeTimer()
+	_ = syscall.Close(d.slot.Fd)
+	d.cb(err, nil)
+}
+
+func (d *asyncDialer) closeTimer() {
+	if d.timer != nil {
+		_ = d.timer.Close()
+		d.timer = nil
+	}
+}
+
 func newConn(
 	ioc *IO,
 	fd int,
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic/sonicerrors"
 	"github.com/talostrading/sonic/sonicopts"
 )
 
@@ -237,6 +238,136 @@ func TestConnAsyncTCPEchoClient(t *testing.T) {
 	marker <- struct{}{}
 }
 
+func TestAsyncDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		done   bool
+		result error
+		b      = make([]byte, 5)
+	)
+	AsyncDial(ioc, "tcp", ln.Addr().String(), time.Second, func(err error, conn Conn) {
+		if err != nil {
+			done, result = true, err
+			return
+		}
+		conn.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+			if err != nil {
+				done, result = true, err
+				return
+			}
+			conn.AsyncReadAll(b, func(err error, _ int) {
+				done, result = true, err
+				_ = conn.Close()
+			})
+		})
+	})
+	runUntil(t, ioc, func() bool { return done })
+
+	if result != nil {
+		t.Fatal(result)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("invalid echo %s", string(b))
+	}
+	if ioc.Pending() != 0 {
+		t.Fatalf("expected no pending operations, got %d", ioc.Pending())
+	}
+}
+
+func TestAsyncDialRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		done   bool
+		result error
+	)
+	AsyncDial(ioc, "tcp", addr, time.Second, func(err error, conn Conn) {
+		done, result = true, err
+	})
+	runUntil(t, ioc, func() bool { return done })
+
+	if !errors.Is(result, sonicerrors.ErrConnRefused) {
+		t.Fatalf("expected ErrConnRefused, got %v", result)
+	}
+}
+
+func TestAsyncDialTimeout(t *testing.T) {
+	// A listener whose backlog is full does not complete new connections.
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		timedOut bool
+		conns    []Conn
+	)
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	for i := 0; i < 16 && !timedOut; i++ {
+		done := false
+		AsyncDial(ioc, "tcp", addr, 50*time.Millisecond, func(err error, conn Conn) {
+			done = true
+			if err == nil {
+				conns = append(conns, conn)
+			} else if errors.Is(err, sonicerrors.ErrTimeout) {
+				timedOut = true
+			} else {
+				t.Fatal(err)
+			}
+		})
+		runUntil(t, ioc, func() bool { return done })
+	}
+	if !timedOut {
+		t.Skip("the listener's backlog never filled up")
+	}
+	if ioc.Pending() != 0 {
+		t.Fatalf("expected no pending operations, got %d", ioc.Pending())
+	}
+}
+
 func TestConnReadHandlesError(t *testing.T) {
 	marker := make(chan struct{}, 1)
 	go func() {
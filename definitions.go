@@ -28,6 +28,40 @@ type AsyncReader interface {
 	AsyncReadAll(b []byte, cb AsyncCallback)
 }
 
+// ZeroCopyMinSize is the size below which AsyncWriteZeroCopy copies the bytes instead. Pinning the pages of a buffer
+// and processing the completion notification costs more than copying small buffers.
+var ZeroCopyMinSize = 16 * 1024
+
+// ZeroCopyWriter is the interface that wraps the AsyncWriteZeroCopy method. It is implemented by the connections
+// returned by Dial and Accept.
+type ZeroCopyWriter interface {
+	// AsyncWriteZeroCopy writes exactly `len(b)` bytes into the underlying socket asynchronously, without copying
+	// them into the kernel, through MSG_ZEROCOPY. The socket must have been created with sonicopts.ZeroCopy(true).
+	//
+	// The provided completion handler is called once the kernel does not reference `b` anymore, which is after the
+	// bytes were transmitted. Until then, `b` must not be modified.
+	//
+	// The bytes are copied, as with AsyncWriteAll, if:
+	//  - `b` is smaller than ZeroCopyMinSize
+	//  - the socket does not have SO_ZEROCOPY enabled, or the platform does not support it
+	//  - the kernel reported that it had to copy previous zero-copy writes, for example on the loopback interface
+	//  - the kernel runs out of the memory it reserves for zero-copy writes, for the rest of `b`
+	//
+	// As such, the completion handlers of copied writes may be called before the ones of earlier zero-copy writes.
+	AsyncWriteZeroCopy(b []byte, cb AsyncCallback)
+}
+
+// WriteWaiter is the interface that wraps the AsyncWaitWrite method. It is implemented by the connections returned by
+// Dial and Accept.
+type WriteWaiter interface {
+	// AsyncWaitWrite invokes the provided handler once the underlying socket can be written without blocking, or when
+	// an error occurs. It is meant for writes which sonic does not make itself, like writes of control messages with
+	// sendmsg(2).
+	//
+	// It must not be called while an asynchronous write is pending.
+	AsyncWaitWrite(cb func(error))
+}
+
 // AsyncWriter is the interface that wraps the AsyncWrite and AsyncWriteAll methods.
 type AsyncWriter interface {
 	// AsyncWrite writes up to `len(b)` bytes from `b` asynchronously.
@@ -11,6 +11,7 @@ import (
 )
 
 var _ File = &file{}
+var _ WriteWaiter = &file{}
 
 type file struct {
 	ioc          *IO
@@ -18,6 +19,9 @@ type file struct {
 	closed       uint32
 	readReactor  fileReadReactor
 	writeReactor fileWriteReactor
+
+	// zeroCopy is created by the first AsyncWriteZeroCopy.
+	zeroCopy *zeroCopyReactor
 }
 
 type fileReadReactor struct {
@@ -51,13 +55,15 @@ type fileWriteReactor struct {
 
 	b          []byte
 	writeAll   bool
+	zeroCopy   bool
 	cb         AsyncCallback
 	wroteSoFar int
 }
 
-func (r *fileWriteReactor) init(b []byte, writeAll bool, cb AsyncCallback) {
+func (r *fileWriteReactor) init(b []byte, writeAll, zeroCopy bool, cb AsyncCallback) {
 	r.b = b
 	r.writeAll = writeAll
+	r.zeroCopy = zeroCopy
 	r.cb = cb
 
 	r.wroteSoFar = 0
@@ -83,7 +89,7 @@ func newFile(ioc *IO, fd int) *file {
 	f.readReactor.init(nil, false, nil)
 
 	f.writeReactor = fileWriteReactor{file: f}
-	f.writeReactor.init(nil, false, nil)
+	f.writeReactor.init(nil, false, false, nil)
 
 	return f
 }
@@ -179,7 +185,9 @@ func (f *file) asyncReadNow(b []byte, readSoFar int, readAll bool, cb AsyncCallb
 	}
 
 	// handles (readAll == false) and (readAll == true && readSoFar != len(b)).
-	if err == sonicerrors.ErrWouldBlock {
+	// A partial read without error means that the fd was drained, so the rest
+	// is also read asynchronously.
+	if err == nil || err == sonicerrors.ErrWouldBlock {
 		// If readAll == true then read some without errors.
 		// We schedule an asynchronous read.
 		f.scheduleRead(readSoFar, cb)
@@ -205,15 +213,15 @@ func (f *file) scheduleRead(readSoFar int, cb AsyncCallback) {
 }
 
 func (f *file) AsyncWrite(b []byte, cb AsyncCallback) {
-	f.asyncWrite(b, false, cb)
+	f.asyncWrite(b, false, false, cb)
 }
 
 func (f *file) AsyncWriteAll(b []byte, cb AsyncCallback) {
-	f.asyncWrite(b, true, cb)
+	f.asyncWrite(b, true, false, cb)
 }
 
-func (f *file) asyncWrite(b []byte, writeAll bool, cb AsyncCallback) {
-	f.writeReactor.init(b, writeAll, cb)
+func (f *file) asyncWrite(b []byte, writeAll, zeroCopy bool, cb AsyncCallback) {
+	f.writeReactor.init(b, writeAll, zeroCopy, cb)
 
 	if f.ioc.Dispatched < MaxCallbackDispatch {
 		f.asyncWriteNow(b, 0, writeAll, func(err error, n int) {
@@ -227,7 +235,15 @@ func (f *file) asyncWrite(b []byte, writeAll bool, cb AsyncCallback) {
 }
 
 func (f *file) asyncWriteNow(b []byte, wroteSoFar int, writeAll bool, cb AsyncCallback) {
-	n, err := f.Write(b[wroteSoFar:])
+	var (
+		n   int
+		err error
+	)
+	if f.writeReactor.zeroCopy {
+		n, err = f.writeZeroCopy(b[wroteSoFar:])
+	} else {
+		n, err = f.Write(b[wroteSoFar:])
+	}
 	wroteSoFar += n
 
 	if err == nil && !(writeAll && wroteSoFar != len(b)) {
@@ -238,13 +254,33 @@ func (f *file) asyncWriteNow(b []byte, wroteSoFar int, writeAll bool, cb AsyncCa
 	}
 
 	// Handles (writeAll == false) and (writeAll == true && wroteSoFar != len(b)).
-	if err == sonicerrors.ErrWouldBlock {
+	// A partial write without error means that the fd's buffer is full, so the
+	// rest is also written asynchronously.
+	if err == nil || err == sonicerrors.ErrWouldBlock {
 		f.scheduleWrite(wroteSoFar, cb)
 	} else {
 		cb(err, wroteSoFar)
 	}
 }
 
+func (f *file) AsyncWaitWrite(cb func(error)) {
+	if f.Closed() {
+		cb(io.EOF)
+		return
+	}
+
+	f.slot.Set(internal.WriteEvent, func(err error) {
+		f.ioc.Deregister(&f.slot)
+		cb(err)
+	})
+
+	if err := f.ioc.SetWrite(&f.slot); err != nil {
+		cb(err)
+	} else {
+		f.ioc.Register(&f.slot)
+	}
+}
+
 func (f *file) scheduleWrite(wroteSoFar int, cb AsyncCallback) {
 	if f.Closed() {
 		cb(io.EOF, 0)
@@ -285,6 +321,7 @@ func (f *file) Seek(offset int64, whence int) (int64, error) {
 func (f *file) Cancel() {
 	f.cancelReads()
 	f.cancelWrites()
+	f.cancelErrors()
 }
 
 func (f *file) cancelReads() {
@@ -307,6 +344,16 @@ func (f *file) cancelWrites() {
 	}
 }
 
+func (f *file) cancelErrors() {
+	if f.slot.Events&internal.PollerErrorEvent == internal.PollerErrorEvent {
+		err := f.ioc.poller.DelError(&f.slot)
+		if err == nil {
+			err = sonicerrors.ErrCancelled
+		}
+		f.slot.Handlers[internal.ErrorEvent](err)
+	}
+}
+
 func (f *file) RawFd() int {
 	return f.slot.Fd
 }
@@ -0,0 +1,126 @@
+package sonic
+
+import (
+	"bytes"
+	"math/rand"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// socketPair returns both ends of a nonblocking unix stream socket pair.
+func socketPair(t *testing.T, ioc *IO) (*file, *file) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := newFile(ioc, fds[0]), newFile(ioc, fds[1])
+	t.Cleanup(func() {
+		_ = a.Close()
+		_ = b.Close()
+	})
+	return a, b
+}
+
+func runUntil(t *testing.T, ioc *IO, done func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+}
+
+// TestFileAsyncAllShortTransfers checks that AsyncReadAll and AsyncWriteAll
+// complete only once all bytes are transferred, even if a read or a write
+// transfers less bytes than asked without failing. This is what happens when
+// a write fills the socket's buffer or a read drains it.
+func TestFileAsyncAllShortTransfers(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	a, b := socketPair(t, ioc)
+
+	// Larger than the socket's buffer, such that the first write is short.
+	wrote := make([]byte, 4*1024*1024)
+	rand.Read(wrote)
+	read := make([]byte, len(wrote))
+
+	var (
+		writeDone, readDone bool
+		writeErr, readErr   error
+		writeN, readN       int
+	)
+	a.AsyncWriteAll(wrote, func(err error, n int) {
+		writeDone, writeErr, writeN = true, err, n
+	})
+	b.AsyncReadAll(read, func(err error, n int) {
+		readDone, readErr, readN = true, err, n
+	})
+	runUntil(t, ioc, func() bool { return writeDone && readDone })
+
+	if writeErr != nil || writeN != len(wrote) {
+		t.Fatalf("AsyncWriteAll: err=%v n=%d, expected %d", writeErr, writeN, len(wrote))
+	}
+	if readErr != nil || readN != len(read) {
+		t.Fatalf("AsyncReadAll: err=%v n=%d, expected %d", readErr, readN, len(read))
+	}
+	if !bytes.Equal(wrote, read) {
+		t.Fatal("read bytes differ from the written bytes")
+	}
+}
+
+func TestFileAsyncWaitWrite(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	a, b := socketPair(t, ioc)
+
+	// Fill the socket's buffer.
+	chunk := make([]byte, 64*1024)
+	for {
+		if _, err := a.Write(chunk); err == sonicerrors.ErrWouldBlock {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		writable bool
+		waitErr  error
+	)
+	a.AsyncWaitWrite(func(err error) {
+		writable, waitErr = true, err
+	})
+	for i := 0; i < 10; i++ {
+		_, _ = ioc.PollOne()
+	}
+	if writable {
+		t.Fatal("the socket should not be writable while its buffer is full")
+	}
+
+	// Drain the peer, which makes room in the socket's buffer.
+	for {
+		if _, err := b.Read(chunk); err == sonicerrors.ErrWouldBlock {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runUntil(t, ioc, func() bool { return writable })
+	if waitErr != nil {
+		t.Fatal(waitErr)
+	}
+	if _, err := a.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,567 @@
+package http
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+	sonictls "github.com/talostrading/sonic/tls"
+)
+
+const (
+	DefaultDialTimeout     = 10 * time.Second
+	DefaultIdleTimeout     = 90 * time.Second
+	DefaultMaxConnsPerHost = 8
+)
+
+// Client sends requests and receives their responses over pooled connections, one pool per scheme, host and port.
+//
+// Each connection sends at most SetMaxPipelined requests before receiving their responses. Requests which cannot be
+// sent on any connection of their pool are queued until a connection frees up or a new one is established.
+//
+// A Client is not safe for concurrent use: it must only be used from the goroutine which runs its IO object.
+type Client struct {
+	ioc       *sonic.IO
+	tlsConfig *tls.Config
+
+	timeout         time.Duration
+	dialTimeout     time.Duration
+	idleTimeout     time.Duration
+	maxConnsPerHost int
+	maxPipelined    int
+	maxHeaderBytes  int
+	maxBodyBytes    int
+
+	pools  map[string]*pool
+	closed bool
+}
+
+// NewClient returns a Client whose https connections are configured by tlsConfig, which may be nil.
+func NewClient(ioc *sonic.IO, tlsConfig *tls.Config) *Client {
+	return &Client{
+		ioc:             ioc,
+		tlsConfig:       tlsConfig,
+		dialTimeout:     DefaultDialTimeout,
+		idleTimeout:     DefaultIdleTimeout,
+		maxConnsPerHost: DefaultMaxConnsPerHost,
+		maxPipelined:    1,
+		maxHeaderBytes:  DefaultMaxHeaderBytes,
+		maxBodyBytes:    DefaultMaxBodyBytes,
+		pools:           make(map[string]*pool),
+	}
+}
+
+// SetTimeout sets the timeout of requests which do not set their own. 0 means no timeout, which is the default.
+func (c *Client) SetTimeout(d time.Duration) {
+	c.timeout = d
+}
+
+// SetDialTimeout bounds the time taken to establish a connection.
+func (c *Client) SetDialTimeout(d time.Duration) {
+	c.dialTimeout = d
+}
+
+// SetIdleTimeout sets the time after which a connection without requests in flight is closed. 0 means never.
+func (c *Client) SetIdleTimeout(d time.Duration) {
+	c.idleTimeout = d
+}
+
+// SetMaxConnsPerHost bounds the number of connections in a pool.
+func (c *Client) SetMaxConnsPerHost(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.maxConnsPerHost = n
+}
+
+// SetMaxPipelined bounds the number of requests in flight on a connection. 1, the default, disables pipelining.
+func (c *Client) SetMaxPipelined(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.maxPipelined = n
+}
+
+func (c *Client) SetMaxHeaderBytes(n int) {
+	c.maxHeaderBytes = n
+}
+
+func (c *Client) SetMaxBodyBytes(n int) {
+	c.maxBodyBytes = n
+}
+
+// Do sends the request and invokes the callback once its response is received, or once the request fails. The
+// response is only valid until the callback returns.
+//
+// The request's timeout covers queueing, establishing a connection and the exchange itself. Requests time out with
+// sonicerrors.ErrTimeout, in which case the connection they were sent on is closed and the requests pipelined on it
+// fail with ErrConnectionClosed.
+func (c *Client) Do(req *Request, cb func(error, *Response)) {
+	if c.closed {
+		cb(ErrClientClosed, nil)
+		return
+	}
+	if req.URL == nil {
+		cb(ErrMalformedMessage, nil)
+		return
+	}
+
+	var port string
+	switch req.URL.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	default:
+		cb(ErrUnsupportedScheme, nil)
+		return
+	}
+	if p := req.URL.Port(); p != "" {
+		port = p
+	}
+	addr := net.JoinHostPort(req.URL.Hostname(), port)
+	key := req.URL.Scheme + "://" + addr
+
+	p, ok := c.pools[key]
+	if !ok {
+		p = &pool{
+			client: c,
+			addr:   addr,
+			tls:    req.URL.Scheme == "https",
+		}
+		c.pools[key] = p
+	}
+
+	call := &call{req: req, cb: cb}
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = c.timeout
+	}
+	if timeout > 0 {
+		call.deadline = time.Now().Add(timeout)
+	}
+
+	p.queue = append(p.queue, call)
+	p.dispatch()
+}
+
+// Close closes all connections. Requests which have not completed fail with ErrClientClosed.
+func (c *Client) Close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	for _, p := range c.pools {
+		queue := p.queue
+		p.queue = nil
+		for _, call := range queue {
+			call.cb(ErrClientClosed, nil)
+		}
+		for len(p.conns) > 0 {
+			p.conns[0].close(ErrClientClosed)
+		}
+		if p.timer != nil {
+			_ = p.timer.Close()
+		}
+	}
+}
+
+type call struct {
+	req      *Request
+	cb       func(error, *Response)
+	deadline time.Time // zero if the request has no timeout
+}
+
+func (c *call) expired(now time.Time) bool {
+	return !c.deadline.IsZero() && !now.Before(c.deadline)
+}
+
+type pool struct {
+	client *Client
+	addr   string
+	tls    bool
+
+	conns   []*clientConn
+	dialing int
+	queue   []*call
+
+	// Armed for the earliest deadline of the queued requests, which may wait for a connection to be established or to
+	// free up. Created on first use.
+	timer *sonic.Timer
+}
+
+// dispatch sends the queued requests on the least loaded connections, establishing new ones if needed.
+func (p *pool) dispatch() {
+	defer p.schedule()
+
+	for len(p.queue) > 0 && !p.client.closed {
+		call := p.queue[0]
+		if call.expired(time.Now()) {
+			p.queue = p.queue[1:]
+			call.cb(sonicerrors.ErrTimeout, nil)
+			continue
+		}
+
+		var conn *clientConn
+		for _, c := range p.conns {
+			if c.closing || len(c.inflight) >= p.client.maxPipelined {
+				continue
+			}
+			if conn == nil || len(c.inflight) < len(conn.inflight) {
+				conn = c
+			}
+		}
+
+		if conn == nil {
+			if len(p.conns)+p.dialing < p.client.maxConnsPerHost && p.dialing < len(p.queue) {
+				p.dial()
+			}
+			return
+		}
+
+		p.queue = p.queue[1:]
+		conn.send(call)
+	}
+}
+
+func (p *pool) dial() {
+	p.dialing++
+
+	if p.tls {
+		sonictls.AsyncDial(
+			p.client.ioc,
+			"tcp",
+			p.addr,
+			p.client.tlsConfig,
+			p.client.dialTimeout,
+			func(err error, stream *sonictls.Stream) {
+				if err != nil {
+					p.onDial(err, nil)
+				} else {
+					p.onDial(nil, stream)
+				}
+			},
+		)
+		return
+	}
+
+	sonic.AsyncDial(p.client.ioc, "tcp", p.addr, p.client.dialTimeout, func(err error, conn sonic.Conn) {
+		if err != nil {
+			p.onDial(err, nil)
+		} else {
+			p.onDial(nil, conn)
+		}
+	})
+}
+
+func (p *pool) onDial(err error, stream sonic.Stream) {
+	p.dialing--
+
+	if err == nil && p.client.closed {
+		_ = stream.Close()
+		return
+	}
+
+	if err == nil {
+		var conn *clientConn
+		conn, err = newClientConn(p, stream)
+		if err == nil {
+			p.conns = append(p.conns, conn)
+			conn.read()
+			p.dispatch()
+			return
+		}
+		_ = stream.Close()
+	}
+
+	// Requests fail if no connection can serve them. Otherwise they wait for the existing ones.
+	if len(p.conns) == 0 && p.dialing == 0 {
+		queue := p.queue
+		p.queue = nil
+		for _, call := range queue {
+			call.cb(err, nil)
+		}
+	}
+}
+
+// schedule arms the timer for the earliest deadline of the queued requests.
+func (p *pool) schedule() {
+	if p.timer != nil {
+		_ = p.timer.Cancel()
+	}
+	if p.client.closed {
+		return
+	}
+
+	var deadline time.Time
+	for _, call := range p.queue {
+		if !call.deadline.IsZero() && (deadline.IsZero() || call.deadline.Before(deadline)) {
+			deadline = call.deadline
+		}
+	}
+	if deadline.IsZero() {
+		return
+	}
+
+	if p.timer == nil {
+		timer, err := sonic.NewTimer(p.client.ioc)
+		if err != nil {
+			// The queued requests then only expire once dispatched.
+			return
+		}
+		p.timer = timer
+	}
+
+	delay := time.Until(deadline)
+	if delay <= 0 {
+		delay = time.Nanosecond // never run the callback in place
+	}
+	_ = p.timer.ScheduleOnce(delay, p.onTimer)
+}
+
+// onTimer fails the queued requests past their deadline with sonicerrors.ErrTimeout.
+func (p *pool) onTimer() {
+	now := time.Now()
+
+	var expired []*call
+	queue := p.queue[:0]
+	for _, call := range p.queue {
+		if call.expired(now) {
+			expired = append(expired, call)
+		} else {
+			queue = append(queue, call)
+		}
+	}
+	for i := len(queue); i < len(p.queue); i++ {
+		p.queue[i] = nil
+	}
+	p.queue = queue
+
+	for _, call := range expired {
+		call.cb(sonicerrors.ErrTimeout, nil)
+	}
+	p.schedule()
+}
+
+func (p *pool) remove(conn *clientConn) {
+	for i, c := range p.conns {
+		if c == conn {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// clientConn is a connection of a pool. It reads responses continuously and writes requests one after the other:
+// requests sent while a write is in progress are encoded in the write buffer and written once the write completes.
+type clientConn struct {
+	pool  *pool
+	codec *ClientCodec
+	conn  *sonic.CodecConn[*Request, *Response]
+	src   *sonic.ByteBuffer
+	dst   *sonic.ByteBuffer
+	timer *sonic.Timer
+
+	inflight  []*call // requests sent whose responses have not been received, in order
+	writing   bool
+	closing   bool // a request asked for the connection to be closed after its response
+	closed    bool
+	idleSince time.Time
+}
+
+func newClientConn(p *pool, stream sonic.Stream) (*clientConn, error) {
+	timer, err := sonic.NewTimer(p.client.ioc)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &clientConn{
+		pool:      p,
+		codec:     NewClientCodec(),
+		src:       sonic.NewByteBuffer(),
+		dst:       sonic.NewByteBuffer(),
+		timer:     timer,
+		idleSince: time.Now(),
+	}
+	c.codec.SetMaxHeaderBytes(p.client.maxHeaderBytes)
+	c.codec.SetMaxBodyBytes(p.client.maxBodyBytes)
+
+	c.conn, err = sonic.NewCodecConn[*Request, *Response](stream, c.codec, c.src, c.dst)
+	if err != nil {
+		_ = timer.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *clientConn) send(call *call) {
+	c.inflight = append(c.inflight, call)
+	if call.req.Close {
+		c.closing = true
+	}
+	c.schedule()
+
+	if err := c.codec.Encode(call.req, c.dst); err != nil {
+		c.inflight = c.inflight[:len(c.inflight)-1]
+		call.cb(err, nil)
+		return
+	}
+
+	// Requests sent while a write is in progress are written once it completes.
+	if !c.writing {
+		c.writing = true
+		c.onWrite(nil)
+	}
+}
+
+func (c *clientConn) onWrite(err error) {
+	if c.closed {
+		return
+	}
+	if err != nil {
+		c.close(err)
+		return
+	}
+
+	if c.dst.ReadLen() > 0 {
+		c.dst.AsyncWriteTo(c.conn.NextLayer(), func(err error, _ int) {
+			c.onWrite(err)
+		})
+	} else {
+		c.writing = false
+	}
+}
+
+func (c *clientConn) read() {
+	c.conn.AsyncReadNext(c.onRead)
+}
+
+func (c *clientConn) onRead(err error, res *Response) {
+	if c.closed {
+		return
+	}
+
+	if errors.Is(err, io.EOF) {
+		var ok bool
+		if res, ok = c.codec.DecodeEOF(c.src); ok {
+			err = nil
+		} else {
+			err = ErrConnectionClosed
+		}
+	}
+	if err != nil {
+		c.close(err)
+		return
+	}
+
+	if len(c.inflight) == 0 {
+		c.close(ErrMalformedMessage) // unsolicited response
+		return
+	}
+
+	call := c.inflight[0]
+	c.inflight = c.inflight[1:]
+	if len(c.inflight) == 0 {
+		c.idleSince = time.Now()
+	}
+	if res.Close {
+		c.closing = true
+	}
+
+	call.cb(nil, res)
+
+	if c.closed {
+		return
+	}
+	if c.closing && len(c.inflight) == 0 {
+		c.close(ErrConnectionClosed)
+		return
+	}
+	c.schedule()
+	c.read()
+	c.pool.dispatch()
+}
+
+// schedule arms the timer for the earliest request deadline or, if no requests are in flight, for the idle timeout.
+func (c *clientConn) schedule() {
+	_ = c.timer.Cancel()
+
+	var delay time.Duration
+	if len(c.inflight) > 0 {
+		var deadline time.Time
+		for _, call := range c.inflight {
+			if !call.deadline.IsZero() && (deadline.IsZero() || call.deadline.Before(deadline)) {
+				deadline = call.deadline
+			}
+		}
+		if deadline.IsZero() {
+			return
+		}
+		delay = time.Until(deadline)
+	} else {
+		if c.pool.client.idleTimeout <= 0 {
+			return
+		}
+		delay = time.Until(c.idleSince.Add(c.pool.client.idleTimeout))
+	}
+
+	if delay <= 0 {
+		delay = time.Nanosecond // never run the callback in place
+	}
+	_ = c.timer.ScheduleOnce(delay, c.onTimer)
+}
+
+func (c *clientConn) onTimer() {
+	if c.closed {
+		return
+	}
+
+	now := time.Now()
+	if len(c.inflight) == 0 {
+		if now.Sub(c.idleSince) >= c.pool.client.idleTimeout {
+			c.close(ErrConnectionClosed)
+		} else {
+			c.schedule()
+		}
+		return
+	}
+
+	for _, call := range c.inflight {
+		if call.expired(now) {
+			c.close(ErrConnectionClosed)
+			return
+		}
+	}
+	c.schedule()
+}
+
+// close closes the connection and fails the requests in flight: those past their deadline with
+// sonicerrors.ErrTimeout, the others with the given error.
+func (c *clientConn) close(err error) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	c.pool.remove(c)
+	_ = c.timer.Close()
+	_ = c.conn.Close()
+
+	now := time.Now()
+	inflight := c.inflight
+	c.inflight = nil
+	for _, call := range inflight {
+		if call.expired(now) {
+			call.cb(sonicerrors.ErrTimeout, nil)
+		} else {
+			call.cb(err, nil)
+		}
+	}
+
+	c.pool.dispatch()
+}
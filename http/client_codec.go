@@ -0,0 +1,270 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/talostrading/sonic"
+)
+
+var _ sonic.Codec[*Request, *Response] = &ClientCodec{}
+
+// ClientCodec encodes requests and decodes the responses to them. It is meant to be used with a sonic.CodecConn.
+//
+// Requests may be pipelined: their methods are remembered such that the responses to HEAD requests are parsed
+// correctly. The decoded Response is reused across calls to Decode, and its body is only valid until the next call.
+type ClientCodec struct {
+	parser parser
+
+	methods []string // of the requests whose responses have not been decoded yet, in order
+
+	response   Response
+	headerDone bool
+}
+
+func NewClientCodec() *ClientCodec {
+	return &ClientCodec{
+		parser:   newParser(),
+		response: Response{Header: make(http.Header)},
+	}
+}
+
+// SetMaxHeaderBytes bounds the size of a response's header section. Larger responses fail with ErrHeaderTooLarge.
+func (c *ClientCodec) SetMaxHeaderBytes(n int) {
+	c.parser.maxHeaderBytes = n
+}
+
+// SetMaxBodyBytes bounds the size of a response's body. Larger responses fail with ErrBodyTooLarge.
+func (c *ClientCodec) SetMaxBodyBytes(n int) {
+	c.parser.maxBodyBytes = n
+}
+
+// Pending returns the number of requests encoded whose responses have not been decoded yet.
+func (c *ClientCodec) Pending() int {
+	return len(c.methods)
+}
+
+func (c *ClientCodec) Encode(req *Request, dst sonic.Buffer) error {
+	if req.URL == nil {
+		return ErrMalformedMessage
+	}
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	if !validToken(method) || !validHeaderValue(host) {
+		return ErrMalformedMessage
+	}
+	for k, vs := range req.Header {
+		if !validToken(k) {
+			return ErrMalformedMessage
+		}
+		for _, v := range vs {
+			if !validHeaderValue(v) {
+				return ErrMalformedMessage
+			}
+		}
+	}
+
+	w := messageWriter{dst: dst, start: dst.WriteLen()}
+	w.writeString(method)
+	w.writeString(" ")
+	w.writeString(req.URL.RequestURI())
+	w.writeString(" HTTP/1.1\r\nHost: ")
+	w.writeString(host)
+	w.write(crlf)
+
+	for k, vs := range req.Header {
+		switch k {
+		case "Host", "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		for _, v := range vs {
+			w.writeHeader(k, v)
+		}
+	}
+
+	if len(req.Body) > 0 || method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch {
+		w.writeHeader("Content-Length", strconv.Itoa(len(req.Body)))
+	}
+	if req.Close && !hasToken(req.Header["Connection"], "close") {
+		w.writeHeader("Connection", "close")
+	}
+	w.write(crlf)
+	w.write(req.Body)
+	if err := w.finish(); err != nil {
+		return err
+	}
+
+	dst.Commit(dst.WriteLen())
+
+	c.methods = append(c.methods, method)
+
+	return nil
+}
+
+func (c *ClientCodec) Decode(src sonic.Buffer) (*Response, error) {
+	c.parser.begin(src)
+
+	for !c.headerDone {
+		block, err := c.parser.header(src)
+		if err != nil {
+			return nil, err
+		}
+
+		c.response.reset()
+		statusLine, err := parseHeader(block, c.response.Header)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.parseStatusLine(statusLine); err != nil {
+			return nil, err
+		}
+
+		code := c.response.StatusCode
+		if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+			// Interim responses precede the final response to the same request.
+			c.parser.skip(src)
+			continue
+		}
+
+		if c.noBody() {
+			c.parser.kind = bodyNone
+		} else if err := c.parser.framing(c.response.Header, true); err != nil {
+			return nil, err
+		}
+		c.headerDone = true
+	}
+
+	body, err := c.parser.body(src)
+	if err != nil {
+		return nil, err
+	}
+	return c.complete(body), nil
+}
+
+// DecodeEOF returns the response whose body is delimited by the connection's closure, if any. It should be called
+// once the stream returns io.EOF.
+func (c *ClientCodec) DecodeEOF(src sonic.Buffer) (*Response, bool) {
+	if !c.headerDone {
+		return nil, false
+	}
+	body, ok := c.parser.bodyAtClose(src)
+	if !ok {
+		return nil, false
+	}
+	res := c.complete(body)
+	res.Close = true
+	return res, true
+}
+
+func (c *ClientCodec) complete(body []byte) *Response {
+	c.headerDone = false
+	if len(c.methods) > 0 {
+		c.methods = c.methods[1:]
+	}
+
+	c.response.Body = body
+	c.response.Close = closeAfter(c.response.ProtoMinor, c.response.Header)
+	return &c.response
+}
+
+// noBody returns true if the response has no body regardless of its headers, RFC7230 3.3.3.
+func (c *ClientCodec) noBody() bool {
+	code := c.response.StatusCode
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		return true
+	}
+	return len(c.methods) > 0 && c.methods[0] == http.MethodHead
+}
+
+func (c *ClientCodec) parseStatusLine(line string) error {
+	// HTTP/1.x SP 3DIGIT SP reason-phrase
+	if len(line) < len("HTTP/1.x 200") || !strings.HasPrefix(line, "HTTP/1.") || line[8] != ' ' {
+		return ErrMalformedMessage
+	}
+
+	switch line[7] {
+	case '0':
+		c.response.ProtoMinor = 0
+	case '1':
+		c.response.ProtoMinor = 1
+	default:
+		return ErrMalformedMessage
+	}
+
+	code, err := strconv.Atoi(line[9:12])
+	if err != nil || code < 100 || code > 999 {
+		return ErrMalformedMessage
+	}
+	c.response.StatusCode = code
+
+	if len(line) > 12 {
+		if line[12] != ' ' {
+			return ErrMalformedMessage
+		}
+		c.response.Status = line[13:]
+	}
+	return nil
+}
+
+// messageWriter writes a message in the write area of a Buffer. Fixed size buffers fail once full, in which case the
+// first error is kept and the bytes written are removed by finish, such that a message is never partially encoded.
+type messageWriter struct {
+	dst   sonic.Buffer
+	start int // length of the write area before the message
+	err   error
+}
+
+func (w *messageWriter) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.dst.Write(b)
+	}
+}
+
+func (w *messageWriter) writeString(s string) {
+	if w.err == nil {
+		_, w.err = w.dst.WriteString(s)
+	}
+}
+
+func (w *messageWriter) writeHeader(key, value string) {
+	w.writeString(key)
+	w.writeString(": ")
+	w.writeString(value)
+	w.write(crlf)
+}
+
+// finish returns the first error of the writes, after removing the bytes they wrote.
+func (w *messageWriter) finish() error {
+	if w.err != nil {
+		w.dst.ShrinkBy(w.dst.WriteLen() - w.start)
+	}
+	return w.err
+}
+
+// validToken returns true if s is a non-empty token, RFC7230 3.2.6.
+func validToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b <= ' ' || b >= 0x7f || strings.IndexByte("\"(),/:;<=>?@[\\]{}", b) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue returns true if s can be sent as a header value without splitting the header section.
+func validHeaderValue(s string) bool {
+	return !strings.ContainsAny(s, "\r\n\x00")
+}
@@ -0,0 +1,171 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// feed decodes the given bytes one at a time, checking the codec asks for more until the last one.
+func feed(t *testing.T, codec *ClientCodec, src *sonic.ByteBuffer, b string) *Response {
+	for i := 0; i < len(b); i++ {
+		_ = src.WriteByte(b[i])
+		res, err := codec.Decode(src)
+		if i < len(b)-1 {
+			if err != sonicerrors.ErrNeedMore {
+				t.Fatalf("decoded at byte %d of %d: %v", i+1, len(b), err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	return nil
+}
+
+func TestClientCodecEncode(t *testing.T) {
+	assert := assert.New(t)
+
+	req, err := NewRequest(http.MethodPost, "http://example.com:8080/path?q=1", []byte("hello"))
+	assert.Nil(err)
+	req.Header.Set("X-Key", "value")
+	req.Close = true
+
+	codec := NewClientCodec()
+	dst := sonic.NewByteBuffer()
+	assert.Nil(codec.Encode(req, dst))
+	assert.Equal(
+		"POST /path?q=1 HTTP/1.1\r\n"+
+			"Host: example.com:8080\r\n"+
+			"X-Key: value\r\n"+
+			"Content-Length: 5\r\n"+
+			"Connection: close\r\n"+
+			"\r\n"+
+			"hello",
+		string(dst.Data()),
+	)
+	assert.Equal(1, codec.Pending())
+
+	req.Header.Set("X-Bad", "a\r\nb")
+	assert.Equal(ErrMalformedMessage, codec.Encode(req, sonic.NewByteBuffer()))
+	assert.Equal(1, codec.Pending())
+}
+
+func TestClientCodecDecodeContentLength(t *testing.T) {
+	assert := assert.New(t)
+
+	codec := NewClientCodec()
+	src := sonic.NewByteBuffer()
+
+	res := feed(t, codec, src, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\ncontent-type: text/plain\r\n\r\nhello")
+	assert.Equal(200, res.StatusCode)
+	assert.Equal("OK", res.Status)
+	assert.Equal(1, res.ProtoMinor)
+	assert.Equal("text/plain", res.Header.Get("Content-Type"))
+	assert.Equal("hello", string(res.Body))
+	assert.False(res.Close)
+
+	// The previous response is consumed by the next Decode.
+	_, err := codec.Decode(src)
+	assert.Equal(sonicerrors.ErrNeedMore, err)
+	assert.Equal(0, src.ReadLen())
+}
+
+func TestClientCodecDecodeChunked(t *testing.T) {
+	assert := assert.New(t)
+
+	codec := NewClientCodec()
+	src := sonic.NewByteBuffer()
+
+	res := feed(t, codec, src, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5;ext=1\r\nhello\r\n"+
+		"6\r\n world\r\n"+
+		"0\r\nTrailer: x\r\n\r\n")
+	assert.Equal("hello world", string(res.Body))
+
+	res = feed(t, codec, src, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+	assert.Len(res.Body, 0)
+}
+
+func TestClientCodecDecodePipelined(t *testing.T) {
+	assert := assert.New(t)
+
+	codec := NewClientCodec()
+	src := sonic.NewByteBuffer()
+
+	head, _ := NewRequest(http.MethodHead, "http://example.com/", nil)
+	get, _ := NewRequest(http.MethodGet, "http://example.com/", nil)
+	dst := sonic.NewByteBuffer()
+	assert.Nil(codec.Encode(head, dst))
+	assert.Nil(codec.Encode(get, dst))
+
+	// The response to HEAD has no body despite its Content-Length. An interim response precedes the second one.
+	_, _ = src.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n" +
+		"HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+
+	res, err := codec.Decode(src)
+	assert.Nil(err)
+	assert.Equal(200, res.StatusCode)
+	assert.Len(res.Body, 0)
+	assert.Equal(1, codec.Pending())
+
+	res, err = codec.Decode(src)
+	assert.Nil(err)
+	assert.Equal(204, res.StatusCode)
+	assert.True(res.Close)
+	assert.Equal(0, codec.Pending())
+}
+
+func TestClientCodecDecodeUntilClose(t *testing.T) {
+	assert := assert.New(t)
+
+	codec := NewClientCodec()
+	src := sonic.NewByteBuffer()
+
+	_, _ = src.WriteString("HTTP/1.0 200 OK\r\n\r\nhello")
+	_, err := codec.Decode(src)
+	assert.Equal(sonicerrors.ErrNeedMore, err)
+
+	res, ok := codec.DecodeEOF(src)
+	assert.True(ok)
+	assert.Equal("hello", string(res.Body))
+	assert.True(res.Close)
+}
+
+func TestClientCodecDecodeErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	decode := func(codec *ClientCodec, b string) error {
+		src := sonic.NewByteBuffer()
+		_, _ = src.WriteString(b)
+		_, err := codec.Decode(src)
+		return err
+	}
+
+	assert.Equal(ErrMalformedMessage, decode(NewClientCodec(), "HTTP/2.0 200 OK\r\n\r\n"))
+	assert.Equal(ErrMalformedMessage, decode(NewClientCodec(), "HTTP/1.1 2000 OK\r\n\r\n"))
+	assert.Equal(ErrMalformedMessage, decode(NewClientCodec(), "HTTP/1.1 200 OK\r\nBad Key: x\r\n\r\n"))
+	assert.Equal(ErrMalformedMessage, decode(NewClientCodec(), "HTTP/1.1 200 OK\r\nA: x\r\n folded\r\n\r\n"))
+	assert.Equal(ErrMalformedMessage, decode(NewClientCodec(),
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n"))
+	assert.Equal(ErrMalformedMessage, decode(NewClientCodec(),
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+
+	codec := NewClientCodec()
+	codec.SetMaxHeaderBytes(16)
+	assert.Equal(ErrHeaderTooLarge, decode(codec, "HTTP/1.1 200 OK\r\nA: b\r\n"))
+
+	codec = NewClientCodec()
+	codec.SetMaxBodyBytes(4)
+	assert.Equal(ErrBodyTooLarge, decode(codec, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"))
+	codec = NewClientCodec()
+	codec.SetMaxBodyBytes(4)
+	assert.Equal(ErrBodyTooLarge, decode(codec,
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\n"))
+}
@@ -0,0 +1,388 @@
+package http
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// runUntil runs the IO object until done is true.
+func runUntil(t *testing.T, ioc *sonic.IO, done *bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !*done {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_, _ = ioc.PollOne()
+	}
+}
+
+// countingServer returns a server which counts the connections it accepts.
+func countingServer(handler http.HandlerFunc, conns *int32) *httptest.Server {
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	srv.Start()
+	return srv
+}
+
+func TestClientContentLength(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, r.ContentLength)
+		_, _ = r.Body.Read(body)
+		w.Header().Set("X-Method", r.Method)
+		_, _ = fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+	}))
+	defer srv.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, nil)
+	defer client.Close()
+
+	req, err := NewRequest(http.MethodPost, srv.URL+"/echo", []byte("hello"))
+	assert.Nil(err)
+
+	done := false
+	client.Do(req, func(err error, res *Response) {
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		assert.Equal("POST", res.Header.Get("X-Method"))
+		assert.Equal("/echo hello", string(res.Body))
+		done = true
+	})
+	runUntil(t, ioc, &done)
+}
+
+func TestClientChunked(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 100; i++ {
+			_, _ = fmt.Fprintf(w, "%d,", i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, nil)
+	defer client.Close()
+
+	var expected strings.Builder
+	for i := 0; i < 100; i++ {
+		_, _ = fmt.Fprintf(&expected, "%d,", i)
+	}
+
+	req, _ := NewRequest(http.MethodGet, srv.URL, nil)
+	done := false
+	client.Do(req, func(err error, res *Response) {
+		assert.Nil(err)
+		assert.Equal([]string{"chunked"}, res.Header["Transfer-Encoding"])
+		assert.Equal(expected.String(), string(res.Body))
+		done = true
+	})
+	runUntil(t, ioc, &done)
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	assert := assert.New(t)
+
+	var conns int32
+	srv := countingServer(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}, &conns)
+	defer srv.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, nil)
+	defer client.Close()
+
+	// Sequential requests reuse the same connection.
+	for i := 0; i < 10; i++ {
+		req, _ := NewRequest(http.MethodGet, fmt.Sprintf("%s/%d", srv.URL, i), nil)
+		done := false
+		client.Do(req, func(err error, res *Response) {
+			assert.Nil(err)
+			assert.Equal(fmt.Sprintf("/%d", i), string(res.Body))
+			done = true
+		})
+		runUntil(t, ioc, &done)
+	}
+	assert.Equal(int32(1), atomic.LoadInt32(&conns))
+
+	// Requests asking for the connection to be closed are honored.
+	req, _ := NewRequest(http.MethodGet, srv.URL, nil)
+	req.Close = true
+	done := false
+	client.Do(req, func(err error, res *Response) {
+		assert.Nil(err)
+		assert.True(res.Close)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+
+	req, _ = NewRequest(http.MethodGet, srv.URL, nil)
+	done = false
+	client.Do(req, func(err error, res *Response) {
+		assert.Nil(err)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+	assert.Equal(int32(2), atomic.LoadInt32(&conns))
+}
+
+func TestClientPipelining(t *testing.T) {
+	assert := assert.New(t)
+
+	var conns int32
+	srv := countingServer(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}, &conns)
+	defer srv.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, nil)
+	defer client.Close()
+	client.SetMaxConnsPerHost(2)
+	client.SetMaxPipelined(8)
+
+	// Responses are received in order on each connection.
+	const n = 64
+	var received []string
+	for i := 0; i < n; i++ {
+		req, _ := NewRequest(http.MethodGet, fmt.Sprintf("%s/%d", srv.URL, i), nil)
+		path := req.URL.Path
+		client.Do(req, func(err error, res *Response) {
+			assert.Nil(err)
+			assert.Equal(path, string(res.Body))
+			received = append(received, path)
+		})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(received) < n && time.Now().Before(deadline) {
+		_, _ = ioc.PollOne()
+	}
+	assert.Len(received, n)
+	assert.LessOrEqual(atomic.LoadInt32(&conns), int32(2))
+}
+
+func TestClientTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		once    sync.Once
+		release = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}))
+	defer srv.Close()
+	defer once.Do(func() { close(release) })
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, nil)
+	defer client.Close()
+
+	req, _ := NewRequest(http.MethodGet, srv.URL+"/slow", nil)
+	req.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	done := false
+	client.Do(req, func(err error, res *Response) {
+		assert.Equal(sonicerrors.ErrTimeout, err)
+		assert.Nil(res)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+	assert.GreaterOrEqual(time.Since(start), 50*time.Millisecond)
+	once.Do(func() { close(release) })
+
+	// The client recovers with a new connection.
+	req, _ = NewRequest(http.MethodGet, srv.URL+"/fast", nil)
+	done = false
+	client.Do(req, func(err error, res *Response) {
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+}
+
+func TestClientTimeoutWhileQueued(t *testing.T) {
+	assert := assert.New(t)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, nil)
+	client.SetMaxConnsPerHost(1)
+	defer client.Close()
+
+	// The slow request occupies the only connection, so the next one is queued.
+	slow, _ := NewRequest(http.MethodGet, srv.URL+"/slow", nil)
+	client.Do(slow, func(error, *Response) {})
+
+	req, _ := NewRequest(http.MethodGet, srv.URL+"/fast", nil)
+	req.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	done := false
+	client.Do(req, func(err error, res *Response) {
+		assert.Equal(sonicerrors.ErrTimeout, err)
+		assert.Nil(res)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+	assert.GreaterOrEqual(time.Since(start), 50*time.Millisecond)
+	assert.Less(time.Since(start), time.Second)
+}
+
+func TestClientTimeoutWhileDialing(t *testing.T) {
+	assert := assert.New(t)
+
+	// The connection is established by the kernel, but the server never answers the ClientHello.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, &tls.Config{InsecureSkipVerify: true})
+	defer client.Close()
+
+	req, _ := NewRequest(http.MethodGet, "https://"+ln.Addr().String()+"/", nil)
+	req.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	done := false
+	client.Do(req, func(err error, res *Response) {
+		assert.Equal(sonicerrors.ErrTimeout, err)
+		assert.Nil(res)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+	assert.Less(time.Since(start), time.Second)
+}
+
+func TestClientIdleTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	var conns int32
+	srv := countingServer(func(w http.ResponseWriter, r *http.Request) {}, &conns)
+	defer srv.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, nil)
+	defer client.Close()
+	client.SetIdleTimeout(20 * time.Millisecond)
+
+	get := func() {
+		req, _ := NewRequest(http.MethodGet, srv.URL, nil)
+		done := false
+		client.Do(req, func(err error, res *Response) {
+			assert.Nil(err)
+			done = true
+		})
+		runUntil(t, ioc, &done)
+	}
+
+	get()
+	for start := time.Now(); time.Since(start) < 100*time.Millisecond; {
+		_, _ = ioc.PollOne()
+	}
+	for _, p := range client.pools {
+		assert.Len(p.conns, 0)
+	}
+
+	get()
+	assert.Equal(int32(2), atomic.LoadInt32(&conns))
+}
+
+func TestClientTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secure"))
+	}))
+	defer srv.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, &tls.Config{RootCAs: srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs})
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		req, _ := NewRequest(http.MethodGet, srv.URL, nil)
+		done := false
+		client.Do(req, func(err error, res *Response) {
+			assert.Nil(err)
+			assert.Equal("secure", string(res.Body))
+			done = true
+		})
+		runUntil(t, ioc, &done)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := NewClient(ioc, nil)
+
+	req, _ := NewRequest(http.MethodGet, "ftp://localhost/", nil)
+	client.Do(req, func(err error, _ *Response) {
+		assert.Equal(ErrUnsupportedScheme, err)
+	})
+
+	client.Close()
+	req, _ = NewRequest(http.MethodGet, "http://localhost/", nil)
+	client.Do(req, func(err error, _ *Response) {
+		assert.Equal(ErrClientClosed, err)
+	})
+}
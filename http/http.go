@@ -0,0 +1,120 @@
+// Package http implements an asynchronous HTTP/1.1 client and server over sonic streams.
+//
+// Messages are parsed incrementally from a sonic.ByteBuffer: no goroutines are involved and all callbacks are invoked
+// on the goroutine which runs the IO object. Bodies are not streamed: a message is handed to the caller once it is
+// fully received, and its body points into the connection's read buffer. It is only valid until the callback which
+// receives the message returns.
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrHeaderTooLarge    = errors.New("http: header too large")
+	ErrBodyTooLarge      = errors.New("http: body too large")
+	ErrMalformedMessage  = errors.New("http: malformed message")
+	ErrUnsupportedScheme = errors.New("http: unsupported scheme")
+	ErrConnectionClosed  = errors.New("http: connection closed")
+	ErrClientClosed      = errors.New("http: client closed")
+)
+
+const (
+	DefaultMaxHeaderBytes = 64 * 1024
+	DefaultMaxBodyBytes   = 64 * 1024 * 1024
+)
+
+// Request is an HTTP request sent by the Client, or received by the Server.
+type Request struct {
+	Method string
+
+	// URL of the request. Clients must set the scheme and host. For requests received by a Server, only the path and
+	// query are set.
+	URL *url.URL
+
+	// Host overrides the Host header, which is otherwise taken from the URL.
+	Host string
+
+	Header http.Header
+	Body   []byte
+
+	// Timeout bounds the time from when the request is passed to Client.Do until its response is received. If 0, the
+	// Client's timeout is used.
+	Timeout time.Duration
+
+	// Set on requests received by a Server. ProtoMinor is 0 for HTTP/1.0 and 1 for HTTP/1.1.
+	ProtoMinor int
+
+	// Close is true if the connection is closed after the response to this request.
+	Close bool
+}
+
+// NewRequest returns a request for the given method and URL. The body may be nil.
+func NewRequest(method, rawURL string, body []byte) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	return &Request{
+		Method: method,
+		URL:    u,
+		Header: make(http.Header),
+		Body:   body,
+	}, nil
+}
+
+// Response is an HTTP response received by the Client, or sent by the Server.
+type Response struct {
+	StatusCode int
+
+	// Reason phrase of the status line. If empty when sent, the standard reason for the status code is used.
+	Status string
+
+	// ProtoMinor is 0 for HTTP/1.0 and 1 for HTTP/1.1.
+	ProtoMinor int
+
+	Header http.Header
+	Body   []byte
+
+	// Close is true if the connection is closed after this response.
+	Close bool
+}
+
+func (r *Response) reset() {
+	r.StatusCode = 0
+	r.Status = ""
+	r.ProtoMinor = 0
+	for k := range r.Header {
+		delete(r.Header, k)
+	}
+	r.Body = nil
+	r.Close = false
+}
+
+// hasToken returns true if the comma separated header values contain the token, ignoring case.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// closeAfter returns true if the connection is closed after a message with the given protocol version and headers.
+func closeAfter(protoMinor int, header http.Header) bool {
+	connection := header["Connection"]
+	if protoMinor == 0 {
+		return !hasToken(connection, "keep-alive")
+	}
+	return hasToken(connection, "close")
+}
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Handler responds to requests received by a Server.
+//
+// ServeHTTP is invoked on the goroutine which runs the Server's IO object. The request, including its body, is only
+// valid until ServeHTTP returns, while the response may be sent later through the ResponseWriter.
+type Handler interface {
+	ServeHTTP(w *ResponseWriter, req *Request)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(w *ResponseWriter, req *Request)
+
+func (f HandlerFunc) ServeHTTP(w *ResponseWriter, req *Request) {
+	f(w, req)
+}
+
+// ServeMux routes requests to handlers by path.
+//
+// Patterns ending in a slash match all paths they prefix, the longest such pattern winning. Other patterns only match
+// their exact path. Requests matching no pattern are answered with 404 Not Found.
+type ServeMux struct {
+	exact    map[string]Handler
+	prefixes []prefixRoute // longest first
+}
+
+type prefixRoute struct {
+	prefix  string
+	handler Handler
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{exact: make(map[string]Handler)}
+}
+
+// Handle registers the handler for the pattern, replacing any handler registered for it before.
+func (m *ServeMux) Handle(pattern string, handler Handler) {
+	if !strings.HasSuffix(pattern, "/") {
+		m.exact[pattern] = handler
+		return
+	}
+
+	for i := range m.prefixes {
+		if m.prefixes[i].prefix == pattern {
+			m.prefixes[i].handler = handler
+			return
+		}
+	}
+	m.prefixes = append(m.prefixes, prefixRoute{prefix: pattern, handler: handler})
+	sort.SliceStable(m.prefixes, func(i, j int) bool {
+		return len(m.prefixes[i].prefix) > len(m.prefixes[j].prefix)
+	})
+}
+
+func (m *ServeMux) HandleFunc(pattern string, f func(w *ResponseWriter, req *Request)) {
+	m.Handle(pattern, HandlerFunc(f))
+}
+
+// Handler returns the handler which serves the given path, or nil if there is none.
+func (m *ServeMux) Handler(path string) Handler {
+	if h, ok := m.exact[path]; ok {
+		return h
+	}
+	for _, route := range m.prefixes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.handler
+		}
+	}
+	return nil
+}
+
+func (m *ServeMux) ServeHTTP(w *ResponseWriter, req *Request) {
+	if h := m.Handler(req.URL.Path); h != nil {
+		h.ServeHTTP(w, req)
+	} else {
+		w.Respond(&Response{StatusCode: http.StatusNotFound})
+	}
+}
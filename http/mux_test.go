@@ -0,0 +1,48 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMux(t *testing.T) {
+	assert := assert.New(t)
+
+	var served string
+	handler := func(name string) Handler {
+		return HandlerFunc(func(*ResponseWriter, *Request) { served = name })
+	}
+
+	mux := NewServeMux()
+	mux.Handle("/", handler("root"))
+	mux.Handle("/api/", handler("api"))
+	mux.Handle("/api/v1/", handler("v1"))
+	mux.Handle("/metrics", handler("metrics"))
+
+	for path, expected := range map[string]string{
+		"/":           "root",
+		"/other":      "root",
+		"/api/":       "api",
+		"/api/x":      "api",
+		"/api/v1/x":   "v1",
+		"/metrics":    "metrics",
+		"/metrics/x":  "root",
+		"/api/v1":     "api",
+		"/api/v1/x/y": "v1",
+	} {
+		served = ""
+		h := mux.Handler(path)
+		if assert.NotNil(h, path) {
+			h.ServeHTTP(nil, nil)
+		}
+		assert.Equal(expected, served, path)
+	}
+
+	// Registering a pattern again replaces its handler.
+	mux.Handle("/api/", handler("api2"))
+	mux.Handler("/api/x").ServeHTTP(nil, nil)
+	assert.Equal("api2", served)
+
+	assert.Nil(NewServeMux().Handler("/"))
+}
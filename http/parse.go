@@ -0,0 +1,291 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var (
+	crlf     = []byte("\r\n")
+	crlfcrlf = []byte("\r\n\r\n")
+)
+
+// readChunk is the minimum number of bytes reserved in the read buffer when more bytes are needed.
+const readChunk = 4096
+
+// maxChunkLine bounds the length of a chunk size line, including its extensions.
+const maxChunkLine = 4096
+
+type bodyKind uint8
+
+const (
+	bodyNone       bodyKind = iota // no body
+	bodyLength                     // the body length is given by Content-Length
+	bodyChunked                    // the body is chunked
+	bodyUntilClose                 // the body ends when the connection is closed, only valid for responses
+)
+
+// parser parses a message from a ByteBuffer incrementally: the header section once it is fully received, then the
+// body.
+//
+// A parsed message points into the buffer. Its bytes are consumed when the next message is parsed, such that it stays
+// valid until then. Chunked bodies are the exception: they are decoded in a buffer owned by the parser.
+type parser struct {
+	maxHeaderBytes int
+	maxBodyBytes   int
+
+	consume int // bytes of the previous message, consumed before parsing the next one
+
+	scanned   int // bytes of the header section searched for its end
+	headerLen int // length of the header section, including the empty line. 0 until it is received
+
+	kind   bodyKind
+	length int // body length if bodyLength
+
+	// Chunked body state: offset of the next chunk size line, relative to the start of the message, and the decoded
+	// body.
+	chunkPos int
+	chunked  []byte
+}
+
+func newParser() parser {
+	return parser{
+		maxHeaderBytes: DefaultMaxHeaderBytes,
+		maxBodyBytes:   DefaultMaxBodyBytes,
+	}
+}
+
+// begin consumes the previous message and makes all received bytes available for parsing.
+func (p *parser) begin(src sonic.Buffer) {
+	if p.consume > 0 {
+		src.Consume(p.consume)
+		p.consume = 0
+	}
+	src.Commit(src.WriteLen())
+}
+
+// header returns the header section, without the empty line which ends it, once it is fully received.
+func (p *parser) header(src sonic.Buffer) ([]byte, error) {
+	data := src.Data()
+
+	from := p.scanned - len(crlfcrlf) + 1
+	if from < 0 {
+		from = 0
+	}
+	if i := bytes.Index(data[from:], crlfcrlf); i >= 0 {
+		p.headerLen = from + i + len(crlfcrlf)
+		if p.headerLen > p.maxHeaderBytes {
+			return nil, ErrHeaderTooLarge
+		}
+		return data[:from+i], nil
+	}
+
+	p.scanned = len(data)
+	if p.scanned > p.maxHeaderBytes {
+		return nil, ErrHeaderTooLarge
+	}
+	src.Reserve(readChunk)
+	return nil, sonicerrors.ErrNeedMore
+}
+
+// parseHeader parses the header fields of the header section into header, and returns the start line.
+func parseHeader(block []byte, header http.Header) (startLine string, err error) {
+	i := bytes.Index(block, crlf)
+	if i < 0 {
+		return string(block), nil
+	}
+	startLine, block = string(block[:i]), block[i+len(crlf):]
+
+	for len(block) > 0 {
+		var line []byte
+		if i := bytes.Index(block, crlf); i >= 0 {
+			line, block = block[:i], block[i+len(crlf):]
+		} else {
+			line, block = block, nil
+		}
+
+		// Obsolete line folding is rejected, RFC7230 3.2.4.
+		if len(line) == 0 || line[0] == ' ' || line[0] == '\t' {
+			return "", ErrMalformedMessage
+		}
+
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 || bytes.ContainsAny(line[:colon], " \t") {
+			return "", ErrMalformedMessage
+		}
+
+		key := textproto.CanonicalMIMEHeaderKey(string(line[:colon]))
+		value := string(bytes.TrimSpace(line[colon+1:]))
+		header[key] = append(header[key], value)
+	}
+	return startLine, nil
+}
+
+// framing determines how the body of the message is delimited, RFC7230 3.3.3. Requests without a length have no
+// body, while responses without one are delimited by the connection's closure.
+func (p *parser) framing(header http.Header, isResponse bool) error {
+	if te := header["Transfer-Encoding"]; len(te) > 0 {
+		last := te[len(te)-1]
+		if i := strings.LastIndexByte(last, ','); i >= 0 {
+			last = last[i+1:]
+		}
+		if strings.EqualFold(strings.TrimSpace(last), "chunked") {
+			p.kind = bodyChunked
+			p.chunkPos = p.headerLen
+			p.chunked = p.chunked[:0]
+			return nil
+		}
+		if isResponse {
+			p.kind = bodyUntilClose
+			return nil
+		}
+		return ErrMalformedMessage
+	}
+
+	if cl := header["Content-Length"]; len(cl) > 0 {
+		for _, v := range cl[1:] {
+			if v != cl[0] {
+				return ErrMalformedMessage
+			}
+		}
+		n, err := strconv.ParseUint(cl[0], 10, 63)
+		if err != nil {
+			return ErrMalformedMessage
+		}
+		if n > uint64(p.maxBodyBytes) {
+			return ErrBodyTooLarge
+		}
+		p.kind = bodyLength
+		p.length = int(n)
+		return nil
+	}
+
+	if isResponse {
+		p.kind = bodyUntilClose
+	} else {
+		p.kind = bodyNone
+	}
+	return nil
+}
+
+// body returns the body once it is fully received. It must be called after header and framing.
+func (p *parser) body(src sonic.Buffer) ([]byte, error) {
+	data := src.Data()
+
+	switch p.kind {
+	case bodyLength:
+		end := p.headerLen + p.length
+		if len(data) < end {
+			src.Reserve(end - len(data))
+			return nil, sonicerrors.ErrNeedMore
+		}
+		body := data[p.headerLen:end]
+		p.finish(end)
+		return body, nil
+	case bodyChunked:
+		return p.chunkedBody(src)
+	case bodyUntilClose:
+		if len(data)-p.headerLen > p.maxBodyBytes {
+			return nil, ErrBodyTooLarge
+		}
+		src.Reserve(readChunk)
+		return nil, sonicerrors.ErrNeedMore
+	default:
+		p.finish(p.headerLen)
+		return nil, nil
+	}
+}
+
+// bodyAtClose returns the body of a message delimited by the connection's closure, once the connection is closed.
+func (p *parser) bodyAtClose(src sonic.Buffer) ([]byte, bool) {
+	if p.kind != bodyUntilClose || p.headerLen == 0 {
+		return nil, false
+	}
+	src.Commit(src.WriteLen())
+	data := src.Data()
+	body := data[p.headerLen:]
+	p.finish(len(data))
+	return body, true
+}
+
+// chunkedBody decodes the chunks received so far. RFC7230 4.1.
+func (p *parser) chunkedBody(src sonic.Buffer) ([]byte, error) {
+	data := src.Data()
+
+	for {
+		line := bytes.Index(data[p.chunkPos:], crlf)
+		if line < 0 {
+			if len(data)-p.chunkPos > maxChunkLine {
+				return nil, ErrMalformedMessage
+			}
+			src.Reserve(readChunk)
+			return nil, sonicerrors.ErrNeedMore
+		}
+
+		sizeField := data[p.chunkPos : p.chunkPos+line]
+		if i := bytes.IndexByte(sizeField, ';'); i >= 0 {
+			sizeField = sizeField[:i] // chunk extensions are ignored
+		}
+		size, err := strconv.ParseUint(string(bytes.TrimSpace(sizeField)), 16, 63)
+		if err != nil {
+			return nil, ErrMalformedMessage
+		}
+		if size > uint64(p.maxBodyBytes-len(p.chunked)) {
+			return nil, ErrBodyTooLarge
+		}
+
+		start := p.chunkPos + line + len(crlf)
+		if size == 0 {
+			// The last chunk is followed by optional trailer fields, which are ignored, and an empty line.
+			if len(data)-start >= len(crlf) && bytes.Equal(data[start:start+len(crlf)], crlf) {
+				p.finish(start + len(crlf))
+				return p.chunked, nil
+			}
+			if i := bytes.Index(data[start:], crlfcrlf); i >= 0 {
+				p.finish(start + i + len(crlfcrlf))
+				return p.chunked, nil
+			}
+			if len(data)-start > p.maxHeaderBytes {
+				return nil, ErrHeaderTooLarge
+			}
+			src.Reserve(readChunk)
+			return nil, sonicerrors.ErrNeedMore
+		}
+
+		end := start + int(size)
+		if len(data) < end+len(crlf) {
+			src.Reserve(end + len(crlf) - len(data))
+			return nil, sonicerrors.ErrNeedMore
+		}
+		if !bytes.Equal(data[end:end+len(crlf)], crlf) {
+			return nil, ErrMalformedMessage
+		}
+
+		p.chunked = append(p.chunked, data[start:end]...)
+		p.chunkPos = end + len(crlf)
+	}
+}
+
+// finish marks the end of the message, whose bytes are consumed before the next message is parsed.
+func (p *parser) finish(n int) {
+	p.consume = n
+	p.scanned = 0
+	p.headerLen = 0
+	p.kind = bodyNone
+	p.length = 0
+	p.chunkPos = 0
+}
+
+// skip discards the current message, like an interim response, and prepares for the next one. It must be called
+// before the message's body is parsed.
+func (p *parser) skip(src sonic.Buffer) {
+	n := p.headerLen
+	p.finish(n)
+	p.begin(src)
+}
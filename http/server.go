@@ -0,0 +1,426 @@
+package http
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/codec/websocket"
+	sonictls "github.com/talostrading/sonic/tls"
+)
+
+const (
+	DefaultServerIdleTimeout = 2 * time.Minute
+	DefaultMaxPipelined      = 16
+)
+
+// Server accepts connections on a listener and passes the requests received on them to a handler.
+//
+// Connections are kept alive unless the client or a response asks otherwise. Requests may be pipelined: up to
+// SetMaxPipelined requests are read ahead on a connection while their responses are pending, and the responses are
+// sent in the order of the requests regardless of the order in which handlers respond.
+//
+// Over TLS, the handshake of each connection runs crypto/tls on its own goroutine, see the tls package: a Server costs
+// one goroutine per TLS handshake in flight. A handshake which does not complete within tls.DefaultHandshakeTimeout
+// fails and its connection is closed.
+//
+// A Server is not safe for concurrent use: it must only be used from the goroutine which runs its IO object.
+type Server struct {
+	ioc       *sonic.IO
+	ln        sonic.Listener
+	tlsConfig *tls.Config
+	handler   Handler
+
+	idleTimeout    time.Duration
+	maxPipelined   int
+	maxHeaderBytes int
+	maxBodyBytes   int
+
+	conns   map[*serverConn]struct{}
+	serving bool
+	closed  bool
+}
+
+// NewServer returns a Server which passes the requests received on the connections accepted by ln to the handler.
+// If tlsConfig is not nil, connections are served over TLS.
+func NewServer(ioc *sonic.IO, ln sonic.Listener, tlsConfig *tls.Config, handler Handler) *Server {
+	return &Server{
+		ioc:            ioc,
+		ln:             ln,
+		tlsConfig:      tlsConfig,
+		handler:        handler,
+		idleTimeout:    DefaultServerIdleTimeout,
+		maxPipelined:   DefaultMaxPipelined,
+		maxHeaderBytes: DefaultMaxHeaderBytes,
+		maxBodyBytes:   DefaultMaxBodyBytes,
+		conns:          make(map[*serverConn]struct{}),
+	}
+}
+
+// SetIdleTimeout sets the time after which a connection without pending requests is closed. 0 means never.
+func (s *Server) SetIdleTimeout(d time.Duration) {
+	s.idleTimeout = d
+}
+
+// SetMaxPipelined bounds the number of requests read on a connection whose responses are pending.
+func (s *Server) SetMaxPipelined(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.maxPipelined = n
+}
+
+// SetMaxHeaderBytes bounds the size of a request's header section. Larger requests are answered with 431 Request
+// Header Fields Too Large.
+func (s *Server) SetMaxHeaderBytes(n int) {
+	s.maxHeaderBytes = n
+}
+
+// SetMaxBodyBytes bounds the size of a request's body. Larger requests are answered with 413 Content Too Large.
+func (s *Server) SetMaxBodyBytes(n int) {
+	s.maxBodyBytes = n
+}
+
+// Serve starts accepting connections. It does not block: connections are served as the IO object is run.
+func (s *Server) Serve() {
+	if s.serving || s.closed {
+		return
+	}
+	s.serving = true
+	s.accept()
+}
+
+func (s *Server) accept() {
+	s.ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if s.closed {
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+
+		if err == nil {
+			s.serve(conn)
+		}
+		s.accept()
+	})
+}
+
+func (s *Server) serve(conn sonic.Conn) {
+	var stream sonic.Stream = conn
+	if s.tlsConfig != nil {
+		// The handshake runs with the first read.
+		stream = sonictls.Server(s.ioc, conn, s.tlsConfig)
+	}
+
+	c, err := newServerConn(s, stream)
+	if err != nil {
+		_ = stream.Close()
+		return
+	}
+	s.conns[c] = struct{}{}
+	c.read()
+}
+
+// Close stops accepting connections and closes the listener and all connections. Responses sent afterwards are
+// discarded.
+func (s *Server) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	for c := range s.conns {
+		c.close()
+	}
+	return s.ln.Close()
+}
+
+// ResponseWriter sends the response to a request. Respond must be called exactly once per request, either while the
+// handler runs or later, from the goroutine which runs the Server's IO object.
+type ResponseWriter struct {
+	conn *serverConn
+	res  *Response
+
+	// Sec-WebSocket-Key of a valid WebSocket upgrade request, empty otherwise.
+	websocketKey string
+	onUpgrade    func(error, *websocket.Stream)
+}
+
+// Respond sends the response. If responses to earlier pipelined requests are pending, the response is retained until
+// they are sent, so it must not be modified afterwards. Responses sent on a closed connection are discarded.
+func (w *ResponseWriter) Respond(res *Response) {
+	if w.res != nil {
+		return
+	}
+	if res.Header == nil {
+		res.Header = make(http.Header)
+	}
+	w.res = res
+	w.conn.flush()
+}
+
+// UpgradeWebsocket answers a WebSocket upgrade request with 101 Switching Protocols, adding the given headers which
+// may be nil, and hands the connection to a websocket.Stream in the server role once the response is sent.
+//
+// The callback is invoked with websocket.ErrCannotUpgrade if the request is not a valid upgrade request, in which case
+// no response is sent and the handler must still respond.
+func (w *ResponseWriter) UpgradeWebsocket(header http.Header, cb func(error, *websocket.Stream)) {
+	if w.websocketKey == "" || w.res != nil {
+		cb(websocket.ErrCannotUpgrade, nil)
+		return
+	}
+
+	res := &Response{
+		StatusCode: http.StatusSwitchingProtocols,
+		Header:     make(http.Header, len(header)+3),
+	}
+	for k, vs := range header {
+		res.Header[k] = vs
+	}
+	res.Header.Set("Upgrade", "websocket")
+	res.Header.Set("Connection", "Upgrade")
+	res.Header.Set("Sec-WebSocket-Accept", websocket.MakeResponseKey([]byte(w.websocketKey)))
+
+	w.onUpgrade = cb
+	w.Respond(res)
+}
+
+// IsWebsocketUpgrade returns true if the request is a valid WebSocket upgrade request. RFC6455 4.2.1.
+func IsWebsocketUpgrade(req *Request) bool {
+	return req.Method == http.MethodGet &&
+		req.ProtoMinor == 1 &&
+		hasToken(req.Header["Upgrade"], "websocket") &&
+		hasToken(req.Header["Connection"], "upgrade") &&
+		req.Header.Get("Sec-Websocket-Version") == "13" &&
+		req.Header.Get("Sec-Websocket-Key") != ""
+}
+
+// serverConn is a connection accepted by a Server. It reads requests until either SetMaxPipelined responses are
+// pending, a WebSocket upgrade request is pending, or the connection is to be closed. Responses are encoded in the
+// write buffer in order and written one write at a time.
+type serverConn struct {
+	server *Server
+	stream sonic.Stream
+	codec  *ServerCodec
+	conn   *sonic.CodecConn[*Response, *Request]
+	src    *sonic.ByteBuffer
+	dst    *sonic.ByteBuffer
+	timer  *sonic.Timer
+
+	pending []*ResponseWriter // in the order of the requests
+
+	reading    bool
+	writing    bool
+	stop       bool            // no more requests are read
+	closeAfter bool            // the connection is closed once the write buffer is drained
+	upgrading  bool            // reading is paused until the response to an upgrade request is sent
+	upgrade    *ResponseWriter // upgrades the connection once the write buffer is drained
+	closed     bool
+	idleSince  time.Time
+}
+
+func newServerConn(s *Server, stream sonic.Stream) (*serverConn, error) {
+	timer, err := sonic.NewTimer(s.ioc)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &serverConn{
+		server:    s,
+		stream:    stream,
+		codec:     NewServerCodec(),
+		src:       sonic.NewByteBuffer(),
+		dst:       sonic.NewByteBuffer(),
+		timer:     timer,
+		idleSince: time.Now(),
+	}
+	c.codec.SetMaxHeaderBytes(s.maxHeaderBytes)
+	c.codec.SetMaxBodyBytes(s.maxBodyBytes)
+
+	c.conn, err = sonic.NewCodecConn[*Response, *Request](stream, c.codec, c.src, c.dst)
+	if err != nil {
+		_ = timer.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *serverConn) read() {
+	if c.closed || c.reading || c.stop || c.upgrading || len(c.pending) >= c.server.maxPipelined {
+		return
+	}
+	c.reading = true
+	c.schedule()
+	c.conn.AsyncReadNext(c.onRead)
+}
+
+func (c *serverConn) onRead(err error, req *Request) {
+	c.reading = false
+	if c.closed {
+		return
+	}
+
+	if err != nil {
+		c.stop = true
+
+		var status int
+		switch {
+		case errors.Is(err, ErrHeaderTooLarge):
+			status = http.StatusRequestHeaderFieldsTooLarge
+		case errors.Is(err, ErrBodyTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, ErrMalformedMessage):
+			status = http.StatusBadRequest
+		}
+		if status != 0 {
+			w := &ResponseWriter{conn: c}
+			c.pending = append(c.pending, w)
+			w.Respond(&Response{StatusCode: status, Close: true})
+		} else {
+			c.flush() // closes the connection once the pending responses are sent
+		}
+		return
+	}
+
+	w := &ResponseWriter{conn: c}
+	if IsWebsocketUpgrade(req) {
+		w.websocketKey = req.Header.Get("Sec-Websocket-Key")
+		c.upgrading = true
+	}
+	if req.Close {
+		c.stop = true
+	}
+	c.pending = append(c.pending, w)
+
+	c.server.handler.ServeHTTP(w, req)
+
+	c.read()
+}
+
+// flush encodes the responses which are next in order and writes them.
+func (c *serverConn) flush() {
+	if c.closed {
+		return
+	}
+
+	for len(c.pending) > 0 && c.pending[0].res != nil && !c.closeAfter && c.upgrade == nil {
+		w := c.pending[0]
+		c.pending = c.pending[1:]
+
+		if err := c.codec.Encode(w.res, c.dst); err != nil {
+			w.res = &Response{StatusCode: http.StatusInternalServerError, Close: true}
+			w.onUpgrade = nil
+			_ = c.codec.Encode(w.res, c.dst)
+		}
+
+		switch {
+		case w.res.Close:
+			c.stop = true
+			c.closeAfter = true
+		case w.onUpgrade != nil:
+			c.stop = true
+			c.upgrade = w
+		case w.websocketKey != "":
+			c.upgrading = false // the upgrade was declined
+		}
+	}
+
+	if len(c.pending) == 0 {
+		c.idleSince = time.Now()
+	}
+
+	if !c.writing {
+		c.write()
+	}
+}
+
+func (c *serverConn) write() {
+	if c.dst.ReadLen() > 0 {
+		c.writing = true
+		c.dst.AsyncWriteTo(c.stream, func(err error, _ int) {
+			if c.closed {
+				return
+			}
+			if err != nil {
+				c.close()
+				return
+			}
+			c.write()
+		})
+		return
+	}
+	c.writing = false
+
+	switch {
+	case c.upgrade != nil:
+		c.upgradeWebsocket()
+	case c.closeAfter || (c.stop && len(c.pending) == 0):
+		c.close()
+	default:
+		c.read()
+		c.schedule()
+	}
+}
+
+// upgradeWebsocket hands the connection over to a websocket.Stream, along with any bytes received after the upgrade
+// request.
+func (c *serverConn) upgradeWebsocket() {
+	cb := c.upgrade.onUpgrade
+	c.detach()
+
+	c.codec.parser.begin(c.src)
+	ws, err := websocket.NewWebsocketStream(c.server.ioc, nil, websocket.RoleServer)
+	if err == nil {
+		err = ws.Accept(c.stream, c.src.Data())
+	}
+	if err != nil {
+		_ = c.stream.Close()
+		cb(err, nil)
+		return
+	}
+	cb(nil, ws)
+}
+
+// schedule arms the timer for the idle timeout if no responses are pending.
+func (c *serverConn) schedule() {
+	_ = c.timer.Cancel()
+	if c.closed || len(c.pending) > 0 || c.server.idleTimeout <= 0 {
+		return
+	}
+
+	delay := time.Until(c.idleSince.Add(c.server.idleTimeout))
+	if delay <= 0 {
+		delay = time.Nanosecond // never run the callback in place
+	}
+	_ = c.timer.ScheduleOnce(delay, c.onTimer)
+}
+
+func (c *serverConn) onTimer() {
+	if c.closed || len(c.pending) > 0 || c.writing {
+		return
+	}
+	if time.Since(c.idleSince) >= c.server.idleTimeout {
+		c.close()
+	} else {
+		c.schedule()
+	}
+}
+
+// detach releases the connection's resources without closing its stream.
+func (c *serverConn) detach() {
+	c.closed = true
+	c.pending = nil
+	_ = c.timer.Close()
+	delete(c.server.conns, c)
+}
+
+func (c *serverConn) close() {
+	if c.closed {
+		return
+	}
+	c.detach()
+	_ = c.stream.Close()
+}
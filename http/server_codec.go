@@ -0,0 +1,234 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+var _ sonic.Codec[*Response, *Request] = &ServerCodec{}
+
+// ServerCodec decodes requests and encodes the responses to them. It is meant to be used with a sonic.CodecConn.
+//
+// Responses must be encoded in the order in which the requests were decoded. The decoded Request is reused across
+// calls to Decode, and its body is only valid until the next call.
+type ServerCodec struct {
+	parser parser
+
+	exchanges []exchange // requests decoded whose responses have not been encoded yet, in order
+
+	request    Request
+	headerDone bool
+
+	// The Date header, formatted at most once per second.
+	date     []byte
+	dateUnix int64
+}
+
+// exchange holds what is needed from a request to encode its response.
+type exchange struct {
+	method     string
+	protoMinor int
+	close      bool
+}
+
+func NewServerCodec() *ServerCodec {
+	return &ServerCodec{
+		parser:  newParser(),
+		request: Request{Header: make(http.Header)},
+	}
+}
+
+// SetMaxHeaderBytes bounds the size of a request's header section. Larger requests fail with ErrHeaderTooLarge.
+func (c *ServerCodec) SetMaxHeaderBytes(n int) {
+	c.parser.maxHeaderBytes = n
+}
+
+// SetMaxBodyBytes bounds the size of a request's body. Larger requests fail with ErrBodyTooLarge.
+func (c *ServerCodec) SetMaxBodyBytes(n int) {
+	c.parser.maxBodyBytes = n
+}
+
+// Pending returns the number of requests decoded whose responses have not been encoded yet.
+func (c *ServerCodec) Pending() int {
+	return len(c.exchanges)
+}
+
+func (c *ServerCodec) Decode(src sonic.Buffer) (*Request, error) {
+	c.parser.begin(src)
+
+	if !c.headerDone {
+		block, err := c.parser.header(src)
+		if err != nil {
+			return nil, err
+		}
+
+		c.resetRequest()
+		requestLine, err := parseHeader(block, c.request.Header)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.parseRequestLine(requestLine); err != nil {
+			return nil, err
+		}
+		if err := c.parser.framing(c.request.Header, false); err != nil {
+			return nil, err
+		}
+		c.headerDone = true
+	}
+
+	body, err := c.parser.body(src)
+	if err != nil {
+		return nil, err
+	}
+	c.headerDone = false
+
+	req := &c.request
+	req.Body = body
+	req.Host = req.Header.Get("Host")
+	req.Close = closeAfter(req.ProtoMinor, req.Header)
+
+	c.exchanges = append(c.exchanges, exchange{
+		method:     req.Method,
+		protoMinor: req.ProtoMinor,
+		close:      req.Close,
+	})
+
+	return req, nil
+}
+
+func (c *ServerCodec) resetRequest() {
+	r := &c.request
+	r.Method = ""
+	r.URL = nil
+	r.Host = ""
+	for k := range r.Header {
+		delete(r.Header, k)
+	}
+	r.Body = nil
+	r.ProtoMinor = 0
+	r.Close = false
+}
+
+func (c *ServerCodec) parseRequestLine(line string) error {
+	// method SP request-target SP HTTP/1.x
+	i := strings.IndexByte(line, ' ')
+	j := strings.LastIndexByte(line, ' ')
+	if i <= 0 || j <= i || !validToken(line[:i]) {
+		return ErrMalformedMessage
+	}
+
+	switch line[j+1:] {
+	case "HTTP/1.0":
+		c.request.ProtoMinor = 0
+	case "HTTP/1.1":
+		c.request.ProtoMinor = 1
+	default:
+		return ErrMalformedMessage
+	}
+
+	u, err := url.ParseRequestURI(line[i+1 : j])
+	if err != nil {
+		return ErrMalformedMessage
+	}
+
+	c.request.Method = line[:i]
+	c.request.URL = u
+	return nil
+}
+
+// Encode encodes the response to the oldest request decoded. The connection must be closed once the response is sent
+// if either the request or the response asked for it, which is reported by Response.Close being set.
+func (c *ServerCodec) Encode(res *Response, dst sonic.Buffer) error {
+	for k, vs := range res.Header {
+		if !validToken(k) {
+			return ErrMalformedMessage
+		}
+		for _, v := range vs {
+			if !validHeaderValue(v) {
+				return ErrMalformedMessage
+			}
+		}
+	}
+	if res.StatusCode < 100 || res.StatusCode > 999 || !validHeaderValue(res.Status) {
+		return ErrMalformedMessage
+	}
+
+	// Responses to requests which could not be decoded are encoded as if they answered a GET.
+	ex := exchange{method: http.MethodGet, protoMinor: 1}
+	if len(c.exchanges) > 0 {
+		ex = c.exchanges[0]
+	}
+	if ex.close || hasToken(res.Header["Connection"], "close") {
+		res.Close = true
+	}
+
+	status := res.Status
+	if status == "" {
+		status = http.StatusText(res.StatusCode)
+	}
+
+	w := messageWriter{dst: dst, start: dst.WriteLen()}
+	w.writeString("HTTP/1.1 ")
+	w.writeString(strconv.Itoa(res.StatusCode))
+	w.writeString(" ")
+	w.writeString(status)
+	w.write(crlf)
+
+	if _, ok := res.Header["Date"]; !ok {
+		w.writeString("Date: ")
+		w.write(c.formatDate())
+		w.write(crlf)
+	}
+
+	noBody := res.StatusCode < 200 || res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified
+	for k, vs := range res.Header {
+		switch {
+		case k == "Transfer-Encoding":
+			continue
+		case k == "Content-Length" && (noBody || ex.method != http.MethodHead):
+			// Set from the body, except for HEAD responses which may advertise the length of the omitted body.
+			continue
+		}
+		for _, v := range vs {
+			w.writeHeader(k, v)
+		}
+	}
+
+	if !noBody && (ex.method != http.MethodHead || len(res.Header["Content-Length"]) == 0) {
+		w.writeHeader("Content-Length", strconv.Itoa(len(res.Body)))
+	}
+	if res.Close && !hasToken(res.Header["Connection"], "close") {
+		w.writeHeader("Connection", "close")
+	} else if !res.Close && ex.protoMinor == 0 && res.StatusCode != http.StatusSwitchingProtocols {
+		w.writeHeader("Connection", "keep-alive")
+	}
+	w.write(crlf)
+
+	if !noBody && ex.method != http.MethodHead {
+		w.write(res.Body)
+	}
+	if err := w.finish(); err != nil {
+		return err
+	}
+
+	dst.Commit(dst.WriteLen())
+	if len(c.exchanges) > 0 {
+		c.exchanges = c.exchanges[1:]
+	}
+
+	return nil
+}
+
+func (c *ServerCodec) formatDate() []byte {
+	now := time.Now()
+	if unix := now.Unix(); unix != c.dateUnix || c.date == nil {
+		c.dateUnix = unix
+		c.date = now.UTC().AppendFormat(c.date[:0], http.TimeFormat)
+	}
+	return c.date
+}
@@ -0,0 +1,118 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestServerCodecDecode(t *testing.T) {
+	assert := assert.New(t)
+
+	codec := NewServerCodec()
+	src := sonic.NewByteBuffer()
+
+	raw := "POST /path?q=1 HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello"
+	for i := 0; i < len(raw)-1; i++ {
+		_ = src.WriteByte(raw[i])
+		_, err := codec.Decode(src)
+		assert.Equal(sonicerrors.ErrNeedMore, err)
+	}
+	_ = src.WriteByte(raw[len(raw)-1])
+
+	req, err := codec.Decode(src)
+	assert.Nil(err)
+	assert.Equal(http.MethodPost, req.Method)
+	assert.Equal("/path", req.URL.Path)
+	assert.Equal("q=1", req.URL.RawQuery)
+	assert.Equal("example.com", req.Host)
+	assert.Equal(1, req.ProtoMinor)
+	assert.Equal("hello", string(req.Body))
+	assert.False(req.Close)
+	assert.Equal(1, codec.Pending())
+
+	// Pipelined requests, the second being chunked. HTTP/1.0 requests close the connection by default.
+	_, _ = src.WriteString("GET /a HTTP/1.1\r\n\r\n" +
+		"PUT /b HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n")
+
+	req, err = codec.Decode(src)
+	assert.Nil(err)
+	assert.Equal("/a", req.URL.Path)
+	assert.Len(req.Body, 0)
+
+	req, err = codec.Decode(src)
+	assert.Nil(err)
+	assert.Equal("/b", req.URL.Path)
+	assert.Equal("abc", string(req.Body))
+	assert.True(req.Close)
+	assert.Equal(3, codec.Pending())
+}
+
+func TestServerCodecDecodeErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	decode := func(codec *ServerCodec, b string) error {
+		src := sonic.NewByteBuffer()
+		_, _ = src.WriteString(b)
+		_, err := codec.Decode(src)
+		return err
+	}
+
+	assert.Equal(ErrMalformedMessage, decode(NewServerCodec(), "GET / HTTP/2.0\r\n\r\n"))
+	assert.Equal(ErrMalformedMessage, decode(NewServerCodec(), "GET\r\n\r\n"))
+	assert.Equal(ErrMalformedMessage, decode(NewServerCodec(), "G(T / HTTP/1.1\r\n\r\n"))
+	assert.Equal(ErrMalformedMessage, decode(NewServerCodec(), "GET x HTTP/1.1\r\n\r\n"))
+	assert.Equal(ErrMalformedMessage, decode(NewServerCodec(), "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n"))
+
+	codec := NewServerCodec()
+	codec.SetMaxHeaderBytes(32)
+	assert.Equal(ErrHeaderTooLarge, decode(codec, "GET / HTTP/1.1\r\nX-Long: "+strings.Repeat("a", 32)))
+
+	codec = NewServerCodec()
+	codec.SetMaxBodyBytes(4)
+	assert.Equal(ErrBodyTooLarge, decode(codec, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\n"))
+}
+
+func TestServerCodecEncode(t *testing.T) {
+	assert := assert.New(t)
+
+	codec := NewServerCodec()
+	src := sonic.NewByteBuffer()
+	_, _ = src.WriteString("HEAD / HTTP/1.1\r\n\r\nGET / HTTP/1.0\r\n\r\nGET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	for i := 0; i < 3; i++ {
+		_, err := codec.Decode(src)
+		assert.Nil(err)
+	}
+
+	header := http.Header{"Date": {"today"}}
+	encode := func(res *Response) string {
+		dst := sonic.NewByteBuffer()
+		assert.Nil(codec.Encode(res, dst))
+		return string(dst.Data())
+	}
+
+	// The response to HEAD advertises the length of the body without sending it.
+	res := &Response{StatusCode: 200, Header: header, Body: []byte("hello")}
+	assert.Equal("HTTP/1.1 200 OK\r\nDate: today\r\nContent-Length: 5\r\n\r\n", encode(res))
+	assert.False(res.Close)
+
+	res = &Response{StatusCode: 404, Status: "Nope", Header: http.Header{"Date": {"today"}}}
+	assert.Equal("HTTP/1.1 404 Nope\r\nDate: today\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", encode(res))
+	assert.True(res.Close)
+
+	res = &Response{StatusCode: 204, Header: http.Header{"Date": {"today"}}}
+	assert.Equal("HTTP/1.1 204 No Content\r\nDate: today\r\nConnection: keep-alive\r\n\r\n", encode(res))
+	assert.Equal(0, codec.Pending())
+
+	// The Date header is added if missing.
+	assert.Contains(encode(&Response{StatusCode: 200}), "\r\nDate: ")
+
+	assert.Equal(ErrMalformedMessage, codec.Encode(
+		&Response{StatusCode: 200, Header: http.Header{"X-Bad": {"a\nb"}}},
+		sonic.NewByteBuffer(),
+	))
+}
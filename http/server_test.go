@@ -0,0 +1,386 @@
+package http
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/codec/websocket"
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// startServer runs a Server in its own goroutine until the test ends and returns its address. The handler is created
+// with the server's IO object, on the server's goroutine.
+func startServer(
+	t *testing.T,
+	tlsConfig *tls.Config,
+	newHandler func(ioc *sonic.IO) Handler,
+	configure ...func(*Server),
+) string {
+	ioc := sonic.MustIO()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:0", sonicopts.Nonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := internal.SocketAddress(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(ioc, ln, tlsConfig, newHandler(ioc))
+	for _, fn := range configure {
+		fn(srv)
+	}
+	srv.Serve()
+
+	var stop int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for atomic.LoadInt32(&stop) == 0 {
+			_ = ioc.RunOneFor(10 * time.Millisecond)
+		}
+		_ = srv.Close()
+		_ = ioc.Close()
+	}()
+	t.Cleanup(func() {
+		atomic.StoreInt32(&stop, 1)
+		<-done
+	})
+
+	return addr.String()
+}
+
+func handler(f func(w *ResponseWriter, req *Request)) func(*sonic.IO) Handler {
+	return func(*sonic.IO) Handler { return HandlerFunc(f) }
+}
+
+func echo(w *ResponseWriter, req *Request) {
+	w.Respond(&Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"X-Method": {req.Method}},
+		Body:       []byte(req.URL.RequestURI() + " " + string(req.Body)),
+	})
+}
+
+func TestServerRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := startServer(t, nil, handler(echo))
+
+	res, err := http.Get("http://" + addr + "/path?q=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	assert.Equal(200, res.StatusCode)
+	assert.Equal("GET", res.Header.Get("X-Method"))
+	assert.NotEmpty(res.Header.Get("Date"))
+	assert.Equal("/path?q=1 ", string(body))
+
+	res, err = http.Post("http://"+addr+"/post", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	assert.Equal("/post hello", string(body))
+
+	// Bodies of unknown length are chunked by the client.
+	res, err = http.Post("http://"+addr+"/chunked", "text/plain", io.MultiReader(
+		strings.NewReader("hello "),
+		strings.NewReader("world"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	assert.Equal("/chunked hello world", string(body))
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := startServer(t, nil, handler(echo))
+
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.CloseIdleConnections()
+
+	reused := 0
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/%d", addr, i), nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if info.Reused {
+					reused++
+				}
+			},
+		}))
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		assert.Equal(fmt.Sprintf("/%d ", i), string(body))
+	}
+	assert.Equal(9, reused)
+}
+
+func TestServerPipelining(t *testing.T) {
+	assert := assert.New(t)
+
+	// Requests are answered in reverse order once all are received, yet responses are sent in order.
+	const n = 4
+	addr := startServer(t, nil, func(ioc *sonic.IO) Handler {
+		var writers []*ResponseWriter
+		var paths []string
+		return HandlerFunc(func(w *ResponseWriter, req *Request) {
+			writers = append(writers, w)
+			paths = append(paths, req.URL.Path)
+			if len(writers) == n {
+				for i := n - 1; i >= 0; i-- {
+					writers[i].Respond(&Response{StatusCode: http.StatusOK, Body: []byte(paths[i])})
+				}
+			}
+		})
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "GET /%d HTTP/1.1\r\nHost: test\r\n\r\n", i)
+	}
+	_, err = conn.Write([]byte(b.String()))
+	assert.Nil(err)
+
+	rd := bufio.NewReader(conn)
+	for i := 0; i < n; i++ {
+		res, err := http.ReadResponse(rd, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(fmt.Sprintf("/%d", i), string(body))
+	}
+}
+
+func TestServerAsyncResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := startServer(t, nil, func(ioc *sonic.IO) Handler {
+		return HandlerFunc(func(w *ResponseWriter, req *Request) {
+			timer, err := sonic.NewTimer(ioc)
+			if err != nil {
+				w.Respond(&Response{StatusCode: http.StatusInternalServerError})
+				return
+			}
+			_ = timer.ScheduleOnce(10*time.Millisecond, func() {
+				_ = timer.Close()
+				w.Respond(&Response{StatusCode: http.StatusAccepted, Body: []byte("later")})
+			})
+		})
+	})
+
+	res, err := http.Get("http://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	assert.Equal(http.StatusAccepted, res.StatusCode)
+	assert.Equal("later", string(body))
+}
+
+func TestServerLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := startServer(t, nil, handler(echo), func(s *Server) {
+		s.SetMaxHeaderBytes(1024)
+		s.SetMaxBodyBytes(16)
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr, nil)
+	req.Header.Set("X-Long", strings.Repeat("a", 2048))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	assert.Equal(http.StatusRequestHeaderFieldsTooLarge, res.StatusCode)
+	assert.True(res.Close)
+
+	res, err = http.Post("http://"+addr, "text/plain", strings.NewReader(strings.Repeat("a", 17)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	assert.Equal(http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	// Malformed requests are answered with 400 Bad Request.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nBad Header\r\n\r\n"))
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	addr := startServer(t, nil, handler(echo), func(s *Server) {
+		s.SetIdleTimeout(20 * time.Millisecond)
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The server closes the connection.
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServerTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	// Borrow the certificate of httptest.
+	ts := httptest.NewTLSServer(nil)
+	serverConfig := ts.TLS.Clone()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	ts.Close()
+
+	addr := startServer(t, serverConfig, handler(echo))
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	defer client.CloseIdleConnections()
+
+	_, port, _ := net.SplitHostPort(addr)
+	res, err := client.Get("https://127.0.0.1:" + port + "/secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	assert.Equal("/secure ", string(body))
+	assert.NotNil(res.TLS)
+}
+
+func TestServerWebsocketUpgrade(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := startServer(t, nil, handler(func(w *ResponseWriter, req *Request) {
+		if req.URL.Path != "/ws" {
+			echo(w, req)
+			return
+		}
+
+		w.UpgradeWebsocket(http.Header{"X-Upgraded": {"yes"}}, func(err error, ws *websocket.Stream) {
+			if err != nil {
+				return
+			}
+			b := make([]byte, 1024)
+			var onMessage func(error, int, websocket.MessageType)
+			onMessage = func(err error, n int, mt websocket.MessageType) {
+				if err != nil {
+					return
+				}
+				ws.AsyncWrite(b[:n], mt, func(err error) {
+					if err == nil {
+						ws.AsyncNextMessage(b, onMessage)
+					}
+				})
+			}
+			ws.AsyncNextMessage(b, onMessage)
+		})
+	}))
+
+	// Requests which are not upgrade requests cannot be upgraded.
+	res, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	assert.Equal(200, res.StatusCode)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := websocket.NewWebsocketStream(ioc, nil, websocket.RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upgraded string
+	ws.SetUpgradeResponseCallback(func(res *http.Response) {
+		upgraded = res.Header.Get("X-Upgraded")
+	})
+
+	done := false
+	ws.AsyncHandshake("ws://"+addr+"/ws", func(err error) {
+		assert.Nil(err)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+	assert.Equal("yes", upgraded)
+
+	for _, msg := range []string{"hello", "world"} {
+		done = false
+		ws.AsyncWrite([]byte(msg), websocket.TypeText, func(err error) { assert.Nil(err) })
+		b := make([]byte, 1024)
+		ws.AsyncNextMessage(b, func(err error, n int, mt websocket.MessageType) {
+			assert.Nil(err)
+			assert.Equal(websocket.TypeText, mt)
+			assert.Equal(msg, string(b[:n]))
+			done = true
+		})
+		runUntil(t, ioc, &done)
+	}
+}
+
+func TestIsWebsocketUpgrade(t *testing.T) {
+	req := &Request{
+		Method:     http.MethodGet,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               {"WebSocket"},
+			"Connection":            {"keep-alive, Upgrade"},
+			"Sec-Websocket-Version": {"13"},
+			"Sec-Websocket-Key":     {websocket.MakeRequestKey()},
+		},
+	}
+	assert.True(t, IsWebsocketUpgrade(req))
+
+	req.Header.Set("Sec-Websocket-Version", "8")
+	assert.False(t, IsWebsocketUpgrade(req))
+}
@@ -7,6 +7,7 @@ type EventType int8
 const (
 	ReadEvent EventType = iota
 	WriteEvent
+	ErrorEvent
 	MaxEvent
 )
 
@@ -72,6 +73,13 @@ type Poller interface {
 	// DelWrite deregisters interest in write events on the provided slot.
 	DelWrite(slot *Slot) error
 
+	// SetError registers interest in error events on the provided slot, which
+	// signal that the slot's socket has messages in its error queue.
+	SetError(slot *Slot) error
+
+	// DelError deregisters interest in error events on the provided slot.
+	DelError(slot *Slot) error
+
 	// Del deregisters interest in all events on the provided slot.
 	Del(slot *Slot) error
 
@@ -22,8 +22,14 @@ type PollerEvent int16
 const (
 	PollerReadEvent  = -PollerEvent(syscall.EVFILT_READ)
 	PollerWriteEvent = -PollerEvent(syscall.EVFILT_WRITE)
+
+	// PollerErrorEvent is never reported, as sockets do not have an error
+	// queue on BSD.
+	PollerErrorEvent = PollerEvent(1 << 14)
 )
 
+var errErrorEventUnsupported = errors.New("error events are not supported by kqueue")
+
 func init() {
 	// The read and write events are used to set/unset bits in a Slot's event mask. We dispatch the read/write handler
 	// based on this event mask, so we must ensure they don't overlap.
@@ -270,6 +276,14 @@ func (p *poller) DelWrite(slot *Slot) error {
 	return nil
 }
 
+func (p *poller) SetError(*Slot) error {
+	return errErrorEventUnsupported
+}
+
+func (p *poller) DelError(*Slot) error {
+	return nil
+}
+
 func (p *poller) Del(slot *Slot) error {
 	err := p.DelRead(slot)
 	if err == nil {
@@ -20,6 +20,11 @@ type PollerEvent uint32
 const (
 	PollerReadEvent  = PollerEvent(syscall.EPOLLIN)
 	PollerWriteEvent = PollerEvent(syscall.EPOLLOUT)
+
+	// PollerErrorEvent is always reported by epoll, it does not need to be in
+	// the event mask. It is set in a Slot's event mask only to dispatch the
+	// error handler.
+	PollerErrorEvent = PollerEvent(syscall.EPOLLERR)
 )
 
 func init() {
@@ -197,6 +202,12 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 			_ = p.DelWrite(slot)
 			slot.Handlers[WriteEvent](nil)
 		}
+
+		if events&slot.Events&PollerErrorEvent == PollerErrorEvent {
+			// TODO this errors should be reported
+			_ = p.DelError(slot)
+			slot.Handlers[ErrorEvent](nil)
+		}
 	}
 
 	return n, nil
@@ -227,6 +238,10 @@ func (p *poller) SetWrite(slot *Slot) error {
 	return p.setRW(slot.Fd, slot, PollerWriteEvent)
 }
 
+func (p *poller) SetError(slot *Slot) error {
+	return p.setRW(slot.Fd, slot, PollerErrorEvent)
+}
+
 func (p *poller) setRW(fd int, slot *Slot, flag PollerEvent) error {
 	events := &slot.Events
 	if *events&flag != flag {
@@ -279,7 +294,10 @@ func (p *poller) modify(fd int, event Event) error {
 func (p *poller) Del(slot *Slot) error {
 	err := p.DelRead(slot)
 	if err == nil {
-		return p.DelWrite(slot)
+		err = p.DelWrite(slot)
+	}
+	if err == nil {
+		return p.DelError(slot)
 	}
 	return nil
 }
@@ -310,6 +328,19 @@ func (p *poller) DelWrite(slot *Slot) error {
 	return nil
 }
 
+func (p *poller) DelError(slot *Slot) error {
+	events := &slot.Events
+	if *events&PollerErrorEvent == PollerErrorEvent {
+		p.pending--
+		*events ^= PollerErrorEvent
+		if *events != 0 {
+			return p.modify(slot.Fd, createEvent(*events, slot))
+		}
+		return p.del(slot.Fd)
+	}
+	return nil
+}
+
 func (p *poller) del(fd int) error {
 	_, _, errno := syscall.Syscall6(
 		syscall.SYS_EPOLL_CTL,
@@ -194,6 +194,11 @@ func connect(fd int, remoteAddr net.Addr, timeout time.Duration, opts ...sonicop
 		// Retry the select syscall if interrupted
 	}
 
+	return ConnectResult(fd)
+}
+
+// ConnectResult returns the outcome of a nonblocking connect on fd, once fd is writable.
+func ConnectResult(fd int) error {
 	socketErr, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
 	if err != nil {
 		return os.NewSyscallError("getsockopt", err)
@@ -211,6 +216,54 @@ func connect(fd int, remoteAddr net.Addr, timeout time.Duration, opts ...sonicop
 	return nil
 }
 
+// ConnectNonblocking starts connecting to the specified endpoint without waiting for the connection to be established.
+//
+// If inProgress is true, the connection is not established yet: fd becomes writable once it is, or once it fails, at
+// which point ConnectResult returns the outcome. The address is resolved synchronously.
+func ConnectNonblocking(
+	network, addr string,
+	opts ...sonicopts.Option,
+) (fd int, remoteAddr net.Addr, inProgress bool, err error) {
+	switch network[:3] {
+	case "tcp":
+		fd, remoteAddr, err = CreateSocketTCP(network, addr, true)
+	case "udp":
+		fd, remoteAddr, err = CreateSocketUDP(network, addr)
+	case "uni":
+		return -1, nil, false, fmt.Errorf("unix domain not supported")
+	default:
+		return -1, nil, false, errUnknownNetwork
+	}
+	if err != nil {
+		return -1, nil, false, err
+	}
+
+	if err = ApplyOpts(fd, opts...); err == nil {
+		err = maybeBindBeforeConnect(fd, opts...)
+	}
+	for err == nil {
+		err = syscall.Connect(fd, ToSockaddr(remoteAddr))
+		if errors.Is(err, syscall.EINTR) {
+			err = nil
+			continue
+		}
+		if errors.Is(err, syscall.EINPROGRESS) || errors.Is(err, syscall.EAGAIN) {
+			return fd, remoteAddr, true, nil
+		}
+		if err == nil {
+			return fd, remoteAddr, false, nil
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			err = sonicerrors.ErrConnRefused
+		} else {
+			err = os.NewSyscallError("connect", err)
+		}
+	}
+
+	_ = syscall.Close(fd)
+	return -1, nil, false, err
+}
+
 func ConnectTCP(
 	network, addr string,
 	timeout time.Duration,
@@ -355,8 +408,12 @@ func ApplyOpts(fd int, opts ...sonicopts.Option) error {
 				return os.NewSyscallError(fmt.Sprintf("tcp_no_delay(%v)", v), err)
 			}
 		case sonicopts.TypeBindSocket:
-			addr := opt.Value().(net.Addr)
-			return syscall.Bind(fd, ToSockaddr(addr))
+			// Bound by maybeBindBeforeConnect, once all other options are applied. A socket can only be bound once.
+		case sonicopts.TypeZeroCopy:
+			v := opt.Value().(bool)
+			if err := setZeroCopy(fd, v); err != nil {
+				return os.NewSyscallError(fmt.Sprintf("zero_copy(%v)", v), err)
+			}
 		default:
 			return fmt.Errorf("unsupported socket option %s", t)
 		}
@@ -0,0 +1,15 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package internal
+
+import "syscall"
+
+func setZeroCopy(int, bool) error {
+	return syscall.ENOPROTOOPT
+}
+
+// ZeroCopyEnabled returns true if SO_ZEROCOPY is enabled on the socket, which
+// is never the case on BSD.
+func ZeroCopyEnabled(int) bool {
+	return false
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package internal
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func setZeroCopy(fd int, v bool) error {
+	iv := 0
+	if v {
+		iv = 1
+	}
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_ZEROCOPY, iv)
+}
+
+// ZeroCopyEnabled returns true if SO_ZEROCOPY is enabled on the socket.
+func ZeroCopyEnabled(fd int) bool {
+	v, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_ZEROCOPY)
+	return err == nil && v == 1
+}
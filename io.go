@@ -55,6 +55,10 @@ type IO struct {
 	//
 	// This counter is shared amongst all asynchronous objects - they are responsible for updating it.
 	Dispatched int
+
+	// Handlers registered with Defer, run at the end of the current dispatch cycle.
+	deferred    []func()
+	dispatching bool
 }
 
 func NewIO() (*IO, error) {
@@ -125,7 +129,20 @@ func (ioc *IO) UnsetWrite(slot *internal.Slot) error {
 	return ioc.poller.DelWrite(slot)
 }
 
-// UnsetRead and UnsetWrite in a single call.
+// SetError tells the kernel to notify us when the socket of the provided IO slot has messages in its error queue. If
+// successful, this call must be succeeded by Register(slot).
+//
+// It is safe to call this method multiple times.
+func (ioc *IO) SetError(slot *internal.Slot) error {
+	return ioc.poller.SetError(slot)
+}
+
+// Like UnsetRead but for errors.
+func (ioc *IO) UnsetError(slot *internal.Slot) error {
+	return ioc.poller.DelError(slot)
+}
+
+// UnsetRead, UnsetWrite and UnsetError in a single call.
 func (ioc *IO) UnsetReadWrite(slot *internal.Slot) error {
 	return ioc.poller.Del(slot)
 }
@@ -246,7 +263,10 @@ func (ioc *IO) PollOne() (n int, err error) {
 }
 
 func (ioc *IO) poll(timeoutMs int) (int, error) {
+	ioc.dispatching = true
 	n, err := ioc.poller.Poll(timeoutMs)
+	ioc.runDeferred()
+	ioc.dispatching = false
 
 	if err != nil {
 		if err == syscall.EINTR {
@@ -268,6 +288,30 @@ func (ioc *IO) poll(timeoutMs int) (int, error) {
 	return n, nil
 }
 
+// Defer schedules the provided handler to be run at the end of the current dispatch cycle, once the handlers of all
+// the events which occurred in the cycle ran. Handlers deferred outside a dispatch cycle run at the end of the next one.
+//
+// Defer is meant to coalesce work, such as flushing writes, triggered by many handlers of the same cycle. It must be
+// called from the IO's goroutine.
+func (ioc *IO) Defer(handler func()) {
+	ioc.deferred = append(ioc.deferred, handler)
+}
+
+// Dispatching returns true if called from a handler run by the event processing loop.
+func (ioc *IO) Dispatching() bool {
+	return ioc.dispatching
+}
+
+func (ioc *IO) runDeferred() {
+	// Deferred handlers may defer other handlers, which run in the same cycle.
+	for i := 0; i < len(ioc.deferred); i++ {
+		handler := ioc.deferred[i]
+		ioc.deferred[i] = nil
+		handler()
+	}
+	ioc.deferred = ioc.deferred[:0]
+}
+
 // Post schedules the provided handler to be run immediately by the event processing loop in its own thread.
 //
 // It is safe to call Post concurrently.
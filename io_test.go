@@ -54,6 +54,51 @@ func TestPost(t *testing.T) {
 	}
 }
 
+func TestDefer(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var events []string
+	if ioc.Dispatching() {
+		t.Fatal("expected not to be dispatching")
+	}
+
+	// Deferred outside a dispatch cycle: runs at the end of the next one.
+	ioc.Defer(func() { events = append(events, "outside") })
+
+	for i := 0; i < 2; i++ {
+		i := i
+		_ = ioc.Post(func() {
+			if !ioc.Dispatching() {
+				t.Fatal("expected to be dispatching")
+			}
+			events = append(events, "post")
+			if i == 0 {
+				ioc.Defer(func() {
+					events = append(events, "deferred")
+					ioc.Defer(func() { events = append(events, "nested") })
+				})
+			}
+		})
+	}
+
+	for ioc.Posted() > 0 {
+		if _, err := ioc.PollOne(); err != nil && err != sonicerrors.ErrTimeout {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"post", "post", "outside", "deferred", "nested"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("expected %v got %v", expected, events)
+		}
+	}
+}
+
 func TestEmptyPoll(t *testing.T) {
 	ioc := MustIO()
 	defer ioc.Close()
@@ -0,0 +1,445 @@
+//go:build linux
+
+package ipc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+var _ sonic.Stream = &Conn{}
+
+// ErrInvalidRing is returned when the other process left a ring in an invalid
+// state, holding more bytes than its size. The connection is then closed.
+var ErrInvalidRing = errors.New("invalid ipc ring")
+
+// The eventfds of a connection, in the order in which they are passed to the
+// attaching process, after the segment. Each ring has an eventfd waking up its
+// reader when data is written, and one waking up its writer when space is
+// freed.
+const (
+	ring0Data = iota
+	ring0Space
+	ring1Data
+	ring1Space
+	numEventFds
+)
+
+// Conn is one side of a connection over a shared memory segment. The process
+// which listens writes to the first ring of the segment and reads from the
+// second one. The attached process does the opposite.
+//
+// Read and Write do not block: they return sonicerrors.ErrWouldBlock when the
+// ring they operate on is empty, respectively full. The asynchronous operations
+// wait for the other process through eventfds registered with the sonic.IO.
+//
+// A Conn must only be used from the goroutine running its sonic.IO.
+type Conn struct {
+	ioc *sonic.IO
+	seg *segment
+	fds [numEventFds]int
+
+	// The ring read from, and its local head.
+	r     *ringHeader
+	rdata []byte
+	head  uint64
+
+	// The ring written to, and its local tail.
+	w     *ringHeader
+	wdata []byte
+	tail  uint64
+
+	size uint64
+
+	// Signaled by the other process: the read slot waits for data, the write
+	// slot waits for space.
+	readSlot  internal.Slot
+	writeSlot internal.Slot
+
+	// Signaled by us: the other process waits on them.
+	peerSpaceFd int
+	peerDataFd  int
+
+	readReactor  readReactor
+	writeReactor writeReactor
+
+	closed atomic.Bool
+}
+
+type readReactor struct {
+	conn *Conn
+
+	b         []byte
+	readAll   bool
+	cb        sonic.AsyncCallback
+	readSoFar int
+}
+
+func (r *readReactor) init(b []byte, readAll bool, cb sonic.AsyncCallback) {
+	r.b = b
+	r.readAll = readAll
+	r.cb = cb
+
+	r.readSoFar = 0
+}
+
+func (r *readReactor) onRead(err error) {
+	r.conn.ioc.Deregister(&r.conn.readSlot)
+	drain(r.conn.readSlot.Fd)
+	if err != nil {
+		r.cb(err, r.readSoFar)
+	} else {
+		r.conn.asyncReadNow(r.b, r.readSoFar, r.readAll, r.cb)
+	}
+}
+
+type writeReactor struct {
+	conn *Conn
+
+	b          []byte
+	writeAll   bool
+	cb         sonic.AsyncCallback
+	wroteSoFar int
+}
+
+func (r *writeReactor) init(b []byte, writeAll bool, cb sonic.AsyncCallback) {
+	r.b = b
+	r.writeAll = writeAll
+	r.cb = cb
+
+	r.wroteSoFar = 0
+}
+
+func (r *writeReactor) onWrite(err error) {
+	r.conn.ioc.Deregister(&r.conn.writeSlot)
+	drain(r.conn.writeSlot.Fd)
+	if err != nil {
+		r.cb(err, r.wroteSoFar)
+	} else {
+		r.conn.asyncWriteNow(r.b, r.wroteSoFar, r.writeAll, r.cb)
+	}
+}
+
+// newConn returns a side of the connection over the passed segment, taking
+// ownership of the segment and the eventfds. The listening process writes to
+// ring 0, the attached process writes to ring 1.
+func newConn(
+	ioc *sonic.IO,
+	seg *segment,
+	fds [numEventFds]int,
+	listening bool,
+) *Conn {
+	c := &Conn{
+		ioc:  ioc,
+		seg:  seg,
+		fds:  fds,
+		size: uint64(seg.size),
+	}
+
+	rd, wr := 1, 0
+	if !listening {
+		rd, wr = 0, 1
+	}
+	c.r, c.rdata = &seg.header.rings[rd], seg.data[rd]
+	c.w, c.wdata = &seg.header.rings[wr], seg.data[wr]
+
+	c.readSlot.Fd = fds[ring0Data+2*rd]
+	c.peerSpaceFd = fds[ring0Space+2*rd]
+	c.writeSlot.Fd = fds[ring0Space+2*wr]
+	c.peerDataFd = fds[ring0Data+2*wr]
+
+	// Both processes start with empty rings.
+	c.head = c.r.head.Load()
+	c.tail = c.w.tail.Load()
+
+	c.readReactor = readReactor{conn: c}
+	c.writeReactor = writeReactor{conn: c}
+
+	return c
+}
+
+// Read copies the bytes available in the ring read from into b. It returns
+// sonicerrors.ErrWouldBlock if there are none, and io.EOF if there are none and
+// the connection is closed. If the other process moved the tail of the ring
+// past its size, the connection is closed and ErrInvalidRing is returned.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, io.EOF
+	}
+
+	// Loading the flag before the tail ensures we read everything written
+	// before the other process closed.
+	closed := c.seg.header.closed.Load() == 1
+	available := c.r.tail.Load() - c.head
+	if available == 0 {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		if closed {
+			return 0, io.EOF
+		}
+		return 0, sonicerrors.ErrWouldBlock
+	}
+	if available > c.size {
+		_ = c.Close()
+		return 0, ErrInvalidRing
+	}
+	if available > uint64(len(b)) {
+		available = uint64(len(b))
+	}
+
+	start := c.head % c.size
+	n := copy(b, c.rdata[start:start+available])
+	c.head += uint64(n)
+	c.r.head.Store(c.head)
+	signal(&c.r.writerWaiting, c.peerSpaceFd)
+	return n, nil
+}
+
+// Write copies as much of b as fits in the ring written to. It returns
+// sonicerrors.ErrWouldBlock if the ring is full, and io.ErrClosedPipe if the
+// connection is closed. If the other process moved the head of the ring past
+// its tail, the connection is closed and ErrInvalidRing is returned.
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.closed.Load() || c.seg.header.closed.Load() == 1 {
+		return 0, io.ErrClosedPipe
+	}
+
+	used := c.tail - c.w.head.Load()
+	if used > c.size {
+		_ = c.Close()
+		return 0, ErrInvalidRing
+	}
+	free := c.size - used
+	if free == 0 {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		return 0, sonicerrors.ErrWouldBlock
+	}
+
+	start := c.tail % c.size
+	n := copy(c.wdata[start:start+free], b)
+	c.tail += uint64(n)
+	c.w.tail.Store(c.tail)
+	signal(&c.w.readerWaiting, c.peerDataFd)
+	return n, nil
+}
+
+func (c *Conn) readable() bool {
+	return c.r.tail.Load() != c.head || c.seg.header.closed.Load() == 1
+}
+
+func (c *Conn) writable() bool {
+	return c.tail-c.w.head.Load() < c.size || c.seg.header.closed.Load() == 1
+}
+
+func (c *Conn) AsyncRead(b []byte, cb sonic.AsyncCallback) {
+	c.asyncRead(b, false, cb)
+}
+
+func (c *Conn) AsyncReadAll(b []byte, cb sonic.AsyncCallback) {
+	c.asyncRead(b, true, cb)
+}
+
+func (c *Conn) asyncRead(b []byte, readAll bool, cb sonic.AsyncCallback) {
+	c.readReactor.init(b, readAll, cb)
+
+	if c.ioc.Dispatched < sonic.MaxCallbackDispatch {
+		c.asyncReadNow(b, 0, readAll, func(err error, n int) {
+			c.ioc.Dispatched++
+			cb(err, n)
+			c.ioc.Dispatched--
+		})
+	} else {
+		c.scheduleRead(0 /* this is the starting point, we did not read anything yet */, cb)
+	}
+}
+
+func (c *Conn) asyncReadNow(b []byte, readSoFar int, readAll bool, cb sonic.AsyncCallback) {
+	n, err := c.Read(b[readSoFar:])
+	readSoFar += n
+
+	if err == nil && !(readAll && readSoFar != len(b)) {
+		cb(nil, readSoFar)
+		return
+	}
+
+	if err == sonicerrors.ErrWouldBlock || (err == nil && readAll) {
+		c.scheduleRead(readSoFar, cb)
+	} else {
+		cb(err, readSoFar)
+	}
+}
+
+func (c *Conn) scheduleRead(readSoFar int, cb sonic.AsyncCallback) {
+	if c.Closed() {
+		cb(io.EOF, readSoFar)
+		return
+	}
+
+	c.readReactor.readSoFar = readSoFar
+	c.readReactor.cb = cb
+
+	// The other process may have written after our last read. In that case, or
+	// if we scheduled because too many callbacks were dispatched in place, we
+	// signal ourselves such that the read completes in the next dispatch cycle.
+	c.r.readerWaiting.Store(1)
+	if c.readable() {
+		c.r.readerWaiting.Store(0)
+		notify(c.readSlot.Fd)
+	}
+
+	c.readSlot.Set(internal.ReadEvent, c.readReactor.onRead)
+	if err := c.ioc.SetRead(&c.readSlot); err != nil {
+		c.r.readerWaiting.Store(0)
+		cb(err, readSoFar)
+	} else {
+		c.ioc.Register(&c.readSlot)
+	}
+}
+
+func (c *Conn) AsyncWrite(b []byte, cb sonic.AsyncCallback) {
+	c.asyncWrite(b, false, cb)
+}
+
+func (c *Conn) AsyncWriteAll(b []byte, cb sonic.AsyncCallback) {
+	c.asyncWrite(b, true, cb)
+}
+
+func (c *Conn) asyncWrite(b []byte, writeAll bool, cb sonic.AsyncCallback) {
+	c.writeReactor.init(b, writeAll, cb)
+
+	if c.ioc.Dispatched < sonic.MaxCallbackDispatch {
+		c.asyncWriteNow(b, 0, writeAll, func(err error, n int) {
+			c.ioc.Dispatched++
+			cb(err, n)
+			c.ioc.Dispatched--
+		})
+	} else {
+		c.scheduleWrite(0 /* this is the starting point, we did not write anything yet */, cb)
+	}
+}
+
+func (c *Conn) asyncWriteNow(b []byte, wroteSoFar int, writeAll bool, cb sonic.AsyncCallback) {
+	n, err := c.Write(b[wroteSoFar:])
+	wroteSoFar += n
+
+	if err == nil && !(writeAll && wroteSoFar != len(b)) {
+		cb(nil, wroteSoFar)
+		return
+	}
+
+	if err == sonicerrors.ErrWouldBlock || (err == nil && writeAll) {
+		c.scheduleWrite(wroteSoFar, cb)
+	} else {
+		cb(err, wroteSoFar)
+	}
+}
+
+func (c *Conn) scheduleWrite(wroteSoFar int, cb sonic.AsyncCallback) {
+	if c.Closed() {
+		cb(io.ErrClosedPipe, wroteSoFar)
+		return
+	}
+
+	c.writeReactor.wroteSoFar = wroteSoFar
+	c.writeReactor.cb = cb
+
+	// See scheduleRead.
+	c.w.writerWaiting.Store(1)
+	if c.writable() {
+		c.w.writerWaiting.Store(0)
+		notify(c.writeSlot.Fd)
+	}
+
+	c.writeSlot.Set(internal.ReadEvent, c.writeReactor.onWrite)
+	if err := c.ioc.SetRead(&c.writeSlot); err != nil {
+		c.w.writerWaiting.Store(0)
+		cb(err, wroteSoFar)
+	} else {
+		c.ioc.Register(&c.writeSlot)
+	}
+}
+
+// Cancel cancels the pending asynchronous reads and writes. Their callbacks are
+// invoked with sonicerrors.ErrCancelled.
+func (c *Conn) Cancel() {
+	c.cancel(&c.readSlot, &c.r.readerWaiting)
+	c.cancel(&c.writeSlot, &c.w.writerWaiting)
+}
+
+func (c *Conn) cancel(slot *internal.Slot, waiting *flag) {
+	if slot.Events&internal.PollerReadEvent == internal.PollerReadEvent {
+		waiting.Store(0)
+		err := c.ioc.UnsetRead(slot)
+		c.ioc.Deregister(slot)
+		if err == nil {
+			err = sonicerrors.ErrCancelled
+		}
+		slot.Handlers[internal.ReadEvent](err)
+	}
+}
+
+// Close closes the connection. The other process reads what was written
+// before, then io.EOF. Pending asynchronous operations are dropped.
+func (c *Conn) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return io.EOF
+	}
+
+	c.seg.header.closed.Store(1)
+	notify(c.peerDataFd)
+	notify(c.peerSpaceFd)
+
+	for _, slot := range []*internal.Slot{&c.readSlot, &c.writeSlot} {
+		_ = c.ioc.UnsetRead(slot)
+		c.ioc.Deregister(slot)
+	}
+
+	var err error
+	for _, fd := range c.fds {
+		if e := unix.Close(fd); e != nil && err == nil {
+			err = e
+		}
+	}
+	if e := c.seg.unmap(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func (c *Conn) Closed() bool {
+	return c.closed.Load()
+}
+
+// RawFd returns the eventfd signaled when there are bytes to read.
+func (c *Conn) RawFd() int {
+	return c.readSlot.Fd
+}
+
+// signal wakes up the other process if it sleeps on the passed eventfd.
+func signal(waiting *flag, fd int) {
+	if waiting.Load() == 1 && waiting.CompareAndSwap(1, 0) {
+		notify(fd)
+	}
+}
+
+func notify(fd int) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], 1)
+	_, _ = unix.Write(fd, b[:])
+}
+
+// drain resets the counter of the passed eventfd. It does not block.
+func drain(fd int) {
+	var b [8]byte
+	_, _ = unix.Read(fd, b[:])
+}
@@ -0,0 +1,284 @@
+//go:build linux
+
+package ipc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/codec/frame"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var pairs = 0
+
+// pair returns both sides of a connection, each on its own IO. Both IOs are
+// run from the test goroutine.
+func pair(t *testing.T, size int) (listening, attached *Conn) {
+	pairs++
+	name := fmt.Sprintf("test-%d-%d", syscall.Getpid(), pairs)
+
+	lioc := sonic.MustIO()
+	t.Cleanup(func() { lioc.Close() })
+	aioc := sonic.MustIO()
+	t.Cleanup(func() { aioc.Close() })
+
+	ln, err := Listen(lioc, name, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type dialed struct {
+		conn *Conn
+		err  error
+	}
+	ch := make(chan dialed, 1)
+	go func() {
+		conn, err := Dial(aioc, name)
+		ch <- dialed{conn, err}
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		listening, err = ln.Accept()
+		if err != sonicerrors.ErrWouldBlock {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listening.Close() })
+
+	d := <-ch
+	if d.err != nil {
+		t.Fatal(d.err)
+	}
+	t.Cleanup(func() { d.conn.Close() })
+	return listening, d.conn
+}
+
+// run runs the IOs of both sides until done returns true.
+func run(t *testing.T, done func() bool, conns ...*Conn) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		for _, conn := range conns {
+			if _, err := conn.ioc.PollOne(); err != nil &&
+				err != sonicerrors.ErrTimeout {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestConnReadWrite(t *testing.T) {
+	pageSize := syscall.Getpagesize()
+	a, b := pair(t, pageSize)
+
+	buf := make([]byte, 2*pageSize)
+	if _, err := b.Read(buf); err != sonicerrors.ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock, got %v", err)
+	}
+
+	// Bytes wrap around the end of the rings, in both directions.
+	for i := 0; i < 100; i++ {
+		for _, dir := range [][2]*Conn{{a, b}, {b, a}} {
+			w, r := dir[0], dir[1]
+			payload := bytes.Repeat([]byte{byte(i)}, 1+i*37%pageSize)
+			if n, err := w.Write(payload); err != nil || n != len(payload) {
+				t.Fatalf("write %d %v", n, err)
+			}
+			if n, err := r.Read(buf); err != nil ||
+				!bytes.Equal(buf[:n], payload) {
+				t.Fatalf("read %d %v", n, err)
+			}
+		}
+	}
+
+	// Writes are partial when the ring fills up.
+	n, err := a.Write(buf)
+	if err != nil || n != pageSize {
+		t.Fatalf("expected to write %d bytes, wrote %d %v", pageSize, n, err)
+	}
+	if _, err := a.Write(buf); err != sonicerrors.ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock, got %v", err)
+	}
+	if n, err := b.Read(buf[:10]); err != nil || n != 10 {
+		t.Fatalf("read %d %v", n, err)
+	}
+	if n, err := a.Write(buf); err != nil || n != 10 {
+		t.Fatalf("write %d %v", n, err)
+	}
+
+	// Closing lets the other side read what was written, then io.EOF.
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.Read(buf); err != nil || n != pageSize {
+		t.Fatalf("read %d %v", n, err)
+	}
+	if _, err := b.Read(buf); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if _, err := b.Write(buf); err != io.ErrClosedPipe {
+		t.Fatalf("expected io.ErrClosedPipe, got %v", err)
+	}
+}
+
+func TestConnInvalidRing(t *testing.T) {
+	pageSize := syscall.Getpagesize()
+	buf := make([]byte, pageSize)
+
+	// The other process moves the tail of the ring read from past its size.
+	a, b := pair(t, pageSize)
+	b.r.tail.Store(b.head + uint64(pageSize) + 1)
+	if _, err := b.Read(buf); err != ErrInvalidRing {
+		t.Fatalf("expected ErrInvalidRing, got %v", err)
+	}
+	if !b.Closed() {
+		t.Fatal("the connection should be closed")
+	}
+	if _, err := a.Read(buf); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	// The other process moves the head of the ring written to past its tail.
+	a, b = pair(t, pageSize)
+	a.w.head.Store(a.tail + 1)
+	if _, err := a.Write(buf); err != ErrInvalidRing {
+		t.Fatalf("expected ErrInvalidRing, got %v", err)
+	}
+	if !a.Closed() {
+		t.Fatal("the connection should be closed")
+	}
+	if _, err := b.Write(buf); err != io.ErrClosedPipe {
+		t.Fatalf("expected io.ErrClosedPipe, got %v", err)
+	}
+}
+
+func TestConnAsync(t *testing.T) {
+	a, b := pair(t, syscall.Getpagesize())
+
+	// Much more than the rings hold, so both sides wait for each other.
+	sent := make([]byte, 1024*1024)
+	rand.Read(sent)
+
+	var (
+		received = make([]byte, len(sent))
+		wrote    = 0
+		read     = 0
+	)
+	a.AsyncWriteAll(sent, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrote = n
+	})
+	b.AsyncReadAll(received, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = n
+	})
+	run(t, func() bool { return wrote == len(sent) && read == len(sent) }, a, b)
+	if !bytes.Equal(sent, received) {
+		t.Fatal("corrupt bytes")
+	}
+
+	// A pending read completes with io.EOF once the other side closes.
+	var result error
+	a.AsyncRead(received, func(err error, n int) {
+		result = err
+	})
+	_ = b.Close()
+	run(t, func() bool { return result != nil }, a)
+	if result != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", result)
+	}
+}
+
+func TestConnCancel(t *testing.T) {
+	a, _ := pair(t, syscall.Getpagesize())
+
+	var results []error
+	a.AsyncRead(make([]byte, 10), func(err error, n int) {
+		results = append(results, err)
+	})
+	a.AsyncWriteAll(make([]byte, 2*syscall.Getpagesize()), func(err error, n int) {
+		results = append(results, err)
+	})
+	_, _ = a.ioc.PollOne()
+	if len(results) != 0 {
+		t.Fatalf("operations should be pending %v", results)
+	}
+
+	a.Cancel()
+	if len(results) != 2 ||
+		!errors.Is(results[0], sonicerrors.ErrCancelled) ||
+		!errors.Is(results[1], sonicerrors.ErrCancelled) {
+		t.Fatalf("invalid results %v", results)
+	}
+}
+
+func TestConnCodec(t *testing.T) {
+	const frames = 10000
+
+	a, b := pair(t, syscall.Getpagesize())
+
+	newConn := func(stream sonic.Stream) *sonic.CodecConn[[]byte, []byte] {
+		src, dst := sonic.NewByteBuffer(), sonic.NewByteBuffer()
+		conn, err := sonic.NewCodecConn[[]byte, []byte](
+			stream, frame.NewCodec(src), src, dst,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	writer, reader := newConn(a), newConn(b)
+
+	wrote := 0
+	var onWrite sonic.AsyncCallback
+	onWrite = func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrote++
+		if wrote < frames {
+			writer.AsyncWriteNext(
+				bytes.Repeat([]byte{byte(wrote)}, 1+wrote%1000), onWrite)
+		}
+	}
+	writer.AsyncWriteNext([]byte{0}, onWrite)
+
+	decoded := 0
+	var onRead func(error, []byte)
+	onRead = func(err error, payload []byte) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := bytes.Repeat([]byte{byte(decoded)}, 1+decoded%1000)
+		if !bytes.Equal(payload, expected) {
+			t.Fatalf("corrupt frame %d", decoded)
+		}
+		decoded++
+		if decoded < frames {
+			reader.AsyncReadNext(onRead)
+		}
+	}
+	reader.AsyncReadNext(onRead)
+
+	run(t, func() bool { return decoded == frames }, a, b)
+}
@@ -0,0 +1,11 @@
+// Package ipc provides a transport between processes of the same host over
+// shared memory.
+//
+// A process listens under a name with Listen. Other processes attach to it by
+// name with Dial. Each attachment gets its own shared memory segment holding
+// two rings, one per direction, and exchanges bytes through them without
+// going through the kernel network stack. The resulting Conn is a sonic.Stream,
+// so codecs such as the ones of the codec package work on top of it.
+//
+// The package is only available on Linux.
+package ipc
@@ -0,0 +1,230 @@
+//go:build linux
+
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+var ErrInvalidHandshake = errors.New("invalid ipc handshake")
+
+type AcceptCallback func(error, *Conn)
+
+// Listener accepts the processes attaching to a name.
+//
+// The name is bound to an abstract unix socket, which only lives as long as
+// the Listener. For each attaching process, the Listener creates a shared
+// memory segment and eventfds and passes their descriptors over the socket,
+// such that nothing is left on the file system once both processes close
+// their Conn, even if they crash.
+type Listener struct {
+	ioc  *sonic.IO
+	slot internal.Slot
+	name string
+	size int
+}
+
+// Listen listens for processes attaching to the passed name. Each connection
+// gets two rings of at least the passed size, rounded up to a multiple of the
+// system's page size.
+func Listen(ioc *sonic.IO, name string, size int) (*Listener, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid ring size %d", size)
+	}
+
+	fd, err := unix.Socket(
+		unix.AF_UNIX,
+		unix.SOCK_SEQPACKET|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, address(name)); err != nil {
+		_ = unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		_ = unix.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+
+	return &Listener{
+		ioc:  ioc,
+		slot: internal.Slot{Fd: fd},
+		name: name,
+		size: size,
+	}, nil
+}
+
+// Accept accepts the next attaching process. It does not block: it returns
+// sonicerrors.ErrWouldBlock if no process is attaching.
+func (l *Listener) Accept() (*Conn, error) {
+	return l.accept()
+}
+
+func (l *Listener) AsyncAccept(cb AcceptCallback) {
+	if l.ioc.Dispatched >= sonic.MaxCallbackDispatch {
+		l.asyncAccept(cb)
+	} else {
+		conn, err := l.accept()
+		if err != nil && (err == sonicerrors.ErrWouldBlock) {
+			l.asyncAccept(cb)
+		} else {
+			l.ioc.Dispatched++
+			cb(err, conn)
+			l.ioc.Dispatched--
+		}
+	}
+}
+
+func (l *Listener) asyncAccept(cb AcceptCallback) {
+	l.slot.Set(internal.ReadEvent, l.handleAsyncAccept(cb))
+
+	if err := l.ioc.SetRead(&l.slot); err != nil {
+		cb(err, nil)
+	} else {
+		l.ioc.Register(&l.slot)
+	}
+}
+
+func (l *Listener) handleAsyncAccept(cb AcceptCallback) internal.Handler {
+	return func(err error) {
+		l.ioc.Deregister(&l.slot)
+
+		if err != nil {
+			cb(err, nil)
+		} else {
+			conn, err := l.accept()
+			cb(err, conn)
+		}
+	}
+}
+
+func (l *Listener) accept() (conn *Conn, err error) {
+	fd, _, err := unix.Accept4(l.slot.Fd, unix.SOCK_CLOEXEC)
+	if err != nil {
+		if err == unix.EWOULDBLOCK || err == unix.EAGAIN {
+			return nil, sonicerrors.ErrWouldBlock
+		}
+		return nil, os.NewSyscallError("accept", err)
+	}
+	// The socket is only used for the handshake.
+	defer unix.Close(fd)
+
+	seg, file, err := createSegment(l.size)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fds, err := newEventFds()
+	if err != nil {
+		_ = seg.unmap()
+		return nil, err
+	}
+
+	rights := unix.UnixRights(append([]int{int(file.Fd())}, fds[:]...)...)
+	if err := unix.Sendmsg(fd, []byte{segmentVersion}, rights, nil, 0); err != nil {
+		closeFds(fds[:])
+		_ = seg.unmap()
+		return nil, os.NewSyscallError("sendmsg", err)
+	}
+
+	return newConn(l.ioc, seg, fds, true), nil
+}
+
+func (l *Listener) Close() error {
+	_ = l.ioc.UnsetRead(&l.slot)
+	l.ioc.Deregister(&l.slot)
+	return unix.Close(l.slot.Fd)
+}
+
+func (l *Listener) Name() string {
+	return l.name
+}
+
+func (l *Listener) RawFd() int {
+	return l.slot.Fd
+}
+
+// Dial attaches to the process listening on the passed name. It blocks until
+// that process accepts the connection.
+func Dial(ioc *sonic.IO, name string) (*Conn, error) {
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Connect(fd, address(name)); err != nil {
+		if err == unix.ECONNREFUSED || err == unix.ENOENT {
+			return nil, sonicerrors.ErrConnRefused
+		}
+		return nil, os.NewSyscallError("connect", err)
+	}
+
+	var (
+		b   [1]byte
+		oob = make([]byte, unix.CmsgSpace((1+numEventFds)*4))
+	)
+	n, oobn, _, _, err := unix.Recvmsg(fd, b[:], oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("recvmsg", err)
+	}
+
+	var received []int
+	if msgs, err := unix.ParseSocketControlMessage(oob[:oobn]); err == nil {
+		for _, msg := range msgs {
+			if rights, err := unix.ParseUnixRights(&msg); err == nil {
+				received = append(received, rights...)
+			}
+		}
+	}
+	if n != 1 || b[0] != segmentVersion || len(received) != 1+numEventFds {
+		closeFds(received)
+		return nil, ErrInvalidHandshake
+	}
+
+	file := os.NewFile(uintptr(received[0]), "sonic-ipc")
+	defer file.Close()
+
+	seg, err := openSegment(file)
+	if err != nil {
+		closeFds(received[1:])
+		return nil, err
+	}
+
+	var fds [numEventFds]int
+	copy(fds[:], received[1:])
+	return newConn(ioc, seg, fds, false), nil
+}
+
+// address returns the abstract unix socket address of the passed name.
+func address(name string) *unix.SockaddrUnix {
+	return &unix.SockaddrUnix{Name: "@sonic-ipc/" + name}
+}
+
+func newEventFds() (fds [numEventFds]int, err error) {
+	for i := range fds {
+		fds[i], err = unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+		if err != nil {
+			closeFds(fds[:i])
+			return fds, os.NewSyscallError("eventfd", err)
+		}
+	}
+	return fds, nil
+}
+
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		_ = unix.Close(fd)
+	}
+}
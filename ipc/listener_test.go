@@ -0,0 +1,213 @@
+//go:build linux
+
+package ipc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+const helperEnv = "SONIC_IPC_HELPER"
+
+func TestListener(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	name := fmt.Sprintf("listener-%d", syscall.Getpid())
+	if _, err := Dial(ioc, name); err != sonicerrors.ErrConnRefused {
+		t.Fatalf("expected ErrConnRefused, got %v", err)
+	}
+
+	ln, err := Listen(ioc, name, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(ioc, name, 1); err == nil {
+		t.Fatal("a name can only be listened on once")
+	}
+	if _, err := ln.Accept(); err != sonicerrors.ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock, got %v", err)
+	}
+
+	// Processes attaching to the same name get their own connection.
+	const n = 3
+	go func() {
+		dioc := sonic.MustIO()
+		defer dioc.Close()
+		for i := 0; i < n; i++ {
+			conn, err := Dial(dioc, name)
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte{byte(i)})
+			_ = conn.Close()
+		}
+	}()
+
+	var conns []*Conn
+	var onAccept AcceptCallback
+	onAccept = func(err error, conn *Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.size != uint64(syscall.Getpagesize()) {
+			t.Fatalf("invalid ring size %d", conn.size)
+		}
+		conns = append(conns, conn)
+		if len(conns) < n {
+			ln.AsyncAccept(onAccept)
+		}
+	}
+	ln.AsyncAccept(onAccept)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(conns) < n && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if len(conns) != n {
+		t.Fatalf("accepted %d connections, expected %d", len(conns), n)
+	}
+
+	// Each byte is read from the connection it was written to, even though
+	// the dialing goroutine closed it.
+	for i, conn := range conns {
+		b := make([]byte, 2)
+		for {
+			n, err := conn.Read(b)
+			if err == sonicerrors.ErrWouldBlock {
+				continue
+			}
+			if err != nil || n != 1 || b[0] != byte(i) {
+				t.Fatalf("invalid read %d %v %v", n, err, b)
+			}
+			break
+		}
+		_ = conn.Close()
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Dial(ioc, name); err != sonicerrors.ErrConnRefused {
+		t.Fatalf("expected ErrConnRefused, got %v", err)
+	}
+}
+
+// TestProcesses echoes bytes through another process, which is this test
+// binary running TestHelperProcess.
+func TestProcesses(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	name := fmt.Sprintf("processes-%d", syscall.Getpid())
+	ln, err := Listen(ioc, name, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+	cmd.Env = append(os.Environ(), helperEnv+"="+name)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cmd.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var conn *Conn
+	ln.AsyncAccept(func(err error, c *Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = c
+	})
+	deadline := time.Now().Add(10 * time.Second)
+	for conn == nil && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if conn == nil {
+		t.Fatal("the helper process did not attach")
+	}
+
+	sent := bytes.Repeat([]byte("sonic"), 10000)
+	received := make([]byte, len(sent))
+	wrote, read := false, false
+	conn.AsyncWriteAll(sent, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrote = true
+	})
+	conn.AsyncReadAll(received, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = true
+	})
+	for !(wrote && read) && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatal("corrupt echo")
+	}
+
+	// The helper exits once it reads io.EOF.
+	_ = conn.Close()
+}
+
+func TestHelperProcess(t *testing.T) {
+	name := os.Getenv(helperEnv)
+	if name == "" {
+		t.Skip("only run by TestProcesses")
+	}
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	b := make([]byte, 1024)
+	done := false
+	var onRead sonic.AsyncCallback
+	onRead = func(err error, n int) {
+		if err == io.EOF {
+			done = true
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.AsyncWriteAll(b[:n], func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.AsyncRead(b, onRead)
+		})
+	}
+	conn.AsyncRead(b, onRead)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !done && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if !done {
+		t.Fatal("timed out")
+	}
+}
@@ -0,0 +1,203 @@
+//go:build linux
+
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/talostrading/sonic/bytes"
+)
+
+var ErrInvalidSegment = errors.New("invalid shared memory segment")
+
+const (
+	segmentMagic   = 0x63706963696e6f73 // "sonicipc" in little endian
+	segmentVersion = 1
+
+	cacheLineSize = 64
+)
+
+type cacheLinePad [cacheLineSize]byte
+
+// cursor is a position in a ring shared between processes. It is alone on its
+// cache line, such that updating it does not invalidate the cache line of the
+// other cursors (false sharing).
+type cursor struct {
+	_ cacheLinePad
+	atomic.Uint64
+	_ cacheLinePad
+}
+
+// flag is set by a side of a ring before it sleeps, such that the other side
+// knows it must wake it up.
+type flag struct {
+	_ cacheLinePad
+	atomic.Uint32
+	_ cacheLinePad
+}
+
+// ringHeader is the shared state of the ring carrying the bytes of one
+// direction.
+//
+// The writer only advances the tail and the reader only advances the head. A
+// side which cannot make progress sets its waiting flag, checks the ring once
+// more and then sleeps on its eventfd. The other side signals the eventfd only
+// if the flag is set, after advancing its cursor. With sequentially consistent
+// atomics, either the sleeper sees the new cursor, or the other side sees the
+// flag.
+type ringHeader struct {
+	tail          cursor
+	head          cursor
+	readerWaiting flag
+	writerWaiting flag
+}
+
+// header is the first page of a segment.
+type header struct {
+	magic   uint64
+	version uint64
+	size    uint64
+
+	// Set by the first side closing the connection.
+	closed atomic.Uint32
+
+	_     cacheLinePad
+	rings [2]ringHeader
+}
+
+// segment is a shared memory file holding a header page followed by the data
+// of two rings of the same size. The data of each ring is mapped twice, back to
+// back, like a bytes.MirroredBuffer, such that the bytes of the ring are always
+// contiguous, even when they wrap around the end of the ring.
+type segment struct {
+	header *header
+	page   []byte
+	data   [2][]byte
+	size   int
+}
+
+// createSegment creates a segment whose rings are of at least the passed
+// size. The returned file is already removed from the file system: it only
+// lives through its descriptor, which is passed to the other process.
+func createSegment(size int) (s *segment, file *os.File, err error) {
+	pageSize := syscall.Getpagesize()
+	if remainder := size % pageSize; remainder > 0 {
+		size += pageSize - remainder
+	}
+	if size <= 0 {
+		return nil, nil, fmt.Errorf("invalid ring size %d", size)
+	}
+
+	directory := "/dev/shm"
+	if _, err = os.Stat(directory); os.IsNotExist(err) {
+		directory = ""
+	}
+	file, err = os.CreateTemp(directory, "sonic-ipc-")
+	if err != nil {
+		return nil, nil, err
+	}
+	_ = os.Remove(file.Name())
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			file = nil
+		}
+	}()
+
+	if err = file.Truncate(int64(pageSize + 2*size)); err != nil {
+		return nil, nil, err
+	}
+
+	s, err = mapSegment(file, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.header.magic = segmentMagic
+	s.header.version = segmentVersion
+	s.header.size = uint64(size)
+	return s, file, nil
+}
+
+// openSegment maps the segment created by another process.
+func openSegment(file *os.File) (*segment, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := (int(stat.Size()) - syscall.Getpagesize()) / 2
+
+	s, err := mapSegment(file, size)
+	if err != nil {
+		return nil, err
+	}
+	if s.header.magic != segmentMagic ||
+		s.header.version != segmentVersion ||
+		s.header.size != uint64(size) {
+		_ = s.unmap()
+		return nil, ErrInvalidSegment
+	}
+	return s, nil
+}
+
+func mapSegment(file *os.File, size int) (s *segment, err error) {
+	pageSize := syscall.Getpagesize()
+	if unsafe.Sizeof(header{}) > uintptr(pageSize) {
+		return nil, fmt.Errorf("segment header does not fit in a page")
+	}
+	if size <= 0 || size%pageSize != 0 {
+		return nil, ErrInvalidSegment
+	}
+
+	s = &segment{size: size}
+	defer func() {
+		if err != nil {
+			_ = s.unmap()
+		}
+	}()
+
+	s.page, err = syscall.Mmap(
+		int(file.Fd()),
+		0,
+		pageSize,
+		syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_SHARED,
+	)
+	if err != nil {
+		return nil, err
+	}
+	/* #nosec G103 -- the use of unsafe has been audited */
+	s.header = (*header)(unsafe.Pointer(&s.page[0]))
+
+	for i := range s.data {
+		offset := int64(pageSize + i*size)
+		s.data[i], err = bytes.MapMirrored(file, offset, size, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *segment) unmap() (err error) {
+	for i, data := range s.data {
+		if data != nil {
+			if e := syscall.Munmap(data); e != nil && err == nil {
+				err = e
+			}
+			s.data[i] = nil
+		}
+	}
+	if s.page != nil {
+		if e := syscall.Munmap(s.page); e != nil && err == nil {
+			err = e
+		}
+		s.page = nil
+		s.header = nil
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// FromEnvironment returns the URL of the proxy to use when connecting to the
+// given target, as configured by the environment. It returns nil if no proxy
+// should be used.
+//
+// The proxy is taken from HTTPS_PROXY for https and wss targets, from
+// HTTP_PROXY for http and ws targets, and from ALL_PROXY if the former are not
+// set. The lowercase versions of these variables take precedence. A proxy
+// without a scheme is assumed to be an HTTP proxy.
+//
+// Targets matched by NO_PROXY are not proxied. NO_PROXY is a comma-separated
+// list of:
+//   - "*", which matches all targets
+//   - IP addresses and CIDR blocks, matching IP targets
+//   - domain names, matching the domain and all its subdomains; a leading "."
+//     or "*." is ignored
+//
+// Each entry can carry a port, in which case it matches only targets on that
+// port.
+//
+// FromEnvironment can be passed directly to websocket.Stream.SetProxy.
+func FromEnvironment(target *url.URL) (*url.URL, error) {
+	var raw string
+	switch target.Scheme {
+	case "https", "wss":
+		raw = getenv("HTTPS_PROXY")
+	case "http", "ws":
+		raw = getenv("HTTP_PROXY")
+	}
+	if raw == "" {
+		raw = getenv("ALL_PROXY")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	if !useProxy(target, getenv("NO_PROXY")) {
+		return nil, nil
+	}
+
+	proxy, err := url.Parse(raw)
+	if err != nil || proxy.Scheme == "" || proxy.Host == "" {
+		// Values like "localhost:3128" parse with "localhost" as the scheme.
+		if proxy, err = url.Parse("http://" + raw); err != nil {
+			return nil, err
+		}
+	}
+	return proxy, nil
+}
+
+func getenv(key string) string {
+	if v := os.Getenv(strings.ToLower(key)); v != "" {
+		return v
+	}
+	return os.Getenv(key)
+}
+
+func useProxy(target *url.URL, noProxy string) bool {
+	host := strings.ToLower(target.Hostname())
+	port := target.Port()
+	if port == "" {
+		switch target.Scheme {
+		case "https", "wss":
+			port = "443"
+		case "http", "ws":
+			port = "80"
+		}
+	}
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return false
+		}
+
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return false
+			}
+			continue
+		}
+
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return false
+			}
+			continue
+		}
+
+		entryHost = strings.TrimPrefix(entryHost, "*")
+		entryHost = strings.TrimPrefix(entryHost, ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return false
+		}
+	}
+
+	return true
+}
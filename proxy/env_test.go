@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func clearEnv(t *testing.T) {
+	for _, key := range []string{"HTTPS_PROXY", "HTTP_PROXY", "ALL_PROXY", "NO_PROXY"} {
+		t.Setenv(key, "")
+		t.Setenv(strings.ToLower(key), "")
+	}
+}
+
+func fromEnvironment(t *testing.T, target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := FromEnvironment(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy == nil {
+		return ""
+	}
+	return proxy.String()
+}
+
+func TestFromEnvironmentScheme(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("HTTPS_PROXY", "http://secure:3128")
+	t.Setenv("HTTP_PROXY", "plain:3128")
+
+	if p := fromEnvironment(t, "wss://example.com"); p != "http://secure:3128" {
+		t.Fatalf("wss proxy=%s", p)
+	}
+	if p := fromEnvironment(t, "https://example.com"); p != "http://secure:3128" {
+		t.Fatalf("https proxy=%s", p)
+	}
+	if p := fromEnvironment(t, "ws://example.com"); p != "http://plain:3128" {
+		t.Fatalf("ws proxy=%s", p)
+	}
+}
+
+func TestFromEnvironmentLowercaseWins(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("HTTPS_PROXY", "http://upper:3128")
+	t.Setenv("https_proxy", "socks5h://lower:1080")
+
+	if p := fromEnvironment(t, "wss://example.com"); p != "socks5h://lower:1080" {
+		t.Fatalf("proxy=%s", p)
+	}
+}
+
+func TestFromEnvironmentAllProxy(t *testing.T) {
+	clearEnv(t)
+	if p := fromEnvironment(t, "wss://example.com"); p != "" {
+		t.Fatalf("expected no proxy got=%s", p)
+	}
+
+	t.Setenv("ALL_PROXY", "socks5://all:1080")
+	if p := fromEnvironment(t, "wss://example.com"); p != "socks5://all:1080" {
+		t.Fatalf("proxy=%s", p)
+	}
+}
+
+func TestFromEnvironmentNoProxy(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("HTTPS_PROXY", "http://proxy:3128")
+	t.Setenv("NO_PROXY", " internal.com, .corp.net,*.lan, 10.0.0.0/8, 192.168.1.1, api.com:8443 ")
+
+	cases := []struct {
+		target  string
+		proxied bool
+	}{
+		{"wss://example.com", true},
+		{"wss://internal.com", false},
+		{"wss://md.internal.com", false},
+		{"wss://notinternal.com", true},
+		{"wss://corp.net", false},
+		{"wss://x.corp.net", false},
+		{"wss://host.lan", false},
+		{"wss://10.1.2.3", false},
+		{"wss://11.1.2.3", true},
+		{"wss://192.168.1.1:9000", false},
+		{"wss://192.168.1.2", true},
+		{"wss://api.com:8443", false},
+		{"wss://api.com", true},
+	}
+	for _, c := range cases {
+		p := fromEnvironment(t, c.target)
+		if proxied := p != ""; proxied != c.proxied {
+			t.Fatalf("target=%s expected proxied=%v given=%v", c.target, c.proxied, proxied)
+		}
+	}
+
+	t.Setenv("NO_PROXY", "*")
+	if p := fromEnvironment(t, "wss://example.com"); p != "" {
+		t.Fatalf("expected no proxy got=%s", p)
+	}
+}
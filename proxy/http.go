@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// MaxHTTPResponseLength is the maximum length of the response to an HTTP
+// CONNECT request, including the status line and headers.
+const MaxHTTPResponseLength = 8192
+
+var headerTerminator = []byte("\r\n\r\n")
+
+var _ handshaker = &httpConnect{}
+
+// httpConnect asks an HTTP proxy to open a tunnel with a CONNECT request.
+//
+// The proxy's response is read in chunks which never go past the end of the
+// response headers, so no byte belonging to the tunnel is consumed during the
+// handshake.
+type httpConnect struct {
+	req []byte
+
+	res   []byte
+	chunk [4]byte
+	last  []byte
+}
+
+func newHTTPConnect(addr string, user *url.Userinfo) *httpConnect {
+	req := bytes.NewBuffer(nil)
+	fmt.Fprintf(req, "CONNECT %s HTTP/1.1\r\n", addr)
+	fmt.Fprintf(req, "Host: %s\r\n", addr)
+	if user != nil {
+		password, _ := user.Password()
+		credentials := user.Username() + ":" + password
+		fmt.Fprintf(req,
+			"Proxy-Authorization: Basic %s\r\n",
+			base64.StdEncoding.EncodeToString([]byte(credentials)),
+		)
+	}
+	fmt.Fprintf(req, "\r\n")
+
+	return &httpConnect{req: req.Bytes()}
+}
+
+func (h *httpConnect) begin() step {
+	h.res = h.res[:0]
+	h.last = h.chunk[:len(headerTerminator)]
+	return step{write: h.req, read: h.last}
+}
+
+func (h *httpConnect) advance() (step, error) {
+	h.res = append(h.res, h.last...)
+	if len(h.res) > MaxHTTPResponseLength {
+		return step{}, ErrResponseTooLong
+	}
+
+	matched := matchedTerminator(h.res)
+	if matched == len(headerTerminator) {
+		return step{}, h.parse()
+	}
+
+	// We can read len(headerTerminator)-matched more bytes without going past
+	// the end of the headers.
+	h.last = h.chunk[:len(headerTerminator)-matched]
+	return step{read: h.last}, nil
+}
+
+// matchedTerminator returns the length of the longest prefix of the header
+// terminator which b ends with.
+func matchedTerminator(b []byte) int {
+	for n := len(headerTerminator); n > 0; n-- {
+		if bytes.HasSuffix(b, headerTerminator[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+func (h *httpConnect) parse() error {
+	res, err := http.ReadResponse(
+		bufio.NewReader(bytes.NewReader(h.res)),
+		&http.Request{Method: http.MethodConnect},
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusProxyAuthRequired:
+		return ErrAuthFailed
+	case res.StatusCode/100 != 2:
+		return fmt.Errorf("%w status=%s", ErrConnectFailed, res.Status)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,275 @@
+// Package proxy implements the client side of the HTTP CONNECT and SOCKS5
+// proxy handshakes.
+//
+// A handshake is driven either synchronously over an io.ReadWriter, which is
+// what the websocket client uses while dialing, or asynchronously over any
+// sonic.AsyncReadWriter, such as a sonic.Conn.
+//
+// Proxies are described by URLs:
+//   - http://[user:password@]host[:port] - HTTP CONNECT with optional basic
+//     authentication. The port defaults to 80.
+//   - socks5://[user:password@]host[:port] - SOCKS5 with optional
+//     username/password authentication. The target's host is resolved locally
+//     by Handshake. AsyncHandshake does not block on DNS, so it leaves the
+//     resolution to the proxy, as with socks5h://. The port defaults to 1080.
+//   - socks5h://[user:password@]host[:port] - like socks5://, except the
+//     target's host is always resolved by the proxy.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+var (
+	ErrUnsupportedScheme = errors.New("unsupported proxy scheme")
+	ErrInvalidTarget     = errors.New("invalid target address")
+	ErrInvalidResponse   = errors.New("invalid proxy response")
+	ErrResponseTooLong   = errors.New("proxy response too long")
+	ErrAuthFailed        = errors.New("proxy authentication failed")
+	ErrConnectFailed     = errors.New("proxy could not connect to target")
+)
+
+// step is the next unit of work of a handshake: the bytes in write, if any,
+// must be written fully, after which exactly len(read) bytes must be read into
+// read. The handshake is done when read is nil.
+type step struct {
+	write []byte
+	read  []byte
+}
+
+// handshaker is a proxy handshake state machine which does no IO itself.
+type handshaker interface {
+	// begin returns the first step of the handshake.
+	begin() step
+
+	// advance is called after the read of the previous step completed. It
+	// returns the next step.
+	advance() (step, error)
+}
+
+// newHandshaker returns the handshake with the passed proxy. If resolve is true,
+// the SOCKS5 handshake resolves the target's host locally, which blocks.
+func newHandshaker(proxy *url.URL, addr string, resolve bool) (handshaker, error) {
+	host, port, err := splitTarget(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proxy.Scheme {
+	case "http":
+		return newHTTPConnect(net.JoinHostPort(host, port), proxy.User), nil
+	case "socks5", "socks5h":
+		return newSOCKS5(host, port, proxy.User, proxy.Scheme == "socks5h" || !resolve)
+	default:
+		return nil, fmt.Errorf("%w scheme=%s", ErrUnsupportedScheme, proxy.Scheme)
+	}
+}
+
+func splitTarget(addr string) (host, port string, err error) {
+	host, port, err = net.SplitHostPort(addr)
+	if err != nil || host == "" || port == "" {
+		return "", "", fmt.Errorf("%w addr=%s", ErrInvalidTarget, addr)
+	}
+	return host, port, nil
+}
+
+// Addr returns the host:port at which the proxy described by the given URL
+// listens, filling in the scheme's default port if the URL has none.
+func Addr(proxy *url.URL) string {
+	port := proxy.Port()
+	if port == "" {
+		switch proxy.Scheme {
+		case "http":
+			port = "80"
+		case "socks5", "socks5h":
+			port = "1080"
+		}
+	}
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
+
+// Handshake asks the proxy at the other end of rw to open a tunnel to addr,
+// which must be of the form host:port.
+//
+// This call blocks until the tunnel is established or an error occurs. After
+// a successful handshake, bytes written to rw go to addr and bytes read from
+// rw come from addr.
+func Handshake(rw io.ReadWriter, proxy *url.URL, addr string) error {
+	h, err := newHandshaker(proxy, addr, true)
+	if err != nil {
+		return err
+	}
+
+	s := h.begin()
+	for {
+		if len(s.write) > 0 {
+			if _, err := rw.Write(s.write); err != nil {
+				return err
+			}
+		}
+
+		if s.read == nil {
+			return nil
+		}
+
+		if _, err := io.ReadFull(rw, s.read); err != nil {
+			return err
+		}
+
+		if s, err = h.advance(); err != nil {
+			return err
+		}
+	}
+}
+
+// AsyncHandshake is like Handshake but asynchronous.
+//
+// This call does not block. The provided callback is invoked when the tunnel
+// is established or an error occurs. The target's host is never resolved
+// locally: resolve it beforehand to tunnel to a specific address through a
+// socks5:// proxy.
+func AsyncHandshake(
+	stream sonic.AsyncReadWriter,
+	proxy *url.URL,
+	addr string,
+	cb func(error),
+) {
+	h, err := newHandshaker(proxy, addr, false)
+	if err != nil {
+		cb(err)
+		return
+	}
+	asyncHandshake(stream, h, h.begin(), cb)
+}
+
+func asyncHandshake(
+	stream sonic.AsyncReadWriter,
+	h handshaker,
+	s step,
+	cb func(error),
+) {
+	if len(s.write) > 0 {
+		stream.AsyncWriteAll(s.write, func(err error, _ int) {
+			if err != nil {
+				cb(err)
+			} else {
+				asyncHandshake(stream, h, step{read: s.read}, cb)
+			}
+		})
+		return
+	}
+
+	if s.read == nil {
+		cb(nil)
+		return
+	}
+
+	stream.AsyncReadAll(s.read, func(err error, _ int) {
+		if err == nil {
+			s, err = h.advance()
+		}
+		if err != nil {
+			cb(err)
+		} else {
+			asyncHandshake(stream, h, s, cb)
+		}
+	})
+}
+
+// AsyncDial connects to the proxy described by the given URL and then asks it
+// to open a tunnel to addr, which must be of the form host:port.
+//
+// This call does not block, except on resolving the proxy's host, see
+// sonic.AsyncDial. The provided callback is invoked with a connection to addr
+// when the tunnel is established, or with an error otherwise. If timeout is
+// positive, it bounds both the connection to the proxy and the handshake: once
+// it expires, the connection is closed and the callback is invoked with
+// sonicerrors.ErrTimeout.
+//
+// As with AsyncHandshake, the target's host is resolved by the proxy.
+func AsyncDial(
+	ioc *sonic.IO,
+	proxy *url.URL,
+	network, addr string,
+	timeout time.Duration,
+	cb func(error, sonic.Conn),
+	opts ...sonicopts.Option,
+) {
+	h, err := newHandshaker(proxy, addr, false)
+	if err != nil {
+		cb(err, nil)
+		return
+	}
+
+	d := &asyncDialer{cb: cb}
+	if timeout > 0 {
+		d.timer, err = sonic.NewTimer(ioc)
+		if err == nil {
+			err = d.timer.ScheduleOnce(timeout, d.onTimeout)
+		}
+		if err != nil {
+			d.finish(err, nil)
+			return
+		}
+	}
+
+	sonic.AsyncDial(ioc, network, Addr(proxy), timeout, func(err error, conn sonic.Conn) {
+		if d.done {
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			d.finish(err, nil)
+			return
+		}
+
+		d.conn = conn
+		asyncHandshake(conn, h, h.begin(), func(err error) {
+			if !d.done {
+				d.finish(err, conn)
+			}
+		})
+	}, opts...)
+}
+
+// asyncDialer is the state of AsyncDial. Completions which follow a timeout
+// are ignored.
+type asyncDialer struct {
+	timer *sonic.Timer
+	conn  sonic.Conn
+	cb    func(error, sonic.Conn)
+	done  bool
+}
+
+func (d *asyncDialer) onTimeout() {
+	if !d.done {
+		d.finish(sonicerrors.ErrTimeout, nil)
+	}
+}
+
+// finish closes the connection if dialing failed and invokes the callback.
+func (d *asyncDialer) finish(err error, conn sonic.Conn) {
+	d.done = true
+	if d.timer != nil {
+		_ = d.timer.Close()
+	}
+	if err != nil {
+		if d.conn != nil {
+			_ = d.conn.Close()
+		}
+		d.cb(err, nil)
+	} else {
+		d.cb(nil, conn)
+	}
+}
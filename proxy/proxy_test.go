@@ -0,0 +1,412 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// echoServer accepts one connection and echoes back everything it reads.
+func echoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+	return ln.Addr().String()
+}
+
+func tunnel(client net.Conn, addr string) {
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	go func() {
+		_, _ = io.Copy(target, client)
+	}()
+	_, _ = io.Copy(client, target)
+}
+
+// httpProxyServer is a stand-in HTTP CONNECT proxy which serves one tunnel. If
+// credentials is not empty, clients must authenticate with them.
+func httpProxyServer(t *testing.T, credentials string) *url.URL {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect {
+			_, _ = fmt.Fprintf(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return
+		}
+		if credentials != "" {
+			expected := "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+			if req.Header.Get("Proxy-Authorization") != expected {
+				_, _ = fmt.Fprintf(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+				return
+			}
+		}
+		_, _ = fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\nX-Proxy: stand-in\r\n\r\n")
+		tunnel(conn, req.Host)
+	}()
+	return &url.URL{Scheme: "http", Host: ln.Addr().String()}
+}
+
+// socks5ProxyServer is a stand-in SOCKS5 proxy which serves one tunnel. If
+// username is not empty, clients must authenticate with username/password.
+// Domain targets are recorded in domain.
+func socks5ProxyServer(t *testing.T, username, password string, domain chan<- string) *url.URL {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, 512)
+		if _, err := io.ReadFull(conn, b[:2]); err != nil {
+			return
+		}
+		methods := b[2 : 2+int(b[1])]
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return
+		}
+
+		want := byte(socks5AuthNone)
+		if username != "" {
+			want = socks5AuthPassword
+		}
+		found := false
+		for _, m := range methods {
+			found = found || m == want
+		}
+		if !found {
+			_, _ = conn.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+			return
+		}
+		_, _ = conn.Write([]byte{socks5Version, want})
+
+		if username != "" {
+			_, _ = io.ReadFull(conn, b[:2])
+			u := make([]byte, b[1])
+			_, _ = io.ReadFull(conn, u)
+			_, _ = io.ReadFull(conn, b[:1])
+			p := make([]byte, b[0])
+			_, _ = io.ReadFull(conn, p)
+			if string(u) != username || string(p) != password {
+				_, _ = conn.Write([]byte{socks5PasswordVersion, 0x01})
+				return
+			}
+			_, _ = conn.Write([]byte{socks5PasswordVersion, 0x00})
+		}
+
+		if _, err := io.ReadFull(conn, b[:4]); err != nil {
+			return
+		}
+		var host string
+		switch b[3] {
+		case socks5AddrIPv4:
+			_, _ = io.ReadFull(conn, b[:4])
+			host = net.IP(b[:4]).String()
+		case socks5AddrDomain:
+			_, _ = io.ReadFull(conn, b[:1])
+			d := make([]byte, b[0])
+			_, _ = io.ReadFull(conn, d)
+			host = string(d)
+			if domain != nil {
+				domain <- host
+			}
+		default:
+			return
+		}
+		_, _ = io.ReadFull(conn, b[:2])
+		port := binary.BigEndian.Uint16(b[:2])
+
+		// Reply with a domain bound address to exercise the variable-length
+		// reply parsing.
+		reply := []byte{socks5Version, socks5ReplySucceeded, 0x00, socks5AddrDomain, 5}
+		reply = append(reply, "proxy"...)
+		reply = append(reply, 0x04, 0x38)
+		_, _ = conn.Write(reply)
+
+		tunnel(conn, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	}()
+	return &url.URL{Scheme: "socks5", Host: ln.Addr().String()}
+}
+
+func asyncDialAndEcho(t *testing.T, proxyURL *url.URL, addr string) error {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	var (
+		done   = false
+		result error
+		b      = make([]byte, 5)
+	)
+	AsyncDial(ioc, proxyURL, "tcp", addr, 5*time.Second, func(err error, conn sonic.Conn) {
+		if err != nil {
+			result = err
+			done = true
+			return
+		}
+		conn.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+			if err != nil {
+				result = err
+				done = true
+				return
+			}
+			conn.AsyncReadAll(b, func(err error, _ int) {
+				if err == nil && string(b) != "hello" {
+					err = fmt.Errorf("invalid echo %s", string(b))
+				}
+				result = err
+				done = true
+				_ = conn.Close()
+			})
+		})
+	})
+
+	for !done {
+		_ = ioc.RunOne()
+	}
+	return result
+}
+
+func TestHTTPConnect(t *testing.T) {
+	proxyURL := httpProxyServer(t, "")
+	if err := asyncDialAndEcho(t, proxyURL, echoServer(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPConnectBasicAuth(t *testing.T) {
+	proxyURL := httpProxyServer(t, "user:secret")
+	proxyURL.User = url.UserPassword("user", "secret")
+	if err := asyncDialAndEcho(t, proxyURL, echoServer(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPConnectBasicAuthFails(t *testing.T) {
+	proxyURL := httpProxyServer(t, "user:secret")
+	proxyURL.User = url.UserPassword("user", "wrong")
+	if err := asyncDialAndEcho(t, proxyURL, "localhost:1"); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed got=%v", err)
+	}
+}
+
+func TestHTTPConnectSync(t *testing.T) {
+	proxyURL := httpProxyServer(t, "")
+	addr := echoServer(t)
+
+	conn, err := net.Dial("tcp", Addr(proxyURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := Handshake(conn, proxyURL, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("invalid echo %s", string(b))
+	}
+}
+
+func TestMatchedTerminator(t *testing.T) {
+	cases := []struct {
+		b        string
+		expected int
+	}{
+		{"", 0},
+		{"HTTP", 0},
+		{"HTTP\r", 1},
+		{"HTTP\r\n", 2},
+		{"HTTP\r\n\r", 3},
+		{"HTTP\r\n\r\n", 4},
+		{"HTTP\r\n\n", 0},
+	}
+	for _, c := range cases {
+		if n := matchedTerminator([]byte(c.b)); n != c.expected {
+			t.Fatalf("b=%q expected=%d given=%d", c.b, c.expected, n)
+		}
+	}
+}
+
+func TestSOCKS5(t *testing.T) {
+	proxyURL := socks5ProxyServer(t, "", "", nil)
+	if err := asyncDialAndEcho(t, proxyURL, echoServer(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSOCKS5Password(t *testing.T) {
+	proxyURL := socks5ProxyServer(t, "user", "secret", nil)
+	proxyURL.User = url.UserPassword("user", "secret")
+	if err := asyncDialAndEcho(t, proxyURL, echoServer(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSOCKS5PasswordFails(t *testing.T) {
+	proxyURL := socks5ProxyServer(t, "user", "secret", nil)
+	proxyURL.User = url.UserPassword("user", "wrong")
+	if err := asyncDialAndEcho(t, proxyURL, "localhost:1"); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed got=%v", err)
+	}
+}
+
+func TestSOCKS5RemoteDNS(t *testing.T) {
+	// AsyncDial never resolves the target's host locally, as that blocks.
+	for _, scheme := range []string{"socks5h", "socks5"} {
+		domain := make(chan string, 1)
+		proxyURL := socks5ProxyServer(t, "", "", domain)
+		proxyURL.Scheme = scheme
+
+		_, port, _ := net.SplitHostPort(echoServer(t))
+		if err := asyncDialAndEcho(t, proxyURL, net.JoinHostPort("localhost", port)); err != nil {
+			t.Fatal(err)
+		}
+		if d := <-domain; d != "localhost" {
+			t.Fatalf("scheme=%s: expected the proxy to resolve localhost, got=%s", scheme, d)
+		}
+	}
+}
+
+func TestSOCKS5LocalDNSSync(t *testing.T) {
+	domain := make(chan string, 1)
+	proxyURL := socks5ProxyServer(t, "", "", domain)
+	_, port, _ := net.SplitHostPort(echoServer(t))
+
+	conn, err := net.Dial("tcp", Addr(proxyURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := Handshake(conn, proxyURL, net.JoinHostPort("localhost", port)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-domain:
+		t.Fatalf("expected localhost to be resolved locally, the proxy got=%s", d)
+	default:
+	}
+}
+
+func TestAsyncDialTimeout(t *testing.T) {
+	// The proxy accepts the connection but never answers the handshake.
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.Copy(io.Discard, conn)
+		closed <- err
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	proxyURL := &url.URL{Scheme: "http", Host: ln.Addr().String()}
+
+	done := false
+	AsyncDial(ioc, proxyURL, "tcp", "localhost:80", 50*time.Millisecond, func(err error, conn sonic.Conn) {
+		done = true
+		if !errors.Is(err, sonicerrors.ErrTimeout) || conn != nil {
+			t.Errorf("expected ErrTimeout, got %v", err)
+		}
+	})
+	for !done {
+		_ = ioc.RunOne()
+	}
+
+	// The connection to the proxy is closed on timeout.
+	if err := <-closed; err != nil {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestUnsupportedScheme(t *testing.T) {
+	err := Handshake(nil, &url.URL{Scheme: "ftp", Host: "localhost"}, "localhost:80")
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Fatalf("expected ErrUnsupportedScheme got=%v", err)
+	}
+}
+
+func TestAddr(t *testing.T) {
+	cases := []struct {
+		proxy    string
+		expected string
+	}{
+		{"http://proxy", "proxy:80"},
+		{"http://proxy:3128", "proxy:3128"},
+		{"socks5://proxy", "proxy:1080"},
+		{"socks5h://proxy:9050", "proxy:9050"},
+	}
+	for _, c := range cases {
+		u, _ := url.Parse(c.proxy)
+		if addr := Addr(u); addr != c.expected {
+			t.Fatalf("proxy=%s expected=%s given=%s", c.proxy, c.expected, addr)
+		}
+	}
+}
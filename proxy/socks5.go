@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// Based on https://datatracker.ietf.org/doc/html/rfc1928 and
+// https://datatracker.ietf.org/doc/html/rfc1929.
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xFF
+
+	socks5PasswordVersion = 0x01
+
+	socks5CommandConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5ReplySucceeded = 0x00
+)
+
+type socks5State uint8
+
+const (
+	socks5StateMethod socks5State = iota
+	socks5StateAuth
+	socks5StateReply
+	socks5StateBoundDomainLen
+	socks5StateBoundAddr
+)
+
+var _ handshaker = &socks5{}
+
+// socks5 asks a SOCKS5 proxy to open a tunnel with a CONNECT command.
+type socks5 struct {
+	state socks5State
+
+	greeting []byte
+	auth     []byte
+	connect  []byte
+
+	// Large enough to hold any reply, including a bound domain address.
+	reply [4 + 1 + 255 + 2]byte
+}
+
+func newSOCKS5(host, port string, user *url.Userinfo, remoteDNS bool) (*socks5, error) {
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w port=%s", ErrInvalidTarget, port)
+	}
+
+	h := &socks5{}
+
+	if user != nil {
+		username := user.Username()
+		password, _ := user.Password()
+		if len(username) == 0 || len(username) > 255 || len(password) > 255 {
+			return nil, fmt.Errorf("%w: invalid credentials length", ErrAuthFailed)
+		}
+
+		h.greeting = []byte{socks5Version, 1, socks5AuthPassword}
+
+		h.auth = append(h.auth, socks5PasswordVersion, byte(len(username)))
+		h.auth = append(h.auth, username...)
+		h.auth = append(h.auth, byte(len(password)))
+		h.auth = append(h.auth, password...)
+	} else {
+		h.greeting = []byte{socks5Version, 1, socks5AuthNone}
+	}
+
+	h.connect = append(h.connect, socks5Version, socks5CommandConnect, 0x00)
+	ip := net.ParseIP(host)
+	if ip == nil && !remoteDNS {
+		addr, err := net.ResolveIPAddr("ip", host)
+		if err != nil {
+			return nil, err
+		}
+		ip = addr.IP
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return nil, fmt.Errorf("%w host=%s", ErrInvalidTarget, host)
+		}
+		h.connect = append(h.connect, socks5AddrDomain, byte(len(host)))
+		h.connect = append(h.connect, host...)
+	case ip.To4() != nil:
+		h.connect = append(h.connect, socks5AddrIPv4)
+		h.connect = append(h.connect, ip.To4()...)
+	default:
+		h.connect = append(h.connect, socks5AddrIPv6)
+		h.connect = append(h.connect, ip.To16()...)
+	}
+	h.connect = binary.BigEndian.AppendUint16(h.connect, uint16(portNum))
+
+	return h, nil
+}
+
+func (h *socks5) begin() step {
+	h.state = socks5StateMethod
+	return step{write: h.greeting, read: h.reply[:2]}
+}
+
+func (h *socks5) advance() (step, error) {
+	switch h.state {
+	case socks5StateMethod:
+		if h.reply[0] != socks5Version {
+			return step{}, ErrInvalidResponse
+		}
+		switch method := h.reply[1]; {
+		case method == socks5AuthPassword && h.auth != nil:
+			h.state = socks5StateAuth
+			return step{write: h.auth, read: h.reply[:2]}, nil
+		case method == socks5AuthNone:
+			h.state = socks5StateReply
+			return step{write: h.connect, read: h.reply[:4]}, nil
+		case method == socks5AuthNoAcceptable:
+			return step{}, ErrAuthFailed
+		default:
+			return step{}, ErrInvalidResponse
+		}
+	case socks5StateAuth:
+		if h.reply[1] != 0x00 {
+			return step{}, ErrAuthFailed
+		}
+		h.state = socks5StateReply
+		return step{write: h.connect, read: h.reply[:4]}, nil
+	case socks5StateReply:
+		if h.reply[0] != socks5Version {
+			return step{}, ErrInvalidResponse
+		}
+		if rep := h.reply[1]; rep != socks5ReplySucceeded {
+			return step{}, fmt.Errorf("%w reply=%s", ErrConnectFailed, socks5ReplyString(rep))
+		}
+
+		// We don't care about the address the proxy bound, but we must read it
+		// all before handing over the tunnel.
+		var n int
+		switch h.reply[3] {
+		case socks5AddrIPv4:
+			n = net.IPv4len + 2
+		case socks5AddrIPv6:
+			n = net.IPv6len + 2
+		case socks5AddrDomain:
+			h.state = socks5StateBoundDomainLen
+			return step{read: h.reply[4:5]}, nil
+		default:
+			return step{}, ErrInvalidResponse
+		}
+		h.state = socks5StateBoundAddr
+		return step{read: h.reply[5 : 5+n]}, nil
+	case socks5StateBoundDomainLen:
+		h.state = socks5StateBoundAddr
+		return step{read: h.reply[5 : 5+int(h.reply[4])+2]}, nil
+	case socks5StateBoundAddr:
+		return step{}, nil
+	default:
+		panic("unreachable")
+	}
+}
+
+func socks5ReplyString(rep byte) string {
+	switch rep {
+	case 0x01:
+		return "general_failure"
+	case 0x02:
+		return "connection_not_allowed"
+	case 0x03:
+		return "network_unreachable"
+	case 0x04:
+		return "host_unreachable"
+	case 0x05:
+		return "connection_refused"
+	case 0x06:
+		return "ttl_expired"
+	case 0x07:
+		return "command_not_supported"
+	case 0x08:
+		return "address_type_not_supported"
+	default:
+		return "unknown"
+	}
+}
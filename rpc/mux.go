@@ -0,0 +1,293 @@
+// Package rpc multiplexes requests and responses over a sonic.CodecConn.
+//
+// Responses are matched to their requests by a correlation ID extracted from both. Messages which do not match a
+// pending request, such as market data pushed by a venue or responses which arrive after their request timed out, are
+// routed to a separate handler.
+package rpc
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var (
+	ErrTooManyInflight = errors.New("rpc: too many requests in flight")
+	ErrDuplicateID     = errors.New("rpc: a request with the same id is in flight")
+	ErrClosed          = errors.New("rpc: mux closed")
+)
+
+// Mux sends requests over a CodecConn and invokes the callback of each request with its response.
+//
+// Requests are encoded as they are made and written in batches, in the order they are made. A response is only valid
+// for the duration of its callback. Each request can have a timeout, after which
+// its callback is invoked with sonicerrors.ErrTimeout.
+//
+// If reading or writing fails, the callbacks of all pending requests are invoked with the error and the connection is
+// closed.
+//
+// A Mux must only be used from the IO's goroutine.
+type Mux[Req, Res any, ID comparable] struct {
+	ioc  *sonic.IO
+	conn *sonic.CodecConn[Req, Res]
+
+	requestID  func(Req) ID
+	responseID func(Res) (ID, bool)
+
+	maxInflight int
+	timeout     time.Duration
+
+	onUnsolicited func(Res)
+	onClose       func(error)
+
+	pending   map[ID]*call[Res, ID]
+	deadlines deadlineHeap[Res, ID]
+	timer     *sonic.Timer
+
+	reading bool
+	err     error // set once closed
+
+	emptyRes Res
+}
+
+type call[Res any, ID comparable] struct {
+	id       ID
+	cb       func(error, Res)
+	deadline time.Time
+	index    int // in the deadline heap, -1 if the call has no deadline
+}
+
+// NewMux returns a multiplexer over the connection. requestID returns the correlation ID of a request. responseID
+// returns the correlation ID of a response, or false if the message is not a response.
+func NewMux[Req, Res any, ID comparable](
+	ioc *sonic.IO,
+	conn *sonic.CodecConn[Req, Res],
+	requestID func(Req) ID,
+	responseID func(Res) (ID, bool),
+) (*Mux[Req, Res, ID], error) {
+	timer, err := sonic.NewTimer(ioc)
+	if err != nil {
+		return nil, err
+	}
+	return &Mux[Req, Res, ID]{
+		ioc:        ioc,
+		conn:       conn,
+		requestID:  requestID,
+		responseID: responseID,
+		pending:    make(map[ID]*call[Res, ID]),
+		timer:      timer,
+	}, nil
+}
+
+// SetMaxInflight bounds the number of requests awaiting their response. Requests over the limit fail with
+// ErrTooManyInflight. Zero, the default, means no limit.
+func (m *Mux[Req, Res, ID]) SetMaxInflight(n int) {
+	m.maxInflight = n
+}
+
+// SetTimeout sets the timeout of the requests made with Call. Zero, the default, means no timeout.
+func (m *Mux[Req, Res, ID]) SetTimeout(timeout time.Duration) {
+	m.timeout = timeout
+}
+
+// SetUnsolicitedHandler sets the handler of the messages which do not match a pending request. Such messages are
+// dropped if no handler is set.
+func (m *Mux[Req, Res, ID]) SetUnsolicitedHandler(fn func(Res)) {
+	m.onUnsolicited = fn
+}
+
+// SetCloseHandler sets the function called once the mux is closed, with the connection error or ErrClosed.
+func (m *Mux[Req, Res, ID]) SetCloseHandler(fn func(error)) {
+	m.onClose = fn
+}
+
+// Inflight returns the number of requests awaiting their response.
+func (m *Mux[Req, Res, ID]) Inflight() int {
+	return len(m.pending)
+}
+
+// Err returns the error which closed the mux, if any.
+func (m *Mux[Req, Res, ID]) Err() error {
+	return m.err
+}
+
+// Start starts reading from the connection. It is called by the first request, but must be called beforehand if
+// messages may arrive before any request is made.
+func (m *Mux[Req, Res, ID]) Start() {
+	if !m.reading && m.err == nil {
+		m.reading = true
+		m.read()
+	}
+}
+
+// Call sends a request with the default timeout.
+func (m *Mux[Req, Res, ID]) Call(req Req, cb func(error, Res)) {
+	m.CallTimeout(req, m.timeout, cb)
+}
+
+// CallTimeout sends a request which times out after the given duration. A zero duration means no timeout.
+func (m *Mux[Req, Res, ID]) CallTimeout(req Req, timeout time.Duration, cb func(error, Res)) {
+	if m.err != nil {
+		cb(m.err, m.emptyRes)
+		return
+	}
+	if m.maxInflight > 0 && len(m.pending) >= m.maxInflight {
+		cb(ErrTooManyInflight, m.emptyRes)
+		return
+	}
+	id := m.requestID(req)
+	if _, ok := m.pending[id]; ok {
+		cb(ErrDuplicateID, m.emptyRes)
+		return
+	}
+
+	c := &call[Res, ID]{id: id, cb: cb, index: -1}
+	m.pending[id] = c
+	if timeout > 0 {
+		c.deadline = time.Now().Add(timeout)
+		heap.Push(&m.deadlines, c)
+		if c.index == 0 {
+			m.schedule()
+		}
+	}
+
+	if err := m.conn.Enqueue(req); err != nil {
+		delete(m.pending, id)
+		if c.index >= 0 {
+			heap.Remove(&m.deadlines, c.index)
+		}
+		cb(err, m.emptyRes)
+		return
+	}
+
+	m.Start()
+	m.conn.AsyncFlush(m.onWrite)
+}
+
+// Close closes the connection. The callbacks of the pending requests are invoked with ErrClosed.
+func (m *Mux[Req, Res, ID]) Close() error {
+	if m.err != nil {
+		return ErrClosed
+	}
+	m.close(ErrClosed)
+	return nil
+}
+
+func (m *Mux[Req, Res, ID]) onWrite(err error, _ int) {
+	if m.err != nil {
+		return
+	}
+	if err != nil {
+		m.close(err)
+	}
+}
+
+func (m *Mux[Req, Res, ID]) read() {
+	m.conn.AsyncReadNext(m.onRead)
+}
+
+func (m *Mux[Req, Res, ID]) onRead(err error, res Res) {
+	if m.err != nil {
+		return
+	}
+	if err != nil {
+		m.close(err)
+		return
+	}
+
+	id, ok := m.responseID(res)
+	c := m.pending[id]
+	if ok && c != nil {
+		delete(m.pending, id)
+		if c.index >= 0 {
+			heap.Remove(&m.deadlines, c.index)
+		}
+		c.cb(nil, res)
+	} else if m.onUnsolicited != nil {
+		m.onUnsolicited(res)
+	}
+
+	if m.err == nil {
+		m.read()
+	}
+}
+
+// schedule arms the timer for the earliest deadline. The timer is not rearmed when the earliest request completes: it
+// fires early and is rearmed then.
+func (m *Mux[Req, Res, ID]) schedule() {
+	_ = m.timer.Cancel()
+	if len(m.deadlines) == 0 {
+		return
+	}
+
+	delay := time.Until(m.deadlines[0].deadline)
+	if delay <= 0 {
+		delay = time.Nanosecond
+	}
+	_ = m.timer.ScheduleOnce(delay, m.onTimer)
+}
+
+func (m *Mux[Req, Res, ID]) onTimer() {
+	now := time.Now()
+	for m.err == nil && len(m.deadlines) > 0 && !now.Before(m.deadlines[0].deadline) {
+		c := heap.Pop(&m.deadlines).(*call[Res, ID])
+		delete(m.pending, c.id)
+		c.cb(sonicerrors.ErrTimeout, m.emptyRes)
+	}
+	if m.err == nil {
+		m.schedule()
+	}
+}
+
+func (m *Mux[Req, Res, ID]) close(err error) {
+	m.err = err
+	_ = m.timer.Close()
+	_ = m.conn.Close()
+
+	pending := m.pending
+	m.pending = make(map[ID]*call[Res, ID])
+	m.deadlines = nil
+	for _, c := range pending {
+		c.cb(err, m.emptyRes)
+	}
+
+	if m.onClose != nil {
+		m.onClose(err)
+	}
+}
+
+// deadlineHeap is a min-heap of calls ordered by deadline.
+type deadlineHeap[Res any, ID comparable] []*call[Res, ID]
+
+func (h deadlineHeap[Res, ID]) Len() int {
+	return len(h)
+}
+
+func (h deadlineHeap[Res, ID]) Less(i, j int) bool {
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h deadlineHeap[Res, ID]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *deadlineHeap[Res, ID]) Push(x any) {
+	c := x.(*call[Res, ID])
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *deadlineHeap[Res, ID]) Pop() any {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	old[n-1] = nil
+	c.index = -1
+	*h = old[:n-1]
+	return c
+}
@@ -0,0 +1,259 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/codec/line"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// Messages are lines made of an ID and a payload: "7 ping". Responses carry the ID of their request, and
+// unsolicited messages the ID "-".
+
+func requestID(req []byte) string {
+	id, _, _ := strings.Cut(string(req), " ")
+	return id
+}
+
+func responseID(res []byte) (string, bool) {
+	id, _, _ := bytes.Cut(res, []byte(" "))
+	if string(id) == "-" {
+		return "", false
+	}
+	return string(id), true
+}
+
+func payload(res []byte) string {
+	_, p, _ := bytes.Cut(res, []byte(" "))
+	return string(p)
+}
+
+// serve runs a stand-in server on its own goroutine. The handler is called for each request line and writes its
+// responses, if any.
+func serve(t *testing.T, handler func(w io.Writer, id, payload string)) string {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			id, p, _ := strings.Cut(scanner.Text(), " ")
+			if id == "close" {
+				return
+			}
+			handler(conn, id, p)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func newMux(t *testing.T, ioc *sonic.IO, addr string) *Mux[[]byte, []byte, string] {
+	conn, err := sonic.Dial(ioc, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, dst := sonic.NewByteBuffer(), sonic.NewByteBuffer()
+	codec, _ := line.NewCodec(src, line.LF)
+	codec.SetStripDelimiter(true)
+	codecConn, err := sonic.NewCodecConn[[]byte, []byte](conn, codec, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMux[[]byte, []byte, string](ioc, codecConn, requestID, responseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+func runUntil(t *testing.T, ioc *sonic.IO, done func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_, _ = ioc.PollOne()
+	}
+}
+
+func TestMuxOutOfOrder(t *testing.T) {
+	// Responses are sent in reverse order once three requests are received, preceded by an unsolicited message.
+	addr := serve(t, func() func(io.Writer, string, string) {
+		var ids []string
+		return func(w io.Writer, id, p string) {
+			ids = append(ids, id)
+			if len(ids) == 3 {
+				_, _ = io.WriteString(w, "- tick\n")
+				for i := len(ids) - 1; i >= 0; i-- {
+					_, _ = io.WriteString(w, ids[i]+" re:"+ids[i]+"\n")
+				}
+			}
+		}
+	}())
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+	m := newMux(t, ioc, addr)
+
+	var unsolicited []string
+	m.SetUnsolicitedHandler(func(res []byte) {
+		unsolicited = append(unsolicited, string(res))
+	})
+
+	responses := make(map[string]string)
+	for _, id := range []string{"a", "b", "c"} {
+		id := id
+		m.Call([]byte(id+" ping"), func(err error, res []byte) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			responses[id] = payload(res)
+		})
+	}
+	if m.Inflight() != 3 {
+		t.Fatalf("expected 3 requests in flight, got %d", m.Inflight())
+	}
+
+	runUntil(t, ioc, func() bool { return len(responses) == 3 })
+	for id, p := range responses {
+		if p != "re:"+id {
+			t.Fatalf("request %s got response %q", id, p)
+		}
+	}
+	if len(unsolicited) != 1 || unsolicited[0] != "- tick" {
+		t.Fatalf("invalid unsolicited messages %q", unsolicited)
+	}
+	if m.Inflight() != 0 {
+		t.Fatalf("expected no requests in flight, got %d", m.Inflight())
+	}
+}
+
+func TestMuxTimeout(t *testing.T) {
+	// The response to "slow" is only sent with the response to the next request.
+	addr := serve(t, func(w io.Writer, id, p string) {
+		if p == "slow" {
+			return
+		}
+		_, _ = io.WriteString(w, "slow late\n"+id+" fast\n")
+	})
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+	m := newMux(t, ioc, addr)
+
+	var unsolicited []string
+	m.SetUnsolicitedHandler(func(res []byte) {
+		unsolicited = append(unsolicited, string(res))
+	})
+
+	var slowErr error
+	start := time.Now()
+	m.CallTimeout([]byte("slow slow"), 50*time.Millisecond, func(err error, _ []byte) {
+		slowErr = err
+	})
+	m.CallTimeout([]byte("never slow"), time.Hour, func(err error, _ []byte) {})
+	runUntil(t, ioc, func() bool { return slowErr != nil })
+
+	if slowErr != sonicerrors.ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", slowErr)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("timed out after %s", elapsed)
+	}
+	if m.Inflight() != 1 {
+		t.Fatalf("expected 1 request in flight, got %d", m.Inflight())
+	}
+
+	// The late response is unsolicited.
+	done := false
+	m.Call([]byte("fast ping"), func(err error, res []byte) {
+		done = err == nil && payload(res) == "fast"
+	})
+	runUntil(t, ioc, func() bool { return done })
+	if len(unsolicited) != 1 || unsolicited[0] != "slow late" {
+		t.Fatalf("invalid unsolicited messages %q", unsolicited)
+	}
+}
+
+func TestMuxLimits(t *testing.T) {
+	addr := serve(t, func(w io.Writer, id, p string) {})
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+	m := newMux(t, ioc, addr)
+	m.SetMaxInflight(2)
+
+	var errs []error
+	record := func(err error, _ []byte) {
+		errs = append(errs, err)
+	}
+	m.Call([]byte("1 a"), record)
+	m.Call([]byte("1 b"), record)
+	m.Call([]byte("2 c"), record)
+	m.Call([]byte("3 d"), record)
+
+	if len(errs) != 2 || errs[0] != ErrDuplicateID || errs[1] != ErrTooManyInflight {
+		t.Fatalf("invalid errors %v", errs)
+	}
+}
+
+func TestMuxConnectionError(t *testing.T) {
+	addr := serve(t, func(w io.Writer, id, p string) {})
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+	m := newMux(t, ioc, addr)
+
+	var closeErr error
+	m.SetCloseHandler(func(err error) {
+		closeErr = err
+	})
+
+	var errs []error
+	record := func(err error, _ []byte) {
+		errs = append(errs, err)
+	}
+	m.CallTimeout([]byte("1 a"), time.Hour, record)
+	m.Call([]byte("2 b"), record)
+	m.Call([]byte("close"), record)
+
+	runUntil(t, ioc, func() bool { return closeErr != nil })
+	if closeErr != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", closeErr)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 failed requests, got %v", errs)
+	}
+	for _, err := range errs {
+		if err != io.EOF {
+			t.Fatalf("expected io.EOF, got %v", err)
+		}
+	}
+
+	m.Call([]byte("3 c"), record)
+	if errs[3] != io.EOF || m.Err() != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", errs[3])
+	}
+	if err := m.Close(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
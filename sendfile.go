@@ -0,0 +1,101 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly || linux
+
+package sonic
+
+import (
+	"io"
+
+	"github.com/talostrading/sonic/internal"
+	"golang.org/x/sys/unix"
+)
+
+type sendFileReactor struct {
+	ioc    *IO
+	slot   internal.Slot
+	file   int
+	offset int64
+	n      int
+	sent   int
+	cb     AsyncCallback
+}
+
+// AsyncSendFile sends exactly n bytes of the passed file, starting at offset,
+// to conn with sendfile(2), without copying them to user space. The file's
+// offset is not changed.
+//
+// The callback is invoked once all bytes are sent, or with io.EOF if the file
+// ends before. conn must be nonblocking and must not have other asynchronous
+// writes pending until the callback is invoked.
+func AsyncSendFile(
+	ioc *IO,
+	file File,
+	conn FileDescriptor,
+	offset int64,
+	n int,
+	cb AsyncCallback,
+) {
+	r := &sendFileReactor{
+		ioc:    ioc,
+		slot:   internal.Slot{Fd: conn.RawFd()},
+		file:   file.RawFd(),
+		offset: offset,
+		n:      n,
+		cb:     cb,
+	}
+	r.slot.Set(internal.WriteEvent, r.onWrite)
+
+	if ioc.Dispatched < MaxCallbackDispatch {
+		r.sendNow(func(err error, n int) {
+			ioc.Dispatched++
+			cb(err, n)
+			ioc.Dispatched--
+		})
+	} else {
+		r.scheduleWrite()
+	}
+}
+
+func (r *sendFileReactor) sendNow(cb AsyncCallback) {
+	for r.sent < r.n {
+		// Only Linux advances the offset. BSDs report the bytes sent even
+		// when failing with EAGAIN.
+		offset := r.offset
+		n, err := unix.Sendfile(r.slot.Fd, r.file, &offset, r.n-r.sent)
+		if n < 0 {
+			n = 0
+		}
+		r.offset += int64(n)
+		r.sent += n
+
+		if err == unix.EAGAIN {
+			r.scheduleWrite()
+			return
+		}
+		if err != nil {
+			cb(err, r.sent)
+			return
+		}
+		if n == 0 {
+			cb(io.EOF, r.sent)
+			return
+		}
+	}
+	cb(nil, r.sent)
+}
+
+func (r *sendFileReactor) scheduleWrite() {
+	if err := r.ioc.SetWrite(&r.slot); err != nil {
+		r.cb(err, r.sent)
+	} else {
+		r.ioc.Register(&r.slot)
+	}
+}
+
+func (r *sendFileReactor) onWrite(err error) {
+	r.ioc.Deregister(&r.slot)
+	if err != nil {
+		r.cb(err, r.sent)
+	} else {
+		r.sendNow(r.cb)
+	}
+}
@@ -0,0 +1,69 @@
+package sonic
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestSendFile(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	data := make([]byte, 4*1024*1024)
+	rand.Read(data)
+
+	tmp, err := os.CreateTemp("", "sonic-sendfile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	_, _ = tmp.Write(data)
+	_ = tmp.Close()
+
+	file, err := Open(ioc, tmp.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	conn, reader := socketPair(t, ioc)
+
+	const offset = 100
+	var (
+		received = make([]byte, len(data)-offset)
+		sent     = 0
+		read     = false
+	)
+	AsyncSendFile(ioc, file, conn, offset, len(received), func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = n
+	})
+	reader.AsyncReadAll(received, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = true
+	})
+	runUntil(t, ioc, func() bool { return read && sent != 0 })
+	if sent != len(received) || !bytes.Equal(data[offset:], received) {
+		t.Fatalf("corrupt sendfile of %d bytes", sent)
+	}
+
+	// The file's end is reported along with the bytes sent before it.
+	var result error
+	AsyncSendFile(ioc, file, conn, int64(len(data)-10), 100, func(err error, n int) {
+		if n != 10 {
+			t.Fatalf("expected to send 10 bytes, sent %d", n)
+		}
+		result = err
+	})
+	runUntil(t, ioc, func() bool { return result != nil })
+	if result != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", result)
+	}
+}
@@ -0,0 +1,381 @@
+package sonic
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrInvalidSlotHandle = errors.New("invalid slot handle")
+	ErrSlotSeqExists     = errors.New("a slot with this sequence number exists")
+)
+
+// DefaultSlotStoreCompactionThreshold is the fragmentation above which a
+// SlotStore compacts its retained messages. See SlotStore.Fragmentation.
+const DefaultSlotStoreCompactionThreshold = 0.5
+
+// SlotHandle addresses a message retained in a SlotStore. A handle stays valid
+// until its message is removed, regardless of the compactions which move the
+// message. The zero value is never a valid handle.
+type SlotHandle uint64
+
+func newSlotHandle(index int, gen uint32) SlotHandle {
+	return SlotHandle(uint64(gen)<<32 | uint64(uint32(index)))
+}
+
+func (h SlotHandle) index() int {
+	return int(uint32(h))
+}
+
+func (h SlotHandle) gen() uint32 {
+	return uint32(h >> 32)
+}
+
+type slotStoreEntry struct {
+	Slot
+	gen    uint32
+	live   bool
+	hasSeq bool
+	seq    int
+}
+
+type slotStoreSeq struct {
+	seq    int
+	handle SlotHandle
+}
+
+// SlotStore retains messages in a single preallocated memory area and addresses
+// them with stable handles. It is meant for retransmission caches and recovery
+// buffers: messages are added as they are sent or received and removed, in any
+// order, once they are acknowledged or recovered.
+//
+// Unlike the save area of a ByteBuffer, removing a message does not move the
+// others. The space it leaves is reclaimed lazily, by compacting the messages
+// once the fragmentation of the area exceeds a threshold, or once a new message
+// does not fit at the end of the area.
+//
+// Messages can be iterated in insertion order and, for those added with a
+// sequence number, in sequence order.
+type SlotStore struct {
+	maxSlots  int
+	maxBytes  int
+	threshold float64
+
+	data []byte
+	tail int // end of the last message in data
+
+	entries []slotStoreEntry
+	free    []int
+
+	// order holds the handles of the messages in insertion order, which is
+	// also their order in data. It holds the handles of the removed messages
+	// until the next compaction, or until it holds twice maxSlots handles.
+	order []SlotHandle
+
+	// seqs holds, from seqStart, the messages added with a sequence number, in
+	// ascending order of their sequence number. Removing the first one moves
+	// seqStart instead of copying the others, such that removing messages in
+	// sequence order takes constant time.
+	seqs     []slotStoreSeq
+	seqStart int
+
+	size        int
+	bytes       int
+	compactions int
+}
+
+// NewSlotStore returns a store of at most maxSlots messages totalling at most
+// maxBytes bytes. The memory area holding the messages is allocated upfront.
+func NewSlotStore(maxSlots, maxBytes int) *SlotStore {
+	return &SlotStore{
+		maxSlots:  maxSlots,
+		maxBytes:  maxBytes,
+		threshold: DefaultSlotStoreCompactionThreshold,
+
+		data:    make([]byte, maxBytes),
+		entries: make([]slotStoreEntry, 0, maxSlots),
+		free:    make([]int, 0, maxSlots),
+		order:   make([]SlotHandle, 0, 2*maxSlots),
+		seqs:    make([]slotStoreSeq, 0, maxSlots),
+	}
+}
+
+// SetCompactionThreshold sets the fragmentation, between 0 and 1, above which
+// the store compacts its messages when one is removed. A threshold of 0
+// compacts on every removal, while a threshold of 1 only compacts when a new
+// message does not fit at the end of the memory area.
+func (s *SlotStore) SetCompactionThreshold(threshold float64) {
+	s.threshold = threshold
+}
+
+// Add copies b into the store and returns its handle.
+func (s *SlotStore) Add(b []byte) (SlotHandle, error) {
+	return s.add(b, false, 0)
+}
+
+// AddSeq copies b into the store under the passed sequence number, which must
+// not be used by another message of the store, and returns its handle.
+func (s *SlotStore) AddSeq(seq int, b []byte) (SlotHandle, error) {
+	if _, ok := s.searchSeq(seq); ok {
+		return 0, ErrSlotSeqExists
+	}
+	return s.add(b, true, seq)
+}
+
+func (s *SlotStore) add(b []byte, hasSeq bool, seq int) (SlotHandle, error) {
+	if s.size >= s.maxSlots || s.bytes+len(b) > s.maxBytes {
+		return 0, ErrNoSpaceLeftForSlot
+	}
+	if s.tail+len(b) > s.maxBytes {
+		s.Compact()
+	}
+
+	var index int
+	if n := len(s.free); n > 0 {
+		index = s.free[n-1]
+		s.free = s.free[:n-1]
+	} else {
+		s.entries = append(s.entries, slotStoreEntry{})
+		index = len(s.entries) - 1
+	}
+
+	entry := &s.entries[index]
+	entry.gen++
+	entry.live = true
+	entry.Slot = Slot{Index: s.tail, Length: len(b)}
+	entry.hasSeq = hasSeq
+	entry.seq = seq
+
+	s.tail += copy(s.data[s.tail:], b)
+	s.size++
+	s.bytes += len(b)
+
+	h := newSlotHandle(index, entry.gen)
+	if len(s.order) == cap(s.order) {
+		s.compactOrder()
+	}
+	s.order = append(s.order, h)
+	if hasSeq {
+		if len(s.seqs) == cap(s.seqs) && s.seqStart > 0 {
+			n := copy(s.seqs, s.seqs[s.seqStart:])
+			s.seqs = s.seqs[:n]
+			s.seqStart = 0
+		}
+		ix, _ := s.searchSeq(seq)
+		s.seqs = append(s.seqs, slotStoreSeq{})
+		copy(s.seqs[ix+1:], s.seqs[ix:])
+		s.seqs[ix] = slotStoreSeq{seq: seq, handle: h}
+	}
+	return h, nil
+}
+
+func (s *SlotStore) entry(h SlotHandle) *slotStoreEntry {
+	if ix := h.index(); ix < len(s.entries) {
+		if entry := &s.entries[ix]; entry.live && entry.gen == h.gen() {
+			return entry
+		}
+	}
+	return nil
+}
+
+// searchSeq returns the index in seqs at which seq is, or would be inserted.
+func (s *SlotStore) searchSeq(seq int) (int, bool) {
+	ix := s.seqStart + sort.Search(len(s.seqs)-s.seqStart, func(i int) bool {
+		return s.seqs[s.seqStart+i].seq >= seq
+	})
+	return ix, ix < len(s.seqs) && s.seqs[ix].seq == seq
+}
+
+// removeSeq removes seqs[ix] by moving the fewest other messages.
+func (s *SlotStore) removeSeq(ix int) {
+	if ix-s.seqStart < len(s.seqs)-1-ix {
+		copy(s.seqs[s.seqStart+1:ix+1], s.seqs[s.seqStart:ix])
+		s.seqStart++
+	} else {
+		s.seqs = append(s.seqs[:ix], s.seqs[ix+1:]...)
+	}
+	if s.seqStart == len(s.seqs) {
+		s.seqs = s.seqs[:0]
+		s.seqStart = 0
+	}
+}
+
+// Get returns the message addressed by the passed handle. The returned slice
+// is only valid until the next call to Add, AddSeq, Remove or Compact.
+func (s *SlotStore) Get(h SlotHandle) ([]byte, bool) {
+	entry := s.entry(h)
+	if entry == nil {
+		return nil, false
+	}
+	return s.data[entry.Index : entry.Index+entry.Length], true
+}
+
+// Lookup returns the handle of the message added under the passed sequence
+// number.
+func (s *SlotStore) Lookup(seq int) (SlotHandle, bool) {
+	if ix, ok := s.searchSeq(seq); ok {
+		return s.seqs[ix].handle, true
+	}
+	return 0, false
+}
+
+// Remove removes the message addressed by the passed handle. Its handle is
+// invalid after this call.
+func (s *SlotStore) Remove(h SlotHandle) error {
+	entry := s.entry(h)
+	if entry == nil {
+		return ErrInvalidSlotHandle
+	}
+
+	if entry.hasSeq {
+		ix, _ := s.searchSeq(entry.seq)
+		s.removeSeq(ix)
+	}
+
+	entry.live = false
+	s.free = append(s.free, h.index())
+	s.size--
+	s.bytes -= entry.Length
+
+	if s.size == 0 {
+		s.Reset()
+	} else if s.Fragmentation() > s.threshold {
+		s.Compact()
+	}
+	return nil
+}
+
+// RemoveSeq removes the message added under the passed sequence number.
+func (s *SlotStore) RemoveSeq(seq int) bool {
+	h, ok := s.Lookup(seq)
+	if ok {
+		_ = s.Remove(h)
+	}
+	return ok
+}
+
+// RemoveUntil removes the messages added with a sequence number lower than or
+// equal to the passed one, as done when a peer acknowledges them. It returns
+// the number of removed messages.
+func (s *SlotStore) RemoveUntil(seq int) (removed int) {
+	for s.seqStart < len(s.seqs) && s.seqs[s.seqStart].seq <= seq {
+		_ = s.Remove(s.seqs[s.seqStart].handle)
+		removed++
+	}
+	return removed
+}
+
+// Compact moves the messages to the start of the memory area, such that the
+// space left by the removed messages is reclaimed. The messages keep their
+// insertion order and their handles.
+func (s *SlotStore) Compact() {
+	s.compactions++
+
+	tail, order := 0, s.order[:0]
+	for _, h := range s.order {
+		entry := s.entry(h)
+		if entry == nil {
+			continue
+		}
+		if entry.Index != tail {
+			copy(s.data[tail:], s.data[entry.Index:entry.Index+entry.Length])
+			entry.Index = tail
+		}
+		tail += entry.Length
+		order = append(order, h)
+	}
+	s.tail = tail
+	s.order = order
+}
+
+// compactOrder drops the handles of the removed messages from order, without
+// moving the messages.
+func (s *SlotStore) compactOrder() {
+	order := s.order[:0]
+	for _, h := range s.order {
+		if s.entry(h) != nil {
+			order = append(order, h)
+		}
+	}
+	s.order = order
+}
+
+// Range calls fn for each message in insertion order, until fn returns false.
+// The store must not be modified by fn.
+func (s *SlotStore) Range(fn func(h SlotHandle, b []byte) bool) {
+	for _, h := range s.order {
+		if entry := s.entry(h); entry != nil {
+			if !fn(h, s.data[entry.Index:entry.Index+entry.Length]) {
+				return
+			}
+		}
+	}
+}
+
+// RangeSeq calls fn for each message added with a sequence number, in
+// ascending order of sequence number, starting from the passed one, until fn
+// returns false. The store must not be modified by fn.
+func (s *SlotStore) RangeSeq(from int, fn func(seq int, h SlotHandle, b []byte) bool) {
+	ix, _ := s.searchSeq(from)
+	for ; ix < len(s.seqs); ix++ {
+		entry := s.entry(s.seqs[ix].handle)
+		if !fn(s.seqs[ix].seq, s.seqs[ix].handle, s.data[entry.Index:entry.Index+entry.Length]) {
+			return
+		}
+	}
+}
+
+// Size returns the number of retained messages.
+func (s *SlotStore) Size() int {
+	return s.size
+}
+
+// Bytes returns the number of bytes of the retained messages.
+func (s *SlotStore) Bytes() int {
+	return s.bytes
+}
+
+func (s *SlotStore) MaxBytes() int {
+	return s.maxBytes
+}
+
+func (s *SlotStore) FillPct() float64 {
+	a := float64(s.Bytes())
+	b := float64(s.MaxBytes())
+	return a / b * 100.0
+}
+
+// Garbage returns the number of bytes left by the removed messages, which are
+// reclaimed by the next compaction.
+func (s *SlotStore) Garbage() int {
+	return s.tail - s.bytes
+}
+
+// Fragmentation returns the share of the used memory area, between 0 and 1,
+// which is left by removed messages.
+func (s *SlotStore) Fragmentation() float64 {
+	if s.tail == 0 {
+		return 0
+	}
+	return float64(s.Garbage()) / float64(s.tail)
+}
+
+// Compactions returns the number of compactions since the store was created.
+func (s *SlotStore) Compactions() int {
+	return s.compactions
+}
+
+// Reset removes all messages. All handles are invalid after this call.
+func (s *SlotStore) Reset() {
+	s.free = s.free[:0]
+	for i := range s.entries {
+		s.entries[i].live = false
+		s.free = append(s.free, i)
+	}
+	s.order = s.order[:0]
+	s.seqs = s.seqs[:0]
+	s.seqStart = 0
+	s.tail = 0
+	s.size = 0
+	s.bytes = 0
+}
@@ -0,0 +1,255 @@
+package sonic
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestSlotStoreAddGetRemove(t *testing.T) {
+	s := NewSlotStore(4, 16)
+
+	h1, err := s.Add([]byte("abcd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := s.Add([]byte("efgh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 == 0 || h2 == 0 || h1 == h2 {
+		t.Fatalf("invalid handles %d %d", h1, h2)
+	}
+	if s.Size() != 2 || s.Bytes() != 8 || s.FillPct() != 50 {
+		t.Fatalf("invalid accounting size=%d bytes=%d", s.Size(), s.Bytes())
+	}
+
+	if b, ok := s.Get(h2); !ok || string(b) != "efgh" {
+		t.Fatalf("invalid message %q", b)
+	}
+
+	if err := s.Remove(h1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get(h1); ok {
+		t.Fatal("removed handle should be invalid")
+	}
+	if err := s.Remove(h1); err != ErrInvalidSlotHandle {
+		t.Fatalf("expected ErrInvalidSlotHandle, got %v", err)
+	}
+
+	// The entry of h1 is reused, with a new generation.
+	h3, err := s.Add([]byte("ijkl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h3.index() != h1.index() || h3 == h1 {
+		t.Fatalf("invalid reused handle %d %d", h1, h3)
+	}
+	if _, ok := s.Get(h1); ok {
+		t.Fatal("stale handle should be invalid")
+	}
+
+	if _, err := s.Add(make([]byte, 9)); err != ErrNoSpaceLeftForSlot {
+		t.Fatalf("expected ErrNoSpaceLeftForSlot, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Add([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Add([]byte("x")); err != ErrNoSpaceLeftForSlot {
+		t.Fatalf("expected ErrNoSpaceLeftForSlot, got %v", err)
+	}
+
+	s.Reset()
+	if s.Size() != 0 || s.Bytes() != 0 || s.Garbage() != 0 {
+		t.Fatal("store should be empty")
+	}
+	if _, ok := s.Get(h2); ok {
+		t.Fatal("handle should be invalid after reset")
+	}
+}
+
+func TestSlotStoreCompaction(t *testing.T) {
+	s := NewSlotStore(8, 16)
+	s.SetCompactionThreshold(1)
+
+	var handles []SlotHandle
+	for i := 0; i < 4; i++ {
+		h, err := s.Add([]byte(fmt.Sprintf("%04d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handles = append(handles, h)
+	}
+
+	// Removals leave garbage behind, which is not reclaimed yet.
+	_ = s.Remove(handles[0])
+	_ = s.Remove(handles[2])
+	if s.Garbage() != 8 || s.Fragmentation() != 0.5 || s.Compactions() != 0 {
+		t.Fatalf("invalid garbage=%d fragmentation=%f compactions=%d",
+			s.Garbage(), s.Fragmentation(), s.Compactions())
+	}
+
+	// The area is full at the tail, so adding compacts it.
+	h, err := s.Add([]byte("abcdefgh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Compactions() != 1 || s.Garbage() != 0 {
+		t.Fatalf("expected a compaction, compactions=%d garbage=%d",
+			s.Compactions(), s.Garbage())
+	}
+	handles = []SlotHandle{handles[1], handles[3], h}
+
+	var got []string
+	s.Range(func(h SlotHandle, b []byte) bool {
+		got = append(got, string(b))
+		return true
+	})
+	if fmt.Sprint(got) != "[0001 0003 abcdefgh]" {
+		t.Fatalf("invalid insertion order %v", got)
+	}
+	for i, want := range []string{"0001", "0003", "abcdefgh"} {
+		if b, ok := s.Get(handles[i]); !ok || string(b) != want {
+			t.Fatalf("handle %d: expected %q, got %q", i, want, b)
+		}
+	}
+
+	// With the default threshold, removals compact once more than half of the
+	// area is garbage.
+	s.SetCompactionThreshold(DefaultSlotStoreCompactionThreshold)
+	_ = s.Remove(handles[2])
+	if s.Compactions() != 1 {
+		t.Fatal("half of the area is garbage, expected no compaction")
+	}
+	_ = s.Remove(handles[0])
+	if s.Compactions() != 2 || s.Garbage() != 0 {
+		t.Fatalf("expected a compaction, compactions=%d garbage=%d",
+			s.Compactions(), s.Garbage())
+	}
+	if b, ok := s.Get(handles[1]); !ok || string(b) != "0003" {
+		t.Fatalf("invalid message %q", b)
+	}
+}
+
+func TestSlotStoreSeq(t *testing.T) {
+	s := NewSlotStore(16, 1024)
+
+	for _, seq := range []int{5, 1, 3, 2, 4} {
+		if _, err := s.AddSeq(seq, []byte(fmt.Sprint(seq))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.AddSeq(3, []byte("3")); err != ErrSlotSeqExists {
+		t.Fatalf("expected ErrSlotSeqExists, got %v", err)
+	}
+	if _, err := s.Add([]byte("unsequenced")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	s.RangeSeq(2, func(seq int, h SlotHandle, b []byte) bool {
+		got = append(got, string(b))
+		return seq < 4
+	})
+	if fmt.Sprint(got) != "[2 3 4]" {
+		t.Fatalf("invalid sequence order %v", got)
+	}
+
+	got = got[:0]
+	s.Range(func(h SlotHandle, b []byte) bool {
+		got = append(got, string(b))
+		return true
+	})
+	if fmt.Sprint(got) != "[5 1 3 2 4 unsequenced]" {
+		t.Fatalf("invalid insertion order %v", got)
+	}
+
+	h, ok := s.Lookup(3)
+	if !ok {
+		t.Fatal("expected sequence 3")
+	}
+	if b, _ := s.Get(h); string(b) != "3" {
+		t.Fatalf("invalid message %q", b)
+	}
+
+	if !s.RemoveSeq(3) || s.RemoveSeq(3) {
+		t.Fatal("sequence 3 should be removed once")
+	}
+	if n := s.RemoveUntil(4); n != 3 {
+		t.Fatalf("expected 3 removed messages, got %d", n)
+	}
+	if _, ok := s.Lookup(5); !ok || s.Size() != 2 {
+		t.Fatalf("expected sequence 5 and 2 messages, got %d", s.Size())
+	}
+}
+
+func TestSlotStoreBounded(t *testing.T) {
+	const maxSlots = 8
+
+	s := NewSlotStore(maxSlots, 1024)
+	s.SetCompactionThreshold(1)
+
+	// Messages are mostly removed in sequence order, sometimes out of it. The
+	// bookkeeping of the store must not grow past its preallocated capacity.
+	rng := rand.New(rand.NewSource(1))
+	live := make(map[int]bool)
+	oldest := 0
+	for seq := 0; seq < 10000; seq++ {
+		if s.Size() == maxSlots {
+			if rng.Intn(4) == 0 {
+				victim := oldest + rng.Intn(seq-oldest)
+				if s.RemoveSeq(victim) != live[victim] {
+					t.Fatalf("invalid removal of %d", victim)
+				}
+				delete(live, victim)
+			} else {
+				removed := s.RemoveUntil(oldest)
+				if removed != 0 != live[oldest] {
+					t.Fatalf("invalid removal until %d", oldest)
+				}
+				delete(live, oldest)
+				oldest++
+			}
+			continue
+		}
+		if _, err := s.AddSeq(seq, []byte(fmt.Sprint(seq))); err != nil {
+			t.Fatal(err)
+		}
+		live[seq] = true
+
+		if cap(s.order) != 2*maxSlots || cap(s.seqs) != maxSlots {
+			t.Fatalf("the store grew to %d/%d", cap(s.order), cap(s.seqs))
+		}
+	}
+
+	var got []int
+	s.RangeSeq(0, func(seq int, h SlotHandle, b []byte) bool {
+		if string(b) != fmt.Sprint(seq) || !live[seq] {
+			t.Fatalf("invalid message %q for %d", b, seq)
+		}
+		got = append(got, seq)
+		return true
+	})
+	if len(got) != len(live) || len(got) != s.Size() {
+		t.Fatalf("expected %d messages, got %d", len(live), len(got))
+	}
+}
+
+func BenchmarkSlotStore(b *testing.B) {
+	s := NewSlotStore(1024, 1024*128)
+	msg := make([]byte, 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.AddSeq(i, msg); err != nil {
+			b.Fatal(err)
+		}
+		if i >= 512 {
+			s.RemoveSeq(i - 512)
+		}
+	}
+}
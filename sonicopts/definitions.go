@@ -16,6 +16,7 @@ const (
 	TypeNoDelay
 	TypeBindSocket
 	TypeMulticast
+	TypeZeroCopy
 	MaxOption
 )
 
@@ -33,6 +34,8 @@ func (t OptionType) String() string {
 		return "bind_socket"
 	case TypeMulticast:
 		return "multicast"
+	case TypeZeroCopy:
+		return "zero_copy"
 	default:
 		panic(fmt.Errorf("invalid option %d", t))
 	}
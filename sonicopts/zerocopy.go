@@ -0,0 +1,22 @@
+package sonicopts
+
+type zeroCopy struct {
+	v bool
+}
+
+// ZeroCopy enables SO_ZEROCOPY on the socket, which is needed by
+// AsyncWriteZeroCopy to transmit without copying. It is only supported on
+// Linux.
+func ZeroCopy(v bool) Option {
+	return &zeroCopy{
+		v: v,
+	}
+}
+
+func (o *zeroCopy) Type() OptionType {
+	return TypeZeroCopy
+}
+
+func (o *zeroCopy) Value() interface{} {
+	return o.v
+}
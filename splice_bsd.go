@@ -0,0 +1,45 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import "errors"
+
+var errSpliceUnsupported = errors.New("splice is not supported on this platform")
+
+// Splicer moves bytes between FileDescriptors with splice(2), which is only
+// available on Linux. NewSplicer always fails on this platform.
+type Splicer struct{}
+
+func NewSplicer(*IO) (*Splicer, error) {
+	return nil, errSpliceUnsupported
+}
+
+func (s *Splicer) AsyncSplice(_, _ FileDescriptor, _ int, cb AsyncCallback) {
+	cb(errSpliceUnsupported, 0)
+}
+
+func (s *Splicer) AsyncSpliceAll(_, _ FileDescriptor, _ int, cb AsyncCallback) {
+	cb(errSpliceUnsupported, 0)
+}
+
+func (s *Splicer) AsyncTee(_, _, _ FileDescriptor, _ int, cb AsyncCallback) {
+	cb(errSpliceUnsupported, 0)
+}
+
+func (s *Splicer) AsyncTeeAll(_, _, _ FileDescriptor, _ int, cb AsyncCallback) {
+	cb(errSpliceUnsupported, 0)
+}
+
+func (s *Splicer) Cancel() {}
+
+func (s *Splicer) Close() error {
+	return nil
+}
+
+func AsyncSplice(_ *IO, _, _ FileDescriptor, _ int, cb AsyncCallback) {
+	cb(errSpliceUnsupported, 0)
+}
+
+func AsyncTee(_ *IO, _, _, _ FileDescriptor, _ int, cb AsyncCallback) {
+	cb(errSpliceUnsupported, 0)
+}
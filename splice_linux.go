@@ -0,0 +1,342 @@
+//go:build linux
+
+package sonic
+
+import (
+	"io"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+const spliceFlags = unix.SPLICE_F_MOVE | unix.SPLICE_F_NONBLOCK
+
+// Splicer moves bytes between FileDescriptors without copying them to user
+// space, with splice(2) through an internal pipe. It is meant for proxying or
+// recording streams.
+//
+// A Splicer can run one transfer at a time and can be reused for many
+// transfers, which saves creating a pipe for each of them.
+//
+// The descriptors of a transfer must be nonblocking and must not have other
+// asynchronous operations pending until the transfer completes, as the Splicer
+// waits on their readiness itself.
+type Splicer struct {
+	ioc *IO
+
+	pipe     *internal.Pipe
+	pipeSize int
+
+	// teePipe holds the bytes duplicated for the recorder. It is created by
+	// the first AsyncTee.
+	teePipe *internal.Pipe
+
+	// One slot per distinct descriptor of the transfer. The source and the
+	// destination share a slot if they are the same descriptor.
+	slots   [3]internal.Slot
+	srcSlot *internal.Slot
+	dstSlot *internal.Slot
+	recSlot *internal.Slot
+
+	// state of the ongoing transfer
+	n           int
+	all         bool
+	cb          AsyncCallback
+	moved       int
+	buffered    int // bytes in pipe
+	teeBuffered int // bytes in teePipe
+	eof         bool
+}
+
+func NewSplicer(ioc *IO) (*Splicer, error) {
+	pipe, err := newSplicePipe()
+	if err != nil {
+		return nil, err
+	}
+	pipeSize, err := unix.FcntlInt(uintptr(pipe.ReadFd()), unix.F_GETPIPE_SZ, 0)
+	if err != nil {
+		_ = pipe.Close()
+		return nil, err
+	}
+	return &Splicer{
+		ioc:      ioc,
+		pipe:     pipe,
+		pipeSize: pipeSize,
+	}, nil
+}
+
+func newSplicePipe() (*internal.Pipe, error) {
+	pipe, err := internal.NewPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := pipe.SetReadNonblock(); err != nil {
+		_ = pipe.Close()
+		return nil, err
+	}
+	if err := pipe.SetWriteNonblock(); err != nil {
+		_ = pipe.Close()
+		return nil, err
+	}
+	return pipe, nil
+}
+
+// AsyncSplice moves at most n bytes from src to dst. The callback is invoked
+// once some bytes are moved, or with io.EOF if src reached its end.
+func (s *Splicer) AsyncSplice(src, dst FileDescriptor, n int, cb AsyncCallback) {
+	s.start(src, dst, nil, n, false, cb)
+}
+
+// AsyncSpliceAll moves exactly n bytes from src to dst. The callback is invoked
+// once all bytes are moved, or with io.EOF if src reached its end before.
+func (s *Splicer) AsyncSpliceAll(src, dst FileDescriptor, n int, cb AsyncCallback) {
+	s.start(src, dst, nil, n, true, cb)
+}
+
+// AsyncTee moves at most n bytes from src to dst, like AsyncSplice, and
+// duplicates them to rec with tee(2). The callback is invoked once the bytes
+// are written to both dst and rec.
+func (s *Splicer) AsyncTee(src, dst, rec FileDescriptor, n int, cb AsyncCallback) {
+	s.start(src, dst, rec, n, false, cb)
+}
+
+// AsyncTeeAll moves exactly n bytes from src to dst, like AsyncSpliceAll, and
+// duplicates them to rec.
+func (s *Splicer) AsyncTeeAll(src, dst, rec FileDescriptor, n int, cb AsyncCallback) {
+	s.start(src, dst, rec, n, true, cb)
+}
+
+func (s *Splicer) start(
+	src, dst, rec FileDescriptor,
+	n int,
+	all bool,
+	cb AsyncCallback,
+) {
+	if rec != nil && s.teePipe == nil {
+		teePipe, err := newSplicePipe()
+		if err != nil {
+			cb(err, 0)
+			return
+		}
+		s.teePipe = teePipe
+	}
+
+	s.n, s.all, s.cb = n, all, cb
+	s.moved, s.buffered, s.teeBuffered, s.eof = 0, 0, 0, false
+
+	s.slots = [3]internal.Slot{}
+	s.srcSlot = s.slotFor(src.RawFd())
+	s.dstSlot = s.slotFor(dst.RawFd())
+	s.recSlot = nil
+	if rec != nil {
+		s.recSlot = s.slotFor(rec.RawFd())
+	}
+
+	if s.ioc.Dispatched < MaxCallbackDispatch {
+		s.transfer(true)
+	} else {
+		s.wait(s.srcSlot, internal.ReadEvent)
+	}
+}
+
+// slotFor returns the slot of the passed descriptor, taking a free one if the
+// descriptor does not have a slot yet.
+func (s *Splicer) slotFor(fd int) *internal.Slot {
+	for i := range s.slots {
+		slot := &s.slots[i]
+		if slot.Fd == fd || slot.Handlers[internal.ReadEvent] == nil {
+			slot.Fd = fd
+			slot.Set(internal.ReadEvent, s.onReady(slot))
+			slot.Set(internal.WriteEvent, s.onReady(slot))
+			return slot
+		}
+	}
+	panic("unreachable")
+}
+
+func (s *Splicer) onReady(slot *internal.Slot) internal.Handler {
+	return func(err error) {
+		s.ioc.Deregister(slot)
+		if err != nil {
+			s.cb(err, s.moved)
+		} else {
+			s.transfer(false)
+		}
+	}
+}
+
+// transfer moves bytes until the transfer completes or one of the descriptors
+// would block, in which case it waits for that descriptor to be ready.
+//
+// Bytes are only read from the source once the pipes are drained, such that
+// EAGAIN from a splice into the pipe always means that the source would block.
+func (s *Splicer) transfer(dispatch bool) {
+	for {
+		if s.buffered > 0 {
+			n, err := unix.Splice(
+				s.pipe.ReadFd(), nil, s.dstSlot.Fd, nil, s.buffered, spliceFlags)
+			if err == unix.EAGAIN {
+				s.wait(s.dstSlot, internal.WriteEvent)
+				return
+			}
+			if err != nil {
+				s.complete(dispatch, err)
+				return
+			}
+			s.buffered -= int(n)
+			s.moved += int(n)
+			continue
+		}
+
+		if s.teeBuffered > 0 {
+			n, err := unix.Splice(
+				s.teePipe.ReadFd(), nil,
+				s.recSlot.Fd, nil,
+				s.teeBuffered, spliceFlags,
+			)
+			if err == unix.EAGAIN {
+				s.wait(s.recSlot, internal.WriteEvent)
+				return
+			}
+			if err != nil {
+				s.complete(dispatch, err)
+				return
+			}
+			s.teeBuffered -= int(n)
+			continue
+		}
+
+		if s.eof {
+			s.complete(dispatch, io.EOF)
+			return
+		}
+		if s.moved == s.n || (!s.all && s.moved > 0) {
+			s.complete(dispatch, nil)
+			return
+		}
+
+		toRead := s.n - s.moved
+		if toRead > s.pipeSize {
+			toRead = s.pipeSize
+		}
+		n, err := unix.Splice(
+			s.srcSlot.Fd, nil, s.pipe.WriteFd(), nil, toRead, spliceFlags)
+		if err == unix.EAGAIN {
+			s.wait(s.srcSlot, internal.ReadEvent)
+			return
+		}
+		if err != nil {
+			s.complete(dispatch, err)
+			return
+		}
+		if n == 0 {
+			s.eof = true
+			continue
+		}
+		s.buffered = int(n)
+
+		if s.recSlot != nil {
+			// Both pipes are empty and of the same size, so the bytes are
+			// duplicated at once. They can't be duplicated in parts, as tee
+			// does not consume the bytes it duplicates.
+			teed, err := unix.Tee(
+				s.pipe.ReadFd(), s.teePipe.WriteFd(),
+				s.buffered, unix.SPLICE_F_NONBLOCK,
+			)
+			if err == nil && int(teed) != s.buffered {
+				err = io.ErrShortWrite
+			}
+			if err != nil {
+				s.complete(dispatch, err)
+				return
+			}
+			s.teeBuffered = int(teed)
+		}
+	}
+}
+
+func (s *Splicer) wait(slot *internal.Slot, event internal.EventType) {
+	var err error
+	if event == internal.ReadEvent {
+		err = s.ioc.SetRead(slot)
+	} else {
+		err = s.ioc.SetWrite(slot)
+	}
+	if err != nil {
+		s.cb(err, s.moved)
+	} else {
+		s.ioc.Register(slot)
+	}
+}
+
+func (s *Splicer) complete(dispatch bool, err error) {
+	if dispatch {
+		s.ioc.Dispatched++
+		s.cb(err, s.moved)
+		s.ioc.Dispatched--
+	} else {
+		s.cb(err, s.moved)
+	}
+}
+
+// Cancel cancels the ongoing transfer, if it waits on a descriptor. The
+// callback is invoked with sonicerrors.ErrCancelled.
+func (s *Splicer) Cancel() {
+	for i := range s.slots {
+		slot := &s.slots[i]
+		if slot.Events&internal.PollerReadEvent == internal.PollerReadEvent {
+			err := s.ioc.poller.DelRead(slot)
+			if err == nil {
+				err = sonicerrors.ErrCancelled
+			}
+			slot.Handlers[internal.ReadEvent](err)
+		}
+		if slot.Events&internal.PollerWriteEvent == internal.PollerWriteEvent {
+			err := s.ioc.poller.DelWrite(slot)
+			if err == nil {
+				err = sonicerrors.ErrCancelled
+			}
+			slot.Handlers[internal.WriteEvent](err)
+		}
+	}
+}
+
+// Close cancels the ongoing transfer and closes the pipes. Bytes read from the
+// source but not yet written to the destination are lost.
+func (s *Splicer) Close() error {
+	s.Cancel()
+	if s.teePipe != nil {
+		_ = s.teePipe.Close()
+	}
+	return s.pipe.Close()
+}
+
+// AsyncSplice moves at most n bytes from src to dst with a Splicer which is
+// closed once the transfer completes. See Splicer.AsyncSplice.
+func AsyncSplice(ioc *IO, src, dst FileDescriptor, n int, cb AsyncCallback) {
+	s, err := NewSplicer(ioc)
+	if err != nil {
+		cb(err, 0)
+		return
+	}
+	s.AsyncSplice(src, dst, n, func(err error, n int) {
+		_ = s.Close()
+		cb(err, n)
+	})
+}
+
+// AsyncTee moves at most n bytes from src to dst and duplicates them to rec
+// with a Splicer which is closed once the transfer completes. See
+// Splicer.AsyncTee.
+func AsyncTee(ioc *IO, src, dst, rec FileDescriptor, n int, cb AsyncCallback) {
+	s, err := NewSplicer(ioc)
+	if err != nil {
+		cb(err, 0)
+		return
+	}
+	s.AsyncTee(src, dst, rec, n, func(err error, n int) {
+		_ = s.Close()
+		cb(err, n)
+	})
+}
@@ -0,0 +1,163 @@
+package sonic
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestSplice(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	srcWriter, src := socketPair(t, ioc)
+	dst, dstReader := socketPair(t, ioc)
+
+	// Much more than the pipe and the sockets hold, so the transfer waits on
+	// both ends.
+	sent := make([]byte, 4*1024*1024)
+	rand.Read(sent)
+	received := make([]byte, len(sent))
+
+	wrote, read, spliced := false, false, -1
+	srcWriter.AsyncWriteAll(sent, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrote = true
+	})
+	dstReader.AsyncReadAll(received, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = true
+	})
+	AsyncSplice(ioc, src, dst, len(sent), func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 || n > len(sent) {
+			t.Fatalf("invalid splice of %d bytes", n)
+		}
+		spliced = n
+	})
+	runUntil(t, ioc, func() bool { return spliced >= 0 })
+
+	s, err := NewSplicer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AsyncSpliceAll(src, dst, len(sent)-spliced, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		spliced += n
+	})
+	runUntil(t, ioc, func() bool { return wrote && read })
+	if spliced != len(sent) || !bytes.Equal(sent, received) {
+		t.Fatalf("corrupt splice of %d bytes", spliced)
+	}
+
+	// The source's end is reported once its bytes are moved.
+	_, _ = srcWriter.Write([]byte("sonic"))
+	_ = srcWriter.Close()
+
+	var results []error
+	var onSplice AsyncCallback
+	onSplice = func(err error, n int) {
+		results = append(results, err)
+		if err == nil {
+			if n != 5 {
+				t.Fatalf("invalid splice of %d bytes", n)
+			}
+			s.AsyncSplice(src, dst, 100, onSplice)
+		}
+	}
+	s.AsyncSplice(src, dst, 100, onSplice)
+	runUntil(t, ioc, func() bool { return len(results) == 2 })
+	if results[0] != nil || results[1] != io.EOF {
+		t.Fatalf("invalid results %v", results)
+	}
+}
+
+func TestTee(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	srcWriter, src := socketPair(t, ioc)
+	dst, dstReader := socketPair(t, ioc)
+	rec, recReader := socketPair(t, ioc)
+
+	sent := make([]byte, 1024*1024)
+	rand.Read(sent)
+
+	var (
+		received = make([]byte, len(sent))
+		recorded = make([]byte, len(sent))
+		done     = 0
+	)
+	onDone := func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		done++
+	}
+	srcWriter.AsyncWriteAll(sent, onDone)
+	dstReader.AsyncReadAll(received, onDone)
+	recReader.AsyncReadAll(recorded, onDone)
+
+	s, err := NewSplicer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AsyncTeeAll(src, dst, rec, len(sent), onDone)
+
+	runUntil(t, ioc, func() bool { return done == 4 })
+	if !bytes.Equal(sent, received) || !bytes.Equal(sent, recorded) {
+		t.Fatal("corrupt tee")
+	}
+
+	// A single transfer through the one-off Splicer.
+	_, _ = srcWriter.Write([]byte("sonic"))
+	AsyncTee(ioc, src, dst, rec, 100, onDone)
+	runUntil(t, ioc, func() bool { return done == 5 })
+	for _, r := range []*file{dstReader, recReader} {
+		if n, err := r.Read(received); err != nil || string(received[:n]) != "sonic" {
+			t.Fatalf("invalid read %d %v", n, err)
+		}
+	}
+}
+
+func TestSpliceCancel(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	_, src := socketPair(t, ioc)
+	dst, _ := socketPair(t, ioc)
+
+	s, err := NewSplicer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var result error
+	s.AsyncSplice(src, dst, 100, func(err error, _ int) {
+		result = err
+	})
+	_, _ = ioc.PollOne()
+	if result != nil {
+		t.Fatalf("the splice should be pending %v", result)
+	}
+
+	s.Cancel()
+	if !errors.Is(result, sonicerrors.ErrCancelled) {
+		t.Fatalf("expected ErrCancelled, got %v", result)
+	}
+}
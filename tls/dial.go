@@ -0,0 +1,146 @@
+package tls
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// Dial connects to the given address and runs the TLS handshake synchronously. Both are bounded by the given timeout,
+// after which Dial fails with sonicerrors.ErrTimeout. If config.ServerName is empty, it is inferred from addr.
+//
+// The options are applied to the socket before it connects. The connection is non-blocking: the handshake blocks polling
+// it, and asynchronous reads and writes of the returned Stream are scheduled on the IO object.
+func Dial(
+	ioc *sonic.IO,
+	network, addr string,
+	config *tls.Config,
+	timeout time.Duration,
+	opts ...sonicopts.Option,
+) (*Stream, error) {
+	deadline := time.Now().Add(timeout)
+	conn, err := sonic.DialTimeout(ioc, network, addr, timeout, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s := Client(ioc, conn, withServerName(config, addr))
+	s.transport.syncDeadline = deadline
+	err = s.Handshake()
+	s.transport.syncDeadline = time.Time{}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// AsyncDial is like Dial but connects and runs the TLS handshake asynchronously. Both are bounded by the given timeout,
+// after which the callback is invoked with sonicerrors.ErrTimeout. A non-positive timeout disables it.
+//
+// The address is resolved synchronously.
+func AsyncDial(
+	ioc *sonic.IO,
+	network, addr string,
+	config *tls.Config,
+	timeout time.Duration,
+	cb func(error, *Stream),
+	opts ...sonicopts.Option,
+) {
+	d := &asyncDialer{cb: cb}
+	if timeout > 0 {
+		timer, err := sonic.NewTimer(ioc)
+		if err == nil {
+			err = timer.ScheduleOnce(timeout, d.onTimeout)
+		}
+		if err != nil {
+			if timer != nil {
+				_ = timer.Close()
+			}
+			cb(err, nil)
+			return
+		}
+		d.timer = timer
+	}
+
+	sonic.AsyncDial(ioc, network, addr, timeout, func(err error, conn sonic.Conn) {
+		if d.done {
+			// Timed out while connecting.
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			d.finish(err, nil)
+			return
+		}
+
+		d.conn = conn
+		d.stream = Client(ioc, conn, withServerName(config, addr))
+		d.stream.AsyncHandshake(func(err error) {
+			if d.done {
+				return
+			}
+			if err != nil {
+				_ = conn.Close()
+				d.finish(err, nil)
+			} else {
+				d.finish(nil, d.stream)
+			}
+		})
+	}, opts...)
+}
+
+// asyncDialer bounds the connection and the handshake of AsyncDial with a single timer.
+type asyncDialer struct {
+	timer  *sonic.Timer
+	conn   sonic.Conn
+	stream *Stream
+	done   bool
+	cb     func(error, *Stream)
+}
+
+func (d *asyncDialer) onTimeout() {
+	if d.done {
+		return
+	}
+	if d.stream != nil {
+		// Fails the handshake, whose completion is then ignored.
+		_ = d.stream.Close()
+	} else if d.conn != nil {
+		_ = d.conn.Close()
+	}
+	d.finish(sonicerrors.ErrTimeout, nil)
+}
+
+func (d *asyncDialer) finish(err error, s *Stream) {
+	d.done = true
+	if d.timer != nil {
+		_ = d.timer.Close()
+		d.timer = nil
+	}
+	d.cb(err, s)
+}
+
+// withServerName returns a copy of the config with the ServerName set to the host of addr, unless it is already set.
+func withServerName(config *tls.Config, addr string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName != "" {
+		return config
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
@@ -0,0 +1,482 @@
+package tls
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"reflect"
+	"syscall"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var (
+	ErrKTLSUnavailable  = errors.New("ktls: tls ULP unavailable")
+	ErrKTLSUnsupported  = errors.New("ktls: unsupported connection")
+	ErrUnexpectedRecord = errors.New("ktls: unexpected record")
+)
+
+// Record content types, RFC8446 5.1.
+const (
+	recordTypeAlert           uint8 = 21
+	recordTypeHandshake       uint8 = 22
+	recordTypeApplicationData uint8 = 23
+)
+
+// Handshake message types which may be received after the handshake, RFC8446 4.
+const (
+	typeNewSessionTicket uint8 = 4
+	typeKeyUpdate        uint8 = 24
+)
+
+const alertCloseNotify uint8 = 0
+
+// AlertError is returned by a kTLS Stream when the peer sends an alert other than close_notify.
+type AlertError uint8
+
+func (e AlertError) Error() string {
+	return fmt.Sprintf("tls: received alert %d", uint8(e))
+}
+
+// trafficKeys is what the kernel needs to take over one direction of a TLS 1.3 record layer.
+type trafficKeys struct {
+	suite  uint16
+	secret []byte // the traffic secret from which key and iv are derived, needed for key updates
+	key    []byte
+	iv     []byte // the 12 byte per-record nonce base
+	seq    uint64 // the sequence number of the next record
+}
+
+// update moves the keys to the next generation of the traffic secret, after a KeyUpdate. RFC8446 7.2.
+func (k *trafficKeys) update() {
+	h := suiteHash(k.suite)
+	k.secret = hkdfExpandLabel(h, k.secret, "traffic upd", h().Size())
+	k.key, k.iv = deriveKeys(k.suite, k.secret)
+	k.seq = 0
+}
+
+// ktls is the kernel TLS state of a Stream.
+type ktls struct {
+	requested bool // kTLS should be enabled after the handshake
+	pending   bool // kTLS is enabled once crypto/tls has no buffered records
+	tx, rx    bool // the directions offloaded to the kernel
+	err       error
+
+	txKeys trafficKeys
+	rxKeys trafficKeys
+
+	// Writes queued once the kernel handles sent records. The first one is in flight if writing is true.
+	writes  []kernelWrite
+	writing bool
+	onWrite sonic.AsyncCallback
+
+	// Set if the keys could not be changed after our KeyUpdate, which fails all subsequent writes.
+	txErr error
+}
+
+// kernelWrite is a write to the next layer once the kernel handles sent records. Application data is written as is,
+// other records are sent along with their type.
+type kernelWrite struct {
+	recordType uint8
+	b          []byte
+	cb         sonic.AsyncCallback
+}
+
+// SetKTLS requests the record layer to be offloaded to the kernel once the handshake completes. It must be called
+// before the handshake.
+//
+// Offloading requires Linux with the tls ULP, TLS 1.3 with an AES-GCM or ChaCha20-Poly1305 suite and a next layer
+// backed by a socket, like a sonic.Conn. If any is missing, the Stream falls back to crypto/tls: see KTLS and KTLSErr.
+//
+// Offloading happens once crypto/tls has consumed all records it read. Afterwards, reads and writes go straight to the
+// next layer, which reads and writes plaintext while the kernel handles the records. Alerts and key updates are
+// handled by the Stream. Session tickets received afterwards are discarded.
+func (s *Stream) SetKTLS(enabled bool) {
+	s.ktls.requested = enabled
+}
+
+// KTLS returns which directions of the record layer are offloaded to the kernel.
+func (s *Stream) KTLS() (tx, rx bool) {
+	return s.ktls.tx, s.ktls.rx
+}
+
+// KTLSErr returns why the record layer could not be offloaded to the kernel, if it was requested.
+func (s *Stream) KTLSErr() error {
+	return s.ktls.err
+}
+
+// tryKTLS offloads the record layer to the kernel if it was requested and crypto/tls has no buffered records.
+func (s *Stream) tryKTLS() {
+	k := &s.ktls
+	if !k.requested || k.tx || k.rx || k.err != nil || !s.handshakeDone || s.handshakeErr != nil {
+		return
+	}
+
+	if s.flushing || s.transport.hasOutput() || s.transport.hasInput() {
+		k.pending = true
+		return
+	}
+	if n, err := bufferedRecords(s.conn); err != nil {
+		k.err = err
+		return
+	} else if n > 0 {
+		k.pending = true
+		return
+	}
+	k.pending = false
+
+	var err error
+	if k.rxKeys, err = exportKeys(s.conn, false); err == nil {
+		k.txKeys, err = exportKeys(s.conn, true)
+	}
+	if err == nil {
+		err = s.enableKTLS()
+	}
+	k.err = err
+}
+
+// enableKTLS installs the exported keys in the kernel. The receiving direction is offloaded first: if it fails, the
+// socket is left as is and crypto/tls keeps handling both directions.
+func (s *Stream) enableKTLS() error {
+	fd := s.next.RawFd()
+	if fd < 0 {
+		return ErrKTLSUnsupported
+	}
+
+	if err := setULP(fd); err != nil {
+		return err
+	}
+	if err := setKeys(fd, false, &s.ktls.rxKeys); err != nil {
+		return err
+	}
+	s.ktls.rx = true
+	if err := setKeys(fd, true, &s.ktls.txKeys); err != nil {
+		return err
+	}
+	s.ktls.tx = true
+	return nil
+}
+
+// exportKeys returns the keys crypto/tls uses to write records if write is true, or to read records otherwise.
+//
+// crypto/tls does not export its record layer state, so it is read through reflection. ErrKTLSUnsupported is returned
+// if the state cannot be found. See connLayout.
+func exportKeys(conn *tls.Conn, write bool) (keys trafficKeys, err error) {
+	state := conn.ConnectionState()
+	if state.Version != tls.VersionTLS13 {
+		return keys, ErrKTLSUnsupported
+	}
+	switch state.CipherSuite {
+	case tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256:
+	default:
+		return keys, ErrKTLSUnsupported
+	}
+
+	l, err := checkedLayout()
+	if err != nil {
+		return keys, err
+	}
+	seqIndex, secretIndex := l.inSeq, l.inSecret
+	if write {
+		seqIndex, secretIndex = l.outSeq, l.outSecret
+	}
+	c := reflect.ValueOf(conn).Elem()
+	seq, secret := c.FieldByIndex(seqIndex), c.FieldByIndex(secretIndex)
+	if secret.Len() == 0 {
+		return keys, ErrKTLSUnsupported
+	}
+
+	var b [8]byte
+	for i := range b {
+		b[i] = uint8(seq.Index(i).Uint())
+	}
+
+	keys.suite = state.CipherSuite
+	keys.secret = append([]byte(nil), secret.Bytes()...)
+	keys.key, keys.iv = deriveKeys(keys.suite, keys.secret)
+	keys.seq = binary.BigEndian.Uint64(b[:])
+	return keys, nil
+}
+
+// bufferedRecords returns the bytes crypto/tls read from the transport but did not yet return to the caller.
+func bufferedRecords(conn *tls.Conn) (int, error) {
+	l, err := checkedLayout()
+	if err != nil {
+		return 0, err
+	}
+	c := reflect.ValueOf(conn).Elem()
+
+	n := c.FieldByIndex(l.rawInputBuf).Len() - int(c.FieldByIndex(l.rawInputOff).Int())
+	n += c.FieldByIndex(l.handBuf).Len() - int(c.FieldByIndex(l.handOff).Int())
+	n += c.FieldByIndex(l.inputS).Len() - int(c.FieldByIndex(l.inputI).Int())
+	return n, nil
+}
+
+func suiteHash(suite uint16) func() hash.Hash {
+	if suite == tls.TLS_AES_256_GCM_SHA384 {
+		return sha512.New384
+	}
+	return sha256.New
+}
+
+func suiteKeyLen(suite uint16) int {
+	if suite == tls.TLS_AES_128_GCM_SHA256 {
+		return 16
+	}
+	return 32
+}
+
+// deriveKeys derives the record protection key and iv from a traffic secret. RFC8446 7.3.
+func deriveKeys(suite uint16, secret []byte) (key, iv []byte) {
+	h := suiteHash(suite)
+	key = hkdfExpandLabel(h, secret, "key", suiteKeyLen(suite))
+	iv = hkdfExpandLabel(h, secret, "iv", 12)
+	return key, iv
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label with an empty context. RFC8446 7.1.
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	info := make([]byte, 0, 2+1+6+len(label)+1)
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, uint8(6+len(label)))
+	info = append(info, "tls13 "...)
+	info = append(info, label...)
+	info = append(info, 0)
+
+	// HKDF-Expand, RFC5869 2.3.
+	var (
+		out  = make([]byte, 0, length)
+		prev []byte
+		mac  = hmac.New(h, secret)
+	)
+	for i := uint8(1); len(out) < length; i++ {
+		mac.Reset()
+		mac.Write(prev)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		prev = mac.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+// asyncReadKernel reads plaintext from the next layer once the kernel handles received records.
+func (s *Stream) asyncReadKernel(b []byte, cb sonic.AsyncCallback) {
+	s.next.AsyncRead(b, func(err error, n int) {
+		if err == syscall.EIO {
+			// The next record is not application data.
+			if err = s.handleControlRecord(); err == nil {
+				s.asyncReadKernel(b, cb)
+				return
+			}
+		}
+		cb(err, n)
+	})
+}
+
+// readKernel is the synchronous counterpart of asyncReadKernel.
+func (s *Stream) readKernel(b []byte) (n int, err error) {
+	for {
+		n, err = s.next.Read(b)
+		if err != syscall.EIO {
+			return n, err
+		}
+		if err = s.handleControlRecord(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// handleControlRecord receives the next record, which is not application data, and handles it.
+func (s *Stream) handleControlRecord() error {
+	recordType, n, err := recvControlRecord(s.next.RawFd(), s.rbuf)
+	if err != nil {
+		return err
+	}
+	record := s.rbuf[:n]
+
+	switch recordType {
+	case recordTypeAlert:
+		if len(record) != 2 {
+			return ErrUnexpectedRecord
+		}
+		if record[1] == alertCloseNotify {
+			return io.EOF
+		}
+		return AlertError(record[1])
+	case recordTypeHandshake:
+		return s.handlePostHandshake(record)
+	default:
+		return ErrUnexpectedRecord
+	}
+}
+
+// handlePostHandshake handles the handshake messages the peer may send after the handshake. RFC8446 4.6.
+func (s *Stream) handlePostHandshake(record []byte) error {
+	for len(record) > 0 {
+		if len(record) < 4 {
+			return ErrUnexpectedRecord
+		}
+		typ, length := record[0], int(record[1])<<16|int(record[2])<<8|int(record[3])
+		if len(record) < 4+length {
+			// Messages spanning multiple records are not expected after the handshake.
+			return ErrUnexpectedRecord
+		}
+		body := record[4 : 4+length]
+		record = record[4+length:]
+
+		switch typ {
+		case typeNewSessionTicket:
+			// Discarded: crypto/tls no longer sees the connection, so it cannot store the session.
+		case typeKeyUpdate:
+			if length != 1 {
+				return ErrUnexpectedRecord
+			}
+			if err := s.updateKeys(body[0] == 1); err != nil {
+				return err
+			}
+		default:
+			return ErrUnexpectedRecord
+		}
+	}
+	return nil
+}
+
+// updateKeys moves the receiving direction to the next traffic secret after the peer's KeyUpdate. If the peer
+// requested it, our KeyUpdate is queued and the sending direction is moved once it is sent.
+func (s *Stream) updateKeys(requested bool) error {
+	k := &s.ktls
+	fd := s.next.RawFd()
+
+	k.rxKeys.update()
+	if err := setKeys(fd, false, &k.rxKeys); err != nil {
+		return err
+	}
+
+	if !requested {
+		return nil
+	}
+	if !k.tx {
+		// crypto/tls owns the sending direction, and it cannot be made to send a KeyUpdate.
+		return ErrKTLSUnsupported
+	}
+	s.asyncWriteKernel(recordTypeHandshake, []byte{typeKeyUpdate, 0, 0, 1, 0}, func(err error, _ int) {
+		if err == nil {
+			k.txKeys.update()
+			err = setKeys(fd, true, &k.txKeys)
+		}
+		if err != nil {
+			k.txErr = err
+		}
+	})
+	return nil
+}
+
+// closeNotifyKernel sends the close_notify alert once the kernel handles sent records. The alert is not sent if other
+// writes are queued or if the next layer would block.
+func (s *Stream) closeNotifyKernel() error {
+	if s.ktls.writing {
+		return sonicerrors.ErrWouldBlock
+	}
+	return sendControlRecord(s.next.RawFd(), recordTypeAlert, []byte{1 /* warning */, alertCloseNotify})
+}
+
+// asyncCloseNotifyKernel sends the close_notify alert once the kernel handles sent records, after the queued writes.
+func (s *Stream) asyncCloseNotifyKernel(cb func(error)) {
+	s.asyncWriteKernel(recordTypeAlert, []byte{1 /* warning */, alertCloseNotify}, func(err error, _ int) {
+		cb(err)
+	})
+}
+
+// writeKernel writes all of b to the next layer once the kernel handles sent records, retrying while it would block.
+func (s *Stream) writeKernel(b []byte) (n int, err error) {
+	if s.ktls.writing {
+		return 0, sonicerrors.ErrWouldBlock
+	}
+	if s.ktls.txErr != nil {
+		return 0, s.ktls.txErr
+	}
+	for n < len(b) {
+		m, err := s.next.Write(b[n:])
+		n += m
+		if err != nil && err != sonicerrors.ErrWouldBlock {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// asyncWriteKernel queues a write once the kernel handles sent records. Writes run one at a time and in order, such
+// that control records, and the key changes following them, are not interleaved with application data.
+func (s *Stream) asyncWriteKernel(recordType uint8, b []byte, cb sonic.AsyncCallback) {
+	k := &s.ktls
+	k.writes = append(k.writes, kernelWrite{recordType: recordType, b: b, cb: cb})
+	if !k.writing {
+		s.writeNextKernel()
+	}
+}
+
+// writeNextKernel runs the first queued write. Control records are sent on write readiness if the next layer would
+// block.
+func (s *Stream) writeNextKernel() {
+	k := &s.ktls
+	k.writing = true
+	if k.onWrite == nil {
+		k.onWrite = s.onKernelWrite
+	}
+
+	w := k.writes[0]
+	if k.txErr != nil {
+		k.onWrite(k.txErr, 0)
+		return
+	}
+	if w.recordType == recordTypeApplicationData {
+		s.next.AsyncWriteAll(w.b, k.onWrite)
+		return
+	}
+
+	err := sendControlRecord(s.next.RawFd(), w.recordType, w.b)
+	if err == sonicerrors.ErrWouldBlock {
+		if waiter, ok := s.next.(sonic.WriteWaiter); ok {
+			waiter.AsyncWaitWrite(func(err error) {
+				if err != nil {
+					k.onWrite(err, 0)
+				} else {
+					s.writeNextKernel()
+				}
+			})
+			return
+		}
+	}
+	n := 0
+	if err == nil {
+		n = len(w.b)
+	}
+	k.onWrite(err, n)
+}
+
+// onKernelWrite completes the write in flight and runs the next one. The completion handler runs first, such that it
+// can change the keys with which the next records are sent.
+func (s *Stream) onKernelWrite(err error, n int) {
+	k := &s.ktls
+	w := k.writes[0]
+	copy(k.writes, k.writes[1:])
+	k.writes[len(k.writes)-1] = kernelWrite{}
+	k.writes = k.writes[:len(k.writes)-1]
+
+	if w.cb != nil {
+		w.cb(err, n)
+	}
+
+	k.writing = false
+	if len(k.writes) > 0 {
+		s.writeNextKernel()
+	}
+}
@@ -0,0 +1,97 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// connLayout holds the index paths of the unexported crypto/tls.Conn fields through which the record layer state is
+// exported. crypto/tls does not export this state, so it is read through reflection.
+//
+// The layout is looked up once, and only on the Go versions it was verified against: see layoutVerified. If any field
+// is missing or has an unexpected type, kTLS is unsupported and the Stream keeps using crypto/tls.
+type connLayout struct {
+	inSeq, inSecret   []int // halfConn.seq and halfConn.trafficSecret of Conn.in
+	outSeq, outSecret []int // the same, of Conn.out
+
+	rawInputBuf, rawInputOff []int // bytes.Buffer.buf and bytes.Buffer.off of Conn.rawInput
+	handBuf, handOff         []int // the same, of Conn.hand
+	inputS, inputI           []int // bytes.Reader.s and bytes.Reader.i of Conn.input
+}
+
+var (
+	layoutOnce sync.Once
+	layout     connLayout
+	layoutErr  error
+)
+
+// checkedLayout returns the layout of crypto/tls.Conn, or why it cannot be used.
+func checkedLayout() (*connLayout, error) {
+	layoutOnce.Do(func() {
+		if !layoutVerified {
+			layoutErr = fmt.Errorf(
+				"%w: the crypto/tls layout is not verified for %s", ErrKTLSUnsupported, runtime.Version())
+			return
+		}
+		layout, layoutErr = lookupLayout()
+	})
+	return &layout, layoutErr
+}
+
+// lookupLayout finds the fields of the layout and checks their types.
+func lookupLayout() (l connLayout, err error) {
+	var (
+		conn   = reflect.TypeOf(tls.Conn{})
+		buffer = reflect.TypeOf(bytes.Buffer{})
+		reader = reflect.TypeOf(bytes.Reader{})
+
+		byteSlice = reflect.TypeOf([]byte(nil))
+		seqArray  = reflect.TypeOf([8]byte{})
+		intType   = reflect.TypeOf(int(0))
+		int64Type = reflect.TypeOf(int64(0))
+	)
+
+	// field returns the index path of t.name, appended to parent, if the field has the expected type. A nil want only
+	// checks the field is a struct.
+	field := func(parent []int, t reflect.Type, name string, want reflect.Type) ([]int, reflect.Type) {
+		if err != nil {
+			return nil, nil
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			err = fmt.Errorf("%w: %s has no field %s", ErrKTLSUnsupported, t, name)
+			return nil, nil
+		}
+		if (want == nil && f.Type.Kind() != reflect.Struct) || (want != nil && f.Type != want) {
+			err = fmt.Errorf("%w: %s.%s has the unexpected type %s", ErrKTLSUnsupported, t, name, f.Type)
+			return nil, nil
+		}
+		return append(append([]int(nil), parent...), f.Index...), f.Type
+	}
+
+	in, halfConn := field(nil, conn, "in", nil)
+	l.inSeq, _ = field(in, halfConn, "seq", seqArray)
+	l.inSecret, _ = field(in, halfConn, "trafficSecret", byteSlice)
+
+	out, halfConn := field(nil, conn, "out", nil)
+	l.outSeq, _ = field(out, halfConn, "seq", seqArray)
+	l.outSecret, _ = field(out, halfConn, "trafficSecret", byteSlice)
+
+	rawInput, _ := field(nil, conn, "rawInput", buffer)
+	l.rawInputBuf, _ = field(rawInput, buffer, "buf", byteSlice)
+	l.rawInputOff, _ = field(rawInput, buffer, "off", intType)
+
+	hand, _ := field(nil, conn, "hand", buffer)
+	l.handBuf, _ = field(hand, buffer, "buf", byteSlice)
+	l.handOff, _ = field(hand, buffer, "off", intType)
+
+	input, _ := field(nil, conn, "input", reader)
+	l.inputS, _ = field(input, reader, "s", byteSlice)
+	l.inputI, _ = field(input, reader, "i", int64Type)
+
+	return l, err
+}
@@ -0,0 +1,7 @@
+//go:build !go1.28
+
+package tls
+
+// layoutVerified is true on the Go versions whose crypto/tls.Conn layout was verified against connLayout. Before
+// extending it to a new Go version, check TestConnLayout passes on it.
+const layoutVerified = true
@@ -0,0 +1,120 @@
+//go:build linux
+
+package tls
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// From linux/tcp.h and linux/tls.h.
+const (
+	tcpULP = 31
+	solTLS = 282
+
+	tlsTX            = 1
+	tlsRX            = 2
+	tlsSetRecordType = 1
+	tlsGetRecordType = 2
+
+	tls13Version = 0x0304
+
+	cipherAESGCM128        = 51
+	cipherAESGCM256        = 52
+	cipherChaCha20Poly1305 = 54
+)
+
+// setULP attaches the tls upper layer protocol to the TCP socket.
+func setULP(fd int) error {
+	if err := syscall.SetsockoptString(fd, syscall.IPPROTO_TCP, tcpULP, "tls"); err != nil {
+		return fmt.Errorf("%w: %v", ErrKTLSUnavailable, err)
+	}
+	return nil
+}
+
+// setKeys installs the keys of one direction in the kernel.
+func setKeys(fd int, write bool, keys *trafficKeys) error {
+	opt := tlsRX
+	if write {
+		opt = tlsTX
+	}
+	if err := syscall.SetsockoptString(fd, solTLS, opt, string(cryptoInfo(keys))); err != nil {
+		return fmt.Errorf("%w: %v", ErrKTLSUnavailable, err)
+	}
+	return nil
+}
+
+// cryptoInfo encodes the keys as one of the struct tls12_crypto_info_* variants: a struct tls_crypto_info header
+// followed by the iv, key, salt and record sequence number.
+func cryptoInfo(keys *trafficKeys) []byte {
+	var (
+		cipher   uint16
+		iv, salt []byte
+	)
+	switch keys.suite {
+	case tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384:
+		cipher = cipherAESGCM128
+		if keys.suite == tls.TLS_AES_256_GCM_SHA384 {
+			cipher = cipherAESGCM256
+		}
+		// The kernel splits the nonce base in a 4 byte salt and an 8 byte iv.
+		salt, iv = keys.iv[:4], keys.iv[4:]
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		cipher = cipherChaCha20Poly1305
+		iv = keys.iv
+	}
+
+	b := make([]byte, 4, 4+len(iv)+len(keys.key)+len(salt)+8)
+	*(*uint16)(unsafe.Pointer(&b[0])) = tls13Version
+	*(*uint16)(unsafe.Pointer(&b[2])) = cipher
+	b = append(b, iv...)
+	b = append(b, keys.key...)
+	b = append(b, salt...)
+	b = binary.BigEndian.AppendUint64(b, keys.seq)
+	return b
+}
+
+// recvControlRecord receives the next record, along with its type, into b.
+func recvControlRecord(fd int, b []byte) (recordType uint8, n int, err error) {
+	var oob [64]byte
+	n, oobn, _, _, err := syscall.Recvmsg(fd, b, oob[:], 0)
+	if err != nil {
+		if err == syscall.EAGAIN {
+			err = sonicerrors.ErrWouldBlock
+		}
+		return 0, 0, err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == solTLS && msg.Header.Type == tlsGetRecordType && len(msg.Data) > 0 {
+			return msg.Data[0], n, nil
+		}
+	}
+	return 0, 0, ErrUnexpectedRecord
+}
+
+// sendControlRecord sends b in a record of the given type. sonicerrors.ErrWouldBlock is returned if the socket's buffer
+// is full.
+func sendControlRecord(fd int, recordType uint8, b []byte) error {
+	oob := make([]byte, syscall.CmsgSpace(1))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = solTLS
+	h.Type = tlsSetRecordType
+	h.SetLen(syscall.CmsgLen(1))
+	oob[syscall.CmsgLen(0)] = recordType
+
+	err := syscall.Sendmsg(fd, b, oob, nil, 0)
+	if err == syscall.EAGAIN {
+		err = sonicerrors.ErrWouldBlock
+	}
+	return err
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package tls
+
+func setULP(fd int) error {
+	return ErrKTLSUnavailable
+}
+
+func setKeys(fd int, write bool, keys *trafficKeys) error {
+	return ErrKTLSUnavailable
+}
+
+func recvControlRecord(fd int, b []byte) (recordType uint8, n int, err error) {
+	return 0, 0, ErrKTLSUnavailable
+}
+
+func sendControlRecord(fd int, recordType uint8, b []byte) error {
+	return ErrKTLSUnavailable
+}
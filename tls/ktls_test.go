@@ -0,0 +1,311 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// sealRecord encrypts an application data record like the kernel would.
+func sealRecord(t *testing.T, keys *trafficKeys, plaintext []byte) []byte {
+	aead := newAEAD(t, keys)
+
+	inner := append(append([]byte(nil), plaintext...), recordTypeApplicationData)
+	header := []byte{recordTypeApplicationData, 3, 3, 0, 0}
+	binary.BigEndian.PutUint16(header[3:], uint16(len(inner)+aead.Overhead()))
+
+	record := aead.Seal(header, nonce(keys), inner, header)
+	keys.seq++
+	return record
+}
+
+// openRecord decrypts an application data record like the kernel would.
+func openRecord(t *testing.T, keys *trafficKeys, record []byte) []byte {
+	aead := newAEAD(t, keys)
+
+	inner, err := aead.Open(nil, nonce(keys), record[5:], record[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.seq++
+	assert.Equal(t, recordTypeApplicationData, inner[len(inner)-1])
+	return inner[:len(inner)-1]
+}
+
+func newAEAD(t *testing.T, keys *trafficKeys) cipher.AEAD {
+	block, err := aes.NewCipher(keys.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func nonce(keys *trafficKeys) []byte {
+	n := append([]byte(nil), keys.iv...)
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], keys.seq)
+	for i := range seq {
+		n[4+i] ^= seq[i]
+	}
+	return n
+}
+
+func readRecord(t *testing.T, conn sonic.Conn) []byte {
+	read := func(b []byte) {
+		deadline := time.Now().Add(5 * time.Second)
+		for n := 0; n < len(b); {
+			m, err := conn.Read(b[n:])
+			n += m
+			if err != nil && err.Error() != "operation would block" {
+				t.Fatal(err)
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timed out")
+			}
+		}
+	}
+
+	header := make([]byte, 5)
+	read(header)
+	record := make([]byte, 5+int(binary.BigEndian.Uint16(header[3:])))
+	copy(record, header)
+	read(record[5:])
+	return record
+}
+
+// TestExportKeys takes over the record layer from crypto/tls in userspace, like the kernel does, and checks the
+// crypto/tls peer agrees.
+func TestExportKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	serverConfig, clientConfig := testConfigs(t)
+	serverConfig.SessionTicketsDisabled = true // no records follow the handshake
+	ln := echoServer(t, serverConfig)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := Client(ioc, conn, clientConfig)
+	assert.Nil(s.Handshake())
+
+	suite := s.ConnectionState().CipherSuite
+	if suite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		t.Skip("ChaCha20-Poly1305 is not in the standard library")
+	}
+
+	n, err := bufferedRecords(s.conn)
+	assert.Nil(err)
+	assert.Equal(0, n)
+
+	tx, err := exportKeys(s.conn, true)
+	assert.Nil(err)
+	rx, err := exportKeys(s.conn, false)
+	assert.Nil(err)
+	assert.Equal(suite, tx.suite)
+	assert.Equal(uint64(0), tx.seq)
+	assert.Equal(uint64(0), rx.seq)
+	assert.Len(tx.key, suiteKeyLen(suite))
+	assert.Len(tx.iv, 12)
+
+	// A record written by crypto/tls is followed by one we seal ourselves.
+	_, err = s.Write([]byte("hello"))
+	assert.Nil(err)
+	tx.seq++
+	_, err = conn.Write(sealRecord(t, &tx, []byte(" world")))
+	assert.Nil(err)
+
+	var echo []byte
+	for len(echo) < len("hello world") {
+		echo = append(echo, openRecord(t, &rx, readRecord(t, conn))...)
+	}
+	assert.Equal("hello world", string(echo))
+
+	// Key updates are derived like crypto/tls does.
+	secret := append([]byte(nil), tx.secret...)
+	tx.update()
+	assert.NotEqual(secret, tx.secret)
+	assert.Equal(uint64(0), tx.seq)
+}
+
+func TestKTLSEcho(t *testing.T) {
+	assert := assert.New(t)
+
+	serverConfig, clientConfig := testConfigs(t)
+	ln := echoServer(t, serverConfig)
+
+	for _, maxVersion := range []uint16{tls.VersionTLS13, tls.VersionTLS12} {
+		ioc := sonic.MustIO()
+
+		conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := clientConfig.Clone()
+		config.MaxVersion = maxVersion
+		s := Client(ioc, conn, config)
+		s.SetKTLS(true)
+
+		done := false
+		s.AsyncHandshake(func(err error) {
+			assert.Nil(err)
+			done = true
+		})
+		runUntil(t, ioc, &done)
+
+		// Offloading happens once the session ticket is consumed, so after the first read.
+		done = false
+		b := make([]byte, 5)
+		s.AsyncWrite([]byte("hello"), func(err error, _ int) { assert.Nil(err) })
+		s.AsyncReadAll(b, func(err error, n int) {
+			assert.Nil(err)
+			assert.Equal("hello", string(b[:n]))
+			done = true
+		})
+		runUntil(t, ioc, &done)
+
+		tx, rx := s.KTLS()
+		switch {
+		case maxVersion == tls.VersionTLS12:
+			assert.True(errors.Is(s.KTLSErr(), ErrKTLSUnsupported))
+			assert.False(tx || rx)
+		case errors.Is(s.KTLSErr(), ErrKTLSUnavailable):
+			t.Log("kTLS is unavailable, checking the fallback:", s.KTLSErr())
+			assert.False(tx || rx)
+		default:
+			assert.Nil(s.KTLSErr())
+			assert.True(tx && rx)
+		}
+
+		// Either way the connection keeps working.
+		done = false
+		s.AsyncWrite([]byte("world"), func(err error, _ int) { assert.Nil(err) })
+		s.AsyncReadAll(b, func(err error, n int) {
+			assert.Nil(err)
+			assert.Equal("world", string(b[:n]))
+			done = true
+		})
+		runUntil(t, ioc, &done)
+
+		assert.Nil(s.Close())
+		ioc.Close()
+	}
+}
+
+func TestPostHandshakeMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	s := Client(sonic.MustIO(), nil, &tls.Config{})
+
+	// Session tickets are discarded.
+	assert.Nil(s.handlePostHandshake([]byte{typeNewSessionTicket, 0, 0, 2, 1, 2, typeNewSessionTicket, 0, 0, 0}))
+
+	// Truncated and unexpected messages fail.
+	assert.Equal(ErrUnexpectedRecord, s.handlePostHandshake([]byte{typeNewSessionTicket, 0, 0, 2, 1}))
+	assert.Equal(ErrUnexpectedRecord, s.handlePostHandshake([]byte{typeKeyUpdate, 0, 0, 2, 0, 0}))
+	assert.Equal(ErrUnexpectedRecord, s.handlePostHandshake([]byte{1, 0, 0, 0}))
+
+	assert.Equal("tls: received alert 40", AlertError(40).Error())
+}
+
+// TestConnLayout fails if crypto/tls.Conn no longer has the fields from which the record layer state is exported, or
+// if kTLS is disabled on the running Go version.
+func TestConnLayout(t *testing.T) {
+	if _, err := lookupLayout(); err != nil {
+		t.Fatalf("the crypto/tls layout changed on %s, kTLS cannot be enabled: %v", runtime.Version(), err)
+	}
+	if !layoutVerified {
+		t.Fatalf("kTLS is disabled on %s: extend layoutVerified to it", runtime.Version())
+	}
+	if _, err := checkedLayout(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKernelWriteQueue checks writes made once the kernel handles sent records are written one at a time and in order.
+func TestKernelWriteQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// Plaintext is written as is, as if the kernel handled the records.
+	s := Client(ioc, conn, &tls.Config{})
+	s.handshakeDone = true
+	s.ktls.tx = true
+
+	// Larger than the socket's buffer, such that the writes are queued.
+	var (
+		wrote     []byte
+		completed []int
+	)
+	for i := 0; i < 3; i++ {
+		b := bytes.Repeat([]byte{byte(i)}, 8*1024*1024)
+		wrote = append(wrote, b...)
+		i := i
+		s.AsyncWrite(b, func(err error, n int) {
+			assert.Nil(err)
+			assert.Equal(len(b), n)
+			completed = append(completed, i)
+		})
+	}
+	_, err = s.Write([]byte("x"))
+	assert.Equal(sonicerrors.ErrWouldBlock, err)
+
+	read := make([]byte, len(wrote))
+	readDone := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(peer, read)
+		readDone <- err
+	}()
+
+	done := false
+	for !done {
+		_ = ioc.RunOneFor(time.Millisecond)
+		done = len(completed) == 3
+	}
+	assert.Nil(<-readDone)
+	assert.Equal([]int{0, 1, 2}, completed)
+	assert.True(bytes.Equal(wrote, read))
+	assert.False(s.ktls.writing)
+	assert.Empty(s.ktls.writes)
+}
@@ -0,0 +1,515 @@
+// Package tls implements a TLS stream which drives crypto/tls over any sonic.Stream, without blocking the IO loop.
+//
+// crypto/tls is only ever handed an in-memory transport: records read from the next layer are fed to it, and the
+// records it produces are written to the next layer by the Stream. After the handshake, reads and writes are fully
+// asynchronous and run on the goroutine which runs the IO object.
+//
+// AsyncHandshake runs the crypto/tls handshake on a separate goroutine, but all IO is still scheduled on the IO object.
+// Each asynchronous handshake in flight therefore costs one goroutine, parked while it waits for the peer, which lives
+// until the handshake completes, fails or times out: see Stream.SetHandshakeTimeout.
+package tls
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+var (
+	ErrHandshakeInProgress = errors.New("tls handshake in progress")
+)
+
+// DefaultHandshakeTimeout bounds AsyncHandshake, see SetHandshakeTimeout.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// readBufferSize can hold a maximum size TLS record.
+const readBufferSize = 16*1024 + 2048
+
+var _ sonic.Stream = &Stream{}
+
+// Stream is a TLS connection over a sonic.Stream.
+//
+// Like the rest of sonic, a Stream is not safe for concurrent use: all its methods must be called from the goroutine
+// which runs its IO object. Only one asynchronous read may be in flight at any time. Writes may be issued while
+// previous writes are in flight, in which case they are written in order.
+type Stream struct {
+	ioc       *sonic.IO
+	next      sonic.Stream
+	conn      *tls.Conn
+	transport *transport
+
+	handshaking        bool
+	handshakeDone      bool
+	handshakeErr       error
+	handshakeCallbacks []func(error)
+	handshakeTimeout   time.Duration
+	handshakeTimer     *time.Timer
+
+	// Buffer into which the next layer is read.
+	rbuf []byte
+
+	// Records which are currently written to the next layer. Swapped with the transport's output buffer.
+	wbuf           []byte
+	flushing       bool
+	flushCallbacks []func(error)
+	spareCallbacks []func(error)
+
+	closed bool
+
+	ktls ktls
+}
+
+// Client returns a new TLS client side Stream using next as the underlying transport. The config cannot be nil: users
+// must set either ServerName or InsecureSkipVerify in the config.
+//
+// The handshake is performed on the first read or write, or explicitly through Handshake or AsyncHandshake.
+func Client(ioc *sonic.IO, next sonic.Stream, config *tls.Config) *Stream {
+	s := newStream(ioc, next)
+	s.conn = tls.Client(s.transport, config)
+	return s
+}
+
+// Server returns a new TLS server side Stream using next as the underlying transport. The config must be non-nil and
+// must include at least one certificate or else set GetCertificate.
+//
+// The handshake is performed on the first read or write, or explicitly through Handshake or AsyncHandshake.
+func Server(ioc *sonic.IO, next sonic.Stream, config *tls.Config) *Stream {
+	s := newStream(ioc, next)
+	s.conn = tls.Server(s.transport, config)
+	return s
+}
+
+func newStream(ioc *sonic.IO, next sonic.Stream) *Stream {
+	s := &Stream{
+		ioc:              ioc,
+		next:             next,
+		rbuf:             make([]byte, readBufferSize),
+		handshakeTimeout: DefaultHandshakeTimeout,
+	}
+	s.transport = newTransport(s)
+	return s
+}
+
+// SetHandshakeTimeout bounds the duration of AsyncHandshake, after which it fails with sonicerrors.ErrTimeout and its
+// goroutine exits, whether or not the IO object is still run. 0 means no timeout.
+//
+// SetHandshakeTimeout must be called before the handshake.
+func (s *Stream) SetHandshakeTimeout(timeout time.Duration) {
+	s.handshakeTimeout = timeout
+}
+
+// Handshake runs the TLS handshake synchronously, if it has not yet been run.
+//
+// If the next layer is non-blocking, like a sonic.Conn, the handshake blocks polling its file descriptor, or busy-waits
+// if it has none. Prefer AsyncHandshake in that case.
+func (s *Stream) Handshake() error {
+	if s.handshakeDone {
+		return s.handshakeErr
+	}
+	if s.handshaking {
+		return ErrHandshakeInProgress
+	}
+
+	s.transport.setMode(modeSync)
+	err := s.conn.Handshake()
+	s.transport.setMode(modeAsync)
+
+	s.handshakeDone = true
+	s.handshakeErr = err
+	if err == nil {
+		s.tryKTLS()
+	}
+	return err
+}
+
+// AsyncHandshake runs the TLS handshake asynchronously, if it has not yet been run. The callback is invoked once the
+// handshake completes, including the write of the handshake's last flight.
+//
+// crypto/tls runs the handshake on a separate goroutine. All reads and writes of the next layer are scheduled on the
+// Stream's IO object, which must be run for the handshake to progress. The goroutine only runs crypto/tls' computations
+// and never touches the next layer: crypto/tls cannot resume a handshake interrupted by a read which would block, as it
+// latches any error returned during the handshake, so the handshake must be able to block on the in-memory transport.
+//
+// The goroutine exits once the handshake completes or fails, which it does when the Stream is closed, when the next
+// layer fails, when the IO object is closed, or once the handshake timeout expires.
+func (s *Stream) AsyncHandshake(cb func(error)) {
+	if s.handshakeDone {
+		cb(s.handshakeErr)
+		return
+	}
+
+	s.handshakeCallbacks = append(s.handshakeCallbacks, cb)
+	if s.handshaking {
+		return
+	}
+	s.handshaking = true
+
+	if s.handshakeTimeout > 0 {
+		s.handshakeTimer = time.NewTimer(s.handshakeTimeout)
+		s.transport.deadline = s.handshakeTimer.C
+	}
+	s.transport.setMode(modeBridged)
+	go func() {
+		err := s.conn.Handshake()
+		_ = s.ioc.Post(func() {
+			s.onHandshake(err)
+		})
+	}()
+}
+
+// pump is posted by the handshake goroutine when crypto/tls needs more records. It writes crypto/tls' pending output,
+// after which it reads the next records from the next layer.
+func (s *Stream) pump() {
+	s.flush(func(err error) {
+		if err != nil {
+			// flush failed the transport, which wakes up the handshake goroutine.
+			return
+		}
+		s.next.AsyncRead(s.rbuf, func(err error, n int) {
+			if n > 0 {
+				s.transport.feed(s.rbuf[:n])
+			}
+			if err != nil {
+				s.transport.fail(err)
+			}
+		})
+	})
+}
+
+func (s *Stream) onHandshake(err error) {
+	s.transport.setMode(modeAsync)
+	if s.handshakeTimer != nil {
+		s.handshakeTimer.Stop()
+		s.handshakeTimer = nil
+	}
+
+	finish := func(err error) {
+		s.handshaking = false
+		s.handshakeDone = true
+		s.handshakeErr = err
+		if err == nil {
+			s.tryKTLS()
+		}
+
+		callbacks := s.handshakeCallbacks
+		s.handshakeCallbacks = nil
+		for _, cb := range callbacks {
+			cb(err)
+		}
+	}
+
+	// The last flight of the handshake, or the alert it failed with, is written before reporting the outcome.
+	s.flush(func(flushErr error) {
+		if err == nil {
+			err = flushErr
+		}
+		finish(err)
+	})
+}
+
+// Read reads decrypted application data into b. If the handshake has not yet been run, it is run synchronously first.
+//
+// If no application data is buffered, at most one read of the next layer is attempted. If the next layer is
+// non-blocking and has no data, sonicerrors.ErrWouldBlock is returned.
+func (s *Stream) Read(b []byte) (int, error) {
+	if err := s.Handshake(); err != nil {
+		return 0, err
+	}
+	if s.ktls.rx {
+		return s.readKernel(b)
+	}
+
+	attempted := false
+	for {
+		n, err := s.conn.Read(b)
+
+		if s.transport.hasOutput() {
+			// Replies to the peer's messages, like key updates.
+			if flushErr := s.transport.flushSync(); err == nil && flushErr != nil {
+				err = flushErr
+			}
+		}
+
+		if err != errWouldBlock {
+			if s.ktls.pending {
+				s.tryKTLS()
+			}
+			return n, err
+		}
+		if attempted {
+			return 0, sonicerrors.ErrWouldBlock
+		}
+		attempted = true
+
+		n, err = s.next.Read(s.rbuf)
+		if n > 0 {
+			s.transport.feed(s.rbuf[:n])
+		}
+		if err != nil {
+			if err == sonicerrors.ErrWouldBlock {
+				return 0, err
+			}
+			s.transport.fail(err)
+		}
+	}
+}
+
+// AsyncRead reads decrypted application data into b. If the handshake has not yet been run, it is run asynchronously
+// first.
+func (s *Stream) AsyncRead(b []byte, cb sonic.AsyncCallback) {
+	if !s.handshakeDone {
+		s.AsyncHandshake(func(err error) {
+			if err != nil {
+				cb(err, 0)
+			} else {
+				s.AsyncRead(b, cb)
+			}
+		})
+		return
+	}
+	if s.ktls.rx {
+		s.asyncReadKernel(b, cb)
+		return
+	}
+
+	n, err := s.conn.Read(b)
+
+	if s.transport.hasOutput() {
+		// Replies to the peer's messages, like key updates. Errors surface on the next write.
+		s.flush(nil)
+	}
+
+	if err == errWouldBlock {
+		s.next.AsyncRead(s.rbuf, func(err error, n int) {
+			if n > 0 {
+				s.transport.feed(s.rbuf[:n])
+			}
+			if err != nil {
+				s.transport.fail(err)
+			}
+			s.AsyncRead(b, cb)
+		})
+		return
+	}
+
+	if s.ktls.pending {
+		s.tryKTLS()
+	}
+	cb(err, n)
+}
+
+// AsyncReadAll reads exactly len(b) bytes of decrypted application data into b.
+func (s *Stream) AsyncReadAll(b []byte, cb sonic.AsyncCallback) {
+	s.asyncReadAll(b, 0, cb)
+}
+
+func (s *Stream) asyncReadAll(b []byte, readBytes int, cb sonic.AsyncCallback) {
+	s.AsyncRead(b[readBytes:], func(err error, n int) {
+		readBytes += n
+		if err != nil || readBytes == len(b) {
+			cb(err, readBytes)
+		} else {
+			s.asyncReadAll(b, readBytes, cb)
+		}
+	})
+}
+
+// Write encrypts b and writes it to the next layer. If the handshake has not yet been run, it is run synchronously
+// first.
+//
+// If the next layer is non-blocking, Write blocks polling its file descriptor, or busy-waits if it has none, until all
+// records are written.
+func (s *Stream) Write(b []byte) (int, error) {
+	if err := s.Handshake(); err != nil {
+		return 0, err
+	}
+	if s.flushing {
+		return 0, sonicerrors.ErrWouldBlock
+	}
+	if s.ktls.tx {
+		return s.writeKernel(b)
+	}
+
+	n, err := s.conn.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, s.transport.flushSync()
+}
+
+// AsyncWrite encrypts b and writes it to the next layer. If the handshake has not yet been run, it is run
+// asynchronously first.
+//
+// b is encrypted before AsyncWrite returns, so it can be reused immediately. The callback is invoked once the records
+// are written to the next layer.
+func (s *Stream) AsyncWrite(b []byte, cb sonic.AsyncCallback) {
+	if !s.handshakeDone {
+		s.AsyncHandshake(func(err error) {
+			if err != nil {
+				cb(err, 0)
+			} else {
+				s.AsyncWrite(b, cb)
+			}
+		})
+		return
+	}
+	if s.ktls.tx {
+		s.asyncWriteKernel(recordTypeApplicationData, b, cb)
+		return
+	}
+
+	n, err := s.conn.Write(b)
+	if err != nil {
+		cb(err, n)
+		return
+	}
+
+	s.flush(func(err error) {
+		if err != nil {
+			cb(err, 0)
+		} else {
+			cb(nil, n)
+		}
+	})
+}
+
+// AsyncWriteAll is the same as AsyncWrite: all of b is always written, unless an error occurs.
+func (s *Stream) AsyncWriteAll(b []byte, cb sonic.AsyncCallback) {
+	s.AsyncWrite(b, cb)
+}
+
+// flush writes all records produced by crypto/tls to the next layer. The optional callback is invoked once no records
+// are left to write, or when writing fails.
+//
+// Records produced while a flush is in progress are written once it completes. This keeps records in order.
+func (s *Stream) flush(cb func(error)) {
+	if cb != nil {
+		s.flushCallbacks = append(s.flushCallbacks, cb)
+	}
+	if s.flushing {
+		return
+	}
+
+	out := s.transport.swapOutput(s.wbuf[:0])
+	if len(out) == 0 {
+		s.wbuf = out
+		if s.ktls.pending {
+			s.tryKTLS()
+		}
+		s.completeFlush(nil)
+		return
+	}
+
+	s.flushing = true
+	s.next.AsyncWriteAll(out, func(err error, _ int) {
+		s.flushing = false
+		s.wbuf = out[:0]
+
+		if err != nil {
+			s.transport.fail(err)
+			s.completeFlush(err)
+		} else {
+			s.flush(nil)
+		}
+	})
+}
+
+func (s *Stream) completeFlush(err error) {
+	callbacks := s.flushCallbacks
+	s.flushCallbacks = s.spareCallbacks[:0]
+	for i, cb := range callbacks {
+		callbacks[i] = nil
+		cb(err)
+	}
+	s.spareCallbacks = callbacks[:0]
+}
+
+// Close sends a close_notify alert, if the handshake completed, and closes the next layer.
+//
+// If the next layer is non-blocking, Close busy-waits until the alert is written. Once the kernel handles sent
+// records, the alert is only sent if it can be written immediately.
+func (s *Stream) Close() error {
+	if s.closed {
+		return net.ErrClosed
+	}
+	s.closed = true
+
+	if s.handshaking {
+		// Unblocks the handshake goroutine.
+		s.transport.fail(net.ErrClosed)
+	} else if s.ktls.tx {
+		_ = s.closeNotifyKernel()
+	} else if !s.flushing {
+		_ = s.conn.Close()
+		_ = s.transport.flushSync()
+	}
+	return s.next.Close()
+}
+
+// AsyncClose sends a close_notify alert asynchronously, if the handshake completed, and then closes the next layer.
+func (s *Stream) AsyncClose(cb func(err error)) {
+	if s.closed {
+		cb(net.ErrClosed)
+		return
+	}
+	s.closed = true
+
+	if s.handshaking {
+		s.transport.fail(net.ErrClosed)
+		cb(s.next.Close())
+		return
+	}
+	if s.ktls.tx {
+		s.asyncCloseNotifyKernel(func(error) {
+			cb(s.next.Close())
+		})
+		return
+	}
+
+	_ = s.conn.Close()
+	s.flush(func(error) {
+		cb(s.next.Close())
+	})
+}
+
+// Cancel cancels all asynchronous operations on the next layer.
+func (s *Stream) Cancel() {
+	s.next.Cancel()
+}
+
+func (s *Stream) RawFd() int {
+	return s.next.RawFd()
+}
+
+// NextLayer returns the stream over which TLS records are read and written.
+func (s *Stream) NextLayer() sonic.Stream {
+	return s.next
+}
+
+// ConnectionState returns basic TLS details about the connection, such as the negotiated protocol and whether the
+// session was resumed. It returns the zero value until the handshake completes.
+func (s *Stream) ConnectionState() tls.ConnectionState {
+	if !s.handshakeDone {
+		return tls.ConnectionState{}
+	}
+	return s.conn.ConnectionState()
+}
+
+// HandshakeComplete returns true once the handshake completed successfully.
+func (s *Stream) HandshakeComplete() bool {
+	return s.handshakeDone && s.handshakeErr == nil
+}
+
+// LocalAddr returns the local address of the next layer, if it is a net.Conn.
+func (s *Stream) LocalAddr() net.Addr {
+	return s.transport.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the next layer, if it is a net.Conn.
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.transport.RemoteAddr()
+}
@@ -0,0 +1,465 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// testConfigs returns a server config with a self-signed certificate for localhost and a client config trusting it.
+func testConfigs(t *testing.T) (server *tls.Config, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{"sonic"},
+	}
+	client = &tls.Config{
+		RootCAs:    pool,
+		ServerName: "localhost",
+		NextProtos: []string{"sonic"},
+	}
+	return server, client
+}
+
+// echoServer runs a crypto/tls server which echoes everything it reads.
+func echoServer(t *testing.T, config *tls.Config) net.Listener {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+func runUntil(t *testing.T, ioc *sonic.IO, done *bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !*done && time.Now().Before(deadline) {
+		_, _ = ioc.PollOne()
+	}
+	if !*done {
+		t.Fatal("timed out")
+	}
+}
+
+func TestClientAsync(t *testing.T) {
+	assert := assert.New(t)
+
+	serverConfig, clientConfig := testConfigs(t)
+	ln := echoServer(t, serverConfig)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	var (
+		s    *Stream
+		done bool
+	)
+	AsyncDial(ioc, "tcp", ln.Addr().String(), clientConfig, time.Second, func(err error, stream *Stream) {
+		if !assert.Nil(err) {
+			done = true
+			return
+		}
+		s = stream
+		done = true
+	})
+	runUntil(t, ioc, &done)
+	assert.True(s.HandshakeComplete())
+
+	state := s.ConnectionState()
+	assert.True(state.HandshakeComplete)
+	assert.Equal("sonic", state.NegotiatedProtocol)
+
+	// A payload spanning multiple records, written in several pieces.
+	payload := make([]byte, 256*1024)
+	_, _ = rand.Read(payload)
+	for i := 0; i < len(payload); i += 64 * 1024 {
+		s.AsyncWrite(payload[i:i+64*1024], func(err error, n int) {
+			assert.Nil(err)
+			assert.Equal(64*1024, n)
+		})
+	}
+
+	done = false
+	echo := make([]byte, len(payload))
+	s.AsyncReadAll(echo, func(err error, n int) {
+		assert.Nil(err)
+		assert.Equal(len(payload), n)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+	assert.True(bytes.Equal(payload, echo))
+
+	done = false
+	s.AsyncClose(func(err error) {
+		assert.Nil(err)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+}
+
+func TestClientSync(t *testing.T) {
+	assert := assert.New(t)
+
+	serverConfig, clientConfig := testConfigs(t)
+	ln := echoServer(t, serverConfig)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	s, err := Dial(ioc, "tcp", ln.Addr().String(), clientConfig, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	n, err := s.Write([]byte("hello"))
+	assert.Nil(err)
+	assert.Equal(5, n)
+
+	b := make([]byte, 5)
+	read := 0
+	deadline := time.Now().Add(5 * time.Second)
+	for read < len(b) && time.Now().Before(deadline) {
+		n, err := s.Read(b[read:])
+		read += n
+		if err != nil {
+			assert.Equal(err.Error(), "operation would block")
+		}
+	}
+	assert.Equal("hello", string(b))
+}
+
+func TestDialTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	// The connection is established by the kernel, but the server never answers the ClientHello.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	_, clientConfig := testConfigs(t)
+
+	start := time.Now()
+	s, err := Dial(ioc, "tcp", ln.Addr().String(), clientConfig, 50*time.Millisecond)
+	assert.Equal(sonicerrors.ErrTimeout, err)
+	assert.Nil(s)
+	assert.Less(time.Since(start), time.Second)
+}
+
+func TestDialBindSocket(t *testing.T) {
+	assert := assert.New(t)
+
+	serverConfig, clientConfig := testConfigs(t)
+	ln := echoServer(t, serverConfig)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	// The socket is bound before it connects.
+	bind := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}
+	s, err := Dial(ioc, "tcp", ln.Addr().String(), clientConfig, 5*time.Second, sonicopts.BindSocket(bind))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	local := s.next.(sonic.Conn).LocalAddr().(*net.TCPAddr)
+	assert.True(bind.IP.Equal(local.IP))
+}
+
+func TestServerAsync(t *testing.T) {
+	assert := assert.New(t)
+
+	serverConfig, clientConfig := testConfigs(t)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "127.0.0.1:0", sonicopts.Nonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The listener reports the address it was created with, so the port is looked up.
+	addr, err := internal.SocketAddress(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientErr := make(chan error, 1)
+	go func() {
+		conn, err := tls.Dial("tcp", addr.String(), clientConfig)
+		if err != nil {
+			clientErr <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			clientErr <- err
+			return
+		}
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			clientErr <- err
+			return
+		}
+		if string(b) != "pong" {
+			clientErr <- io.ErrUnexpectedEOF
+			return
+		}
+		clientErr <- nil
+	}()
+
+	done := false
+	ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if !assert.Nil(err) {
+			done = true
+			return
+		}
+
+		s := Server(ioc, conn, serverConfig)
+		b := make([]byte, 4)
+		// The handshake is run implicitly by the first read.
+		s.AsyncReadAll(b, func(err error, n int) {
+			assert.Nil(err)
+			assert.Equal("ping", string(b[:n]))
+			assert.Equal("sonic", s.ConnectionState().NegotiatedProtocol)
+
+			s.AsyncWrite([]byte("pong"), func(err error, _ int) {
+				assert.Nil(err)
+				done = true
+			})
+		})
+	})
+	runUntil(t, ioc, &done)
+
+	select {
+	case err := <-clientErr:
+		assert.Nil(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("client timed out")
+	}
+}
+
+func TestSessionResumption(t *testing.T) {
+	assert := assert.New(t)
+
+	serverConfig, clientConfig := testConfigs(t)
+	clientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	ln := echoServer(t, serverConfig)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	connect := func() tls.ConnectionState {
+		var (
+			s    *Stream
+			done bool
+		)
+		AsyncDial(ioc, "tcp", ln.Addr().String(), clientConfig, time.Second, func(err error, stream *Stream) {
+			assert.Nil(err)
+			s = stream
+			done = true
+		})
+		runUntil(t, ioc, &done)
+		if s == nil {
+			t.FailNow()
+		}
+
+		// The session ticket is sent after the handshake, so it is received by the first read.
+		done = false
+		b := make([]byte, 1)
+		s.AsyncWrite([]byte{1}, func(err error, _ int) { assert.Nil(err) })
+		s.AsyncReadAll(b, func(err error, _ int) {
+			assert.Nil(err)
+			done = true
+		})
+		runUntil(t, ioc, &done)
+
+		state := s.ConnectionState()
+		assert.Nil(s.Close())
+		return state
+	}
+
+	assert.False(connect().DidResume)
+	assert.True(connect().DidResume)
+}
+
+func TestHandshakeError(t *testing.T) {
+	assert := assert.New(t)
+
+	serverConfig, _ := testConfigs(t)
+	ln := echoServer(t, serverConfig)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	// The client does not trust the server's certificate.
+	done := false
+	AsyncDial(ioc, "tcp", ln.Addr().String(), &tls.Config{}, time.Second, func(err error, s *Stream) {
+		assert.NotNil(err)
+		assert.Nil(s)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+}
+
+func TestAsyncDialTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	// The connection is established by the kernel, but the server never answers the ClientHello.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	_, clientConfig := testConfigs(t)
+
+	done := false
+	start := time.Now()
+	AsyncDial(ioc, "tcp", ln.Addr().String(), clientConfig, 50*time.Millisecond, func(err error, s *Stream) {
+		assert.Equal(sonicerrors.ErrTimeout, err)
+		assert.Nil(s)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+	assert.Less(time.Since(start), time.Second)
+
+	// The handshake's completion is not reported once timed out.
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		_, _ = ioc.PollOne()
+	}
+}
+
+func TestAsyncHandshakeReleasesGoroutine(t *testing.T) {
+	// The connection is established by the kernel, but the server never answers the ClientHello.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	_, clientConfig := testConfigs(t)
+
+	// waitGoroutines waits until the goroutines started since before exited.
+	waitGoroutines := func(before int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				t.Fatalf("the handshake goroutine did not exit, %d > %d", runtime.NumGoroutine(), before)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	handshake := func(ioc *sonic.IO, timeout time.Duration, cb func(error)) {
+		conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := Client(ioc, conn, clientConfig)
+		s.SetHandshakeTimeout(timeout)
+		s.AsyncHandshake(cb)
+
+		// The ClientHello is written, after which the IO object is no longer run.
+		deadline := time.Now().Add(20 * time.Millisecond)
+		for time.Now().Before(deadline) {
+			_, _ = ioc.PollOne()
+		}
+	}
+
+	// The handshake times out although its IO object is no longer run.
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	before := runtime.NumGoroutine()
+	var handshakeErr error
+	handshake(ioc, 50*time.Millisecond, func(err error) { handshakeErr = err })
+	waitGoroutines(before)
+
+	done := false
+	for !done {
+		_, _ = ioc.PollOne()
+		done = handshakeErr != nil
+	}
+	if handshakeErr != sonicerrors.ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", handshakeErr)
+	}
+
+	// The handshake goroutine exits once the IO object is closed, without a timeout.
+	closedIOC := sonic.MustIO()
+	before = runtime.NumGoroutine()
+	handshake(closedIOC, 0, func(error) {})
+	_ = closedIOC.Close()
+	waitGoroutines(before)
+}
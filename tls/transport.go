@@ -0,0 +1,292 @@
+package tls
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+// mode dictates how the transport moves records between crypto/tls and the next layer.
+type mode uint8
+
+const (
+	// modeAsync is the mode in which the Stream is after the handshake. Reads return errWouldBlock when no records are
+	// buffered, and writes are buffered until the Stream flushes them asynchronously.
+	modeAsync mode = iota
+
+	// modeBridged is used by AsyncHandshake, during which crypto/tls runs on its own goroutine. Reads block until the
+	// IO loop feeds records read from the next layer. Writes are buffered and flushed by the IO loop.
+	modeBridged
+
+	// modeSync is used by the synchronous Stream methods. Records are read from and written to the next layer directly.
+	modeSync
+)
+
+// wouldBlock is returned by the transport in modeAsync when no records are buffered. It is temporary, which means
+// crypto/tls does not latch it: the read can be retried once more records are fed.
+type wouldBlock struct{}
+
+func (wouldBlock) Error() string   { return sonicerrors.ErrWouldBlock.Error() }
+func (wouldBlock) Timeout() bool   { return false }
+func (wouldBlock) Temporary() bool { return true }
+
+var errWouldBlock net.Error = wouldBlock{}
+
+var _ net.Conn = &transport{}
+
+// transport is the net.Conn through which crypto/tls reads and writes records. Records are buffered in memory such that
+// the Stream can move them to and from the next layer asynchronously.
+//
+// The mutex is only contended in modeBridged, when the handshake goroutine and the IO loop share the buffers.
+type transport struct {
+	s *Stream
+
+	mu   sync.Mutex
+	in   []byte // records read from the next layer, not yet consumed by crypto/tls
+	out  []byte // records produced by crypto/tls, not yet written to the next layer
+	err  error  // sticky error of the next layer
+	mode mode
+
+	// Signalled, in modeBridged, once records or an error are fed.
+	wake chan struct{}
+
+	// Fires, in modeBridged, once the handshake deadline expires. nil if the handshake has no deadline.
+	deadline <-chan time.Time
+
+	// Bounds, in modeSync, the wait for the next layer. Zero if there is no deadline.
+	syncDeadline time.Time
+}
+
+// closedCheckInterval is the period at which a handshake goroutine waiting for records checks whether the IO object was
+// closed, in which case the records will never come.
+const closedCheckInterval = 100 * time.Millisecond
+
+func newTransport(s *Stream) *transport {
+	return &transport{
+		s:    s,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+func (t *transport) Read(b []byte) (n int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for len(t.in) == 0 && t.err == nil {
+		switch t.mode {
+		case modeAsync:
+			return 0, errWouldBlock
+		case modeBridged:
+			t.mu.Unlock()
+			err := t.wait()
+			t.mu.Lock()
+			if err != nil && t.err == nil {
+				t.err = err
+			}
+		case modeSync:
+			t.mu.Unlock()
+			err := t.readSync()
+			t.mu.Lock()
+			if err != nil {
+				t.err = err
+			}
+		}
+	}
+
+	if len(t.in) == 0 {
+		return 0, t.err
+	}
+	n = copy(b, t.in)
+	t.in = t.in[:copy(t.in, t.in[n:])]
+	return n, nil
+}
+
+// wait asks the IO loop for more records and blocks until they or an error are fed. It gives up once the handshake
+// deadline expires or the IO object is closed, such that the handshake goroutine does not outlive an IO object which is
+// no longer run.
+func (t *transport) wait() error {
+	if err := t.s.ioc.Post(t.s.pump); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-t.wake:
+			return nil
+		case <-t.deadline:
+			return sonicerrors.ErrTimeout
+		case <-time.After(closedCheckInterval):
+			if t.s.ioc.Closed() {
+				return net.ErrClosed
+			}
+		}
+	}
+}
+
+// readSync reads the next records from the next layer, retrying while it would block.
+func (t *transport) readSync() error {
+	buf := t.s.rbuf
+	for {
+		n, err := t.s.next.Read(buf)
+		if n > 0 {
+			t.mu.Lock()
+			t.in = append(t.in, buf[:n]...)
+			t.mu.Unlock()
+			return nil
+		}
+		if err == nil || err == sonicerrors.ErrWouldBlock {
+			if err := t.waitSync(unix.POLLIN); err != nil {
+				return err
+			}
+			continue
+		}
+		return err
+	}
+}
+
+// waitSync waits until the next layer is ready for the given poll events, or until the sync deadline expires. If the
+// next layer has no file descriptor, it returns immediately, such that the caller busy-waits.
+func (t *transport) waitSync(events int16) error {
+	timeout := -1
+	if !t.syncDeadline.IsZero() {
+		remaining := time.Until(t.syncDeadline)
+		if remaining <= 0 {
+			return sonicerrors.ErrTimeout
+		}
+		// Rounded up, such that the deadline has expired once poll times out.
+		timeout = int((remaining + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	fd := t.s.next.RawFd()
+	if fd < 0 {
+		return nil
+	}
+	_, err := unix.Poll([]unix.PollFd{{Fd: int32(fd), Events: events}}, timeout)
+	if err != nil && err != unix.EINTR {
+		return err
+	}
+	return nil
+}
+
+func (t *transport) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	if t.err != nil {
+		t.mu.Unlock()
+		return 0, t.err
+	}
+	t.out = append(t.out, b...)
+	sync := t.mode == modeSync
+	t.mu.Unlock()
+
+	if sync {
+		if err := t.flushSync(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// flushSync writes all buffered records to the next layer, retrying while it would block.
+func (t *transport) flushSync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+
+	for len(t.out) > 0 {
+		n, err := t.s.next.Write(t.out)
+		t.out = t.out[:copy(t.out, t.out[n:])]
+		if err != nil && err != sonicerrors.ErrWouldBlock {
+			t.err = err
+			return err
+		}
+		if len(t.out) > 0 {
+			if err := t.waitSync(unix.POLLOUT); err != nil {
+				t.err = err
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// feed buffers the records read from the next layer.
+func (t *transport) feed(b []byte) {
+	t.mu.Lock()
+	t.in = append(t.in, b...)
+	t.mu.Unlock()
+	t.signal()
+}
+
+// fail makes all subsequent reads and writes fail with err, once the buffered records are consumed.
+func (t *transport) fail(err error) {
+	t.mu.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+	t.mu.Unlock()
+	t.signal()
+}
+
+func (t *transport) signal() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// swapOutput returns the buffered records and replaces the output buffer with b.
+func (t *transport) swapOutput(b []byte) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := t.out
+	t.out = b
+	return out
+}
+
+func (t *transport) hasOutput() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.out) > 0
+}
+
+func (t *transport) hasInput() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.in) > 0
+}
+
+func (t *transport) setMode(m mode) {
+	t.mu.Lock()
+	t.mode = m
+	t.mu.Unlock()
+}
+
+// Close is called by crypto/tls when the Stream is closed. It is a no-op: the Stream closes the next layer itself, once
+// the close_notify alert is written.
+func (t *transport) Close() error {
+	return nil
+}
+
+func (t *transport) LocalAddr() net.Addr {
+	if conn, ok := t.s.next.(net.Conn); ok {
+		return conn.LocalAddr()
+	}
+	return nil
+}
+
+func (t *transport) RemoteAddr() net.Addr {
+	if conn, ok := t.s.next.(net.Conn); ok {
+		return conn.RemoteAddr()
+	}
+	return nil
+}
+
+// Deadlines are not supported: crypto/tls only sets them to bound the close_notify write, which the transport buffers.
+func (t *transport) SetDeadline(time.Time) error      { return nil }
+func (t *transport) SetReadDeadline(time.Time) error  { return nil }
+func (t *transport) SetWriteDeadline(time.Time) error { return nil }
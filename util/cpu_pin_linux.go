@@ -4,6 +4,9 @@ package util
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
 	"golang.org/x/sys/unix"
 )
@@ -36,3 +39,21 @@ func PinTo(cpus ...int) error {
 
 	return nil
 }
+
+// NUMANodeOf returns the NUMA node of the passed CPU, such that memory used
+// from a thread pinned to that CPU with PinTo can be bound to the same node.
+func NUMANodeOf(cpu int) (int, error) {
+	entries, err := os.ReadDir(fmt.Sprintf("/sys/devices/system/cpu/cpu%d", cpu))
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if id, ok := strings.CutPrefix(entry.Name(), "node"); ok {
+			if node, err := strconv.Atoi(id); err == nil {
+				return node, nil
+			}
+		}
+	}
+	// Kernels built without NUMA support have a single, implicit node.
+	return 0, nil
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"log"
 	"os"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -42,3 +43,19 @@ func TestCPUPin(t *testing.T) {
 		}
 	}
 }
+
+func TestNUMANodeOf(t *testing.T) {
+	node, err := NUMANodeOf(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node < 0 {
+		t.Fatalf("invalid node %d", node)
+	}
+
+	if runtime.GOOS == "linux" {
+		if _, err := NUMANodeOf(1 << 20); err == nil {
+			t.Fatal("expected an error for an invalid cpu")
+		}
+	}
+}
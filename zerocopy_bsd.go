@@ -0,0 +1,17 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+var _ ZeroCopyWriter = &file{}
+
+type zeroCopyReactor struct{}
+
+// AsyncWriteZeroCopy copies the bytes, as MSG_ZEROCOPY is not supported on
+// this platform.
+func (f *file) AsyncWriteZeroCopy(b []byte, cb AsyncCallback) {
+	f.AsyncWriteAll(b, cb)
+}
+
+func (f *file) writeZeroCopy(b []byte) (int, error) {
+	return f.Write(b)
+}
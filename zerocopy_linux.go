@@ -0,0 +1,246 @@
+//go:build linux
+
+package sonic
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+var _ ZeroCopyWriter = &file{}
+
+const sizeofSockExtendedErr = int(unsafe.Sizeof(unix.SockExtendedErr{}))
+
+// zeroCopyReactor tracks the zero-copy writes of a file until the kernel
+// notifies, through the socket's error queue, that it is done with them.
+//
+// The kernel numbers each successful zero-copy send call of a socket, starting
+// from 0. Its notifications carry ranges of completed send calls, which it
+// coalesces when they are not read in time.
+type zeroCopyReactor struct {
+	file *file
+
+	enabled bool
+
+	// next is the number of the next zero-copy send call.
+	next uint32
+	// completed is the number of the first send call which has not completed.
+	// Send calls completed out of order are held in ranges.
+	completed uint32
+	ranges    [][2]uint32
+
+	// pending holds the zero-copy writes waiting for completion, in order.
+	pending []zeroCopyWrite
+
+	b   [1]byte
+	oob []byte
+}
+
+type zeroCopyWrite struct {
+	b    []byte
+	last uint32 // the number of the last send call of the write
+	err  error
+	cb   AsyncCallback
+}
+
+func (f *file) AsyncWriteZeroCopy(b []byte, cb AsyncCallback) {
+	if f.zeroCopy == nil {
+		f.zeroCopy = &zeroCopyReactor{
+			file:    f,
+			enabled: internal.ZeroCopyEnabled(f.slot.Fd),
+			oob: make([]byte, unix.CmsgSpace(
+				sizeofSockExtendedErr+unix.SizeofSockaddrInet6)),
+		}
+		f.slot.Set(internal.ErrorEvent, f.zeroCopy.onError)
+	}
+	r := f.zeroCopy
+
+	if !r.enabled || len(b) < ZeroCopyMinSize {
+		f.AsyncWriteAll(b, cb)
+		return
+	}
+
+	first := r.next
+	f.asyncWrite(b, true, true, func(err error, n int) {
+		if r.next == first {
+			// Every byte was copied.
+			cb(err, n)
+			return
+		}
+		r.pending = append(r.pending, zeroCopyWrite{
+			b:    b[:n],
+			last: r.next - 1,
+			err:  err,
+			cb:   cb,
+		})
+		r.dispatch()
+	})
+}
+
+func (f *file) writeZeroCopy(b []byte) (int, error) {
+	r := f.zeroCopy
+	if !r.enabled {
+		return f.Write(b)
+	}
+
+	n, err := unix.SendmsgN(f.slot.Fd, b, nil, nil, unix.MSG_ZEROCOPY)
+	if err == unix.ENOBUFS {
+		// The kernel ran out of the socket's optmem, which holds the state of
+		// zero-copy writes until they complete.
+		return f.Write(b)
+	}
+	if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+		return 0, sonicerrors.ErrWouldBlock
+	}
+	if err != nil {
+		return 0, err
+	}
+	r.next++
+	return n, nil
+}
+
+// onError reads the completion notifications from the socket's error queue.
+func (r *zeroCopyReactor) onError(err error) {
+	r.file.ioc.Deregister(&r.file.slot)
+	if err == nil {
+		err = r.read()
+	}
+	if err != nil {
+		r.fail(err)
+	} else {
+		r.dispatch()
+	}
+}
+
+// read reads all the notifications of the error queue.
+func (r *zeroCopyReactor) read() error {
+	notified := false
+	for {
+		_, oobn, _, _, err := unix.Recvmsg(
+			r.file.slot.Fd, r.b[:], r.oob, unix.MSG_ERRQUEUE)
+		if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		msgs, err := unix.ParseSocketControlMessage(r.oob[:oobn])
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if !(msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_RECVERR) &&
+				!(msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_RECVERR) {
+				continue
+			}
+			if len(msg.Data) < sizeofSockExtendedErr {
+				continue
+			}
+			/* #nosec G103 -- the use of unsafe has been audited */
+			ee := (*unix.SockExtendedErr)(unsafe.Pointer(&msg.Data[0]))
+			if ee.Origin != unix.SO_EE_ORIGIN_ZEROCOPY {
+				continue
+			}
+			if ee.Errno != 0 {
+				return syscall.Errno(ee.Errno)
+			}
+			notified = true
+			r.complete(ee.Info, ee.Data)
+			if ee.Code&unix.SO_EE_CODE_ZEROCOPY_COPIED != 0 {
+				// The kernel copied the bytes anyway, so zero-copy only adds
+				// overhead on this socket's route.
+				r.enabled = false
+			}
+		}
+	}
+
+	if !notified {
+		// The error event was caused by an error on the socket.
+		if v, err := unix.GetsockoptInt(
+			r.file.slot.Fd, unix.SOL_SOCKET, unix.SO_ERROR); err == nil && v != 0 {
+			return syscall.Errno(v)
+		}
+	}
+	return nil
+}
+
+// complete marks the send calls from lo to hi, inclusive, as completed.
+func (r *zeroCopyReactor) complete(lo, hi uint32) {
+	if int32(lo-r.completed) > 0 {
+		r.ranges = append(r.ranges, [2]uint32{lo, hi})
+		return
+	}
+	if int32(hi+1-r.completed) > 0 {
+		r.completed = hi + 1
+	}
+
+	// Merge the ranges which completed out of order.
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(r.ranges); i++ {
+			if lo, hi := r.ranges[i][0], r.ranges[i][1]; int32(lo-r.completed) <= 0 {
+				if int32(hi+1-r.completed) > 0 {
+					r.completed = hi + 1
+				}
+				r.ranges = append(r.ranges[:i], r.ranges[i+1:]...)
+				merged = true
+				i--
+			}
+		}
+	}
+}
+
+// dispatch invokes the callbacks of the completed writes, in order, and waits
+// for the notifications of the rest, and of the send calls of failed writes.
+func (r *zeroCopyReactor) dispatch() {
+	n := 0
+	for n < len(r.pending) && int32(r.pending[n].last-r.completed) < 0 {
+		n++
+	}
+	completed := r.pending[:n]
+	r.pending = r.pending[n:]
+	for i := range completed {
+		w := completed[i]
+		completed[i] = zeroCopyWrite{}
+		w.cb(w.err, len(w.b))
+	}
+
+	if r.completed != r.next {
+		if err := r.watch(); err != nil {
+			r.fail(err)
+		}
+	}
+}
+
+// fail invokes the callbacks of all pending writes with the passed error.
+//
+// Their send calls still complete, and their notifications are still queued.
+// The error queue is drained until all send calls complete: epoll always
+// reports EPOLLERR while the queue is not empty, which would otherwise wake the
+// IO loop without the error handler being dispatched.
+func (r *zeroCopyReactor) fail(err error) {
+	pending := r.pending
+	r.pending = nil
+	for _, w := range pending {
+		w.cb(err, len(w.b))
+	}
+
+	if r.completed != r.next {
+		// There is nothing left to do if the error queue cannot be watched.
+		_ = r.watch()
+	}
+}
+
+// watch dispatches onError once the socket's error queue has notifications.
+func (r *zeroCopyReactor) watch() error {
+	if err := r.file.ioc.SetError(&r.file.slot); err != nil {
+		return err
+	}
+	r.file.ioc.Register(&r.file.slot)
+	return nil
+}
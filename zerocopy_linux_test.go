@@ -0,0 +1,266 @@
+package sonic
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+func TestZeroCopyTCP(t *testing.T) {
+	const (
+		writes = 16
+		size   = 64 * 1024
+	)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, writes*size)
+		_, _ = io.ReadFull(conn, b)
+		received <- b
+	}()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String(), sonicopts.ZeroCopy(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sent := make([]byte, writes*size)
+	rand.Read(sent)
+
+	completed := 0
+	var onWrite AsyncCallback
+	onWrite = func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != size {
+			t.Fatalf("invalid write of %d bytes", n)
+		}
+		completed++
+		if completed < writes {
+			b := sent[completed*size : (completed+1)*size]
+			conn.(ZeroCopyWriter).AsyncWriteZeroCopy(b, onWrite)
+		}
+	}
+	conn.(ZeroCopyWriter).AsyncWriteZeroCopy(sent[:size], onWrite)
+	runUntil(t, ioc, func() bool { return completed == writes })
+
+	if !bytes.Equal(sent, <-received) {
+		t.Fatal("corrupt bytes")
+	}
+
+	r := zeroCopyOf(conn)
+	if r.next == 0 {
+		t.Fatal("no write went through MSG_ZEROCOPY")
+	}
+	if len(r.pending) != 0 || r.completed != r.next {
+		t.Fatalf("invalid state completed=%d next=%d", r.completed, r.next)
+	}
+	// The loopback interface copies the bytes anyway, so the later writes are
+	// copied instead.
+	if r.enabled {
+		t.Fatal("zero-copy writes should be disabled on loopback")
+	}
+}
+
+func TestZeroCopyDrainAfterFailure(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String(), sonicopts.ZeroCopy(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var failed error
+	conn.(ZeroCopyWriter).AsyncWriteZeroCopy(
+		make([]byte, 2*ZeroCopyMinSize),
+		func(err error, _ int) { failed = err },
+	)
+	r := zeroCopyOf(conn)
+	if len(r.pending) != 1 {
+		t.Skip("the write did not go through MSG_ZEROCOPY")
+	}
+
+	// Fail the write as onError does, before its notification is read.
+	_ = ioc.UnsetError(&r.file.slot)
+	ioc.Deregister(&r.file.slot)
+	r.fail(io.ErrUnexpectedEOF)
+	if failed != io.ErrUnexpectedEOF {
+		t.Fatalf("the write should have failed, got %v", failed)
+	}
+
+	// The notification of the failed write's send call is still drained.
+	runUntil(t, ioc, func() bool { return r.completed == r.next })
+}
+
+func TestZeroCopyFallback(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+		}
+	}()
+
+	for _, c := range []struct {
+		opts []sonicopts.Option
+		size int
+	}{
+		{nil, 2 * ZeroCopyMinSize},
+		{[]sonicopts.Option{sonicopts.ZeroCopy(true)}, ZeroCopyMinSize - 1},
+	} {
+		conn, err := Dial(ioc, "tcp", ln.Addr().String(), c.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := false
+		conn.(ZeroCopyWriter).AsyncWriteZeroCopy(
+			make([]byte, c.size),
+			func(err error, n int) {
+				if err != nil || n != c.size {
+					t.Fatalf("invalid write %d %v", n, err)
+				}
+				done = true
+			},
+		)
+		runUntil(t, ioc, func() bool { return done })
+
+		if next := zeroCopyOf(conn).next; next != 0 {
+			t.Fatalf("the bytes should have been copied, not sent %d times", next)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestZeroCopyUDP(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	conn, err := Dial(ioc, "udp", peer.LocalAddr().String(), sonicopts.ZeroCopy(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sent := make([]byte, 32*1024)
+	rand.Read(sent)
+
+	done := false
+	conn.(ZeroCopyWriter).AsyncWriteZeroCopy(sent, func(err error, n int) {
+		if err != nil || n != len(sent) {
+			t.Fatalf("invalid write %d %v", n, err)
+		}
+		done = true
+	})
+	runUntil(t, ioc, func() bool { return done })
+
+	if next := zeroCopyOf(conn).next; next != 1 {
+		t.Fatalf("expected a single zero-copy send, got %d", next)
+	}
+
+	b := make([]byte, 64*1024)
+	n, _, err := peer.ReadFrom(b)
+	if err != nil || !bytes.Equal(b[:n], sent) {
+		t.Fatalf("invalid datagram %d %v", n, err)
+	}
+}
+
+func TestZeroCopyCompletionRanges(t *testing.T) {
+	var (
+		r      = &zeroCopyReactor{next: 5}
+		called []int
+	)
+	for i := 0; i < 5; i++ {
+		i := i
+		r.pending = append(r.pending, zeroCopyWrite{
+			last: uint32(i),
+			cb:   func(error, int) { called = append(called, i) },
+		})
+	}
+
+	// Out of order completions are held until the ones before complete.
+	r.complete(3, 4)
+	r.complete(1, 1)
+	if r.completed != 0 || len(r.ranges) != 2 {
+		t.Fatalf("invalid state completed=%d ranges=%v", r.completed, r.ranges)
+	}
+	r.complete(0, 0)
+	if r.completed != 2 {
+		t.Fatalf("invalid state completed=%d ranges=%v", r.completed, r.ranges)
+	}
+	r.complete(2, 2)
+	if r.completed != 5 || len(r.ranges) != 0 {
+		t.Fatalf("invalid state completed=%d ranges=%v", r.completed, r.ranges)
+	}
+
+	r.file = &file{ioc: MustIO(), slot: internal.Slot{Fd: -1}}
+	defer r.file.ioc.Close()
+	r.dispatch()
+	if len(called) != 5 || called[4] != 4 || len(r.pending) != 0 {
+		t.Fatalf("invalid callbacks %v", called)
+	}
+
+	// Send call numbers wrap around.
+	r.completed = ^uint32(0)
+	r.complete(^uint32(0), 1)
+	if r.completed != 2 {
+		t.Fatalf("invalid state completed=%d", r.completed)
+	}
+}
+
+func zeroCopyOf(c Conn) *zeroCopyReactor {
+	return c.(*conn).zeroCopy
+}